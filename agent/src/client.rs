@@ -17,6 +17,41 @@ pub struct MetricsSummary {
     pub tx_bytes: u64,
     pub drop_count: u64,
     pub uptime_seconds: u64,
+    /// Anomaly events since the agent started
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub anomaly_events: Option<u64>,
+    /// Large packet events since the agent started
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub large_packet_events: Option<u64>,
+}
+
+/// Host details sent with heartbeat
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct AgentMetadata {
+    pub hostname: String,
+    pub os: String,
+    pub kernel: String,
+    pub arch: String,
+}
+
+/// Kind of event reported with heartbeat
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "SCREAMING_SNAKE_CASE")]
+pub enum EventType {
+    EventTypeAnomaly,
+    EventTypeLargePacket,
+}
+
+/// Discrete event detected since the last heartbeat
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct AgentEvent {
+    #[serde(rename = "type")]
+    pub event_type: EventType,
+    pub timestamp_ms: i64,
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub detail: String,
 }
 
 /// Heartbeat request payload
@@ -27,6 +62,10 @@ pub struct HeartbeatRequest {
     pub current_version: String,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub metrics: Option<MetricsSummary>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<AgentMetadata>,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub events: Vec<AgentEvent>,
 }
 
 /// Command from server
@@ -37,6 +76,7 @@ pub enum Command {
     CommandNoop,
     CommandUpgrade,
     CommandReconfigure,
+    CommandDowngrade,
 }
 
 impl Default for Command {
@@ -82,17 +122,19 @@ impl SentinelClient {
         let body = serde_json::to_vec(request)
             .context("Failed to serialize request")?;
         
-        // Generate timestamp and signature
+        // Generate timestamp, nonce and signature
         let timestamp = std::time::SystemTime::now()
             .duration_since(std::time::UNIX_EPOCH)
             .map(|d| d.as_secs() as i64)
             .unwrap_or(0);
-        let signature = crate::crypto::sign_request(&self.api_key, timestamp, &body);
+        let nonce = uuid::Uuid::new_v4().to_string();
+        let signature = crate::crypto::sign_request(&self.api_key, timestamp, &nonce, &body);
 
         let response = ureq::post(&url)
             .set("Authorization", &format!("Bearer {}", self.api_key))
             .set("Content-Type", "application/json")
             .set("X-Sennet-Timestamp", &timestamp.to_string())
+            .set("X-Sennet-Nonce", &nonce)
             .set("X-Sennet-Signature", &signature)
             .send_bytes(&body)
             .context("Failed to send heartbeat request")?;
@@ -121,13 +163,32 @@ mod tests {
                 tx_bytes: 500,
                 drop_count: 0,
                 uptime_seconds: 3600,
+                anomaly_events: Some(3),
+                large_packet_events: None,
+            }),
+            metadata: Some(AgentMetadata {
+                hostname: "node-1".to_string(),
+                os: "linux".to_string(),
+                kernel: "6.8.0".to_string(),
+                arch: "x86_64".to_string(),
             }),
+            events: vec![AgentEvent {
+                event_type: EventType::EventTypeAnomaly,
+                timestamp_ms: 1714564800000,
+                detail: String::new(),
+            }],
         };
 
         let json = serde_json::to_string(&request).unwrap();
         assert!(json.contains("agentId"));
         assert!(json.contains("currentVersion"));
         assert!(json.contains("rxPackets"));
+        assert!(json.contains("\"kernel\":\"6.8.0\""));
+        assert!(json.contains("\"type\":\"EVENT_TYPE_ANOMALY\""));
+        assert!(json.contains("\"timestampMs\":1714564800000"));
+        assert!(!json.contains("detail"));
+        assert!(json.contains("\"anomalyEvents\":3"));
+        assert!(!json.contains("largePacketEvents"));
     }
 
     #[test]
@@ -13,16 +13,24 @@ type HmacSha256 = Hmac<Sha256>;
 /// # Arguments
 /// * `secret` - The API key or shared secret
 /// * `timestamp` - Unix timestamp in seconds
+/// * `nonce` - Single-use value sent as X-Sennet-Nonce; the server rejects reuse.
+///   It is signed behind its little-endian u32 length, so no bytes can be
+///   moved between nonce and body. An empty nonce signs the legacy
+///   timestamp-and-body form.
 /// * `body` - The request body bytes
 /// 
 /// # Returns
 /// Hex-encoded HMAC signature
-pub fn sign_request(secret: &str, timestamp: i64, body: &[u8]) -> String {
+pub fn sign_request(secret: &str, timestamp: i64, nonce: &str, body: &[u8]) -> String {
     let mut mac = HmacSha256::new_from_slice(secret.as_bytes())
         .expect("HMAC can take key of any size");
     
     // Include timestamp in signature to prevent replay attacks
     mac.update(&timestamp.to_le_bytes());
+    if !nonce.is_empty() {
+        mac.update(&(nonce.len() as u32).to_le_bytes());
+        mac.update(nonce.as_bytes());
+    }
     mac.update(body);
     
     hex::encode(mac.finalize().into_bytes())
@@ -33,13 +41,14 @@ pub fn sign_request(secret: &str, timestamp: i64, body: &[u8]) -> String {
 /// # Arguments
 /// * `secret` - The API key or shared secret
 /// * `timestamp` - Unix timestamp from request header
+/// * `nonce` - Nonce from request header
 /// * `body` - The request body bytes
 /// * `signature` - The signature to verify (hex-encoded)
 /// 
 /// # Returns
 /// true if signature is valid
-pub fn verify_signature(secret: &str, timestamp: i64, body: &[u8], signature: &str) -> bool {
-    let expected = sign_request(secret, timestamp, body);
+pub fn verify_signature(secret: &str, timestamp: i64, nonce: &str, body: &[u8], signature: &str) -> bool {
+    let expected = sign_request(secret, timestamp, nonce, body);
     // Use constant-time comparison to prevent timing attacks
     constant_time_eq(expected.as_bytes(), signature.as_bytes())
 }
@@ -67,8 +76,8 @@ mod tests {
         let timestamp = 1706178000i64;
         let body = b"test request body";
         
-        let signature = sign_request(secret, timestamp, body);
-        assert!(verify_signature(secret, timestamp, body, &signature));
+        let signature = sign_request(secret, timestamp, "nonce-1", body);
+        assert!(verify_signature(secret, timestamp, "nonce-1", body, &signature));
     }
 
     #[test]
@@ -77,13 +86,25 @@ mod tests {
         let timestamp = 1706178000i64;
         let body = b"test request body";
         
-        let signature = sign_request(secret, timestamp, body);
+        let signature = sign_request(secret, timestamp, "nonce-1", body);
         // Tampered body should fail
-        assert!(!verify_signature(secret, timestamp, b"tampered body", &signature));
+        assert!(!verify_signature(secret, timestamp, "nonce-1", b"tampered body", &signature));
         // Different secret should fail
-        assert!(!verify_signature("wrong_secret", timestamp, body, &signature));
+        assert!(!verify_signature("wrong_secret", timestamp, "nonce-1", body, &signature));
         // Different timestamp should fail
-        assert!(!verify_signature(secret, timestamp + 1, body, &signature));
+        assert!(!verify_signature(secret, timestamp + 1, "nonce-1", body, &signature));
+        // Different nonce should fail
+        assert!(!verify_signature(secret, timestamp, "nonce-2", body, &signature));
+    }
+
+    #[test]
+    fn test_nonce_body_boundary() {
+        let secret = "sk_test_123456";
+        let timestamp = 1706178000i64;
+
+        // Moving bytes from the body into the nonce must change the signature
+        let signature = sign_request(secret, timestamp, "ab", b"c{}");
+        assert!(!verify_signature(secret, timestamp, "abc", b"{}", &signature));
     }
 
     #[test]
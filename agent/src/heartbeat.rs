@@ -4,10 +4,12 @@
 
 use anyhow::Result;
 use backoff::ExponentialBackoff;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Arc;
 use std::time::{Duration, Instant};
 use tracing::{debug, error, info, warn};
 
-use crate::client::{Command, HeartbeatRequest, MetricsSummary, SentinelClient};
+use crate::client::{AgentMetadata, Command, EventType, HeartbeatRequest, MetricsSummary, SentinelClient};
 use crate::config::Config;
 use crate::identity::IdentityManager;
 use crate::upgrade::Updater;
@@ -20,12 +22,41 @@ use aya::maps::{Map, MapData, PerCpuArray};
 #[cfg(target_os = "linux")]
 use std::path::Path;
 
+/// Running totals of events detected since the agent started, reported with
+/// every heartbeat. The server counts how far they moved between beats.
+#[derive(Debug, Default)]
+pub struct EventTotals {
+    anomalies: AtomicU64,
+    large_packets: AtomicU64,
+}
+
+impl EventTotals {
+    /// Count one detected event
+    #[allow(dead_code)] // No detector feeds it yet
+    pub fn record(&self, event_type: EventType) {
+        let total = match event_type {
+            EventType::EventTypeAnomaly => &self.anomalies,
+            EventType::EventTypeLargePacket => &self.large_packets,
+        };
+        total.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Current (anomaly, large packet) totals
+    pub fn snapshot(&self) -> (u64, u64) {
+        (
+            self.anomalies.load(Ordering::Relaxed),
+            self.large_packets.load(Ordering::Relaxed),
+        )
+    }
+}
+
 /// Heartbeat loop that runs continuously
 pub struct HeartbeatLoop {
     config: Config,
     identity: IdentityManager,
     client: SentinelClient,
     start_time: Instant,
+    events: Arc<EventTotals>,
 }
 
 impl HeartbeatLoop {
@@ -36,9 +67,16 @@ impl HeartbeatLoop {
             identity,
             client,
             start_time: Instant::now(),
+            events: Arc::new(EventTotals::default()),
         }
     }
 
+    /// Totals that event detectors count into; they go out on every heartbeat
+    #[allow(dead_code)] // No detector feeds it yet
+    pub fn event_totals(&self) -> Arc<EventTotals> {
+        Arc::clone(&self.events)
+    }
+
     /// Run the heartbeat loop forever
     pub async fn run(self) -> Result<()> {
         let interval = Duration::from_secs(self.config.heartbeat_interval_secs);
@@ -66,6 +104,8 @@ impl HeartbeatLoop {
             agent_id: self.identity.agent_id().to_string(),
             current_version: self.identity.version().to_string(),
             metrics: Some(self.collect_metrics()),
+            metadata: Some(collect_metadata()),
+            events: Vec::new(),
         };
 
         // Use exponential backoff for retries
@@ -92,6 +132,7 @@ impl HeartbeatLoop {
     /// Collect current metrics from eBPF maps (Linux) or return zeros (other platforms)
     fn collect_metrics(&self) -> MetricsSummary {
         let uptime = self.start_time.elapsed().as_secs();
+        let (anomalies, large_packets) = self.events.snapshot();
         
         #[cfg(target_os = "linux")]
         {
@@ -105,6 +146,8 @@ impl HeartbeatLoop {
                         tx_bytes: counters.tx_bytes,
                         drop_count: counters.drop_count,
                         uptime_seconds: uptime,
+                        anomaly_events: Some(anomalies),
+                        large_packet_events: Some(large_packets),
                     };
                 }
                 Err(e) => {
@@ -121,6 +164,8 @@ impl HeartbeatLoop {
             tx_bytes: 0,
             drop_count: 0,
             uptime_seconds: uptime,
+            anomaly_events: Some(anomalies),
+            large_packet_events: Some(large_packets),
         }
     }
     
@@ -200,6 +245,11 @@ impl HeartbeatLoop {
                 // TODO: Implement config reload
                 warn!("Config reload not yet implemented");
             }
+            Command::CommandDowngrade => {
+                warn!("Downgrade requested: {} -> {}", self.identity.version(), latest_version);
+                // TODO: Install a pinned release rather than the latest one
+                warn!("Automatic downgrade not yet implemented");
+            }
             Command::CommandUnspecified => {
                 warn!("Received unspecified command");
             }
@@ -207,6 +257,22 @@ impl HeartbeatLoop {
     }
 }
 
+/// Collect host details; fields that can't be read are sent empty
+fn collect_metadata() -> AgentMetadata {
+    let read = |path: &str| {
+        std::fs::read_to_string(path)
+            .map(|s| s.trim().to_string())
+            .unwrap_or_default()
+    };
+
+    AgentMetadata {
+        hostname: read("/proc/sys/kernel/hostname"),
+        os: std::env::consts::OS.to_string(),
+        kernel: read("/proc/sys/kernel/osrelease"),
+        arch: std::env::consts::ARCH.to_string(),
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -222,6 +288,15 @@ mod tests {
         let _ = elapsed; 
     }
 
+    #[test]
+    fn test_event_totals() {
+        let totals = EventTotals::default();
+        totals.record(EventType::EventTypeAnomaly);
+        totals.record(EventType::EventTypeAnomaly);
+        totals.record(EventType::EventTypeLargePacket);
+        assert_eq!(totals.snapshot(), (2, 1));
+    }
+
     #[test]
     fn test_command_handling() {
         // Test that commands are properly recognized
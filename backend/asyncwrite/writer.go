@@ -0,0 +1,154 @@
+// Package asyncwrite moves database writes off the request path: entries are
+// queued on a buffered channel and flushed in batches by a background worker,
+// which Drain lets finish before shutdown
+package asyncwrite
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// MaxBatch is the most entries handed to one flush call
+const MaxBatch = 100
+
+// Writer queues entries of type T and flushes them with a single worker
+type Writer[T any] struct {
+	name     string
+	flush    func([]T) error
+	interval time.Duration // 0 flushes as soon as entries arrive
+	ch       chan T
+	done     chan struct{}
+
+	mu      sync.RWMutex // Guards closing ch against concurrent Write
+	closed  bool
+	pending atomic.Int64 // Accepted entries not yet flushed or given up on
+}
+
+// New starts a writer that buffers up to size entries and flushes them with
+// flush. name identifies the writer in logs.
+func New[T any](name string, size int, flush func([]T) error) *Writer[T] {
+	return NewBatching(name, size, 0, flush)
+}
+
+// NewBatching is New for a writer that collects entries and flushes them
+// every interval, or sooner once MaxBatch have accumulated, trading latency
+// for fewer, larger writes
+func NewBatching[T any](name string, size int, interval time.Duration, flush func([]T) error) *Writer[T] {
+	w := &Writer[T]{
+		name:     name,
+		flush:    flush,
+		interval: interval,
+		ch:       make(chan T, size),
+		done:     make(chan struct{}),
+	}
+	if interval > 0 {
+		go w.runBatching()
+	} else {
+		go w.run()
+	}
+	return w
+}
+
+// Write queues an entry without blocking, reporting false if it was dropped
+// because the buffer is full or the writer is draining
+func (w *Writer[T]) Write(entry T) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.ch <- entry:
+		w.pending.Add(1)
+		return true
+	default:
+		logging.Warnf("%s writer buffer full, dropping entry", w.name)
+		return false
+	}
+}
+
+// Pending returns the number of accepted entries not yet flushed
+func (w *Writer[T]) Pending() int {
+	return int(w.pending.Load())
+}
+
+// Drain stops accepting entries and waits for the worker to flush those
+// already buffered. If ctx ends first, the entries still unflushed are logged
+// and their count returned; the worker keeps going in the background until
+// the process exits. Drain must be called at most once.
+func (w *Writer[T]) Drain(ctx context.Context) int {
+	w.mu.Lock()
+	w.closed = true
+	close(w.ch)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return 0
+	case <-ctx.Done():
+		n := w.Pending()
+		logging.Errorf("%s writer: %d entries not flushed before shutdown: %v", w.name, n, ctx.Err())
+		return n
+	}
+}
+
+func (w *Writer[T]) run() {
+	defer close(w.done)
+	for entry := range w.ch {
+		batch := []T{entry}
+		// Take whatever else is already buffered
+	fill:
+		for len(batch) < MaxBatch {
+			select {
+			case next, ok := <-w.ch:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		w.flushBatch(batch)
+	}
+}
+
+func (w *Writer[T]) runBatching() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var batch []T
+	for {
+		select {
+		case entry, ok := <-w.ch:
+			if !ok {
+				w.flushBatch(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= MaxBatch {
+				w.flushBatch(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.flushBatch(batch)
+			batch = nil
+		}
+	}
+}
+
+func (w *Writer[T]) flushBatch(batch []T) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.flush(batch); err != nil {
+		logging.Errorf("%s writer: failed to flush %d entries: %v", w.name, len(batch), err)
+	}
+	w.pending.Add(-int64(len(batch)))
+}
@@ -0,0 +1,117 @@
+package asyncwrite
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriter_DrainFlushesBufferedEntries(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var flushed []int
+	w := New("test", 10, func(batch []int) error {
+		<-release // Hold the worker so entries pile up in the buffer
+		mu.Lock()
+		flushed = append(flushed, batch...)
+		mu.Unlock()
+		return nil
+	})
+
+	for i := 0; i < 5; i++ {
+		if !w.Write(i) {
+			t.Fatalf("Write %d was dropped", i)
+		}
+	}
+	close(release)
+
+	if lost := w.Drain(context.Background()); lost != 0 {
+		t.Errorf("Expected nothing lost, got %d", lost)
+	}
+	if len(flushed) != 5 {
+		t.Errorf("Expected all 5 entries flushed, got %v", flushed)
+	}
+	if w.Write(5) {
+		t.Error("Expected writes after Drain to be refused")
+	}
+}
+
+func TestWriter_DrainTimeoutReportsUnflushed(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	w := New("test", 10, func(batch []int) error {
+		<-block
+		return nil
+	})
+	w.Write(1)
+	w.Write(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if lost := w.Drain(ctx); lost != 2 {
+		t.Errorf("Expected 2 unflushed entries, got %d", lost)
+	}
+}
+
+func TestWriter_FullBufferDrops(t *testing.T) {
+	block := make(chan struct{})
+	w := New("test", 1, func(batch []int) error {
+		<-block
+		return nil
+	})
+	// One entry may be held by the worker and one by the buffer; a third
+	// can't fit
+	accepted := 0
+	for i := 0; i < 3; i++ {
+		if w.Write(i) {
+			accepted++
+		}
+	}
+	if accepted == 3 {
+		t.Error("Expected a write to be dropped once the buffer was full")
+	}
+	close(block)
+	w.Drain(context.Background())
+	if n := w.Pending(); n != 0 {
+		t.Errorf("Expected no pending entries after drain, got %d", n)
+	}
+}
+
+func TestBatchingWriter_FlushesOnInterval(t *testing.T) {
+	batches := make(chan []int, 10)
+	w := NewBatching("test", 10, 20*time.Millisecond, func(batch []int) error {
+		batches <- batch
+		return nil
+	})
+	defer w.Drain(context.Background())
+
+	w.Write(1)
+	w.Write(2)
+	select {
+	case batch := <-batches:
+		if len(batch) != 2 {
+			t.Errorf("Expected both entries in one batch, got %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the ticker to flush the batch")
+	}
+}
+
+func TestBatchingWriter_DrainFlushesPartialBatch(t *testing.T) {
+	var flushed []int
+	w := NewBatching("test", 10, time.Hour, func(batch []int) error {
+		flushed = append(flushed, batch...)
+		return nil
+	})
+	w.Write(1)
+	w.Write(2)
+	w.Write(3)
+
+	if lost := w.Drain(context.Background()); lost != 0 {
+		t.Errorf("Expected nothing lost, got %d", lost)
+	}
+	if len(flushed) != 3 {
+		t.Errorf("Expected all 3 entries flushed on drain, got %v", flushed)
+	}
+}
@@ -0,0 +1,48 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/auth"
+)
+
+func TestNewFirebaseAuth_NotConfigured(t *testing.T) {
+	t.Setenv("FIREBASE_SERVICE_ACCOUNT_JSON", "")
+	t.Setenv("FIREBASE_SERVICE_ACCOUNT_PATH", "")
+
+	if auth.Configured() {
+		t.Fatal("Expected Firebase to be unconfigured")
+	}
+	fa, err := auth.NewFirebaseAuth()
+	if !errors.Is(err, auth.ErrNotConfigured) {
+		t.Fatalf("Expected ErrNotConfigured, got %v", err)
+	}
+	if fa != nil {
+		t.Error("Expected no client when unconfigured")
+	}
+}
+
+func TestFirebaseRoutes_NotImplementedWhenUnconfigured(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not run without Firebase")
+	})
+
+	routes := map[string]http.Handler{
+		"FirebaseMiddleware":  auth.FirebaseMiddleware(nil)(ok),
+		"RequireRole":         auth.RequireRole(nil, "admin")(ok),
+		"RequireRecentSignIn": auth.RequireRecentSignIn(nil, time.Minute)(ok),
+	}
+	for name, h := range routes {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+		req.Header.Set("Authorization", "Bearer some-id-token")
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotImplemented {
+			t.Errorf("%s: expected 501, got %d", name, rec.Code)
+		}
+	}
+}
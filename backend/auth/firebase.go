@@ -3,6 +3,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
@@ -13,15 +14,42 @@ import (
 
 // FirebaseAuth wraps the Firebase Admin SDK auth client
 type FirebaseAuth struct {
-	client *auth.Client
+	client   *auth.Client
+	verifier TokenVerifier
+}
+
+// TokenVerifier verifies Firebase ID tokens. *auth.Client implements it.
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
+
+// NewFirebaseAuthWithVerifier creates a FirebaseAuth that only verifies
+// tokens, for tests and token sources other than the Admin SDK. The user
+// management methods need a client from NewFirebaseAuth.
+func NewFirebaseAuthWithVerifier(v TokenVerifier) *FirebaseAuth {
+	return &FirebaseAuth{verifier: v}
+}
+
+// ErrNotConfigured is returned by NewFirebaseAuth when no credentials are set
+var ErrNotConfigured = errors.New("firebase auth is not configured")
+
+// Configured reports whether a Firebase service account is set. Application
+// default credentials alone don't count, since they're often present for the
+// GCP cost provider rather than for dashboard auth.
+func Configured() bool {
+	return os.Getenv("FIREBASE_SERVICE_ACCOUNT_JSON") != "" || os.Getenv("FIREBASE_SERVICE_ACCOUNT_PATH") != ""
 }
 
 // NewFirebaseAuth creates a new Firebase Auth client
 // It reads credentials from:
 // 1. FIREBASE_SERVICE_ACCOUNT_JSON env var (base64 encoded JSON)
 // 2. FIREBASE_SERVICE_ACCOUNT_PATH env var (path to JSON file)
-// 3. GOOGLE_APPLICATION_CREDENTIALS env var (GCP default)
+// If neither is set it returns ErrNotConfigured.
 func NewFirebaseAuth() (*FirebaseAuth, error) {
+	if !Configured() {
+		return nil, ErrNotConfigured
+	}
+
 	ctx := context.Background()
 	var app *firebase.App
 	var err error
@@ -40,12 +68,6 @@ func NewFirebaseAuth() (*FirebaseAuth, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize Firebase with file: %w", err)
 		}
-	} else {
-		// Fall back to Application Default Credentials
-		app, err = firebase.NewApp(ctx, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to initialize Firebase with default credentials: %w", err)
-		}
 	}
 
 	client, err := app.Auth(ctx)
@@ -53,12 +75,12 @@ func NewFirebaseAuth() (*FirebaseAuth, error) {
 		return nil, fmt.Errorf("failed to get Firebase Auth client: %w", err)
 	}
 
-	return &FirebaseAuth{client: client}, nil
+	return &FirebaseAuth{client: client, verifier: client}, nil
 }
 
 // VerifyToken verifies a Firebase ID token and returns the decoded token
 func (fa *FirebaseAuth) VerifyToken(ctx context.Context, idToken string) (*auth.Token, error) {
-	token, err := fa.client.VerifyIDToken(ctx, idToken)
+	token, err := fa.verifier.VerifyIDToken(ctx, idToken)
 	if err != nil {
 		return nil, fmt.Errorf("invalid ID token: %w", err)
 	}
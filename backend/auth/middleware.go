@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"firebase.google.com/go/v4/auth"
 )
@@ -20,10 +21,16 @@ const (
 	FirebaseTokenKey ContextKey = "firebase_token"
 )
 
-// FirebaseMiddleware creates HTTP middleware that verifies Firebase ID tokens
+// FirebaseMiddleware creates HTTP middleware that verifies Firebase ID tokens.
+// With a nil client (Firebase unconfigured) every request gets a 501.
 func FirebaseMiddleware(fa *FirebaseAuth) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fa == nil {
+				notConfigured(w)
+				return
+			}
+
 			// Extract token from Authorization header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
@@ -62,6 +69,11 @@ func FirebaseMiddleware(fa *FirebaseAuth) func(http.Handler) http.Handler {
 	}
 }
 
+// notConfigured responds to Firebase-only routes when Firebase is disabled
+func notConfigured(w http.ResponseWriter) {
+	http.Error(w, "Firebase authentication is not configured on this server", http.StatusNotImplemented)
+}
+
 // GetFirebaseUID extracts the Firebase UID from the request context
 func GetFirebaseUID(ctx context.Context) string {
 	if uid, ok := ctx.Value(FirebaseUIDKey).(string); ok {
@@ -90,6 +102,10 @@ func GetFirebaseToken(ctx context.Context) *auth.Token {
 func RequireRole(fa *FirebaseAuth, role string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fa == nil {
+				notConfigured(w)
+				return
+			}
 			token := GetFirebaseToken(r.Context())
 			if token == nil {
 				http.Error(w, "Authentication required", http.StatusUnauthorized)
@@ -108,3 +124,27 @@ func RequireRole(fa *FirebaseAuth, role string) func(http.Handler) http.Handler
 		})
 	}
 }
+
+// RequireRecentSignIn creates middleware that rejects Firebase tokens whose
+// user signed in more than maxAge ago, so the dashboard has to re-authenticate
+// before a sensitive request. It runs after FirebaseMiddleware.
+func RequireRecentSignIn(fa *FirebaseAuth, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fa == nil {
+				notConfigured(w)
+				return
+			}
+			token := GetFirebaseToken(r.Context())
+			if token == nil {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if time.Since(time.Unix(token.AuthTime, 0)) > maxAge {
+				http.Error(w, "Recent sign-in required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
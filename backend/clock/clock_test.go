@@ -0,0 +1,25 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/clock"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+
+	if !fake.Now().Equal(start) {
+		t.Errorf("Expected %v, got %v", start, fake.Now())
+	}
+	fake.Advance(90 * time.Second)
+	if want := start.Add(90 * time.Second); !fake.Now().Equal(want) {
+		t.Errorf("Expected %v after Advance, got %v", want, fake.Now())
+	}
+	fake.Set(start)
+	if !fake.Now().Equal(start) {
+		t.Errorf("Expected %v after Set, got %v", start, fake.Now())
+	}
+}
@@ -0,0 +1,75 @@
+package cloud
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc lets a function stand in for an HTTP transport
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func fakeResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+const stsIdentityResponse = `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::123456789012:user/sennet</Arn>
+    <UserId>AIDAEXAMPLE</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`
+
+const stsErrorResponse = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>InvalidClientTokenId</Code>
+    <Message>The security token included in the request is invalid.</Message>
+  </Error>
+</ErrorResponse>`
+
+// fakeSTS accepts requests signed with the access key "AKIAGOOD"
+func fakeSTS(t *testing.T) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "sts.us-east-1.amazonaws.com" {
+			t.Errorf("Unexpected STS host %s", req.URL.Host)
+		}
+		body, _ := io.ReadAll(req.Body)
+		if !strings.Contains(string(body), "Action=GetCallerIdentity") {
+			t.Errorf("Unexpected STS body %s", body)
+		}
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") || req.Header.Get("X-Amz-Date") == "" {
+			t.Errorf("Expected a SigV4 signed request, got %q", auth)
+		}
+		if strings.Contains(auth, "Credential=AKIAGOOD/") {
+			return fakeResponse(http.StatusOK, stsIdentityResponse), nil
+		}
+		return fakeResponse(http.StatusForbidden, stsErrorResponse), nil
+	})}
+}
+
+func TestAWSProvider_TestConnection(t *testing.T) {
+	good, _ := NewAWSProvider("aws", &AWSConfig{AccessKeyID: "AKIAGOOD", SecretAccessKey: "secret", Region: "us-east-1"})
+	good.SetHTTPClient(fakeSTS(t))
+	if err := good.TestConnection(context.Background()); err != nil {
+		t.Errorf("Expected valid credentials to pass, got %v", err)
+	}
+
+	bad, _ := NewAWSProvider("aws", &AWSConfig{AccessKeyID: "AKIABAD", SecretAccessKey: "secret", Region: "us-east-1"})
+	bad.SetHTTPClient(fakeSTS(t))
+	err := bad.TestConnection(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "InvalidClientTokenId") {
+		t.Errorf("Expected STS rejection to be reported, got %v", err)
+	}
+}
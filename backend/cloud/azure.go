@@ -0,0 +1,271 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud/retry"
+)
+
+const (
+	azureLoginURL      = "https://login.microsoftonline.com/"
+	azureManagementURL = "https://management.azure.com"
+	azureCostAPI       = "2023-03-01"
+
+	// azureTokenLeeway refreshes tokens this long before they expire, so a
+	// token doesn't lapse between the check and the query
+	azureTokenLeeway = time.Minute
+)
+
+// azureEgressServices are the Cost Management service names that bill
+// network transfer out of Azure
+var azureEgressServices = map[string]bool{
+	"Bandwidth":                true,
+	"Virtual Network":          true,
+	"NAT Gateway":              true,
+	"VPN Gateway":              true,
+	"ExpressRoute":             true,
+	"Azure Front Door Service": true,
+	"Content Delivery Network": true,
+	"Azure Private Link":       true,
+}
+
+// SetHTTPClient replaces the client used for token and Cost Management requests
+func (p *AzureProvider) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// FetchCosts queries Cost Management for daily actual costs grouped by
+// location and service, keeping only network egress services
+func (p *AzureProvider) FetchCosts(ctx context.Context, startDate, endDate time.Time) ([]CostResult, error) {
+	query := map[string]interface{}{
+		"type":      "ActualCost",
+		"timeframe": "Custom",
+		"timePeriod": map[string]string{
+			"from": startDate.UTC().Format("2006-01-02T00:00:00Z"),
+			"to":   endDate.UTC().Format("2006-01-02T23:59:59Z"),
+		},
+		"dataset": map[string]interface{}{
+			"granularity": "Daily",
+			"aggregation": map[string]interface{}{
+				"totalCost": map[string]string{"name": "Cost", "function": "Sum"},
+			},
+			"grouping": []map[string]string{
+				{"type": "Dimension", "name": "ResourceLocation"},
+				{"type": "Dimension", "name": "ServiceName"},
+			},
+		},
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	next := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.CostManagement/query?api-version=%s",
+		azureManagementURL, url.PathEscape(p.config.SubscriptionID), azureCostAPI)
+	var results []CostResult
+	for next != "" {
+		var page *azureQueryResult
+		err := retry.Do(ctx, p.MaxAttempts, p.RetryBaseDelay, func() error {
+			var err error
+			page, err = p.queryCosts(ctx, next, body)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		rows, err := page.costResults()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rows...)
+		next = page.Properties.NextLink
+	}
+	return results, nil
+}
+
+// azureQueryResult is one page of a Cost Management query response
+type azureQueryResult struct {
+	Properties struct {
+		NextLink string `json:"nextLink"`
+		Columns  []struct {
+			Name string `json:"name"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"properties"`
+}
+
+// costResults converts rows to CostResults, locating columns by name
+func (r *azureQueryResult) costResults() ([]CostResult, error) {
+	col := make(map[string]int)
+	for i, c := range r.Properties.Columns {
+		col[c.Name] = i
+	}
+	for _, name := range []string{"Cost", "UsageDate", "ResourceLocation", "ServiceName"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("Azure cost query response has no %s column", name)
+		}
+	}
+
+	var results []CostResult
+	for _, row := range r.Properties.Rows {
+		if len(row) != len(r.Properties.Columns) {
+			continue
+		}
+		service, _ := row[col["ServiceName"]].(string)
+		if !azureEgressServices[service] {
+			continue
+		}
+		amount, ok := row[col["Cost"]].(float64)
+		if !ok {
+			continue
+		}
+		usageDate, ok := row[col["UsageDate"]].(float64) // yyyymmdd
+		if !ok {
+			continue
+		}
+		date, err := time.Parse("20060102", strconv.FormatInt(int64(usageDate), 10))
+		if err != nil {
+			continue
+		}
+		currency := "USD"
+		if i, ok := col["Currency"]; ok {
+			if c, ok := row[i].(string); ok && c != "" {
+				currency = c
+			}
+		}
+		region, _ := row[col["ResourceLocation"]].(string)
+
+		results = append(results, CostResult{
+			Date:     date,
+			Service:  service,
+			Region:   region,
+			CostUSD:  toUSD(amount, currency),
+			Currency: currency,
+		})
+	}
+	return results, nil
+}
+
+// toUSD converts a billed amount to USD. Amounts are assumed to already be
+// in USD until exchange rates are wired in; callers keep the original
+// currency on the CostResult.
+func toUSD(amount float64, currency string) float64 {
+	return amount
+}
+
+// queryCosts posts a cost query, refreshing the token and retrying once if
+// it was rejected as expired
+func (p *AzureProvider) queryCosts(ctx context.Context, endpoint string, body []byte) (*azureQueryResult, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := p.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, retry.Retryable(fmt.Errorf("Azure cost query failed: %w", err))
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			p.invalidateToken()
+			continue
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			err := fmt.Errorf("Azure cost query returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+			if retry.RetryableStatus(resp.StatusCode) {
+				return nil, retry.Retryable(err)
+			}
+			return nil, err
+		}
+		var result azureQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to decode Azure cost query: %w", err)
+		}
+		return &result, nil
+	}
+}
+
+// accessToken returns a cached client-credentials token, fetching a new one
+// when none is cached or it is about to expire
+func (p *AzureProvider) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && p.clock.Now().Add(azureTokenLeeway).Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+		"scope":         {azureManagementURL + "/.default"},
+	}
+	endpoint := azureLoginURL + url.PathEscape(p.config.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", retry.Retryable(fmt.Errorf("Azure token request failed: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("Azure token request returned %s", resp.Status)
+		if retry.RetryableStatus(resp.StatusCode) {
+			return "", retry.Retryable(err)
+		}
+		return "", err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode Azure token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("Azure token response has no access_token")
+	}
+
+	p.token = token.AccessToken
+	p.tokenExpiry = p.clock.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return p.token, nil
+}
+
+func (p *AzureProvider) invalidateToken() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = ""
+}
+
+// TestConnection checks the client credentials by requesting a fresh token
+func (p *AzureProvider) TestConnection(ctx context.Context) error {
+	p.invalidateToken()
+	_, err := p.accessToken(ctx)
+	return err
+}
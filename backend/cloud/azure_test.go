@@ -0,0 +1,197 @@
+package cloud
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/clock"
+)
+
+// fakeAzure answers token and Cost Management requests without the network
+type fakeAzure struct {
+	mu          sync.Mutex
+	tokens      int
+	queries     int
+	rejectNext  bool // Answer the next query with 401, as for an expired token
+	throttle    int  // Answer this many queries with 429 first
+	lastAuth    string
+	queryBodies []string
+}
+
+const azureQueryResponse = `{
+  "properties": {
+    "nextLink": "",
+    "columns": [
+      {"name": "Cost", "type": "Number"},
+      {"name": "UsageDate", "type": "Number"},
+      {"name": "ResourceLocation", "type": "String"},
+      {"name": "ServiceName", "type": "String"},
+      {"name": "Currency", "type": "String"}
+    ],
+    "rows": [
+      [12.5, 20240115, "eastus", "Bandwidth", "USD"],
+      [3.25, 20240116, "westeurope", "Bandwidth", "USD"],
+      [40.0, 20240115, "eastus", "Virtual Machines", "USD"]
+    ]
+  }
+}`
+
+func (f *fakeAzure) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	respond := func(status int, body string) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}
+	}
+
+	switch {
+	case req.URL.Host == "login.microsoftonline.com":
+		f.tokens++
+		req.ParseForm()
+		if req.PostForm.Get("grant_type") != "client_credentials" || req.PostForm.Get("client_secret") != "s3cret" {
+			return respond(http.StatusBadRequest, `{"error":"invalid_client"}`), nil
+		}
+		return respond(http.StatusOK, `{"token_type":"Bearer","expires_in":3600,"access_token":"token-`+strconv.Itoa(f.tokens)+`"}`), nil
+	case req.URL.Host == "management.azure.com":
+		f.queries++
+		f.lastAuth = req.Header.Get("Authorization")
+		body, _ := io.ReadAll(req.Body)
+		f.queryBodies = append(f.queryBodies, string(body))
+		if f.rejectNext {
+			f.rejectNext = false
+			return respond(http.StatusUnauthorized, `{"error":{"code":"ExpiredAuthenticationToken"}}`), nil
+		}
+		if f.throttle > 0 {
+			f.throttle--
+			return respond(http.StatusTooManyRequests, `{"error":{"code":"429"}}`), nil
+		}
+		return respond(http.StatusOK, azureQueryResponse), nil
+	}
+	return respond(http.StatusNotFound, ""), nil
+}
+
+func newTestAzureProvider(t *testing.T) (*AzureProvider, *fakeAzure) {
+	t.Helper()
+	p, err := NewAzureProvider("azure-prod", &AzureConfig{
+		TenantID:       "tenant",
+		ClientID:       "client",
+		ClientSecret:   "s3cret",
+		SubscriptionID: "sub-123",
+	})
+	if err != nil {
+		t.Fatalf("NewAzureProvider failed: %v", err)
+	}
+	fake := &fakeAzure{}
+	p.SetHTTPClient(&http.Client{Transport: fake})
+	return p, fake
+}
+
+func TestAzureProvider_FetchCosts(t *testing.T) {
+	p, fake := newTestAzureProvider(t)
+
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	costs, err := p.FetchCosts(context.Background(), start, start.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("FetchCosts failed: %v", err)
+	}
+
+	// Only the Bandwidth rows are egress
+	if len(costs) != 2 {
+		t.Fatalf("Expected 2 egress costs, got %d: %+v", len(costs), costs)
+	}
+	first := costs[0]
+	if !first.Date.Equal(start) || first.Region != "eastus" || first.Service != "Bandwidth" || first.CostUSD != 12.5 || first.Currency != "USD" {
+		t.Errorf("Unexpected first cost %+v", first)
+	}
+	if costs[1].Region != "westeurope" || costs[1].CostUSD != 3.25 {
+		t.Errorf("Unexpected second cost %+v", costs[1])
+	}
+
+	if fake.lastAuth != "Bearer token-1" {
+		t.Errorf("Expected query to use the fetched token, got %q", fake.lastAuth)
+	}
+	for _, want := range []string{`"ResourceLocation"`, `"ServiceName"`, `"from":"2024-01-15T00:00:00Z"`} {
+		if !strings.Contains(fake.queryBodies[0], want) {
+			t.Errorf("Expected query body to contain %s, got %s", want, fake.queryBodies[0])
+		}
+	}
+}
+
+func TestAzureProvider_TokenRefresh(t *testing.T) {
+	p, fake := newTestAzureProvider(t)
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFake(now)
+	p.SetClock(fakeClock)
+	ctx := context.Background()
+
+	p.FetchCosts(ctx, now, now)
+	p.FetchCosts(ctx, now, now)
+	if fake.tokens != 1 {
+		t.Errorf("Expected the token to be cached between queries, fetched %d", fake.tokens)
+	}
+
+	// Within the refresh leeway of the hour-long token, a new one is fetched
+	now = now.Add(59*time.Minute + 30*time.Second)
+	fakeClock.Set(now)
+	p.FetchCosts(ctx, now, now)
+	if fake.tokens != 2 || fake.lastAuth != "Bearer token-2" {
+		t.Errorf("Expected a refreshed token near expiry, got %d fetches, auth %q", fake.tokens, fake.lastAuth)
+	}
+
+	// A token rejected by the API is dropped and the query retried once
+	fake.rejectNext = true
+	if _, err := p.FetchCosts(ctx, now, now); err != nil {
+		t.Fatalf("Expected retry after 401 to succeed, got %v", err)
+	}
+	if fake.tokens != 3 || fake.lastAuth != "Bearer token-3" {
+		t.Errorf("Expected a new token after 401, got %d fetches, auth %q", fake.tokens, fake.lastAuth)
+	}
+}
+
+func TestAzureProvider_TestConnection(t *testing.T) {
+	p, fake := newTestAzureProvider(t)
+	if err := p.TestConnection(context.Background()); err != nil {
+		t.Errorf("Expected valid credentials to pass, got %v", err)
+	}
+	if fake.tokens != 1 {
+		t.Errorf("Expected one token request, got %d", fake.tokens)
+	}
+
+	p.config.ClientSecret = "wrong"
+	if err := p.TestConnection(context.Background()); err == nil {
+		t.Error("Expected a rejected client secret to fail, even with a cached token")
+	}
+}
+
+func TestAzureProvider_RetriesThrottledQueries(t *testing.T) {
+	p, fake := newTestAzureProvider(t)
+	p.RetryBaseDelay = time.Millisecond
+	fake.throttle = 2
+
+	costs, err := p.FetchCosts(context.Background(), time.Now(), time.Now())
+	if err != nil {
+		t.Fatalf("Expected throttled query to succeed on retry, got %v", err)
+	}
+	if fake.queries != 3 || len(costs) != 2 {
+		t.Errorf("Expected 3 queries and 2 costs, got %d queries and %d costs", fake.queries, len(costs))
+	}
+
+	// With retries disabled the throttle is returned
+	p.MaxAttempts = 1
+	fake.throttle = 1
+	if _, err := p.FetchCosts(context.Background(), time.Now(), time.Now()); err == nil {
+		t.Error("Expected a throttled query to fail without retries")
+	}
+}
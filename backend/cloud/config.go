@@ -3,6 +3,8 @@ package cloud
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/sennet/sennet/backend/crypto"
 )
 
 type ProviderType string
@@ -150,3 +152,16 @@ func CloudConfigFromJSON(data string) (*CloudConfig, error) {
 	}
 	return &config, nil
 }
+
+// ParseStoredConfig parses a config as stored in the database, decrypting it
+// first unless it is a legacy plaintext row
+func ParseStoredConfig(stored string) (*CloudConfig, error) {
+	if json.Valid([]byte(stored)) {
+		return CloudConfigFromJSON(stored)
+	}
+	plaintext, err := crypto.DecryptString(stored)
+	if err != nil {
+		return nil, err
+	}
+	return CloudConfigFromJSON(plaintext)
+}
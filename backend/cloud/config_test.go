@@ -171,6 +171,21 @@ func TestCloudConfig_ToJSON(t *testing.T) {
 	}
 }
 
+func TestParseStoredConfig_LegacyPlaintext(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "")
+	parsed, err := ParseStoredConfig(`{"id":"aws-old","provider":"aws","aws":{"region":"eu-west-1"}}`)
+	if err != nil {
+		t.Fatalf("ParseStoredConfig() error = %v", err)
+	}
+	if parsed.ID != "aws-old" || parsed.AWS.Region != "eu-west-1" {
+		t.Errorf("Expected the plaintext row to parse as-is, got %+v", parsed)
+	}
+
+	if _, err := ParseStoredConfig("bm90LWpzb24="); err == nil {
+		t.Error("Expected ciphertext to fail without an encryption key")
+	}
+}
+
 func TestRegistry(t *testing.T) {
 	registry := NewRegistry()
 
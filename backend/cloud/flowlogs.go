@@ -0,0 +1,188 @@
+package cloud
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud/retry"
+)
+
+// S3Object is one object returned when listing a bucket
+type S3Object struct {
+	Key          string
+	LastModified time.Time
+}
+
+// S3Getter is the subset of the S3 API needed to read flow logs. Production
+// code wraps the AWS SDK client; tests supply an in-memory fake. Errors
+// worth retrying, such as SlowDown, should be marked with retry.Retryable.
+type S3Getter interface {
+	ListObjects(ctx context.Context, bucket, prefix string) ([]S3Object, error)
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+}
+
+// defaultFlowLogFields is the column order of the default (version 2) VPC
+// flow log format, used when a file has no header line
+var defaultFlowLogFields = []string{
+	"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status",
+}
+
+// flowLogDatePath matches the yyyy/mm/dd segment of a flow log object key,
+// e.g. AWSLogs/123456789012/vpcflowlogs/us-east-1/2024/01/15/...
+var flowLogDatePath = regexp.MustCompile(`/(\d{4})/(\d{2})/(\d{2})/`)
+
+// fetchFlowLogs reads every gzipped flow log object under FlowLogsBucket (a
+// bucket name, optionally followed by /prefix) covering startDate to endDate
+func (p *AWSProvider) fetchFlowLogs(ctx context.Context, startDate, endDate time.Time) ([]FlowLogEntry, error) {
+	bucketPath := p.config.FlowLogsBucket
+	bucket, prefix, _ := strings.Cut(bucketPath, "/")
+
+	var objects []S3Object
+	err := retry.Do(ctx, p.MaxAttempts, p.RetryBaseDelay, func() error {
+		var err error
+		objects, err = p.s3.ListObjects(ctx, bucket, prefix)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flow logs in %s: %w", bucketPath, err)
+	}
+
+	firstDay := startDate.UTC().Truncate(24 * time.Hour)
+	var entries []FlowLogEntry
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.Key, ".gz") {
+			continue
+		}
+		day := flowLogObjectDay(obj)
+		if day.Before(firstDay) || day.After(endDate) {
+			continue
+		}
+
+		// A download can fail mid-stream, so retries start the object over
+		var parsed []FlowLogEntry
+		err := retry.Do(ctx, p.MaxAttempts, p.RetryBaseDelay, func() error {
+			body, err := p.s3.GetObject(ctx, bucket, obj.Key)
+			if err != nil {
+				return err
+			}
+			defer body.Close()
+			parsed, err = parseGzipFlowLog(body)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", obj.Key, err)
+		}
+		for _, e := range parsed {
+			if !e.Timestamp.Before(startDate) && !e.Timestamp.After(endDate) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// flowLogObjectDay returns the UTC day an object's logs were delivered for,
+// taken from its key when it follows the AWS layout
+func flowLogObjectDay(obj S3Object) time.Time {
+	if m := flowLogDatePath.FindStringSubmatch(obj.Key); m != nil {
+		if day, err := time.Parse("2006/01/02", m[1]+"/"+m[2]+"/"+m[3]); err == nil {
+			return day
+		}
+	}
+	return obj.LastModified.UTC().Truncate(24 * time.Hour)
+}
+
+// parseGzipFlowLog decompresses and parses a flow log file as it streams
+func parseGzipFlowLog(r io.Reader) ([]FlowLogEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ParseFlowLog(gz)
+}
+
+// ParseFlowLog parses space-delimited VPC flow log records. A leading header
+// line sets the column order (so custom formats work); without one the
+// default version 2 format is assumed. NODATA/SKIPDATA records and lines
+// that don't parse are skipped.
+func ParseFlowLog(r io.Reader) ([]FlowLogEntry, error) {
+	columns := indexFlowLogFields(defaultFlowLogFields)
+	var entries []FlowLogEntry
+
+	scanner := bufio.NewScanner(r)
+	first := true
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if first && fields[0] == "version" {
+			columns = indexFlowLogFields(fields)
+			first = false
+			continue
+		}
+		first = false
+
+		if entry, ok := parseFlowLogRecord(fields, columns); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func indexFlowLogFields(names []string) map[string]int {
+	columns := make(map[string]int, len(names))
+	for i, name := range names {
+		columns[name] = i
+	}
+	return columns
+}
+
+func parseFlowLogRecord(fields []string, columns map[string]int) (FlowLogEntry, bool) {
+	if len(fields) != len(columns) {
+		return FlowLogEntry{}, false
+	}
+	get := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return fields[i]
+		}
+		return "-"
+	}
+
+	var e FlowLogEntry
+	var err error
+	e.SrcIP, e.DstIP, e.Action = get("srcaddr"), get("dstaddr"), get("action")
+	if e.SrcIP == "-" || e.DstIP == "-" {
+		return FlowLogEntry{}, false
+	}
+	if e.SrcPort, err = strconv.Atoi(get("srcport")); err != nil {
+		return FlowLogEntry{}, false
+	}
+	if e.DstPort, err = strconv.Atoi(get("dstport")); err != nil {
+		return FlowLogEntry{}, false
+	}
+	if e.Protocol, err = strconv.Atoi(get("protocol")); err != nil {
+		return FlowLogEntry{}, false
+	}
+	if e.Packets, err = strconv.ParseInt(get("packets"), 10, 64); err != nil {
+		return FlowLogEntry{}, false
+	}
+	if e.Bytes, err = strconv.ParseInt(get("bytes"), 10, 64); err != nil {
+		return FlowLogEntry{}, false
+	}
+	start, err := strconv.ParseInt(get("start"), 10, 64)
+	if err != nil {
+		return FlowLogEntry{}, false
+	}
+	e.Timestamp = time.Unix(start, 0).UTC()
+	return e, true
+}
@@ -0,0 +1,126 @@
+package cloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves gzipped objects from memory
+type fakeS3 struct {
+	objects map[string][]byte
+	bucket  string
+}
+
+func (f *fakeS3) ListObjects(ctx context.Context, bucket, prefix string) ([]S3Object, error) {
+	if bucket != f.bucket {
+		return nil, fmt.Errorf("no such bucket %q", bucket)
+	}
+	var objs []S3Object
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			objs = append(objs, S3Object{Key: key})
+		}
+	}
+	return objs, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no such key %q", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func gzipString(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip failed: %v", err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+const sampleFlowLog = `version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status
+2 123456789012 eni-0a1b2c3d 10.0.1.5 203.0.113.12 49152 443 6 20 4249 1705312800 1705312860 ACCEPT OK
+2 123456789012 eni-0a1b2c3d 203.0.113.12 10.0.1.5 443 49152 6 18 52318 1705312800 1705312860 ACCEPT OK
+2 123456789012 eni-0a1b2c3d - - - - - - - 1705312800 1705312860 - NODATA
+2 123456789012 eni-0a1b2c3d 10.0.1.5 198.51.100.7 not-a-port 22 6 1 40 1705312800 1705312860 REJECT OK
+truncated line
+`
+
+func TestAWSProvider_FetchFlowLogs(t *testing.T) {
+	s3 := &fakeS3{
+		bucket: "flow-logs",
+		objects: map[string][]byte{
+			"vpc/AWSLogs/123456789012/vpcflowlogs/us-east-1/2024/01/15/eni_20240115T1000Z.log.gz": gzipString(t, sampleFlowLog),
+			// Outside the requested range
+			"vpc/AWSLogs/123456789012/vpcflowlogs/us-east-1/2023/12/01/eni_20231201T1000Z.log.gz": gzipString(t, sampleFlowLog),
+		},
+	}
+	p, _ := NewAWSProvider("aws-prod", &AWSConfig{Region: "us-east-1", FlowLogsBucket: "flow-logs/vpc"})
+	p.SetS3Getter(s3)
+
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	entries, err := p.FetchFlowLogs(context.Background(), start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("FetchFlowLogs failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries (header, NODATA and malformed lines skipped), got %d: %+v", len(entries), entries)
+	}
+
+	want := FlowLogEntry{
+		Timestamp: time.Unix(1705312800, 0).UTC(),
+		SrcIP:     "10.0.1.5",
+		DstIP:     "203.0.113.12",
+		SrcPort:   49152,
+		DstPort:   443,
+		Bytes:     4249,
+		Packets:   20,
+		Action:    "ACCEPT",
+		Protocol:  6,
+	}
+	if entries[0] != want {
+		t.Errorf("First entry = %+v, want %+v", entries[0], want)
+	}
+	if entries[1].Bytes != 52318 || entries[1].SrcPort != 443 {
+		t.Errorf("Unexpected second entry %+v", entries[1])
+	}
+}
+
+func TestParseFlowLog_CustomFieldOrder(t *testing.T) {
+	log := "version srcaddr dstaddr bytes packets srcport dstport protocol action start\n" +
+		"5 10.0.0.1 10.0.0.2 900 3 1234 80 17 REJECT 1705312800\n"
+	entries, err := ParseFlowLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("ParseFlowLog failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Bytes != 900 || entries[0].Protocol != 17 || entries[0].Action != "REJECT" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+}
+
+func TestAWSProvider_FetchFlowLogsRequiresBucket(t *testing.T) {
+	p, _ := NewAWSProvider("aws-prod", &AWSConfig{Region: "us-east-1"})
+	p.SetS3Getter(&fakeS3{})
+	if _, err := p.FetchFlowLogs(context.Background(), time.Now(), time.Now()); !errors.Is(err, ErrNoFlowLogs) {
+		t.Errorf("Expected ErrNoFlowLogs without a flow logs bucket, got %v", err)
+	}
+}
+
+func TestAWSProvider_FetchFlowLogsWithoutS3Client(t *testing.T) {
+	p, _ := NewAWSProvider("aws-prod", &AWSConfig{Region: "us-east-1", FlowLogsBucket: "flow-logs"})
+	if _, err := p.FetchFlowLogs(context.Background(), time.Now(), time.Now()); !errors.Is(err, ErrNoFlowLogs) {
+		t.Errorf("Expected ErrNoFlowLogs without an S3 client, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package cloud
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sennet/sennet/backend/cloud/retry"
+)
+
+const (
+	// gcpTokenURL is always used for the token exchange, rather than the
+	// token_uri in the uploaded key, so a crafted key can't redirect it
+	gcpTokenURL = "https://oauth2.googleapis.com/token"
+	gcpScope    = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// gcpServiceAccount is the subset of a service account key file we use
+type gcpServiceAccount struct {
+	Type         string `json:"type"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+}
+
+// SetHTTPClient replaces the client used for Google API requests
+func (p *GCPProvider) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// TestConnection validates the service account key and exchanges a signed
+// JWT for an access token
+func (p *GCPProvider) TestConnection(ctx context.Context) error {
+	account, key, err := p.serviceAccount()
+	if err != nil {
+		return err
+	}
+	assertion, err := p.signJWT(account, key)
+	if err != nil {
+		return err
+	}
+	return retry.Do(ctx, p.MaxAttempts, p.RetryBaseDelay, func() error {
+		return p.exchangeToken(ctx, assertion)
+	})
+}
+
+// exchangeToken trades a signed assertion for an access token, marking
+// throttling and server errors retryable
+func (p *GCPProvider) exchangeToken(ctx context.Context, assertion string) error {
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gcpTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return retry.Retryable(fmt.Errorf("GCP token request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.NewDecoder(resp.Body).Decode(&token)
+	if resp.StatusCode != http.StatusOK {
+		if retry.RetryableStatus(resp.StatusCode) {
+			return retry.Retryable(fmt.Errorf("GCP token request returned %s", resp.Status))
+		}
+		if token.Error != "" {
+			return fmt.Errorf("GCP rejected service account: %s: %s", token.Error, token.ErrorDescription)
+		}
+		return fmt.Errorf("GCP token request returned %s", resp.Status)
+	}
+	if token.AccessToken == "" {
+		return errors.New("GCP token response has no access_token")
+	}
+	return nil
+}
+
+// serviceAccount loads and validates the configured service account key
+func (p *GCPProvider) serviceAccount() (*gcpServiceAccount, *rsa.PrivateKey, error) {
+	data := []byte(p.config.ServiceAccountJSON)
+	if len(data) == 0 {
+		var err error
+		if data, err = os.ReadFile(p.config.ServiceAccountFile); err != nil {
+			return nil, nil, fmt.Errorf("failed to read service account file: %w", err)
+		}
+	}
+
+	var account gcpServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, nil, fmt.Errorf("invalid service account JSON: %w", err)
+	}
+	if account.Type != "service_account" {
+		return nil, nil, fmt.Errorf("key type is %q, want service_account", account.Type)
+	}
+	if account.ClientEmail == "" {
+		return nil, nil, errors.New("service account key has no client_email")
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, nil, errors.New("service account private_key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, nil, fmt.Errorf("invalid service account private_key: %w", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("service account private_key is not an RSA key")
+	}
+	return &account, key, nil
+}
+
+// signJWT builds the RS256 assertion for the OAuth JWT bearer grant
+func (p *GCPProvider) signJWT(account *gcpServiceAccount, key *rsa.PrivateKey) (string, error) {
+	now := p.clock.Now().Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": account.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": gcpScope,
+		"aud":   gcpTokenURL,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
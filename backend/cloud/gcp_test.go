@@ -0,0 +1,126 @@
+package cloud
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServiceAccount(t *testing.T) (string, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	account, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "sennet-prod",
+		"private_key_id": "kid-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "billing@sennet-prod.iam.gserviceaccount.com",
+		"token_uri":      "https://attacker.example/token",
+	})
+	return string(account), key
+}
+
+// fakeGoogleToken grants a token for assertions signed by trusted
+func fakeGoogleToken(t *testing.T, trusted *rsa.PublicKey) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.String() != gcpTokenURL {
+			t.Errorf("Expected the Google token endpoint, got %s", req.URL)
+		}
+		req.ParseForm()
+		parts := strings.Split(req.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			return fakeResponse(http.StatusBadRequest, `{"error":"invalid_request"}`), nil
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(trusted, crypto.SHA256, digest[:], sig) != nil {
+			return fakeResponse(http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`), nil
+		}
+		return fakeResponse(http.StatusOK, `{"access_token":"ya29.token","expires_in":3599,"token_type":"Bearer"}`), nil
+	})}
+}
+
+func TestGCPProvider_TestConnection(t *testing.T) {
+	account, key := testServiceAccount(t)
+	p, _ := NewGCPProvider("gcp", &GCPConfig{ProjectID: "sennet-prod", ServiceAccountJSON: account})
+	p.SetHTTPClient(fakeGoogleToken(t, &key.PublicKey))
+	if err := p.TestConnection(context.Background()); err != nil {
+		t.Errorf("Expected a valid service account to pass, got %v", err)
+	}
+
+	// A key Google doesn't know about is rejected at the token exchange
+	other, _ := testServiceAccount(t)
+	p, _ = NewGCPProvider("gcp", &GCPConfig{ProjectID: "sennet-prod", ServiceAccountJSON: other})
+	p.SetHTTPClient(fakeGoogleToken(t, &key.PublicKey))
+	if err := p.TestConnection(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Expected token exchange rejection, got %v", err)
+	}
+}
+
+func TestGCPProvider_TestConnectionRetriesThrottling(t *testing.T) {
+	account, key := testServiceAccount(t)
+	p, _ := NewGCPProvider("gcp", &GCPConfig{ProjectID: "sennet-prod", ServiceAccountJSON: account})
+	p.RetryBaseDelay = time.Millisecond
+	granted := fakeGoogleToken(t, &key.PublicKey)
+	requests := 0
+	p.SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if requests == 1 {
+			return fakeResponse(http.StatusTooManyRequests, `{"error":"rate_limit_exceeded"}`), nil
+		}
+		return granted.Transport.RoundTrip(req)
+	})})
+	if err := p.TestConnection(context.Background()); err != nil {
+		t.Errorf("Expected the throttled token request to be retried, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 token requests, got %d", requests)
+	}
+
+	// A rejected key is not retried
+	other, _ := testServiceAccount(t)
+	p, _ = NewGCPProvider("gcp", &GCPConfig{ProjectID: "sennet-prod", ServiceAccountJSON: other})
+	requests = 0
+	p.SetHTTPClient(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return granted.Transport.RoundTrip(req)
+	})})
+	if err := p.TestConnection(context.Background()); err == nil || requests != 1 {
+		t.Errorf("Expected one rejected token request, got %d and %v", requests, err)
+	}
+}
+
+func TestGCPProvider_TestConnectionInvalidKey(t *testing.T) {
+	for name, account := range map[string]string{
+		"not json":      "{",
+		"wrong type":    `{"type":"authorized_user","client_email":"a@b","private_key":"x"}`,
+		"no email":      `{"type":"service_account","private_key":"x"}`,
+		"bad pem":       `{"type":"service_account","client_email":"a@b","private_key":"not a key"}`,
+		"missing files": "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, _ := NewGCPProvider("gcp", &GCPConfig{ProjectID: "p", ServiceAccountJSON: account, ServiceAccountFile: "/nonexistent/key.json"})
+			p.SetHTTPClient(&http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+				t.Error("Expected validation to fail before any request")
+				return fakeResponse(http.StatusOK, `{}`), nil
+			})})
+			if err := p.TestConnection(context.Background()); err == nil {
+				t.Error("Expected an invalid key to fail")
+			}
+		})
+	}
+}
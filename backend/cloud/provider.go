@@ -2,17 +2,35 @@ package cloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
+
+	"github.com/sennet/sennet/backend/clock"
 )
 
+// ErrNoFlowLogs is wrapped by FetchFlowLogs when a provider or config has
+// no flow log source, as opposed to a failed read
+var ErrNoFlowLogs = errors.New("flow logs not available")
+
 type CostResult struct {
 	Date     time.Time
 	Service  string
 	Region   string
 	CostUSD  float64
-	BytesOut int64
+	BytesOut *int64 // nil when the billing API doesn't report transfer volume
+	Tags     []CostTag
+	Currency string // Currency the provider billed in; CostUSD is converted from it
+}
+
+// CostTag is a resource tag (cost-center, team, ...) reported with a cost.
+// Weight is the share of the cost allocated to the value; zero means all of it.
+type CostTag struct {
+	Key    string
+	Value  string
+	Weight float64
 }
 
 type FlowLogEntry struct {
@@ -87,86 +105,154 @@ func CreateProvider(config *CloudConfig) (Provider, error) {
 	}
 }
 
+// Retry defaults for provider API calls
+const (
+	DefaultMaxAttempts    = 3
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+)
+
 type AWSProvider struct {
 	id     string
 	config *AWSConfig
+	s3     S3Getter
+	client *http.Client
+	clock  clock.Clock
+
+	// MaxAttempts and RetryBaseDelay control retries of throttled or
+	// failed S3 calls
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
 }
 
 func NewAWSProvider(id string, config *AWSConfig) (*AWSProvider, error) {
 	if config == nil {
 		return nil, fmt.Errorf("AWS config is nil")
 	}
-	return &AWSProvider{id: id, config: config}, nil
+	return &AWSProvider{
+		id:             id,
+		config:         config,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		clock:          clock.Real,
+		MaxAttempts:    DefaultMaxAttempts,
+		RetryBaseDelay: DefaultRetryBaseDelay,
+	}, nil
 }
 
 func (p *AWSProvider) Name() ProviderType {
 	return ProviderAWS
 }
 
+// SetClock replaces the time source used to sign requests
+func (p *AWSProvider) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
 func (p *AWSProvider) FetchCosts(ctx context.Context, startDate, endDate time.Time) ([]CostResult, error) {
 	return nil, fmt.Errorf("AWS Cost Explorer not implemented - requires aws-sdk-go-v2")
 }
 
-func (p *AWSProvider) FetchFlowLogs(ctx context.Context, startDate, endDate time.Time) ([]FlowLogEntry, error) {
-	return nil, fmt.Errorf("AWS Flow Logs not implemented - requires aws-sdk-go-v2")
+// SetS3Getter sets the S3 client used to read flow logs from FlowLogsBucket.
+// Without one, FetchFlowLogs reports ErrNoFlowLogs.
+func (p *AWSProvider) SetS3Getter(s3 S3Getter) {
+	p.s3 = s3
 }
 
-func (p *AWSProvider) TestConnection(ctx context.Context) error {
-	return nil
+// FetchFlowLogs downloads and parses the VPC flow logs delivered to
+// FlowLogsBucket between startDate and endDate
+func (p *AWSProvider) FetchFlowLogs(ctx context.Context, startDate, endDate time.Time) ([]FlowLogEntry, error) {
+	if p.config.FlowLogsBucket == "" {
+		return nil, fmt.Errorf("%w: AWS config %s has no flow_logs_bucket", ErrNoFlowLogs, p.id)
+	}
+	if p.s3 == nil {
+		// No S3 client is linked in yet (it requires aws-sdk-go-v2), so the
+		// bucket can't be read; SetS3Getter supplies one
+		return nil, fmt.Errorf("%w: no S3 client configured to read %s", ErrNoFlowLogs, p.config.FlowLogsBucket)
+	}
+	return p.fetchFlowLogs(ctx, startDate, endDate)
 }
 
 type AzureProvider struct {
 	id     string
 	config *AzureConfig
+	client *http.Client
+	clock  clock.Clock
+
+	// MaxAttempts and RetryBaseDelay control retries of throttled or
+	// failed Cost Management calls
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
 }
 
 func NewAzureProvider(id string, config *AzureConfig) (*AzureProvider, error) {
 	if config == nil {
 		return nil, fmt.Errorf("Azure config is nil")
 	}
-	return &AzureProvider{id: id, config: config}, nil
+	return &AzureProvider{
+		id:             id,
+		config:         config,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		clock:          clock.Real,
+		MaxAttempts:    DefaultMaxAttempts,
+		RetryBaseDelay: DefaultRetryBaseDelay,
+	}, nil
 }
 
 func (p *AzureProvider) Name() ProviderType {
 	return ProviderAzure
 }
 
-func (p *AzureProvider) FetchCosts(ctx context.Context, startDate, endDate time.Time) ([]CostResult, error) {
-	return nil, fmt.Errorf("Azure Cost Management not implemented - requires azure-sdk-for-go")
+// SetClock replaces the time source used for access token expiry
+func (p *AzureProvider) SetClock(c clock.Clock) {
+	p.clock = c
 }
 
 func (p *AzureProvider) FetchFlowLogs(ctx context.Context, startDate, endDate time.Time) ([]FlowLogEntry, error) {
-	return nil, fmt.Errorf("Azure NSG Flow Logs not implemented")
-}
-
-func (p *AzureProvider) TestConnection(ctx context.Context) error {
-	return nil
+	return nil, fmt.Errorf("%w: Azure NSG Flow Logs not implemented", ErrNoFlowLogs)
 }
 
 type GCPProvider struct {
 	id     string
 	config *GCPConfig
+	client *http.Client
+	clock  clock.Clock
+
+	// MaxAttempts and RetryBaseDelay control retries of throttled or
+	// failed token requests
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
 }
 
 func NewGCPProvider(id string, config *GCPConfig) (*GCPProvider, error) {
 	if config == nil {
 		return nil, fmt.Errorf("GCP config is nil")
 	}
-	return &GCPProvider{id: id, config: config}, nil
+	return &GCPProvider{
+		id:             id,
+		config:         config,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		clock:          clock.Real,
+		MaxAttempts:    DefaultMaxAttempts,
+		RetryBaseDelay: DefaultRetryBaseDelay,
+	}, nil
 }
 
 func (p *GCPProvider) Name() ProviderType {
 	return ProviderGCP
 }
 
+// SetClock replaces the time source used to sign token requests
+func (p *GCPProvider) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
 func (p *GCPProvider) FetchCosts(ctx context.Context, startDate, endDate time.Time) ([]CostResult, error) {
 	return nil, fmt.Errorf("GCP Billing API not implemented - requires google-cloud-go")
 }
 
 func (p *GCPProvider) FetchFlowLogs(ctx context.Context, startDate, endDate time.Time) ([]FlowLogEntry, error) {
-	return nil, fmt.Errorf("GCP VPC Flow Logs not implemented")
-}
-
-func (p *GCPProvider) TestConnection(ctx context.Context) error {
-	return nil
+	return nil, fmt.Errorf("%w: GCP VPC Flow Logs not implemented", ErrNoFlowLogs)
 }
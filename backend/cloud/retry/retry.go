@@ -0,0 +1,82 @@
+// Package retry retries calls to cloud provider APIs that fail transiently,
+// such as throttling (429) and server errors
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// retryableError marks an error as worth retrying
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as transient so Do tries again. A nil err stays nil.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether err was marked Retryable or is a network timeout
+func IsRetryable(err error) bool {
+	var marked *retryableError
+	if errors.As(err, &marked) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryableStatus reports whether an HTTP status is worth retrying:
+// throttling and server-side failures
+func RetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Do calls fn up to attempts times, stopping at the first success or
+// non-retryable error. Retries wait a jittered exponential backoff starting
+// at baseDelay; cancelling ctx stops the wait and returns ctx's error.
+func Do(ctx context.Context, attempts int, baseDelay time.Duration, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff(baseDelay, attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		if err = fn(); err == nil || !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff returns the wait before the given retry: baseDelay doubled per
+// attempt, with the upper half randomised so clients don't retry in step
+func backoff(baseDelay time.Duration, attempt int) time.Duration {
+	d := baseDelay << (attempt - 1)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,81 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDo_TransientErrorSucceedsOnThirdAttempt(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), 5, time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return Retryable(errors.New("429 Too Many Requests"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestDo_NonRetryableFailsImmediately(t *testing.T) {
+	calls := 0
+	denied := errors.New("403 Forbidden")
+	err := Do(context.Background(), 5, time.Millisecond, func() error {
+		calls++
+		return denied
+	})
+	if !errors.Is(err, denied) {
+		t.Errorf("Expected the original error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected a single attempt, got %d", calls)
+	}
+}
+
+func TestDo_GivesUpAfterAttempts(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		return Retryable(errors.New("503 Service Unavailable"))
+	})
+	if err == nil || !IsRetryable(err) {
+		t.Errorf("Expected the last retryable error, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestDo_StopsWhenContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Do(ctx, 5, time.Hour, func() error {
+		calls++
+		cancel()
+		return Retryable(errors.New("throttled"))
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no retry after cancellation, got %d attempts", calls)
+	}
+}
+
+func TestBackoff_GrowsWithJitter(t *testing.T) {
+	for attempt := 1; attempt <= 4; attempt++ {
+		full := 100 * time.Millisecond << (attempt - 1)
+		for i := 0; i < 20; i++ {
+			if d := backoff(100*time.Millisecond, attempt); d < full/2 || d > full {
+				t.Fatalf("backoff(attempt %d) = %s, want within [%s, %s]", attempt, d, full/2, full)
+			}
+		}
+	}
+}
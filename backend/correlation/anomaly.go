@@ -0,0 +1,113 @@
+package correlation
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// MinAnomalyBaselineDays is how many earlier days a service needs before its
+// costs are checked for anomalies. Services with less history are skipped.
+const MinAnomalyBaselineDays = 3
+
+// anomalyWindow is how many preceding normal days form a day's baseline
+const anomalyWindow = 7
+
+// Anomaly is a day on which a service's egress cost exceeded its baseline
+type Anomaly struct {
+	Service        string  `json:"service"`
+	Date           string  `json:"date"`
+	CostUSD        float64 `json:"cost_usd"`
+	BaselineMean   float64 `json:"baseline_mean_usd"`
+	BaselineStddev float64 `json:"baseline_stddev_usd"`
+}
+
+// DetectAnomalies returns the days in the last lookbackDays on which a
+// service's total daily cost exceeded the mean plus stddevThreshold standard
+// deviations of its preceding days (up to a week). The baseline reaches back
+// before the lookback window and leaves out days already flagged, so a
+// sustained spike doesn't raise its own baseline. Days without a row count
+// as zero cost once a service has appeared. Results are ordered by date then
+// service.
+func (e *Engine) DetectAnomalies(lookbackDays int, stddevThreshold float64) ([]Anomaly, error) {
+	end := time.Now().UTC()
+	start := end.AddDate(0, 0, -lookbackDays).Format("2006-01-02")
+	historyStart := end.AddDate(0, 0, -lookbackDays-anomalyWindow)
+	costs, err := e.database.GetEgressCosts(historyStart.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	byService := make(map[string]map[string]float64)
+	for _, c := range costs {
+		if byService[c.Service] == nil {
+			byService[c.Service] = make(map[string]float64)
+		}
+		byService[c.Service][c.Date] += c.CostUSD
+	}
+
+	anomalies := []Anomaly{}
+	for service, daily := range byService {
+		days := make([]string, 0, len(daily))
+		for d := range daily {
+			days = append(days, d)
+		}
+		sort.Strings(days)
+		first, err := time.Parse("2006-01-02", days[0])
+		if err != nil {
+			continue
+		}
+
+		// Costs for every day from the service's first row, gaps as zero
+		var series []float64
+		var dates []string
+		for d := first; d.Format("2006-01-02") <= days[len(days)-1]; d = d.AddDate(0, 0, 1) {
+			date := d.Format("2006-01-02")
+			series = append(series, daily[date])
+			dates = append(dates, date)
+		}
+
+		// Costs of the days not flagged, which later days are measured against
+		var normal []float64
+		for i := range series {
+			if len(normal) < MinAnomalyBaselineDays {
+				normal = append(normal, series[i])
+				continue
+			}
+			mean, stddev := meanStddev(normal[max(0, len(normal)-anomalyWindow):])
+			if series[i] <= mean || series[i] <= mean+stddevThreshold*stddev {
+				normal = append(normal, series[i])
+				continue
+			}
+			if dates[i] >= start {
+				anomalies = append(anomalies, Anomaly{
+					Service:        service,
+					Date:           dates[i],
+					CostUSD:        series[i],
+					BaselineMean:   mean,
+					BaselineStddev: stddev,
+				})
+			}
+		}
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].Date != anomalies[j].Date {
+			return anomalies[i].Date < anomalies[j].Date
+		}
+		return anomalies[i].Service < anomalies[j].Service
+	})
+	return anomalies, nil
+}
+
+// meanStddev returns the mean and population standard deviation of values
+func meanStddev(values []float64) (mean, stddev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stddev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(values)))
+}
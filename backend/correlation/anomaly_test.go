@@ -0,0 +1,91 @@
+package correlation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+func daysAgo(n int) string {
+	return time.Now().UTC().AddDate(0, 0, -n).Format("2006-01-02")
+}
+
+func TestDetectAnomalies_FlagsSpike(t *testing.T) {
+	database := setupTestDB(t)
+	baseline := []float64{10, 11, 9, 10, 12, 10, 9}
+	for i, cost := range baseline {
+		database.SaveEgressCost("aws", daysAgo(len(baseline)-i), "AmazonEC2", "us-east-1", cost, nil)
+	}
+	database.SaveEgressCost("aws", daysAgo(0), "AmazonEC2", "us-east-1", 50, nil)
+
+	anomalies, err := NewEngine(database, cloud.NewRegistry()).DetectAnomalies(30, 3)
+	if err != nil {
+		t.Fatalf("DetectAnomalies failed: %v", err)
+	}
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %+v", anomalies)
+	}
+	a := anomalies[0]
+	if a.Service != "AmazonEC2" || a.Date != daysAgo(0) || a.CostUSD != 50 {
+		t.Errorf("Expected today's EC2 spike, got %+v", a)
+	}
+	if a.BaselineMean < 9 || a.BaselineMean > 12 {
+		t.Errorf("Expected a baseline mean around 10, got %.2f", a.BaselineMean)
+	}
+}
+
+func TestDetectAnomalies_FlatData(t *testing.T) {
+	database := setupTestDB(t)
+	for i := 0; i < 10; i++ {
+		database.SaveEgressCost("aws", daysAgo(i), "AmazonS3", "us-east-1", 20, nil)
+	}
+
+	anomalies, err := NewEngine(database, cloud.NewRegistry()).DetectAnomalies(30, 3)
+	if err != nil {
+		t.Fatalf("DetectAnomalies failed: %v", err)
+	}
+	if len(anomalies) != 0 {
+		t.Errorf("Expected no anomalies for flat costs, got %+v", anomalies)
+	}
+}
+
+func TestDetectAnomalies_ColdStart(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", daysAgo(2), "AmazonEC2", "us-east-1", 1, nil)
+	database.SaveEgressCost("aws", daysAgo(1), "AmazonEC2", "us-east-1", 1, nil)
+	database.SaveEgressCost("aws", daysAgo(0), "AmazonEC2", "us-east-1", 100, nil)
+
+	anomalies, err := NewEngine(database, cloud.NewRegistry()).DetectAnomalies(30, 3)
+	if err != nil {
+		t.Fatalf("DetectAnomalies failed: %v", err)
+	}
+	if len(anomalies) != 0 {
+		t.Errorf("Expected no anomalies with only %d days of history, got %+v", 2, anomalies)
+	}
+}
+
+func TestDetectAnomalies_SustainedSpike(t *testing.T) {
+	database := setupTestDB(t)
+	// A week of normal costs before the lookback window, then a spike for
+	// every day inside it
+	for i := 10; i > 3; i-- {
+		database.SaveEgressCost("aws", daysAgo(i), "AmazonEC2", "us-east-1", 10+float64(i%2), nil)
+	}
+	for i := 3; i >= 0; i-- {
+		database.SaveEgressCost("aws", daysAgo(i), "AmazonEC2", "us-east-1", 60, nil)
+	}
+
+	anomalies, err := NewEngine(database, cloud.NewRegistry()).DetectAnomalies(3, 3)
+	if err != nil {
+		t.Fatalf("DetectAnomalies failed: %v", err)
+	}
+	if len(anomalies) != 4 {
+		t.Fatalf("Expected every spiked day in the window flagged, got %+v", anomalies)
+	}
+	for _, a := range anomalies {
+		if a.BaselineMean > 11 {
+			t.Errorf("Expected the spike left out of the baseline, got mean %.2f on %s", a.BaselineMean, a.Date)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package correlation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSavingsBaselines parses a comma-separated list of provider=discount
+// pairs (e.g. "aws=0.28,gcp=0.2"). Each discount is the fraction actual
+// costs sit below on-demand pricing under a savings plan or committed-use
+// agreement, and must be in [0, 1).
+func ParseSavingsBaselines(spec string) (map[string]float64, error) {
+	baselines := make(map[string]float64)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		provider, value, ok := strings.Cut(pair, "=")
+		provider = strings.TrimSpace(provider)
+		if !ok || provider == "" {
+			return nil, fmt.Errorf("invalid baseline %q: want provider=discount", pair)
+		}
+		discount, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid discount for %s: %w", provider, err)
+		}
+		if discount < 0 || discount >= 1 {
+			return nil, fmt.Errorf("invalid discount for %s: %v must be at least 0 and below 1", provider, discount)
+		}
+		baselines[provider] = discount
+	}
+	return baselines, nil
+}
@@ -0,0 +1,61 @@
+package correlation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+func TestGetCostSummary_RealizedSavings(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 75, nil)
+	database.SaveEgressCost("gcp", "2024-01-10", "Compute", "us-central1", 20, nil)
+	database.SaveRecommendation("cdn", "Serve static assets from a CDN", 12)
+
+	e := NewEngine(database, cloud.NewRegistry())
+	e.SetSavingsBaselines(map[string]float64{"aws": 0.25})
+
+	summary, err := e.GetCostSummary("2024-01-01", "2024-01-31")
+	if err != nil {
+		t.Fatalf("GetCostSummary failed: %v", err)
+	}
+
+	// $75 at a 25% discount is $100 on demand; gcp has no baseline
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	if !near(summary.RealizedSavingsUSD, 25) {
+		t.Errorf("Expected realized savings of 25, got %v", summary.RealizedSavingsUSD)
+	}
+	if !near(summary.OnDemandEquivalentUSD, 120) {
+		t.Errorf("Expected on-demand equivalent of 120, got %v", summary.OnDemandEquivalentUSD)
+	}
+	if _, ok := summary.RealizedSavingsByProvider["gcp"]; ok {
+		t.Error("Expected no realized savings for a provider without a baseline")
+	}
+	if summary.PotentialSavingsUSD != 12 {
+		t.Errorf("Expected potential savings from open recommendations (12), got %v", summary.PotentialSavingsUSD)
+	}
+
+	// Without baselines nothing is realized
+	e.SetSavingsBaselines(nil)
+	summary, _ = e.GetCostSummary("2024-01-01", "2024-01-31")
+	if summary.RealizedSavingsUSD != 0 || summary.OnDemandEquivalentUSD != summary.TotalCostUSD {
+		t.Errorf("Expected no realized savings without baselines, got %+v", summary)
+	}
+}
+
+func TestParseSavingsBaselines(t *testing.T) {
+	got, err := ParseSavingsBaselines("aws=0.28, gcp=0")
+	if err != nil {
+		t.Fatalf("ParseSavingsBaselines failed: %v", err)
+	}
+	if got["aws"] != 0.28 || got["gcp"] != 0 || len(got) != 2 {
+		t.Errorf("Unexpected baselines %v", got)
+	}
+
+	for _, spec := range []string{"aws", "=0.1", "aws=x", "aws=1", "aws=-0.1"} {
+		if _, err := ParseSavingsBaselines(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package correlation
+
+import (
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+)
+
+// BudgetAlert is a provider whose month-to-date spend, projected linearly to
+// the end of the month, exceeds its monthly budget
+type BudgetAlert struct {
+	Provider        string  `json:"provider"`
+	BudgetUSD       float64 `json:"budget_usd"`
+	MonthToDateUSD  float64 `json:"month_to_date_usd"`
+	ProjectedUSD    float64 `json:"projected_usd"`
+	PercentOfBudget float64 `json:"percent_of_budget"`
+}
+
+// CheckBudgets sums each budgeted provider's costs from the first of asOf's
+// month through asOf, projects that daily rate over the whole month and
+// returns an alert for every provider projected over budget, ordered by
+// provider. Months are calendar months in UTC.
+func (e *Engine) CheckBudgets(asOf time.Time) ([]BudgetAlert, error) {
+	budgets, err := e.database.GetBudgets()
+	if err != nil {
+		return nil, err
+	}
+
+	asOf = asOf.UTC()
+	monthStart := time.Date(asOf.Year(), asOf.Month(), 1, 0, 0, 0, 0, time.UTC)
+	daysInMonth := monthStart.AddDate(0, 1, -1).Day()
+	elapsed := asOf.Day()
+
+	spend := make(map[string]float64)
+	err = e.database.ForEachEgressCost(monthStart.Format("2006-01-02"), asOf.Format("2006-01-02"), func(c db.EgressCost) error {
+		spend[c.Provider] += c.CostUSD
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []BudgetAlert{}
+	for _, b := range budgets {
+		mtd := spend[b.Provider]
+		projected := mtd / float64(elapsed) * float64(daysInMonth)
+		if projected <= b.MonthlyUSD {
+			continue
+		}
+		alerts = append(alerts, BudgetAlert{
+			Provider:        b.Provider,
+			BudgetUSD:       b.MonthlyUSD,
+			MonthToDateUSD:  mtd,
+			ProjectedUSD:    projected,
+			PercentOfBudget: projected / b.MonthlyUSD * 100,
+		})
+	}
+	return alerts, nil
+}
@@ -0,0 +1,54 @@
+package correlation
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+func TestCheckBudgets_ProjectsOverBudget(t *testing.T) {
+	database := setupTestDB(t)
+	// $30/day for the first ten days of a 30-day month projects to $900
+	for day := 1; day <= 10; day++ {
+		date := time.Date(2024, 6, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+		database.SaveEgressCost("aws", date, "AmazonEC2", "us-east-1", 20, nil)
+		database.SaveEgressCost("aws", date, "AmazonS3", "us-east-1", 10, nil)
+		database.SaveEgressCost("gcp", date, "Compute", "us-central1", 5, nil)
+	}
+	// Last month's spend doesn't count
+	database.SaveEgressCost("aws", "2024-05-31", "AmazonEC2", "us-east-1", 1000, nil)
+
+	database.SaveBudget("aws", 600)
+	database.SaveBudget("gcp", 500) // Projects to $150
+	database.SaveBudget("azure", 100)
+
+	alerts, err := NewEngine(database, cloud.NewRegistry()).CheckBudgets(time.Date(2024, 6, 10, 15, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("CheckBudgets failed: %v", err)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("Expected only aws over budget, got %+v", alerts)
+	}
+	a := alerts[0]
+	if a.Provider != "aws" || a.BudgetUSD != 600 || a.MonthToDateUSD != 300 {
+		t.Errorf("Unexpected alert %+v", a)
+	}
+	if math.Abs(a.ProjectedUSD-900) > 1e-9 || math.Abs(a.PercentOfBudget-150) > 1e-9 {
+		t.Errorf("Expected $900 projected (150%%), got $%.2f (%.1f%%)", a.ProjectedUSD, a.PercentOfBudget)
+	}
+}
+
+func TestCheckBudgets_NoBudgets(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-06-01", "AmazonEC2", "us-east-1", 1000, nil)
+
+	alerts, err := NewEngine(database, cloud.NewRegistry()).CheckBudgets(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("CheckBudgets failed: %v", err)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("Expected no alerts, got %+v", alerts)
+	}
+}
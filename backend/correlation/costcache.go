@@ -0,0 +1,74 @@
+package correlation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+// DefaultCostCacheTTL is how long fetched provider costs are reused by later syncs
+const DefaultCostCacheTTL = 15 * time.Minute
+
+// costCacheKey identifies one provider fetch. Dates are whole days, so syncs
+// started minutes apart share an entry.
+type costCacheKey struct {
+	providerID string
+	startDate  string
+	endDate    string
+}
+
+type costCacheEntry struct {
+	provider  cloud.Provider // A re-registered provider misses, e.g. after a config change
+	costs     []cloud.CostResult
+	fetchedAt time.Time
+}
+
+// costCache holds successful provider fetches for a TTL. It is safe for
+// concurrent use.
+type costCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[costCacheKey]costCacheEntry
+}
+
+func newCostCache(ttl time.Duration) *costCache {
+	return &costCache{ttl: ttl, entries: make(map[costCacheKey]costCacheEntry)}
+}
+
+func newCostCacheKey(providerID string, start, end time.Time) costCacheKey {
+	return costCacheKey{providerID, start.Format("2006-01-02"), end.Format("2006-01-02")}
+}
+
+func (c *costCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.entries = make(map[costCacheKey]costCacheEntry)
+}
+
+// get returns the cached costs for key if they were fetched from provider
+// within the TTL of now
+func (c *costCache) get(key costCacheKey, provider cloud.Provider, now time.Time) (costCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.provider != provider || now.Sub(entry.fetchedAt) >= c.ttl {
+		return costCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *costCache) put(key costCacheKey, entry costCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	for k, e := range c.entries {
+		if entry.fetchedAt.Sub(e.fetchedAt) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
+}
@@ -0,0 +1,65 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+func TestSyncCosts_CachesProviderResults(t *testing.T) {
+	database := setupTestDB(t)
+	provider := &stubProvider{costs: []cloud.CostResult{
+		{Date: time.Now(), Service: "AmazonEC2", Region: "us-east-1", CostUSD: 1},
+	}}
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", provider)
+	e := NewEngine(database, registry)
+
+	for i := 0; i < 2; i++ {
+		if err := e.SyncCosts(context.Background(), 30); err != nil {
+			t.Fatalf("SyncCosts failed: %v", err)
+		}
+	}
+	if n := provider.fetches.Load(); n != 1 {
+		t.Errorf("Expected a second sync within the TTL to use the cache, got %d fetches", n)
+	}
+
+	if err := e.ForceSyncCosts(context.Background(), 30); err != nil {
+		t.Fatalf("ForceSyncCosts failed: %v", err)
+	}
+	if n := provider.fetches.Load(); n != 2 {
+		t.Errorf("Expected a forced sync to fetch, got %d fetches", n)
+	}
+
+	// A re-registered provider (e.g. after a config change) isn't served stale results
+	replacement := &stubProvider{}
+	registry.Register("aws-main", replacement)
+	e.SyncCosts(context.Background(), 30)
+	if n := replacement.fetches.Load(); n != 1 {
+		t.Errorf("Expected the replacement provider to be fetched, got %d fetches", n)
+	}
+
+	e.SetCostCacheTTL(0)
+	e.SyncCosts(context.Background(), 30)
+	e.SyncCosts(context.Background(), 30)
+	if n := replacement.fetches.Load(); n != 3 {
+		t.Errorf("Expected every sync to fetch with the cache disabled, got %d fetches", n)
+	}
+}
+
+func TestSyncCosts_DoesNotCacheFailures(t *testing.T) {
+	database := setupTestDB(t)
+	provider := &stubProvider{err: errors.New("throttled")}
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", provider)
+	e := NewEngine(database, registry)
+
+	e.SyncCosts(context.Background(), 30)
+	e.SyncCosts(context.Background(), 30)
+	if n := provider.fetches.Load(); n != 2 {
+		t.Errorf("Expected a failed fetch to be retried on the next sync, got %d fetches", n)
+	}
+}
@@ -1,23 +1,141 @@
 package correlation
 
 import (
+	"container/list"
 	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sennet/sennet/backend/cloud"
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
+	"golang.org/x/sync/errgroup"
 )
 
+// DefaultStaleAfter is how old synced cost data may get before it is reported stale
+const DefaultStaleAfter = 24 * time.Hour
+
+// DefaultSyncConcurrency is how many providers SyncCosts fetches at once
+const DefaultSyncConcurrency = 4
+
+// CostMetricsWindowDays is how many days back the provider cost gauge
+// totals, so it keeps one meaning however many days each sync fetches
+const CostMetricsWindowDays = 30
+
+// maxCachedSummaries bounds the periods GetCostSummary keeps cached, as
+// periods come straight from request parameters. The least recently used
+// period is dropped to make room.
+const maxCachedSummaries = 64
+
 type Engine struct {
-	database *db.DB
-	registry *cloud.Registry
+	database        *db.DB
+	registry        *cloud.Registry
+	staleAfter      time.Duration
+	syncConcurrency int
+	costs           *costCache
+
+	// summaries caches GetCostSummary results by period, most recently
+	// used first in summaryLRU. It is cleared on every cost write;
+	// generation guards against caching a summary computed from data that
+	// changed mid-read.
+	mu          sync.Mutex
+	summaries   map[string]*list.Element // Of summaryLRU, holding *cachedSummary
+	summaryLRU  *list.List
+	generation  uint64
+	baselines   map[string]float64 // Savings plan discount by provider
+	unsubscribe func()
+
+	prom *metrics.Metrics // nil records into metrics.Default()
+}
+
+type cachedSummary struct {
+	key     string
+	summary *CostSummary
 }
 
 func NewEngine(database *db.DB, registry *cloud.Registry) *Engine {
-	return &Engine{
-		database: database,
-		registry: registry,
+	e := &Engine{
+		database:        database,
+		registry:        registry,
+		staleAfter:      DefaultStaleAfter,
+		syncConcurrency: DefaultSyncConcurrency,
+		costs:           newCostCache(DefaultCostCacheTTL),
+		summaries:       make(map[string]*list.Element),
+		summaryLRU:      list.New(),
 	}
+	e.unsubscribe = database.OnCostChange(e.invalidateSummaries)
+	return e
+}
+
+// Close stops the engine listening for cost changes on its database. An
+// engine mustn't be used after Close; engines living as long as their
+// database needn't be closed.
+func (e *Engine) Close() {
+	e.unsubscribe()
+}
+
+// SetMetrics records the cost gauges into m instead of metrics.Default()
+func (e *Engine) SetMetrics(m *metrics.Metrics) {
+	e.prom = m
+}
+
+func (e *Engine) metrics() *metrics.Metrics {
+	if e.prom == nil {
+		return metrics.Default()
+	}
+	return e.prom
+}
+
+func (e *Engine) invalidateSummaries() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clearSummariesLocked()
+}
+
+func (e *Engine) clearSummariesLocked() {
+	e.summaries = make(map[string]*list.Element)
+	e.summaryLRU.Init()
+	e.generation++
+}
+
+// SetStaleThreshold sets how old synced cost data may get before it is reported stale
+func (e *Engine) SetStaleThreshold(d time.Duration) {
+	e.staleAfter = d
+}
+
+// SetSyncConcurrency sets how many providers SyncCosts fetches at once
+func (e *Engine) SetSyncConcurrency(n int) {
+	e.syncConcurrency = n
+}
+
+// SetCostCacheTTL sets how long fetched provider costs are reused by later
+// syncs. Zero or less disables the cache. Existing entries are dropped.
+func (e *Engine) SetCostCacheTTL(ttl time.Duration) {
+	e.costs.setTTL(ttl)
+}
+
+// SetSavingsBaselines sets the committed-use discount for each provider, as
+// parsed by ParseSavingsBaselines. Providers without one are assumed to pay
+// on-demand prices.
+func (e *Engine) SetSavingsBaselines(baselines map[string]float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.baselines = baselines
+	e.clearSummariesLocked()
+}
+
+// ProviderFreshness reports when costs were last synced from a cloud config.
+// LastSyncedAt and AgeSeconds are nil if the config has never synced.
+type ProviderFreshness struct {
+	ID           string     `json:"id"`
+	Provider     string     `json:"provider"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+	AgeSeconds   *float64   `json:"age_seconds"`
+	Stale        bool       `json:"stale"`
 }
 
 type CostSummary struct {
@@ -26,39 +144,305 @@ type CostSummary struct {
 	ByService    map[string]float64 `json:"by_service"`
 	ByRegion     map[string]float64 `json:"by_region"`
 	Period       string             `json:"period"`
+
+	// OnDemandEquivalentUSD is what the period would have cost without
+	// savings plans; RealizedSavingsUSD is the difference from the total.
+	// Providers without a configured baseline count at their actual cost.
+	OnDemandEquivalentUSD     float64            `json:"on_demand_equivalent_usd"`
+	RealizedSavingsUSD        float64            `json:"realized_savings_usd"`
+	RealizedSavingsByProvider map[string]float64 `json:"realized_savings_by_provider"`
+
+	// PotentialSavingsUSD is the estimated savings of open recommendations
+	PotentialSavingsUSD float64 `json:"potential_savings_usd"`
+}
+
+// SyncError reports the providers that failed during SyncCosts, keyed by
+// cloud config ID. Costs from every other provider were still saved.
+type SyncError struct {
+	Failed map[string]error
 }
 
+func (e *SyncError) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for id := range e.Failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%s: %v", id, e.Failed[id])
+	}
+	return fmt.Sprintf("cost sync failed for %d provider(s): %s", len(ids), strings.Join(parts, "; "))
+}
+
+func (e *SyncError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// SyncCosts fetches the last days of costs from every registered provider,
+// at most syncConcurrency at a time, and records the run. Providers fetched
+// within the cost cache TTL are served from the cache. If any provider fails
+// the returned error is a *SyncError; the others' costs are saved.
 func (e *Engine) SyncCosts(ctx context.Context, days int) error {
+	return e.SyncCostsWithProgress(ctx, days, false, nil)
+}
+
+// ForceSyncCosts is SyncCosts bypassing the cost cache. The fresh results
+// replace any cached ones.
+func (e *Engine) ForceSyncCosts(ctx context.Context, days int) error {
+	return e.SyncCostsWithProgress(ctx, days, true, nil)
+}
+
+// Sync progress stages, in the order a provider passes through them. Each
+// provider ends with either SyncCompleted or SyncFailed.
+const (
+	SyncStarted   = "started"
+	SyncFetched   = "fetched"
+	SyncCompleted = "completed"
+	SyncFailed    = "failed"
+)
+
+// SyncProgress reports one provider reaching a stage of a sync. Rows is the
+// number fetched (SyncFetched) or saved (SyncCompleted).
+type SyncProgress struct {
+	Provider string `json:"provider"`
+	Stage    string `json:"stage"`
+	Rows     int    `json:"rows,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SyncCostsWithProgress is SyncCosts (or ForceSyncCosts if force is set)
+// reporting each provider's progress to fn. Calls to fn are serialized but
+// providers run concurrently, so stages of different providers interleave.
+// fn may be nil.
+func (e *Engine) SyncCostsWithProgress(ctx context.Context, days int, force bool, fn func(SyncProgress)) error {
+	var progressMu sync.Mutex
+	report := func(p SyncProgress) {
+		if fn == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		fn(p)
+	}
+
 	endDate := time.Now()
 	startDate := endDate.AddDate(0, 0, -days)
 
+	run := db.SyncRun{
+		StartedAt:      time.Now(),
+		ProviderCounts: make(map[string]int),
+		Errors:         make(map[string]string),
+	}
+	failed := make(map[string]error)
+	var mu sync.Mutex     // Guards run and failed
+	var saveMu sync.Mutex // SQLite takes one writer at a time, so only fetches overlap
+
+	var g errgroup.Group
+	if e.syncConcurrency > 0 {
+		g.SetLimit(e.syncConcurrency)
+	}
 	for _, id := range e.registry.List() {
 		provider, ok := e.registry.Get(id)
 		if !ok {
 			continue
 		}
 
+		g.Go(func() error {
+			report(SyncProgress{Provider: id, Stage: SyncStarted})
+			saved, err := e.syncProvider(ctx, id, provider, startDate, endDate, force, &saveMu, report)
+			if err != nil {
+				report(SyncProgress{Provider: id, Stage: SyncFailed, Rows: saved, Error: err.Error()})
+			} else {
+				report(SyncProgress{Provider: id, Stage: SyncCompleted, Rows: saved})
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if saved > 0 || err == nil {
+				run.ProviderCounts[id] = saved
+			}
+			if err != nil {
+				run.Errors[id] = err.Error()
+				failed[id] = err
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	run.FinishedAt = time.Now()
+	if _, err := e.database.SaveSyncRun(run); err != nil {
+		return err
+	}
+	if err := e.RefreshFreshnessMetrics(); err != nil {
+		return err
+	}
+	windowStart := endDate.AddDate(0, 0, -CostMetricsWindowDays)
+	if err := e.RefreshCostMetrics(windowStart.Format("2006-01-02"), endDate.Format("2006-01-02")); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return &SyncError{Failed: failed}
+	}
+	return nil
+}
+
+// syncProvider fetches and saves one provider's costs, returning how many
+// rows were saved. A row that fails to save doesn't stop the rest; the last
+// such error is returned alongside the count. Saving holds saveMu.
+func (e *Engine) syncProvider(ctx context.Context, id string, provider cloud.Provider, startDate, endDate time.Time, force bool, saveMu *sync.Mutex, report func(SyncProgress)) (int, error) {
+	key := newCostCacheKey(id, startDate, endDate)
+	cached, ok := e.costs.get(key, provider, time.Now())
+	if force || !ok {
 		costs, err := provider.FetchCosts(ctx, startDate, endDate)
 		if err != nil {
+			return 0, err
+		}
+		cached = costCacheEntry{provider: provider, costs: costs, fetchedAt: time.Now()}
+		e.costs.put(key, cached)
+	}
+	costs, syncedAt := cached.costs, cached.fetchedAt
+	report(SyncProgress{Provider: id, Stage: SyncFetched, Rows: len(costs)})
+
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	saved := 0
+	var saveErr error
+	for _, cost := range costs {
+		tags := make([]db.CostTag, len(cost.Tags))
+		for i, t := range cost.Tags {
+			tags[i] = db.CostTag{Key: t.Key, Value: t.Value, Weight: t.Weight}
+		}
+		err := e.database.SaveAccountEgressCost(
+			id,
+			string(provider.Name()),
+			cost.Date.Format("2006-01-02"),
+			cost.Service,
+			cost.Region,
+			cost.CostUSD,
+			cost.BytesOut,
+			tags,
+		)
+		if err != nil {
+			saveErr = err
 			continue
 		}
+		saved++
+	}
+	e.database.MarkCloudSynced(id, syncedAt)
+	return saved, saveErr
+}
+
+// Freshness reports the age of synced cost data for every cloud config
+func (e *Engine) Freshness() ([]ProviderFreshness, error) {
+	configs, err := e.database.GetCloudConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	freshness := make([]ProviderFreshness, 0, len(configs))
+	for _, c := range configs {
+		f := ProviderFreshness{
+			ID:           c.ID,
+			Provider:     c.Provider,
+			LastSyncedAt: c.LastSyncedAt,
+			Stale:        true,
+		}
+		if c.LastSyncedAt != nil {
+			age := now.Sub(*c.LastSyncedAt)
+			seconds := age.Seconds()
+			f.AgeSeconds = &seconds
+			f.Stale = age > e.staleAfter
+		}
+		freshness = append(freshness, f)
+	}
+	return freshness, nil
+}
+
+// RefreshFreshnessMetrics updates the cost data age and last sync gauges
+// from the database
+func (e *Engine) RefreshFreshnessMetrics() error {
+	freshness, err := e.Freshness()
+	if err != nil {
+		return err
+	}
 
-		for _, cost := range costs {
-			e.database.SaveEgressCost(
-				string(provider.Name()),
-				cost.Date.Format("2006-01-02"),
-				cost.Service,
-				cost.Region,
-				cost.CostUSD,
-				cost.BytesOut,
-			)
+	ages := make(map[string]float64, len(freshness))
+	synced := make(map[string]time.Time, len(freshness))
+	for _, f := range freshness {
+		if f.AgeSeconds != nil {
+			ages[f.ID] = *f.AgeSeconds
+			synced[f.ID] = *f.LastSyncedAt
+		} else {
+			ages[f.ID] = math.Inf(1)
 		}
 	}
+	e.metrics().SetCostDataAge(ages)
+	e.metrics().SetLastCostSync(synced)
+	return nil
+}
 
+// RefreshCostMetrics sets the provider cost gauge to the totals between the
+// dates, inclusive, by provider and service. SyncCosts calls it with the
+// last CostMetricsWindowDays, whatever window it synced.
+func (e *Engine) RefreshCostMetrics(startDate, endDate string) error {
+	totals := make(map[string]map[string]float64)
+	err := e.database.ForEachEgressCost(startDate, endDate, func(c db.EgressCost) error {
+		if totals[c.Provider] == nil {
+			totals[c.Provider] = make(map[string]float64)
+		}
+		totals[c.Provider][c.Service] += c.CostUSD
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	e.metrics().SetProviderCosts(totals)
 	return nil
 }
 
+// GetCostSummary aggregates costs over the period. Results are cached until
+// cost data next changes, so callers must not modify the returned summary.
 func (e *Engine) GetCostSummary(startDate, endDate string) (*CostSummary, error) {
+	key := startDate + "|" + endDate
+	e.mu.Lock()
+	if elem, ok := e.summaries[key]; ok {
+		e.summaryLRU.MoveToFront(elem)
+		e.mu.Unlock()
+		return elem.Value.(*cachedSummary).summary, nil
+	}
+	generation := e.generation
+	baselines := e.baselines
+	e.mu.Unlock()
+
+	summary, err := e.computeCostSummary(startDate, endDate, baselines)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.summaries[key]; ok || e.generation != generation {
+		return summary, nil // Cached by a concurrent read, or already stale
+	}
+	if e.summaryLRU.Len() >= maxCachedSummaries {
+		oldest := e.summaryLRU.Back()
+		e.summaryLRU.Remove(oldest)
+		delete(e.summaries, oldest.Value.(*cachedSummary).key)
+	}
+	e.summaries[key] = e.summaryLRU.PushFront(&cachedSummary{key: key, summary: summary})
+	return summary, nil
+}
+
+func (e *Engine) computeCostSummary(startDate, endDate string, baselines map[string]float64) (*CostSummary, error) {
 	costs, err := e.database.GetEgressCosts(startDate, endDate)
 	if err != nil {
 		return nil, err
@@ -69,6 +453,8 @@ func (e *Engine) GetCostSummary(startDate, endDate string) (*CostSummary, error)
 		ByService:  make(map[string]float64),
 		ByRegion:   make(map[string]float64),
 		Period:     startDate + " to " + endDate,
+
+		RealizedSavingsByProvider: make(map[string]float64),
 	}
 
 	for _, cost := range costs {
@@ -82,9 +468,104 @@ func (e *Engine) GetCostSummary(startDate, endDate string) (*CostSummary, error)
 		}
 	}
 
+	summary.OnDemandEquivalentUSD = summary.TotalCostUSD
+	for provider, actual := range summary.ByProvider {
+		discount, ok := baselines[provider]
+		if !ok {
+			continue
+		}
+		realized := actual/(1-discount) - actual
+		summary.RealizedSavingsByProvider[provider] = realized
+		summary.RealizedSavingsUSD += realized
+		summary.OnDemandEquivalentUSD += realized
+	}
+
+	savings, err := e.database.GetRecommendationSavings()
+	if err != nil {
+		return nil, err
+	}
+	for _, byStatus := range savings {
+		summary.PotentialSavingsUSD += byStatus["open"]
+	}
+
 	return summary, nil
 }
 
+// AttributeCosts splits each provider/region's egress cost for a date across
+// the services that generated it and stores the result as cost attributions.
+// Costs are weighted by bytes transferred when every row reports bytes,
+// and by their own cost otherwise.
 func (e *Engine) AttributeCosts(date string) error {
+	costs, err := e.database.GetEgressCosts(date, date)
+	if err != nil {
+		return err
+	}
+
+	type groupKey struct{ provider, region string }
+	groups := make(map[groupKey][]db.EgressCost)
+	var order []groupKey
+	for _, c := range costs {
+		k := groupKey{c.Provider, c.Region}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], c)
+	}
+
+	for _, k := range order {
+		group := groups[k]
+		var total float64
+		for _, c := range group {
+			total += c.CostUSD
+		}
+
+		weights, byBytes := attributionWeights(group)
+		for i, c := range group {
+			var bytes *int64
+			if byBytes {
+				bytes = c.BytesOut
+			}
+			if err := e.database.SaveCostAttribution(date, "service", c.Service, total*weights[i], bytes, c.Provider, c.Region); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// attributionWeights returns each cost's share of its group, summing to 1.
+// Shares are by bytes when all bytes are known and non-zero in total,
+// falling back to cost-proportional shares otherwise. The second result
+// reports which weighting was used.
+func attributionWeights(costs []db.EgressCost) ([]float64, bool) {
+	weights := make([]float64, len(costs))
+
+	var totalBytes int64
+	bytesKnown := true
+	for _, c := range costs {
+		if c.BytesOut == nil {
+			bytesKnown = false
+			break
+		}
+		totalBytes += *c.BytesOut
+	}
+	if bytesKnown && totalBytes > 0 {
+		for i, c := range costs {
+			weights[i] = float64(*c.BytesOut) / float64(totalBytes)
+		}
+		return weights, true
+	}
+
+	var totalCost float64
+	for _, c := range costs {
+		totalCost += c.CostUSD
+	}
+	for i, c := range costs {
+		if totalCost > 0 {
+			weights[i] = c.CostUSD / totalCost
+		} else {
+			weights[i] = 1 / float64(len(costs))
+		}
+	}
+	return weights, false
+}
@@ -0,0 +1,148 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func attributedByService(t *testing.T, e *Engine, date string) map[string]float64 {
+	t.Helper()
+	attrs, err := e.database.GetCostAttributions(date, date)
+	if err != nil {
+		t.Fatalf("GetCostAttributions failed: %v", err)
+	}
+	out := make(map[string]float64)
+	for _, a := range attrs {
+		out[a.EntityName] += a.CostUSD
+	}
+	return out
+}
+
+func TestAttributeCosts_ByBytes(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 10, int64Ptr(300))
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 30, int64Ptr(100))
+
+	e := NewEngine(database, cloud.NewRegistry())
+	if err := e.AttributeCosts("2024-01-10"); err != nil {
+		t.Fatalf("AttributeCosts failed: %v", err)
+	}
+
+	got := attributedByService(t, e, "2024-01-10")
+	if math.Abs(got["AmazonEC2"]-30) > 1e-9 || math.Abs(got["AmazonS3"]-10) > 1e-9 {
+		t.Errorf("Expected byte-weighted split 30/10, got %v", got)
+	}
+}
+
+func TestAttributeCosts_UnknownBytesFallsBackToCost(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 10, int64Ptr(300))
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 30, nil)
+	// Known but zero bytes everywhere must not divide by zero either
+	database.SaveEgressCost("gcp", "2024-01-10", "Compute", "us-central1", 5, int64Ptr(0))
+
+	e := NewEngine(database, cloud.NewRegistry())
+	if err := e.AttributeCosts("2024-01-10"); err != nil {
+		t.Fatalf("AttributeCosts failed: %v", err)
+	}
+
+	got := attributedByService(t, e, "2024-01-10")
+	want := map[string]float64{"AmazonEC2": 10, "AmazonS3": 30, "Compute": 5}
+	for svc, cost := range want {
+		if math.IsNaN(got[svc]) || math.Abs(got[svc]-cost) > 1e-9 {
+			t.Errorf("%s: expected cost-weighted %.2f, got %v", svc, cost, got[svc])
+		}
+	}
+
+	attrs, _ := database.GetCostAttributions("2024-01-10", "2024-01-10")
+	for _, a := range attrs {
+		if a.Provider == "aws" && a.Bytes != nil {
+			t.Errorf("Expected unknown bytes on cost-weighted attribution, got %d", *a.Bytes)
+		}
+	}
+}
+
+func TestSyncCosts_RecordsRun(t *testing.T) {
+	database := setupTestDB(t)
+	now := time.Now()
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", &stubProvider{costs: []cloud.CostResult{
+		{Date: now, Service: "AmazonEC2", Region: "us-east-1", CostUSD: 1},
+		{Date: now, Service: "AmazonS3", Region: "us-east-1", CostUSD: 2},
+	}})
+	registry.Register("gcp-main", &stubProvider{err: errors.New("credentials expired")})
+
+	e := NewEngine(database, registry)
+	var syncErr *SyncError
+	if err := e.SyncCosts(context.Background(), 1); !errors.As(err, &syncErr) {
+		t.Fatalf("Expected a SyncError for gcp-main, got %v", err)
+	}
+
+	runs, err := database.GetSyncRuns(10)
+	if err != nil {
+		t.Fatalf("GetSyncRuns failed: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 sync run, got %d", len(runs))
+	}
+	run := runs[0]
+	if run.ProviderCounts["aws-main"] != 2 {
+		t.Errorf("Expected 2 rows for aws-main, got %v", run.ProviderCounts)
+	}
+	if _, ok := run.ProviderCounts["gcp-main"]; ok {
+		t.Errorf("Expected no count for failed provider, got %v", run.ProviderCounts)
+	}
+	if run.Errors["gcp-main"] != "credentials expired" {
+		t.Errorf("Expected gcp-main error recorded, got %v", run.Errors)
+	}
+	if run.FinishedAt.Before(run.StartedAt) {
+		t.Errorf("Expected finish %v after start %v", run.FinishedAt, run.StartedAt)
+	}
+}
+
+func TestSyncCosts_ConcurrentWithPartialFailure(t *testing.T) {
+	database := setupTestDB(t)
+	now := time.Now()
+	registry := cloud.NewRegistry()
+	for _, id := range []string{"aws-a", "aws-b", "aws-c", "aws-d"} {
+		registry.Register(id, &stubProvider{costs: []cloud.CostResult{
+			{Date: now, Service: "svc-" + id, Region: "us-east-1", CostUSD: 1},
+		}})
+	}
+	registry.Register("aws-broken", &stubProvider{err: errors.New("access denied")})
+
+	e := NewEngine(database, registry)
+	e.SetSyncConcurrency(2)
+	err := e.SyncCosts(context.Background(), 1)
+
+	var syncErr *SyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("Expected a SyncError, got %v", err)
+	}
+	if len(syncErr.Failed) != 1 || !strings.Contains(err.Error(), "aws-broken") || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("Expected the error to name aws-broken, got %q", err)
+	}
+
+	day := now.Format("2006-01-02")
+	costs, err := database.GetEgressCosts(day, day)
+	if err != nil {
+		t.Fatalf("GetEgressCosts failed: %v", err)
+	}
+	services := make(map[string]bool)
+	for _, c := range costs {
+		services[c.Service] = true
+	}
+	for _, id := range []string{"aws-a", "aws-b", "aws-c", "aws-d"} {
+		if !services["svc-"+id] {
+			t.Errorf("Expected costs from %s to be saved, got %v", id, services)
+		}
+	}
+}
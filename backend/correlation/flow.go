@@ -0,0 +1,92 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+// SourceEntity is the entity type of attributions made from flow logs. The
+// entity name is the flow's source IP.
+const SourceEntity = "source_ip"
+
+// AttributeFlowCosts splits each provider's egress cost for a date (YYYY-MM-DD)
+// across the sources that sent traffic that day, in proportion to the bytes
+// each sent according to the provider's flow logs. Rejected flows are
+// ignored. Attributions are recorded per provider, so configs of the same
+// provider share its cost. Earlier flow attributions for the date are
+// replaced. Providers without flow logs (cloud.ErrNoFlowLogs) are skipped;
+// other fetch failures are returned joined after the rest are attributed.
+func (e *Engine) AttributeFlowCosts(ctx context.Context, date string) error {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("invalid date %q: %w", date, err)
+	}
+
+	ids := e.registry.List()
+	sort.Strings(ids)
+
+	bytesBySource := make(map[string]map[string]int64) // provider -> source IP -> bytes
+	var errs []error
+	for _, id := range ids {
+		provider, ok := e.registry.Get(id)
+		if !ok {
+			continue
+		}
+		flows, err := provider.FetchFlowLogs(ctx, day, day.Add(24*time.Hour))
+		if errors.Is(err, cloud.ErrNoFlowLogs) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+
+		name := string(provider.Name())
+		if bytesBySource[name] == nil {
+			bytesBySource[name] = make(map[string]int64)
+		}
+		for _, f := range flows {
+			if f.Action == "REJECT" || f.Bytes <= 0 || f.SrcIP == "" {
+				continue
+			}
+			bytesBySource[name][f.SrcIP] += f.Bytes
+		}
+	}
+
+	if len(bytesBySource) > 0 {
+		costs, err := e.database.GetEgressCosts(date, date)
+		if err != nil {
+			return err
+		}
+		costByProvider := make(map[string]float64)
+		for _, c := range costs {
+			costByProvider[c.Provider] += c.CostUSD
+		}
+
+		for provider, sources := range bytesBySource {
+			if err := e.database.DeleteCostAttributions(date, SourceEntity, provider); err != nil {
+				return err
+			}
+			var totalBytes int64
+			for _, b := range sources {
+				totalBytes += b
+			}
+			if totalBytes == 0 {
+				continue
+			}
+			for src, b := range sources {
+				share := costByProvider[provider] * float64(b) / float64(totalBytes)
+				if err := e.database.SaveCostAttribution(date, SourceEntity, src, share, &b, provider, ""); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+package correlation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/db"
+)
+
+func TestAttributeFlowCosts_SplitsByByteShare(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 60, nil)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 40, nil)
+
+	registry := cloud.NewRegistry()
+	registry.Register("aws-prod", &stubProvider{flows: []cloud.FlowLogEntry{
+		{SrcIP: "10.0.0.1", Bytes: 600, Action: "ACCEPT"},
+		{SrcIP: "10.0.0.2", Bytes: 300, Action: "ACCEPT"},
+		{SrcIP: "10.0.0.1", Bytes: 300, Action: "ACCEPT"},
+		{SrcIP: "10.0.0.3", Bytes: 5000, Action: "REJECT"},
+	}})
+	e := NewEngine(database, registry)
+
+	// Running twice replaces rather than duplicates the day's attributions
+	for i := 0; i < 2; i++ {
+		if err := e.AttributeFlowCosts(context.Background(), "2024-01-10"); err != nil {
+			t.Fatalf("AttributeFlowCosts failed: %v", err)
+		}
+	}
+
+	attrs, err := database.GetCostAttributions("2024-01-10", "2024-01-10")
+	if err != nil {
+		t.Fatalf("GetCostAttributions failed: %v", err)
+	}
+	got := make(map[string]db.CostAttribution)
+	for _, a := range attrs {
+		if a.EntityType != SourceEntity {
+			t.Errorf("Unexpected entity type %q", a.EntityType)
+		}
+		got[a.EntityName] = a
+	}
+	if len(got) != 2 || len(attrs) != 2 {
+		t.Fatalf("Expected one attribution per accepted source, got %+v", attrs)
+	}
+	// 900 of 1200 bytes and 300 of 1200 bytes of the $100 day
+	if a := got["10.0.0.1"]; math.Abs(a.CostUSD-75) > 1e-9 || a.Bytes == nil || *a.Bytes != 900 {
+		t.Errorf("Expected 10.0.0.1 to carry $75 for 900 bytes, got %+v", a)
+	}
+	if a := got["10.0.0.2"]; math.Abs(a.CostUSD-25) > 1e-9 || a.Provider != "aws" {
+		t.Errorf("Expected 10.0.0.2 to carry $25 of aws cost, got %+v", a)
+	}
+}
+
+func TestAttributeFlowCosts_SkipsProvidersWithoutFlowLogs(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 10, nil)
+
+	registry := cloud.NewRegistry()
+	registry.Register("aws-prod", &stubProvider{err: fmt.Errorf("%w: no bucket", cloud.ErrNoFlowLogs)})
+	if err := NewEngine(database, registry).AttributeFlowCosts(context.Background(), "2024-01-10"); err != nil {
+		t.Errorf("Expected providers without flow logs to be skipped, got %v", err)
+	}
+
+	registry.Register("aws-broken", &stubProvider{err: fmt.Errorf("access denied")})
+	if err := NewEngine(database, registry).AttributeFlowCosts(context.Background(), "2024-01-10"); err == nil {
+		t.Error("Expected a failed flow log read to be reported")
+	}
+}
@@ -0,0 +1,137 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// stubProvider returns canned costs and flow logs, or err if set
+type stubProvider struct {
+	costs   []cloud.CostResult
+	flows   []cloud.FlowLogEntry
+	err     error
+	fetches atomic.Int32
+}
+
+func (p *stubProvider) Name() cloud.ProviderType { return cloud.ProviderAWS }
+
+func (p *stubProvider) FetchCosts(ctx context.Context, start, end time.Time) ([]cloud.CostResult, error) {
+	p.fetches.Add(1)
+	return p.costs, p.err
+}
+
+func (p *stubProvider) FetchFlowLogs(ctx context.Context, start, end time.Time) ([]cloud.FlowLogEntry, error) {
+	return p.flows, p.err
+}
+
+func (p *stubProvider) TestConnection(ctx context.Context) error { return p.err }
+
+func freshnessByID(t *testing.T, e *Engine) map[string]ProviderFreshness {
+	t.Helper()
+	freshness, err := e.Freshness()
+	if err != nil {
+		t.Fatalf("Freshness failed: %v", err)
+	}
+	out := make(map[string]ProviderFreshness)
+	for _, f := range freshness {
+		out[f.ID] = f
+	}
+	return out
+}
+
+func TestFreshness_CurrentAfterSync(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveCloudConfig("aws-main", "aws", `{}`)
+	database.SaveCloudConfig("aws-broken", "aws", `{}`)
+
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", &stubProvider{})
+	registry.Register("aws-broken", &stubProvider{err: context.DeadlineExceeded})
+
+	e := NewEngine(database, registry)
+	if err := e.SyncCosts(context.Background(), 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected aws-broken's error from SyncCosts, got %v", err)
+	}
+
+	got := freshnessByID(t, e)
+	synced := got["aws-main"]
+	if synced.LastSyncedAt == nil || synced.AgeSeconds == nil {
+		t.Fatalf("Expected aws-main to have a sync time, got %+v", synced)
+	}
+	if *synced.AgeSeconds > 60 || synced.Stale {
+		t.Errorf("Expected fresh data after sync, got age %.0fs stale=%v", *synced.AgeSeconds, synced.Stale)
+	}
+
+	// A failed fetch doesn't count as a sync
+	if broken := got["aws-broken"]; broken.LastSyncedAt != nil || !broken.Stale {
+		t.Errorf("Expected failed provider to stay unsynced and stale, got %+v", broken)
+	}
+}
+
+func TestFreshness_NeverSyncedIsStale(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveCloudConfig("gcp-main", "gcp", `{}`)
+
+	got := freshnessByID(t, NewEngine(database, cloud.NewRegistry()))["gcp-main"]
+	if got.LastSyncedAt != nil || got.AgeSeconds != nil {
+		t.Errorf("Expected unknown sync time, got %+v", got)
+	}
+	if !got.Stale {
+		t.Error("Expected never-synced provider to be stale")
+	}
+}
+
+func TestFreshness_StaleAfterThreshold(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveCloudConfig("aws-main", "aws", `{}`)
+	database.MarkCloudSynced("aws-main", time.Now().Add(-2*time.Hour))
+
+	e := NewEngine(database, cloud.NewRegistry())
+	if freshnessByID(t, e)["aws-main"].Stale {
+		t.Error("Expected 2h old data to be fresh under the default threshold")
+	}
+
+	e.SetStaleThreshold(time.Hour)
+	if !freshnessByID(t, e)["aws-main"].Stale {
+		t.Error("Expected 2h old data to be stale under a 1h threshold")
+	}
+}
+
+func TestSyncCosts_UpdatesCostGauges(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveCloudConfig("aws-gauges", "aws", `{}`)
+	// Synced earlier, outside this sync's window but inside the gauge's
+	older := time.Now().AddDate(0, 0, -10).Format("2006-01-02")
+	database.SaveEgressCost("aws", older, "AmazonS3", "us-east-1", 1, nil)
+
+	registry := cloud.NewRegistry()
+	registry.Register("aws-gauges", &stubProvider{costs: []cloud.CostResult{
+		{Date: time.Now(), Service: "AmazonEC2", Region: "us-east-1", CostUSD: 1.5},
+		{Date: time.Now(), Service: "AmazonEC2", Region: "eu-west-1", CostUSD: 2},
+		{Date: time.Now(), Service: "AmazonS3", Region: "us-east-1", CostUSD: 0.25},
+	}})
+	e := NewEngine(database, registry)
+	if err := e.SyncCosts(context.Background(), 1); err != nil {
+		t.Fatalf("SyncCosts failed: %v", err)
+	}
+
+	costs := metrics.Default().ProviderCostUSD
+	if got := testutil.ToFloat64(costs.WithLabelValues("aws", "AmazonEC2")); got != 3.5 {
+		t.Errorf("Expected AmazonEC2 total 3.5, got %v", got)
+	}
+	if got := testutil.ToFloat64(costs.WithLabelValues("aws", "AmazonS3")); got != 1.25 {
+		t.Errorf("Expected AmazonS3 total 1.25 over the gauge window, got %v", got)
+	}
+
+	synced := testutil.ToFloat64(metrics.Default().LastCostSyncTimestamp.WithLabelValues("aws-gauges"))
+	if age := time.Since(time.Unix(int64(synced), 0)); age < 0 || age > time.Minute {
+		t.Errorf("Expected a recent last sync timestamp, got %v", synced)
+	}
+}
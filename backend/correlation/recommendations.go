@@ -1,7 +1,10 @@
 package correlation
 
 import (
+	"time"
+
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
 )
 
 type RecommendationType string
@@ -89,6 +92,7 @@ var DefaultRules = []RecommendationRule{
 type RecommendationEngine struct {
 	database *db.DB
 	rules    []RecommendationRule
+	prom     *metrics.Metrics // nil records into metrics.Default()
 }
 
 func NewRecommendationEngine(database *db.DB) *RecommendationEngine {
@@ -98,24 +102,83 @@ func NewRecommendationEngine(database *db.DB) *RecommendationEngine {
 	}
 }
 
+// SetMetrics records the savings gauge into m instead of metrics.Default()
+func (e *RecommendationEngine) SetMetrics(m *metrics.Metrics) {
+	e.prom = m
+}
+
+func (e *RecommendationEngine) metrics() *metrics.Metrics {
+	if e.prom == nil {
+		return metrics.Default()
+	}
+	return e.prom
+}
+
+// GenerateRecommendations evaluates the rules against each account's costs
+// in the period separately and saves a recommendation, tagged with the
+// account, for every rule an account triggers. Types the user has dismissed
+// or snoozed for an account are skipped for it. Costs saved without an
+// account are evaluated together as one more account.
 func (e *RecommendationEngine) GenerateRecommendations(startDate, endDate string) error {
 	costs, err := e.database.GetEgressCosts(startDate, endDate)
 	if err != nil {
 		return err
 	}
+	byAccount := make(map[string][]db.EgressCost)
+	for _, c := range costs {
+		byAccount[c.AccountID] = append(byAccount[c.AccountID], c)
+	}
+
+	for accountID, accountCosts := range byAccount {
+		suppressed, err := e.database.GetSuppressedRecommendationTypes(accountID)
+		if err != nil {
+			return err
+		}
 
-	for _, rule := range e.rules {
-		if rule.Condition(costs) {
-			savings := rule.Savings(costs)
-			if savings > 0 {
-				e.database.SaveRecommendation(
-					string(rule.Type),
-					rule.Description,
-					savings,
-				)
+		for _, rule := range e.rules {
+			if suppressed[string(rule.Type)] {
+				continue
+			}
+			if rule.Condition(accountCosts) {
+				savings := rule.Savings(accountCosts)
+				if savings > 0 {
+					e.database.SaveAccountRecommendation(
+						accountID,
+						string(rule.Type),
+						rule.Description,
+						savings,
+					)
+				}
 			}
 		}
 	}
 
+	return e.RefreshSavingsMetrics()
+}
+
+// UpdateStatus changes a recommendation's status and refreshes the savings gauge
+func (e *RecommendationEngine) UpdateStatus(id int64, status string) error {
+	if err := e.database.UpdateRecommendationStatus(id, status); err != nil {
+		return err
+	}
+	return e.RefreshSavingsMetrics()
+}
+
+// Snooze hides a recommendation until the given time and refreshes the
+// savings gauge
+func (e *RecommendationEngine) Snooze(id int64, until time.Time) error {
+	if err := e.database.SnoozeRecommendation(id, until); err != nil {
+		return err
+	}
+	return e.RefreshSavingsMetrics()
+}
+
+// RefreshSavingsMetrics recomputes the per-type, per-status savings gauge from the database
+func (e *RecommendationEngine) RefreshSavingsMetrics() error {
+	savings, err := e.database.GetRecommendationSavings()
+	if err != nil {
+		return err
+	}
+	e.metrics().SetRecommendationSavings(savings)
 	return nil
 }
@@ -0,0 +1,208 @@
+package correlation
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestGenerateRecommendations_UpdatesSavingsGauge(t *testing.T) {
+	database := setupTestDB(t)
+
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 200, nil)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 50, nil)
+
+	engine := NewRecommendationEngine(database)
+	if err := engine.GenerateRecommendations("2024-01-01", "2024-01-31"); err != nil {
+		t.Fatalf("GenerateRecommendations failed: %v", err)
+	}
+
+	want := map[RecommendationType]float64{
+		RecCrossAZ:       100, // 50% of EC2
+		RecVPCEndpoint:   60,  // 30% of EC2
+		RecCrossRegionS3: 40,  // 80% of S3
+	}
+	for recType, savings := range want {
+		got := testutil.ToFloat64(metrics.RecommendationSavings.WithLabelValues(string(recType), "open"))
+		if got != savings {
+			t.Errorf("%s: expected savings %.2f, got %.2f", recType, savings, got)
+		}
+	}
+
+	// Dismissing a recommendation moves its savings to the dismissed series
+	recs, _ := database.GetRecommendations()
+	var crossAZ db.Recommendation
+	for _, r := range recs {
+		if r.Type == string(RecCrossAZ) {
+			crossAZ = r
+		}
+	}
+	if err := engine.UpdateStatus(crossAZ.ID, "dismissed"); err != nil {
+		t.Fatalf("UpdateStatus failed: %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.RecommendationSavings.WithLabelValues(string(RecCrossAZ), "dismissed")); got != 100 {
+		t.Errorf("Expected dismissed savings 100, got %.2f", got)
+	}
+	if n := testutil.CollectAndCount(metrics.RecommendationSavings); n != 3 {
+		t.Errorf("Expected 3 savings series after status change, got %d", n)
+	}
+}
+
+func TestGenerateRecommendations_SkipsDismissedTypes(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 50, nil)
+
+	engine := NewRecommendationEngine(database)
+	if err := engine.GenerateRecommendations("2024-01-01", "2024-01-31"); err != nil {
+		t.Fatalf("GenerateRecommendations failed: %v", err)
+	}
+	recs, _ := database.GetRecommendations()
+	if len(recs) != 1 || recs[0].Type != string(RecCrossRegionS3) {
+		t.Fatalf("Expected one cross-region S3 recommendation, got %+v", recs)
+	}
+	if err := engine.UpdateStatus(recs[0].ID, db.RecommendationDismissed); err != nil {
+		t.Fatalf("UpdateStatus failed: %v", err)
+	}
+
+	// The same condition still holds, but the dismissal sticks
+	if err := engine.GenerateRecommendations("2024-01-01", "2024-01-31"); err != nil {
+		t.Fatalf("GenerateRecommendations failed: %v", err)
+	}
+	if recs, _ := database.GetRecommendations(); len(recs) != 0 {
+		t.Errorf("Expected the dismissed recommendation not to reappear, got %+v", recs)
+	}
+	all, _ := database.GetAllRecommendations()
+	if len(all) != 1 || all[0].Status != db.RecommendationDismissed {
+		t.Errorf("Expected only the dismissed recommendation in the full list, got %+v", all)
+	}
+}
+
+func TestGenerateRecommendations_SnoozeExpires(t *testing.T) {
+	database := setupTestDB(t)
+	fake := clock.NewFake(time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC))
+	database.SetClock(fake)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 50, nil)
+
+	engine := NewRecommendationEngine(database)
+	if err := engine.GenerateRecommendations("2024-01-01", "2024-01-31"); err != nil {
+		t.Fatalf("GenerateRecommendations failed: %v", err)
+	}
+	recs, _ := database.GetRecommendations()
+	if len(recs) != 1 {
+		t.Fatalf("Expected one recommendation, got %+v", recs)
+	}
+	if err := engine.UpdateStatus(recs[0].ID, db.RecommendationSnoozed); err != nil {
+		t.Fatalf("UpdateStatus failed: %v", err)
+	}
+
+	// Hidden and not regenerated while the snooze lasts
+	engine.GenerateRecommendations("2024-01-01", "2024-01-31")
+	if recs, _ := database.GetRecommendations(); len(recs) != 0 {
+		t.Errorf("Expected the snoozed recommendation hidden, got %+v", recs)
+	}
+	all, _ := database.GetAllRecommendations()
+	if len(all) != 1 || all[0].SnoozedUntil == nil || !all[0].SnoozedUntil.Equal(fake.Now().Add(db.DefaultRecommendationSnooze)) {
+		t.Fatalf("Expected one recommendation snoozed for the default time, got %+v", all)
+	}
+
+	// Once it runs out the same recommendation is open again, not a duplicate
+	fake.Advance(db.DefaultRecommendationSnooze + time.Minute)
+	engine.GenerateRecommendations("2024-01-01", "2024-01-31")
+	all, _ = database.GetAllRecommendations()
+	if len(all) != 1 || all[0].Status != db.RecommendationOpen || all[0].SnoozedUntil != nil {
+		t.Errorf("Expected the snoozed recommendation reopened, got %+v", all)
+	}
+}
+
+func TestGenerateRecommendations_Idempotent(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 200, nil)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 50, nil)
+
+	engine := NewRecommendationEngine(database)
+	for i := 0; i < 3; i++ {
+		if err := engine.GenerateRecommendations("2024-01-01", "2024-01-31"); err != nil {
+			t.Fatalf("GenerateRecommendations failed: %v", err)
+		}
+	}
+
+	byType := make(map[string]int)
+	recs, _ := database.GetAllRecommendations()
+	for _, r := range recs {
+		if r.Status == db.RecommendationOpen {
+			byType[r.Type]++
+		}
+	}
+	for _, recType := range []RecommendationType{RecCrossAZ, RecVPCEndpoint, RecCrossRegionS3} {
+		if byType[string(recType)] != 1 {
+			t.Errorf("%s: expected exactly 1 open recommendation, got %d", recType, byType[string(recType)])
+		}
+	}
+
+	// Changed costs update the existing recommendation's savings
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 100, nil)
+	engine.GenerateRecommendations("2024-01-01", "2024-01-31")
+	recs, _ = database.GetRecommendations()
+	for _, r := range recs {
+		if r.Type == string(RecCrossRegionS3) && r.EstimatedSavingsUSD != 80 {
+			t.Errorf("Expected updated savings 80, got %.2f", r.EstimatedSavingsUSD)
+		}
+	}
+}
+
+func TestGenerateRecommendations_PerAccount(t *testing.T) {
+	database := setupTestDB(t)
+
+	// Two AWS accounts: one runs heavy EC2 traffic, the other barely any
+	database.SaveAccountEgressCost("aws-busy", "aws", "2024-01-10", "AmazonEC2", "us-east-1", 300, nil, nil)
+	database.SaveAccountEgressCost("aws-quiet", "aws", "2024-01-10", "AmazonEC2", "us-east-1", 5, nil, nil)
+
+	engine := NewRecommendationEngine(database)
+	if err := engine.GenerateRecommendations("2024-01-01", "2024-01-31"); err != nil {
+		t.Fatalf("GenerateRecommendations failed: %v", err)
+	}
+
+	busy, err := database.GetAccountRecommendations("aws-busy")
+	if err != nil {
+		t.Fatalf("GetAccountRecommendations failed: %v", err)
+	}
+	var crossAZ *db.Recommendation
+	for i, r := range busy {
+		if r.AccountID != "aws-busy" {
+			t.Errorf("Expected only aws-busy recommendations, got %+v", r)
+		}
+		if r.Type == string(RecCrossAZ) {
+			crossAZ = &busy[i]
+		}
+	}
+	if crossAZ == nil {
+		t.Fatalf("Expected a cross-AZ recommendation for the busy account, got %+v", busy)
+	}
+	// Savings come from the busy account's costs alone
+	if crossAZ.EstimatedSavingsUSD != 150 {
+		t.Errorf("Expected cross-AZ savings 150, got %.2f", crossAZ.EstimatedSavingsUSD)
+	}
+
+	quiet, err := database.GetAccountRecommendations("aws-quiet")
+	if err != nil {
+		t.Fatalf("GetAccountRecommendations failed: %v", err)
+	}
+	if len(quiet) != 0 {
+		t.Errorf("Expected no recommendations for the quiet account, got %+v", quiet)
+	}
+}
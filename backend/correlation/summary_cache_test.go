@@ -0,0 +1,71 @@
+package correlation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sennet/sennet/backend/cloud"
+)
+
+func TestGetCostSummary_CachedUntilCostsChange(t *testing.T) {
+	database := setupTestDB(t)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 10, nil)
+
+	e := NewEngine(database, cloud.NewRegistry())
+	first, err := e.GetCostSummary("2024-01-01", "2024-01-31")
+	if err != nil {
+		t.Fatalf("GetCostSummary failed: %v", err)
+	}
+	again, _ := e.GetCostSummary("2024-01-01", "2024-01-31")
+	if again != first {
+		t.Error("Expected repeated read to be served from cache")
+	}
+
+	// An import through SaveEgressCost must invalidate the cached summary
+	database.SaveEgressCost("gcp", "2024-01-11", "Compute", "us-central1", 5, nil)
+	after, err := e.GetCostSummary("2024-01-01", "2024-01-31")
+	if err != nil {
+		t.Fatalf("GetCostSummary failed: %v", err)
+	}
+	if after.TotalCostUSD != 15 {
+		t.Errorf("Expected summary to reflect import (15), got %.2f", after.TotalCostUSD)
+	}
+
+	// So must a retention delete
+	if _, err := database.DeleteEgressCostsBefore("2024-01-11"); err != nil {
+		t.Fatalf("DeleteEgressCostsBefore failed: %v", err)
+	}
+	pruned, _ := e.GetCostSummary("2024-01-01", "2024-01-31")
+	if pruned.TotalCostUSD != 5 {
+		t.Errorf("Expected summary to reflect prune (5), got %.2f", pruned.TotalCostUSD)
+	}
+}
+
+func TestGetCostSummary_CacheBounded(t *testing.T) {
+	database := setupTestDB(t)
+	e := NewEngine(database, cloud.NewRegistry())
+
+	first, _ := e.GetCostSummary("2024-01-01", "2024-01-31")
+	for day := 1; day <= maxCachedSummaries+10; day++ {
+		e.GetCostSummary(fmt.Sprintf("2023-01-%03d", day), "2024-01-31")
+		e.GetCostSummary("2024-01-01", "2024-01-31") // Kept in use, so never the oldest
+	}
+	if n := len(e.summaries); n != maxCachedSummaries {
+		t.Errorf("Expected the cache capped at %d periods, got %d", maxCachedSummaries, n)
+	}
+	if again, _ := e.GetCostSummary("2024-01-01", "2024-01-31"); again != first {
+		t.Error("Expected the recently used period to stay cached")
+	}
+}
+
+func TestEngine_CloseStopsListening(t *testing.T) {
+	database := setupTestDB(t)
+	e := NewEngine(database, cloud.NewRegistry())
+	e.GetCostSummary("2024-01-01", "2024-01-31")
+	e.Close()
+
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 10, nil)
+	if len(e.summaries) != 1 {
+		t.Error("Expected a closed engine to no longer be notified of cost changes")
+	}
+}
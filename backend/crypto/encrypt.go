@@ -5,10 +5,14 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 var (
@@ -16,43 +20,93 @@ var (
 	ErrInvalidCiphertext = errors.New("invalid ciphertext")
 	// ErrNoEncryptionKey is returned when encryption key is not configured
 	ErrNoEncryptionKey = errors.New("ENCRYPTION_KEY environment variable not set")
+	// ErrUnknownKey is returned when ciphertext names a key that is neither
+	// ENCRYPTION_KEY nor ENCRYPTION_KEY_OLD
+	ErrUnknownKey = errors.New("ciphertext encrypted with an unknown key")
+	// ErrInvalidKey is returned when a configured key isn't 32 bytes of
+	// base64 or hex
+	ErrInvalidKey = errors.New("encryption key must be 32 bytes, as base64 or 64 hex characters")
 )
 
-// GetEncryptionKey retrieves the 32-byte encryption key from environment
-// The key should be 32 bytes for AES-256
+// keySize is the AES-256 key length in bytes
+const keySize = 32
+
+// keyIDSeparator ends the key ID prefix of versioned ciphertext. It is not
+// in the base64 alphabet, so unprefixed ciphertext from older releases can't
+// be mistaken for versioned.
+const keyIDSeparator = ":"
+
+// KeyID identifies a key in the ciphertext it produces: the first 4 bytes of
+// its SHA-256, hex encoded. It reveals nothing usable about the key.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// GetEncryptionKey retrieves the 32-byte AES-256 encryption key from
+// ENCRYPTION_KEY, given as base64 (see GenerateKey) or 64 hex characters.
+// Keys of any other size are rejected with ErrInvalidKey.
 func GetEncryptionKey() ([]byte, error) {
 	keyStr := os.Getenv("ENCRYPTION_KEY")
 	if keyStr == "" {
 		return nil, ErrNoEncryptionKey
 	}
-
-	// Decode from base64
-	key, err := base64.StdEncoding.DecodeString(keyStr)
+	key, err := parseKey(keyStr)
 	if err != nil {
-		// Try using as raw bytes if not base64
-		key = []byte(keyStr)
+		return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
 	}
+	return key, nil
+}
 
-	// Pad or truncate to 32 bytes
-	if len(key) < 32 {
-		padded := make([]byte, 32)
-		copy(padded, key)
-		key = padded
-	} else if len(key) > 32 {
-		key = key[:32]
+// GetOldEncryptionKey retrieves the previous key from ENCRYPTION_KEY_OLD,
+// kept during a rotation so existing ciphertext stays readable. It is
+// validated like ENCRYPTION_KEY. Returns nil if no old key is configured.
+func GetOldEncryptionKey() ([]byte, error) {
+	keyStr := os.Getenv("ENCRYPTION_KEY_OLD")
+	if keyStr == "" {
+		return nil, nil
 	}
+	key, err := parseKey(keyStr)
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY_OLD: %w", err)
+	}
+	return key, nil
+}
 
+// parseKey decodes a key given as 64 hex characters or as base64 of exactly
+// keySize bytes. Hex is tried first, since 64 hex characters are also valid
+// (48 byte) base64.
+func parseKey(keyStr string) ([]byte, error) {
+	if len(keyStr) == 2*keySize {
+		if key, err := hex.DecodeString(keyStr); err == nil {
+			return key, nil
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(keyStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not valid base64 or hex", ErrInvalidKey)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidKey, len(key))
+	}
 	return key, nil
 }
 
 // Encrypt encrypts plaintext using AES-256-GCM
-// Returns base64-encoded ciphertext
+// Returns base64-encoded ciphertext prefixed with the key's ID and a colon
 func Encrypt(plaintext []byte) (string, error) {
 	key, err := GetEncryptionKey()
 	if err != nil {
 		return "", err
 	}
+	ciphertext, err := encryptWithKey(key, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return KeyID(key) + keyIDSeparator + ciphertext, nil
+}
 
+func encryptWithKey(key, plaintext []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -74,13 +128,43 @@ func Encrypt(plaintext []byte) (string, error) {
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// Decrypt decrypts base64-encoded ciphertext using AES-256-GCM
+// Decrypt decrypts ciphertext produced by Encrypt with whichever of the
+// current key and ENCRYPTION_KEY_OLD its prefix names. Unprefixed ciphertext
+// from older releases is tried with the current key and then the old one.
 func Decrypt(ciphertextB64 string) ([]byte, error) {
 	key, err := GetEncryptionKey()
 	if err != nil {
 		return nil, err
 	}
 
+	if id, body, versioned := strings.Cut(ciphertextB64, keyIDSeparator); versioned {
+		if KeyID(key) == id {
+			return decryptWithKey(key, body)
+		}
+		old, err := GetOldEncryptionKey()
+		if err != nil {
+			return nil, err
+		}
+		if old != nil && KeyID(old) == id {
+			return decryptWithKey(old, body)
+		}
+		return nil, ErrUnknownKey
+	}
+
+	plaintext, err := decryptWithKey(key, ciphertextB64)
+	if errors.Is(err, ErrInvalidCiphertext) {
+		old, oldErr := GetOldEncryptionKey()
+		if oldErr != nil {
+			return nil, oldErr
+		}
+		if old != nil {
+			return decryptWithKey(old, ciphertextB64)
+		}
+	}
+	return plaintext, err
+}
+
+func decryptWithKey(key []byte, ciphertextB64 string) ([]byte, error) {
 	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextB64)
 	if err != nil {
 		return nil, err
@@ -111,6 +195,28 @@ func Decrypt(ciphertextB64 string) ([]byte, error) {
 	return plaintext, nil
 }
 
+// IsCurrent reports whether ciphertext is prefixed with the current key's
+// ID. Unprefixed ciphertext is never current, so re-encrypting upgrades it
+// to the versioned format.
+func IsCurrent(ciphertextB64 string) bool {
+	key, err := GetEncryptionKey()
+	if err != nil {
+		return false
+	}
+	id, _, versioned := strings.Cut(ciphertextB64, keyIDSeparator)
+	return versioned && id == KeyID(key)
+}
+
+// ReEncrypt decrypts ciphertext with any known key and encrypts it again
+// under the current key
+func ReEncrypt(ciphertextB64 string) (string, error) {
+	plaintext, err := Decrypt(ciphertextB64)
+	if err != nil {
+		return "", err
+	}
+	return Encrypt(plaintext)
+}
+
 // EncryptString encrypts a string and returns base64-encoded ciphertext
 func EncryptString(plaintext string) (string, error) {
 	return Encrypt([]byte(plaintext))
@@ -0,0 +1,174 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func setKeys(t *testing.T, current, old string) {
+	t.Helper()
+	t.Setenv("ENCRYPTION_KEY", current)
+	t.Setenv("ENCRYPTION_KEY_OLD", old)
+}
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	return key
+}
+
+func mustParseKey(t *testing.T, keyStr string) []byte {
+	t.Helper()
+	key, err := parseKey(keyStr)
+	if err != nil {
+		t.Fatalf("parseKey failed: %v", err)
+	}
+	return key
+}
+
+func TestGetEncryptionKey_Sizing(t *testing.T) {
+	generated := newKey(t)
+	raw := mustParseKey(t, generated)
+
+	accepted := map[string]string{
+		"generated base64": generated,
+		"hex":              hex.EncodeToString(raw),
+	}
+	for name, keyStr := range accepted {
+		t.Setenv("ENCRYPTION_KEY", keyStr)
+		key, err := GetEncryptionKey()
+		if err != nil {
+			t.Errorf("%s: expected key to be accepted, got %v", name, err)
+			continue
+		}
+		if !bytes.Equal(key, raw) {
+			t.Errorf("%s: decoded to the wrong key", name)
+		}
+	}
+
+	rejected := map[string]string{
+		"one character":      "x",
+		"short passphrase":   "test-encryption-key",
+		"short base64":       base64.StdEncoding.EncodeToString(raw[:16]),
+		"long base64":        base64.StdEncoding.EncodeToString(append(raw, raw...)),
+		"short hex":          hex.EncodeToString(raw[:31]),
+		"64 chars, not hex":  strings.Repeat("z", 64),
+		"raw 32-byte string": strings.Repeat("k", 32),
+	}
+	for name, keyStr := range rejected {
+		t.Setenv("ENCRYPTION_KEY", keyStr)
+		if _, err := GetEncryptionKey(); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: expected ErrInvalidKey, got %v", name, err)
+		}
+		if _, err := EncryptString("secret"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: expected Encrypt to refuse the key, got %v", name, err)
+		}
+	}
+}
+
+func TestDecrypt_RejectsInvalidOldKey(t *testing.T) {
+	oldKey := newKey(t)
+	setKeys(t, oldKey, "")
+	ciphertext, _ := EncryptString("secret")
+
+	setKeys(t, newKey(t), "short")
+	if _, err := DecryptString(ciphertext); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for a malformed ENCRYPTION_KEY_OLD, got %v", err)
+	}
+}
+
+func TestEncrypt_PrefixesKeyID(t *testing.T) {
+	key := newKey(t)
+	setKeys(t, key, "")
+
+	ciphertext, err := EncryptString("secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if want := KeyID(mustParseKey(t, key)) + ":"; !strings.HasPrefix(ciphertext, want) {
+		t.Errorf("Expected ciphertext prefixed with %q, got %q", want, ciphertext)
+	}
+	if !IsCurrent(ciphertext) {
+		t.Error("Expected fresh ciphertext to be current")
+	}
+	if plaintext, err := DecryptString(ciphertext); err != nil || plaintext != "secret" {
+		t.Errorf("Expected round trip, got %q, %v", plaintext, err)
+	}
+}
+
+func TestDecrypt_WithOldKey(t *testing.T) {
+	oldKey, newKeyStr := newKey(t), newKey(t)
+	setKeys(t, oldKey, "")
+	ciphertext, err := EncryptString("secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	setKeys(t, newKeyStr, oldKey)
+	if plaintext, err := DecryptString(ciphertext); err != nil || plaintext != "secret" {
+		t.Errorf("Expected old-key ciphertext to decrypt, got %q, %v", plaintext, err)
+	}
+	if IsCurrent(ciphertext) {
+		t.Error("Expected old-key ciphertext not to be current")
+	}
+
+	// Once the old key is dropped its ciphertext names an unknown key
+	setKeys(t, newKeyStr, "")
+	if _, err := DecryptString(ciphertext); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestReEncrypt_ToNewKey(t *testing.T) {
+	oldKey, newKeyStr := newKey(t), newKey(t)
+	setKeys(t, oldKey, "")
+	ciphertext, _ := EncryptString("secret")
+
+	setKeys(t, newKeyStr, oldKey)
+	rotated, err := ReEncrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("ReEncrypt failed: %v", err)
+	}
+	if !IsCurrent(rotated) {
+		t.Error("Expected re-encrypted ciphertext to be current")
+	}
+
+	setKeys(t, newKeyStr, "")
+	if plaintext, err := DecryptString(rotated); err != nil || plaintext != "secret" {
+		t.Errorf("Expected re-encrypted ciphertext to decrypt under the new key alone, got %q, %v", plaintext, err)
+	}
+}
+
+func TestDecrypt_UnversionedLegacyCiphertext(t *testing.T) {
+	oldKey, newKeyStr := newKey(t), newKey(t)
+
+	// Ciphertext as written by releases before key IDs
+	legacy, err := encryptWithKey(mustParseKey(t, oldKey), []byte("secret"))
+	if err != nil {
+		t.Fatalf("encryptWithKey failed: %v", err)
+	}
+
+	setKeys(t, oldKey, "")
+	if plaintext, err := DecryptString(legacy); err != nil || plaintext != "secret" {
+		t.Errorf("Expected legacy ciphertext to decrypt under its key, got %q, %v", plaintext, err)
+	}
+	if IsCurrent(legacy) {
+		t.Error("Expected unversioned ciphertext never to be current")
+	}
+
+	setKeys(t, newKeyStr, oldKey)
+	if plaintext, err := DecryptString(legacy); err != nil || plaintext != "secret" {
+		t.Errorf("Expected legacy ciphertext to decrypt with the old key, got %q, %v", plaintext, err)
+	}
+	rotated, err := ReEncrypt(legacy)
+	if err != nil || !IsCurrent(rotated) {
+		t.Errorf("Expected legacy ciphertext to re-encrypt to the current key, got %q, %v", rotated, err)
+	}
+}
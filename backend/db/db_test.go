@@ -1,12 +1,19 @@
 package db_test
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sennet/sennet/backend/clock"
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/idgen"
 )
 
 func setupTestDB(t *testing.T) (*db.DB, func()) {
@@ -70,6 +77,52 @@ func TestDB_ValidateAPIKey_Invalid(t *testing.T) {
 	}
 }
 
+func TestDB_DeleteAPIKey(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	key, _ := database.CreateAPIKey("Doomed Key")
+	if valid, _ := database.ValidateAPIKey(key); !valid {
+		t.Fatal("Expected new key to be valid")
+	}
+
+	keys, err := database.ListAPIKeys()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Expected 1 listed key, got %v (%v)", keys, err)
+	}
+	if err := database.DeleteAPIKey(keys[0].Key); err != nil {
+		t.Fatalf("DeleteAPIKey failed for listed ID %s: %v", keys[0].Key, err)
+	}
+	if valid, _ := database.ValidateAPIKey(key); valid {
+		t.Error("Expected deleted key to be invalid")
+	}
+	if err := database.DeleteAPIKey(keys[0].Key); !errors.Is(err, db.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestDB_DeleteAPIKey_IgnoresPlaintext(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	key, _ := database.CreateAPIKey("Kept Key")
+	if err := database.DeleteAPIKey(key); !errors.Is(err, db.ErrKeyNotFound) {
+		t.Errorf("Expected the plaintext key to match no ID, got %v", err)
+	}
+	if valid, _ := database.ValidateAPIKey(key); !valid {
+		t.Error("Expected the key to keep validating")
+	}
+}
+
+func TestDB_DeleteAPIKey_Nonexistent(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := database.DeleteAPIKey("kid_doesnotexist"); !errors.Is(err, db.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
 func TestDB_ValidateAPIKey_BadFormat(t *testing.T) {
 	database, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -153,6 +206,8 @@ func TestDB_GetAgent_NotFound(t *testing.T) {
 func TestDB_AgentLastSeen(t *testing.T) {
 	database, cleanup := setupTestDB(t)
 	defer cleanup()
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	database.SetClock(fake)
 
 	agentID := "test-agent-lastseen"
 
@@ -160,14 +215,153 @@ func TestDB_AgentLastSeen(t *testing.T) {
 	database.CreateOrUpdateAgent(agentID, "1.0.0")
 	agent1, _ := database.GetAgent(agentID)
 
-	// Wait a bit and update
-	time.Sleep(100 * time.Millisecond)
+	fake.Advance(time.Minute)
 	database.CreateOrUpdateAgent(agentID, "1.0.0")
 	agent2, _ := database.GetAgent(agentID)
 
 	// LastSeen should be updated
-	if !agent2.LastSeen.After(agent1.LastSeen) && !agent2.LastSeen.Equal(agent1.LastSeen) {
-		t.Error("Expected LastSeen to be updated")
+	if !agent2.LastSeen.Equal(agent1.LastSeen.Add(time.Minute)) {
+		t.Errorf("Expected LastSeen to advance by a minute, got %v -> %v", agent1.LastSeen, agent2.LastSeen)
+	}
+}
+
+func TestDB_ActiveAgentWindowWithFakeClock(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	database.SetClock(fake)
+
+	database.CreateOrUpdateAgent("early", "1.0.0")
+	fake.Advance(4 * time.Minute)
+	database.CreateOrUpdateAgent("late", "1.0.0")
+
+	if n, _ := database.GetActiveAgentCount(5); n != 2 {
+		t.Errorf("Expected 2 active agents, got %d", n)
+	}
+	fake.Advance(2 * time.Minute)
+	if n, _ := database.GetActiveAgentCount(5); n != 1 {
+		t.Errorf("Expected only the late agent to be active, got %d", n)
+	}
+}
+
+func TestDB_DeleteStaleAgents(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	database.SetClock(fake)
+
+	database.CreateOrUpdateAgent("stale-1", "1.0.0")
+	database.CreateOrUpdateAgent("stale-2", "1.0.0")
+	fake.Advance(48 * time.Hour)
+	database.CreateOrUpdateAgent("fresh", "1.0.0")
+	database.SaveAgentEvents("stale-1", []db.AgentEvent{{Type: "anomaly"}})
+
+	if n, err := database.DeleteStaleAgents(24 * time.Hour); err != nil || n != 2 {
+		t.Fatalf("DeleteStaleAgents = %d, %v; want 2, nil", n, err)
+	}
+	if agent, _ := database.GetAgent("fresh"); agent == nil {
+		t.Error("Expected the fresh agent to survive")
+	}
+	if events, _ := database.GetAgentEvents("stale-1", 10); len(events) != 0 {
+		t.Errorf("Expected the stale agent's events deleted, got %+v", events)
+	}
+
+	fake.Advance(48 * time.Hour)
+	if ids, err := database.DeleteStaleAgentIDs(24 * time.Hour); err != nil || !slices.Equal(ids, []string{"fresh"}) {
+		t.Errorf("DeleteStaleAgentIDs = %v, %v; want [fresh]", ids, err)
+	}
+}
+
+func TestDB_APIKeyTimestampsUseClock(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	database.SetClock(fake)
+
+	key := "sk_" + strings.Repeat("a", 32)
+	if err := database.EnsureAPIKey(key, "seeded"); err != nil {
+		t.Fatalf("EnsureAPIKey failed: %v", err)
+	}
+	fake.Advance(time.Hour)
+	if err := database.UpdateAPIKeyLastUsed(key); err != nil {
+		t.Fatalf("UpdateAPIKeyLastUsed failed: %v", err)
+	}
+
+	stored, err := database.GetAPIKey(key)
+	if err != nil || stored == nil {
+		t.Fatalf("GetAPIKey = %v, %v", stored, err)
+	}
+	if !stored.CreatedAt.Equal(start) {
+		t.Errorf("Expected created_at %s, got %s", start, stored.CreatedAt)
+	}
+	if stored.LastUsed == nil || !stored.LastUsed.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected last_used %s, got %v", start.Add(time.Hour), stored.LastUsed)
+	}
+}
+
+func TestDB_APIKeyExpiryWithFakeClock(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	database.SetClock(fake)
+
+	key, err := database.CreateAPIKeyWithTTL("short-lived", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if valid, _ := database.ValidateAPIKey(key); !valid {
+		t.Error("Expected key to validate before its TTL")
+	}
+
+	fake.Advance(59 * time.Minute)
+	if exists, _ := database.APIKeyExists(key); !exists {
+		t.Error("Expected key to exist a minute before expiry")
+	}
+
+	fake.Advance(2 * time.Minute)
+	if valid, _ := database.ValidateAPIKey(key); valid {
+		t.Error("Expected key to be rejected after its TTL")
+	}
+	stored, _ := database.GetAPIKey(key)
+	if stored == nil || !stored.Expired {
+		t.Errorf("Expected key to be reported expired, got %+v", stored)
+	}
+	if n, _ := database.DeleteExpiredAPIKeys(); n != 1 {
+		t.Errorf("Expected 1 expired key deleted, got %d", n)
+	}
+}
+
+func TestDB_UpsertAgentMetadata(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := database.CreateOrUpdateAgent("meta-agent", "1.0.0"); err != nil {
+		t.Fatalf("CreateOrUpdateAgent failed: %v", err)
+	}
+	if err := database.UpsertAgentMetadata("meta-agent", db.AgentMetadata{Hostname: "node-1", Kernel: "6.1.0"}); err != nil {
+		t.Fatalf("UpsertAgentMetadata failed: %v", err)
+	}
+	// A later partial report fills in gaps without erasing earlier fields
+	if err := database.UpsertAgentMetadata("meta-agent", db.AgentMetadata{OS: "linux", Arch: "arm64"}); err != nil {
+		t.Fatalf("UpsertAgentMetadata failed: %v", err)
+	}
+
+	agent, err := database.GetAgent("meta-agent")
+	if err != nil {
+		t.Fatalf("GetAgent failed: %v", err)
+	}
+	want := db.AgentMetadata{Hostname: "node-1", OS: "linux", Kernel: "6.1.0", Arch: "arm64"}
+	if agent.Metadata != want {
+		t.Errorf("Expected %+v, got %+v", want, agent.Metadata)
+	}
+	if agent.Version != "1.0.0" {
+		t.Errorf("Expected version to be kept, got %q", agent.Version)
+	}
+
+	agents, _ := database.ListAgentsAfter("", 10)
+	if len(agents) != 1 || agents[0].Metadata != want {
+		t.Errorf("Expected listed agent to carry metadata, got %+v", agents)
 	}
 }
 
@@ -211,3 +405,640 @@ func TestDB_ListAPIKeys(t *testing.T) {
 		t.Errorf("Expected 2 keys, got %d", len(keys))
 	}
 }
+
+func TestDB_APIKeyStoredHashed(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "hashed.db")
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+
+	key, err := database.CreateAPIKey("hashed")
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	valid, err := database.ValidateAPIKey(key)
+	if err != nil {
+		t.Fatalf("Failed to validate key: %v", err)
+	}
+	if !valid {
+		t.Error("Expected generated key to validate")
+	}
+	if exists, _ := database.APIKeyExists(key); !exists {
+		t.Error("Expected generated key to exist")
+	}
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer raw.Close()
+
+	var storedKey, storedHash string
+	err = raw.QueryRow(`SELECT key, key_hash FROM api_keys WHERE name = 'hashed'`).Scan(&storedKey, &storedHash)
+	if err != nil {
+		t.Fatalf("Failed to read stored row: %v", err)
+	}
+	if storedKey == key || storedHash == key {
+		t.Error("Expected plaintext key not to be stored")
+	}
+	if storedHash != db.HashAPIKey(key) {
+		t.Errorf("Expected stored hash %q, got %q", db.HashAPIKey(key), storedHash)
+	}
+
+	// The stored values must not themselves work as credentials
+	if valid, _ := database.ValidateAPIKey(storedKey); valid {
+		t.Error("Expected stored key ID to be rejected")
+	}
+}
+
+func TestDB_KeyIDGenerator(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	hashed, _ := database.CreateAPIKey("hashed-id")
+	database.SetKeyIDGenerator(idgen.NewULID(clock.Real))
+	generated, _ := database.CreateAPIKey("generated-id")
+
+	k, _ := database.GetAPIKey(hashed)
+	if want := "kid_" + db.HashAPIKey(hashed)[:16]; k.Key != want {
+		t.Errorf("Expected hash-derived ID %s by default, got %s", want, k.Key)
+	}
+	k, _ = database.GetAPIKey(generated)
+	if !strings.HasPrefix(k.Key, "kid_") || len(k.Key) != len("kid_")+26 {
+		t.Errorf("Expected a ULID key ID, got %s", k.Key)
+	}
+	if valid, _ := database.ValidateAPIKey(generated); !valid {
+		t.Error("Expected the key with a generated ID to validate")
+	}
+}
+
+func TestDB_RevokeAPIKeys(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	database.SetClock(fake)
+
+	oldCI, _ := database.CreateAPIKey("ci-old")
+	fake.Advance(100 * 24 * time.Hour)
+	newCI, _ := database.CreateAPIKey("ci-new")
+	admin, _ := database.CreateAPIKey("admin")
+
+	// Pattern and age together only match the old CI key
+	n, err := database.RevokeAPIKeys("ci-*", 90*24*time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("RevokeAPIKeys(ci-*, 90d) = %d, %v; want 1, nil", n, err)
+	}
+	if valid, _ := database.ValidateAPIKey(oldCI); valid {
+		t.Error("Expected revoked key to stop validating")
+	}
+	for _, key := range []string{newCI, admin} {
+		if valid, _ := database.ValidateAPIKey(key); !valid {
+			t.Errorf("Expected non-matching key %s to keep validating", key)
+		}
+	}
+
+	// Revoked keys stay listed
+	info, err := database.GetAPIKey(oldCI)
+	if err != nil || info == nil || info.RevokedAt == nil {
+		t.Errorf("Expected revoked key to be kept with RevokedAt set, got %+v, %v", info, err)
+	}
+
+	// Matching every remaining active key is refused and changes nothing
+	if _, err := database.RevokeAPIKeys("*", 0); !errors.Is(err, db.ErrLastActiveKey) {
+		t.Errorf("Expected ErrLastActiveKey, got %v", err)
+	}
+	if valid, _ := database.ValidateAPIKey(admin); !valid {
+		t.Error("Expected guard to leave keys untouched")
+	}
+
+	if n, err := database.RevokeAPIKeys("ci-*", 0); err != nil || n != 1 {
+		t.Errorf("RevokeAPIKeys(ci-*) = %d, %v; want 1, nil", n, err)
+	}
+	if _, err := database.RevokeAPIKeys("admin", 0); !errors.Is(err, db.ErrLastActiveKey) {
+		t.Errorf("Expected the last active key to be protected, got %v", err)
+	}
+	if _, err := database.RevokeAPIKeys("", 0); err == nil {
+		t.Error("Expected an error without a pattern or age")
+	}
+}
+
+func TestDB_APIKeyTTL(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ttl.db")
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+
+	future, err := database.CreateAPIKeyWithTTL("future", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	past, err := database.CreateAPIKeyWithTTL("past", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if _, err := database.CreateAPIKeyWithTTL("negative", -time.Hour); err == nil {
+		t.Error("Expected negative TTL to be rejected")
+	}
+
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec(`UPDATE api_keys SET expires_at = datetime('now', '-1 hour') WHERE name = 'past'`); err != nil {
+		t.Fatalf("Failed to backdate key: %v", err)
+	}
+
+	if valid, _ := database.ValidateAPIKey(future); !valid {
+		t.Error("Expected key expiring in the future to validate")
+	}
+	if valid, _ := database.ValidateAPIKey(past); valid {
+		t.Error("Expected key expiring in the past to be rejected")
+	}
+
+	keys, err := database.ListAPIKeys()
+	if err != nil {
+		t.Fatalf("Failed to list keys: %v", err)
+	}
+	expired := map[string]bool{}
+	for _, k := range keys {
+		if k.ExpiresAt == nil {
+			t.Errorf("Expected %s to have an expiry", k.Name)
+		}
+		expired[k.Name] = k.Expired
+	}
+	if expired["future"] || !expired["past"] {
+		t.Errorf("Expected only the past key to be expired, got %v", expired)
+	}
+}
+
+func TestDB_MigratesLegacyAPIKeys(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Schema as created by releases before key scopes existed
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE api_keys (
+		key TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP,
+		last_used TIMESTAMP,
+		user_id TEXT
+	);
+	INSERT INTO api_keys (key, name) VALUES ('sk_legacy', 'old-key');
+	`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer database.Close()
+
+	key, err := database.GetAPIKey("sk_legacy")
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if key == nil {
+		t.Fatal("Expected legacy key to survive migration")
+	}
+	if len(key.Scopes) != 1 || key.Scopes[0] != db.ScopeAll {
+		t.Errorf("Expected legacy key to keep full access, got %v", key.Scopes)
+	}
+	if key.Key == "sk_legacy" {
+		t.Error("Expected legacy plaintext key to be replaced by its ID")
+	}
+	if valid, _ := database.ValidateAPIKey("sk_legacy"); !valid {
+		t.Error("Expected legacy key to still validate after hashing")
+	}
+}
+
+func TestDB_RekeysLegacyEgressCosts(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Schema as created by releases that stored costs per provider only
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE egress_costs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider TEXT NOT NULL,
+		date TEXT NOT NULL,
+		service TEXT,
+		region TEXT,
+		cost_usd REAL NOT NULL,
+		bytes_out INTEGER,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(provider, date, service, region)
+	);
+	INSERT INTO egress_costs (provider, date, service, region, cost_usd) VALUES ('aws', '2024-01-10', 'AmazonEC2', 'us-east-1', 40);
+	INSERT INTO egress_costs (provider, date, service, region, cost_usd) VALUES ('aws', '2024-01-11', 'AmazonEC2', 'us-east-1', 30);
+	`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer database.Close()
+
+	// Re-synced per account, accounts keep separate costs for the same day,
+	// which replace the provider-wide legacy cost rather than add to it
+	for _, account := range []string{"aws-prod", "aws-dev"} {
+		if err := database.SaveAccountEgressCost(account, "aws", "2024-01-10", "AmazonEC2", "us-east-1", 60, nil, nil); err != nil {
+			t.Fatalf("SaveAccountEgressCost failed: %v", err)
+		}
+	}
+	costs, err := database.GetEgressCosts("2024-01-01", "2024-01-31")
+	if err != nil {
+		t.Fatalf("GetEgressCosts failed: %v", err)
+	}
+	byDay := make(map[string]map[string]float64)
+	for _, c := range costs {
+		if byDay[c.Date] == nil {
+			byDay[c.Date] = make(map[string]float64)
+		}
+		byDay[c.Date][c.AccountID] = c.CostUSD
+	}
+	if len(costs) != 3 || len(byDay["2024-01-10"]) != 2 || byDay["2024-01-10"]["aws-prod"] != 60 || byDay["2024-01-10"]["aws-dev"] != 60 {
+		t.Errorf("Expected the re-synced day held per account only, got %+v", costs)
+	}
+	// A day not re-synced keeps its legacy cost
+	if byDay["2024-01-11"][""] != 30 {
+		t.Errorf("Expected the legacy cost kept for an unsynced day, got %+v", costs)
+	}
+
+	summary, err := database.GetEgressCostsSummary("2024-01-10", "2024-01-11")
+	if err != nil {
+		t.Fatalf("GetEgressCostsSummary failed: %v", err)
+	}
+	if summary["aws:AmazonEC2"] != 150 {
+		t.Errorf("Expected costs counted once (150), got %v", summary)
+	}
+
+	// The account dimension groups by account, and legacy costs by provider
+	cells, err := database.GetCostMatrix("2024-01-10", "2024-01-11", "account", "date")
+	if err != nil {
+		t.Fatalf("GetCostMatrix failed: %v", err)
+	}
+	accounts := make(map[string]float64)
+	for _, c := range cells {
+		accounts[c.Row] += c.CostUSD
+	}
+	if len(accounts) != 3 || accounts["aws-prod"] != 60 || accounts["aws-dev"] != 60 || accounts["aws"] != 30 {
+		t.Errorf("Expected costs grouped by account, got %v", accounts)
+	}
+}
+
+func TestDB_DropsLegacyCostsSupersededBeforeUpgrade(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "dup.db")
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	// Left side by side by an earlier release of the rekey migration
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 40, nil)
+	database.SaveAccountEgressCost("", "aws", "2024-01-10", "AmazonEC2", "us-west-2", 10, nil, nil)
+	database.Close()
+	raw, _ := sql.Open("sqlite", dbPath)
+	raw.Exec(`INSERT INTO egress_costs (provider, account_id, date, service, region, cost_usd) VALUES ('aws', 'aws-prod', '2024-01-10', 'AmazonEC2', 'us-east-1', 60)`)
+	raw.Close()
+
+	database, err = db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer database.Close()
+	costs, _ := database.GetEgressCosts("2024-01-01", "2024-01-31")
+	if len(costs) != 2 {
+		t.Fatalf("Expected the superseded legacy cost dropped, got %+v", costs)
+	}
+	for _, c := range costs {
+		if c.Region == "us-east-1" && c.AccountID != "aws-prod" {
+			t.Errorf("Expected the account cost kept for us-east-1, got %+v", c)
+		}
+	}
+}
+
+func TestDB_CostTagsReplacedAndPruned(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	database.SaveEgressCostWithTags("aws", "2024-01-10", "AmazonEC2", "us-east-1", 100, nil,
+		[]db.CostTag{{Key: "team", Value: "net"}})
+	// A later sync retags the same cost row
+	database.SaveEgressCostWithTags("aws", "2024-01-10", "AmazonEC2", "us-east-1", 100, nil,
+		[]db.CostTag{{Key: "team", Value: "web"}})
+
+	costs, err := database.GetCostsByTag("team", "2024-01-01", "2024-01-31")
+	if err != nil {
+		t.Fatalf("GetCostsByTag failed: %v", err)
+	}
+	if len(costs) != 1 || costs[0].Value != "web" || costs[0].CostUSD != 100 {
+		t.Errorf("Expected only the new tag to count, got %+v", costs)
+	}
+
+	if _, err := database.DeleteEgressCostsBefore("2024-02-01"); err != nil {
+		t.Fatalf("DeleteEgressCostsBefore failed: %v", err)
+	}
+	// A new row must not inherit tags from a pruned row
+	database.SaveEgressCost("aws", "2024-02-10", "AmazonEC2", "us-east-1", 10, nil)
+	costs, _ = database.GetCostsByTag("team", "2024-01-01", "2024-12-31")
+	if len(costs) != 1 || costs[0].Value != db.UntaggedCost {
+		t.Errorf("Expected only untagged cost after pruning, got %+v", costs)
+	}
+}
+
+func TestDB_EgressCostUnknownBytes(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	zero := int64(0)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 10, nil)
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonS3", "us-east-1", 5, &zero)
+
+	costs, err := database.GetEgressCosts("2024-01-10", "2024-01-10")
+	if err != nil {
+		t.Fatalf("Failed to get costs: %v", err)
+	}
+	for _, c := range costs {
+		switch c.Service {
+		case "AmazonEC2":
+			if c.BytesOut != nil {
+				t.Errorf("Expected unknown bytes for EC2, got %d", *c.BytesOut)
+			}
+		case "AmazonS3":
+			if c.BytesOut == nil || *c.BytesOut != 0 {
+				t.Errorf("Expected known zero bytes for S3, got %v", c.BytesOut)
+			}
+		}
+	}
+}
+
+func TestDB_CostChangeNotifications(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var calls int
+	unregister := database.OnCostChange(func() { calls++ })
+
+	if err := database.SaveEgressCost("aws", "2024-01-01", "AmazonEC2", "us-east-1", 1, nil); err != nil {
+		t.Fatalf("SaveEgressCost failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 notification after save, got %d", calls)
+	}
+
+	// Deleting nothing doesn't notify
+	if n, _ := database.DeleteEgressCostsBefore("2023-01-01"); n != 0 {
+		t.Errorf("Expected no rows deleted, got %d", n)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no notification for empty delete, got %d", calls)
+	}
+
+	if n, _ := database.DeleteEgressCostsBefore("2025-01-01"); n != 1 {
+		t.Errorf("Expected 1 row deleted, got %d", n)
+	}
+	if calls != 2 {
+		t.Errorf("Expected notification after delete, got %d", calls)
+	}
+
+	unregister()
+	database.SaveEgressCost("aws", "2024-01-02", "AmazonEC2", "us-east-1", 1, nil)
+	if calls != 2 {
+		t.Errorf("Expected no notification after unregistering, got %d", calls)
+	}
+}
+
+func TestDB_GetAgentsSeenSince(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open raw connection: %v", err)
+	}
+	defer raw.Close()
+
+	now := time.Now().UTC()
+	boundary := now.Add(-time.Hour)
+	seen := map[string]time.Time{
+		"fresh":        now,
+		"just-inside":  boundary.Add(time.Minute),
+		"just-outside": boundary.Add(-time.Minute),
+		"ancient":      now.AddDate(0, -1, 0),
+	}
+	for id, at := range seen {
+		if err := database.CreateOrUpdateAgent(id, "1.0.0"); err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := raw.Exec(`UPDATE agents SET last_seen = ? WHERE id = ?`, at.Format("2006-01-02 15:04:05"), id); err != nil {
+			t.Fatalf("Failed to backdate agent: %v", err)
+		}
+	}
+
+	ids := func(agents []db.Agent, err error) []string {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		out := []string{}
+		for _, a := range agents {
+			out = append(out, a.ID)
+		}
+		return out
+	}
+
+	if got := fmt.Sprint(ids(database.GetAgentsSeenSince(boundary))); got != "[fresh just-inside]" {
+		t.Errorf("GetAgentsSeenSince = %s", got)
+	}
+	if got := fmt.Sprint(ids(database.GetAgentsNotSeenSince(boundary))); got != "[ancient just-outside]" {
+		t.Errorf("GetAgentsNotSeenSince = %s", got)
+	}
+	if got := fmt.Sprint(ids(database.GetAgentsSeenSince(now.Add(time.Hour)))); got != "[]" {
+		t.Errorf("Expected no agents seen in the future, got %s", got)
+	}
+}
+
+func TestDB_MetricsHistory(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// Inserted out of order to check the query sorts by timestamp
+	for _, minute := range []int{2, 0, 1, 10} {
+		sample := db.MetricsSample{RxPackets: uint64(minute * 100), DropCount: uint64(minute), UptimeSeconds: uint64(minute * 60)}
+		if err := database.SaveMetrics("agent-1", sample, base.Add(time.Duration(minute)*time.Minute)); err != nil {
+			t.Fatalf("SaveMetrics failed: %v", err)
+		}
+	}
+	database.SaveMetrics("agent-2", db.MetricsSample{RxPackets: 999}, base)
+
+	history, err := database.GetMetricsHistory("agent-1", base, base.Add(5*time.Minute))
+	if err != nil {
+		t.Fatalf("GetMetricsHistory failed: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 samples in range, got %d", len(history))
+	}
+	for i, m := range history {
+		if want := base.Add(time.Duration(i) * time.Minute); !m.Timestamp.Equal(want) {
+			t.Errorf("Sample %d: expected ts %s, got %s", i, want, m.Timestamp)
+		}
+		if m.RxPackets != uint64(i*100) || m.DropCount != uint64(i) || m.UptimeSeconds != uint64(i*60) {
+			t.Errorf("Sample %d: unexpected values %+v", i, m)
+		}
+	}
+}
+
+func TestGetSchemaVersion(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer database.Close()
+
+	fresh, err := database.GetSchemaVersion()
+	if err != nil {
+		t.Fatalf("GetSchemaVersion failed: %v", err)
+	}
+	if fresh.Latest == 0 || fresh.Current != fresh.Latest || fresh.Pending {
+		t.Fatalf("Expected a fresh database at the latest version, got %+v", fresh)
+	}
+
+	// Simulate a database last migrated by an older build
+	raw, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec(`DELETE FROM schema_migrations WHERE version = ?`, fresh.Latest); err != nil {
+		t.Fatalf("Failed to remove migration record: %v", err)
+	}
+
+	got, err := database.GetSchemaVersion()
+	if err != nil {
+		t.Fatalf("GetSchemaVersion failed: %v", err)
+	}
+	if got.Current != fresh.Latest-1 || got.Latest != fresh.Latest || !got.Pending {
+		t.Errorf("Expected version %d of %d to be flagged pending, got %+v", fresh.Latest-1, fresh.Latest, got)
+	}
+}
+
+func TestDB_ListAgents(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, a := range []struct{ id, version string }{{"a", "2.0.0"}, {"b", "1.0.0"}, {"c", "1.5.0"}} {
+		if err := database.CreateOrUpdateAgent(a.id, a.version); err != nil {
+			t.Fatalf("CreateOrUpdateAgent failed: %v", err)
+		}
+	}
+
+	page, err := database.ListAgents(2, 1, "version")
+	if err != nil {
+		t.Fatalf("ListAgents failed: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != "c" || page[1].ID != "a" {
+		t.Errorf("Expected c, a as the second page by version, got %+v", page)
+	}
+	if _, err := database.ListAgents(10, 0, "owner"); err == nil {
+		t.Error("Expected an error for an unknown sort")
+	}
+}
+
+func TestDB_GetVersionDistribution(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+	clk := clock.NewFake(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC))
+	database.SetClock(clk)
+
+	database.CreateOrUpdateAgent("old", "1.0.0")
+	clk.Advance(48 * time.Hour)
+	database.CreateOrUpdateAgent("a", "1.1.0")
+	database.CreateOrUpdateAgent("b", "1.1.0")
+	database.CreateOrUpdateAgent("c", "1.2.0")
+
+	counts, err := database.GetVersionDistribution(clk.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("GetVersionDistribution failed: %v", err)
+	}
+	if len(counts) != 2 || counts["1.1.0"] != 2 || counts["1.2.0"] != 1 {
+		t.Errorf("Expected recent agents only, got %v", counts)
+	}
+}
+
+func TestDB_AuditLogFilters(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []db.AuditLog{
+		{Timestamp: base, UserID: "alice", Method: "GET", Path: "/api/clouds", StatusCode: 200, Duration: time.Millisecond},
+		{Timestamp: base.Add(time.Minute), UserID: "bob", Method: "POST", Path: "/api/clouds", StatusCode: 403},
+		{Timestamp: base.Add(2 * time.Minute), UserID: "alice", Method: "POST", Path: "/api/admin/reencrypt", StatusCode: 200, Action: "reencrypt"},
+	}
+	if err := database.SaveAuditLogs(entries); err != nil {
+		t.Fatalf("SaveAuditLogs failed: %v", err)
+	}
+	if err := database.SaveAuditLog(db.AuditLog{Timestamp: base.Add(3 * time.Minute), UserID: "carol", Method: "GET", Path: "/api/clouds/1", StatusCode: 404}); err != nil {
+		t.Fatalf("SaveAuditLog failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter db.AuditLogFilter
+		want   []string // User IDs, newest first
+	}{
+		{"all", db.AuditLogFilter{}, []string{"carol", "alice", "bob", "alice"}},
+		{"user", db.AuditLogFilter{UserID: "alice"}, []string{"alice", "alice"}},
+		{"path prefix", db.AuditLogFilter{PathPrefix: "/api/clouds"}, []string{"carol", "bob", "alice"}},
+		{"status", db.AuditLogFilter{StatusCode: 403}, []string{"bob"}},
+		{"time range", db.AuditLogFilter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)}, []string{"alice", "bob"}},
+		{"page", db.AuditLogFilter{Limit: 2, Offset: 1}, []string{"alice", "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := database.GetAuditLogs(tt.filter)
+			if err != nil {
+				t.Fatalf("GetAuditLogs failed: %v", err)
+			}
+			var users []string
+			for _, e := range got {
+				users = append(users, e.UserID)
+			}
+			if !slices.Equal(users, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, users)
+			}
+		})
+	}
+
+	got, err := database.GetAuditLogs(db.AuditLogFilter{Since: base.Add(2 * time.Minute), Limit: 1, Offset: 1})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected one entry, got %v (%v)", got, err)
+	}
+	if e := got[0]; !e.Timestamp.Equal(entries[2].Timestamp) || e.Action != "reencrypt" || e.Method != "POST" {
+		t.Errorf("Entry did not round-trip: %+v", e)
+	}
+}
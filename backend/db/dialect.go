@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	// Registers the "pgx" database/sql driver used for DriverPostgres
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Supported database drivers, as passed to NewWithDriver
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Drivers lists the accepted driver names
+var Drivers = []string{DriverSQLite, DriverPostgres}
+
+// Dialect rewrites queries, which are written for SQLite, into the SQL a
+// driver expects. Only the constructs the package uses are handled:
+// ? placeholders, INSERT OR IGNORE, and the AUTOINCREMENT and REAL column
+// types in the schema. ON CONFLICT ... DO UPDATE with excluded.* needs no
+// rewriting, as both databases accept it. GLOB has no Postgres equivalent,
+// so queries build it with Glob instead.
+type Dialect struct {
+	name string
+}
+
+// DialectFor returns the dialect of a driver name from Drivers
+func DialectFor(driver string) (Dialect, error) {
+	if !slices.Contains(Drivers, driver) {
+		return Dialect{}, fmt.Errorf("unknown database driver %q (want %s)", driver, strings.Join(Drivers, " or "))
+	}
+	return Dialect{name: driver}, nil
+}
+
+// Name returns the dialect's driver name
+func (d Dialect) Name() string { return d.name }
+
+// sqlDriver returns the database/sql driver to open for the dialect
+func (d Dialect) sqlDriver() string {
+	if d.name == DriverPostgres {
+		return "pgx"
+	}
+	return "sqlite"
+}
+
+// Glob returns a condition matching column against the SQLite glob pattern
+// (*, ? and [...] classes, case-sensitive), and the argument to bind for its
+// placeholder. Postgres matches the equivalent regular expression.
+func (d Dialect) Glob(column, pattern string) (string, interface{}) {
+	if d.name != DriverPostgres {
+		return column + " GLOB ?", pattern
+	}
+	return column + " ~ ?", globRegexp(pattern)
+}
+
+// globRegexp translates a glob pattern into an anchored regular expression
+func globRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); {
+		switch pattern[i] {
+		case '*':
+			b.WriteString(".*")
+			i++
+			continue
+		case '?':
+			b.WriteString(".")
+			i++
+			continue
+		case '[':
+			// A ] straight after [ or [^ belongs to the class
+			start := i + 1
+			if start < len(pattern) && pattern[start] == '^' {
+				start++
+			}
+			if end := strings.IndexByte(pattern[min(start+1, len(pattern)):], ']'); end >= 0 {
+				end += min(start+1, len(pattern))
+				b.WriteString("[" + strings.ReplaceAll(pattern[i+1:end], `\`, `\\`) + "]")
+				i = end + 1
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(pattern[i:])
+		b.WriteString(regexp.QuoteMeta(string(r)))
+		i += size
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Rewrite translates a SQLite query for the dialect
+func (d Dialect) Rewrite(query string) string {
+	if d.name != DriverPostgres {
+		return query
+	}
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(query), "INSERT OR IGNORE INTO"); ok {
+		query = "INSERT INTO" + rest + " ON CONFLICT DO NOTHING"
+	}
+	return rebind(query)
+}
+
+// Schema translates SQLite DDL for the dialect
+func (d Dialect) Schema(ddl string) string {
+	if d.name != DriverPostgres {
+		return ddl
+	}
+	ddl = strings.ReplaceAll(ddl, "INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY")
+	return strings.ReplaceAll(ddl, " REAL", " DOUBLE PRECISION")
+}
+
+// rebind numbers ? placeholders as $1, $2, ..., leaving question marks in
+// quoted strings and identifiers alone
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dialectConn is a connection whose queries are rewritten for its dialect,
+// so the rest of the package can keep writing SQLite
+type dialectConn struct {
+	*sql.DB
+	dialect Dialect
+}
+
+func (c *dialectConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.Rewrite(query), args...)
+}
+
+func (c *dialectConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.Rewrite(query), args...)
+}
+
+func (c *dialectConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRow(c.dialect.Rewrite(query), args...)
+}
+
+func (c *dialectConn) Begin() (*dialectTx, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &dialectTx{Tx: tx, dialect: c.dialect}, nil
+}
+
+// dialectTx is a transaction on a dialectConn
+type dialectTx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx *dialectTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.Rewrite(query), args...)
+}
+
+func (tx *dialectTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.Rewrite(query), args...)
+}
+
+func (tx *dialectTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.Rewrite(query), args...)
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postgresDSNEnv names the Postgres database the cross-driver tests use.
+// They skip Postgres when it is unset.
+const postgresDSNEnv = "SENNET_TEST_POSTGRES_DSN"
+
+func TestDialect_RewritesForPostgres(t *testing.T) {
+	pg, _ := DialectFor(DriverPostgres)
+
+	tests := []struct {
+		in, want string
+	}{
+		{`SELECT id FROM agents WHERE id = ? AND state = ?`, `SELECT id FROM agents WHERE id = $1 AND state = $2`},
+		{`UPDATE agents SET labels = '{"q":"?"}' WHERE id = ?`, `UPDATE agents SET labels = '{"q":"?"}' WHERE id = $1`},
+		{`INSERT OR IGNORE INTO api_keys (key, name) VALUES (?, ?)`, `INSERT INTO api_keys (key, name) VALUES ($1, $2) ON CONFLICT DO NOTHING`},
+	}
+	for _, tt := range tests {
+		if got := pg.Rewrite(tt.in); got != tt.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	ddl := pg.Schema(`id INTEGER PRIMARY KEY AUTOINCREMENT, cost_usd REAL NOT NULL`)
+	if ddl != `id BIGSERIAL PRIMARY KEY, cost_usd DOUBLE PRECISION NOT NULL` {
+		t.Errorf("Unexpected Postgres schema: %q", ddl)
+	}
+
+	lite, _ := DialectFor(DriverSQLite)
+	query := `INSERT OR IGNORE INTO api_keys (key) VALUES (?)`
+	if got := lite.Rewrite(query); got != query {
+		t.Errorf("Expected SQLite queries unchanged, got %q", got)
+	}
+}
+
+func TestNewWithDriver_RejectsUnknownDriver(t *testing.T) {
+	if _, err := NewWithDriver("mysql", "ignored"); err == nil || !strings.Contains(err.Error(), "unknown database driver") {
+		t.Errorf("Expected an unknown driver error, got %v", err)
+	}
+}
+
+// openAllDrivers returns a database per testable driver: SQLite always, and
+// Postgres when postgresDSNEnv is set
+func openAllDrivers(t *testing.T) map[string]*DB {
+	t.Helper()
+	databases := make(map[string]*DB)
+
+	lite, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite database: %v", err)
+	}
+	t.Cleanup(func() { lite.Close() })
+	databases[DriverSQLite] = lite
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Logf("Skipping Postgres: set %s to run it", postgresDSNEnv)
+		return databases
+	}
+	database, err := NewWithDriver(DriverPostgres, dsn)
+	if err != nil {
+		t.Fatalf("Failed to open Postgres database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	databases[DriverPostgres] = database
+	return databases
+}
+
+func TestCoreOperations_AllDrivers(t *testing.T) {
+	for driver, database := range openAllDrivers(t) {
+		t.Run(driver, func(t *testing.T) {
+			// Unique per run, since a Postgres database outlives the test
+			agentID := "agent-" + strings.ToLower(time.Now().Format("20060102150405.000000000"))
+
+			before, err := database.GetActiveAgentCount(5)
+			if err != nil {
+				t.Fatalf("GetActiveAgentCount failed: %v", err)
+			}
+			if err := database.CreateOrUpdateAgent(agentID, "1.0.0"); err != nil {
+				t.Fatalf("CreateOrUpdateAgent failed: %v", err)
+			}
+			// The upsert path updates the existing row
+			if err := database.CreateOrUpdateAgent(agentID, "1.1.0"); err != nil {
+				t.Fatalf("CreateOrUpdateAgent update failed: %v", err)
+			}
+			agent, err := database.GetAgent(agentID)
+			if err != nil || agent == nil {
+				t.Fatalf("GetAgent failed: %v (%v)", agent, err)
+			}
+			if agent.Version != "1.1.0" {
+				t.Errorf("Expected upserted version 1.1.0, got %q", agent.Version)
+			}
+			if after, err := database.GetActiveAgentCount(5); err != nil || after != before+1 {
+				t.Errorf("Expected %d active agents, got %d (%v)", before+1, after, err)
+			}
+
+			key, err := database.CreateScopedAPIKey("ci", time.Hour, []string{ScopeAll})
+			if err != nil {
+				t.Fatalf("CreateScopedAPIKey failed: %v", err)
+			}
+			if valid, err := database.ValidateAPIKey(key); err != nil || !valid {
+				t.Errorf("Expected created key to validate, got %v (%v)", valid, err)
+			}
+
+			// Seeding twice is a no-op the second time
+			seeded := "sk_seed_" + agentID
+			for i := 0; i < 2; i++ {
+				if err := database.EnsureAPIKey(seeded, "seed"); err != nil {
+					t.Fatalf("EnsureAPIKey failed: %v", err)
+				}
+			}
+			if valid, err := database.ValidateAPIKey(seeded); err != nil || !valid {
+				t.Errorf("Expected seeded key to validate, got %v (%v)", valid, err)
+			}
+
+			// Name globs and the tag cost split use SQLite-only SQL on SQLite
+			if _, err := database.CreateScopedAPIKey("rotate-"+agentID, time.Hour, []string{ScopeAll}); err != nil {
+				t.Fatalf("CreateScopedAPIKey failed: %v", err)
+			}
+			if n, err := database.RevokeAPIKeys("rotate-"+agentID+"*", 0); err != nil || n != 1 {
+				t.Errorf("Expected one key revoked by name glob, got %d (%v)", n, err)
+			}
+			tagKey := "team-" + agentID
+			if err := database.SaveEgressCostWithTags("aws", "1999-01-01", agentID, "us-east-1", 8, nil, []CostTag{{Key: tagKey, Value: "net", Weight: 0.75}}); err != nil {
+				t.Fatalf("SaveEgressCostWithTags failed: %v", err)
+			}
+			byTag, err := database.GetCostsByTag(tagKey, "1999-01-01", "1999-01-01")
+			if err != nil || !slices.Contains(byTag, TagCost{Value: "net", CostUSD: 6}) {
+				t.Errorf("Expected 6 of the cost allocated to net, got %+v (%v)", byTag, err)
+			}
+
+			version, err := database.GetSchemaVersion()
+			if err != nil || version.Pending {
+				t.Errorf("Expected a fully migrated schema, got %+v (%v)", version, err)
+			}
+		})
+	}
+}
+
+func TestDialect_GlobForPostgres(t *testing.T) {
+	pg, _ := DialectFor(DriverPostgres)
+	if cond, arg := pg.Glob("name", "ci-*"); cond != "name ~ ?" || arg != "^ci-.*$" {
+		t.Errorf("Glob = %q, %v", cond, arg)
+	}
+	lite, _ := DialectFor(DriverSQLite)
+	if cond, arg := lite.Glob("name", "ci-*"); cond != "name GLOB ?" || arg != "ci-*" {
+		t.Errorf("SQLite Glob = %q, %v", cond, arg)
+	}
+
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"ci-*", "ci-runner", true},
+		{"ci-*", "CI-runner", false},
+		{"key?", "key1", true},
+		{"key?", "key12", false},
+		{"a.b", "axb", false},
+		{"[abc]-key", "b-key", true},
+		{"[^abc]-key", "b-key", false},
+		{"[]x]", "]", true},
+		{"deploy (old)*", "deploy (old) 2", true},
+		{"[unclosed", "[unclosed", true},
+		{"café*", "café-1", true},
+	}
+	for _, tt := range tests {
+		re, err := regexp.Compile(globRegexp(tt.pattern))
+		if err != nil {
+			t.Errorf("%q: invalid regexp %q: %v", tt.pattern, globRegexp(tt.pattern), err)
+			continue
+		}
+		if got := re.MatchString(tt.name); got != tt.want {
+			t.Errorf("%q against %q = %v, want %v (regexp %q)", tt.pattern, tt.name, got, tt.want, re)
+		}
+	}
+}
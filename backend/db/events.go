@@ -0,0 +1,39 @@
+package db
+
+import "sync"
+
+// costListeners is the invalidation bus for cost data. Anything caching
+// aggregates derived from egress_costs or recommendations subscribes with
+// OnCostChange and is called after every write that changes either table.
+type costListeners struct {
+	mu   sync.RWMutex
+	next uint64
+	fns  map[uint64]func()
+}
+
+// OnCostChange registers fn to be called after egress costs or
+// recommendations are written or deleted. fn runs synchronously on the writer's goroutine, so it must be cheap.
+// The returned func unregisters fn; it is safe to call more than once.
+func (db *DB) OnCostChange(fn func()) (unregister func()) {
+	db.costEvents.mu.Lock()
+	defer db.costEvents.mu.Unlock()
+	if db.costEvents.fns == nil {
+		db.costEvents.fns = make(map[uint64]func())
+	}
+	id := db.costEvents.next
+	db.costEvents.next++
+	db.costEvents.fns[id] = fn
+	return func() {
+		db.costEvents.mu.Lock()
+		defer db.costEvents.mu.Unlock()
+		delete(db.costEvents.fns, id)
+	}
+}
+
+func (db *DB) notifyCostChange() {
+	db.costEvents.mu.RLock()
+	defer db.costEvents.mu.RUnlock()
+	for _, fn := range db.costEvents.fns {
+		fn()
+	}
+}
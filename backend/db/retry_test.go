@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database, dbPath
+}
+
+func TestDB_WriteRetriesOnContention(t *testing.T) {
+	database, dbPath := openTestDB(t)
+	database.SetRetryPolicy(RetryPolicy{MaxRetries: 10, BaseDelay: 5 * time.Millisecond})
+
+	// A second connection holds the write lock for a while
+	locker, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open locking connection: %v", err)
+	}
+	defer locker.Close()
+	locker.SetMaxOpenConns(1)
+
+	if _, err := locker.Exec("BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("Failed to take write lock: %v", err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		locker.Exec("COMMIT")
+		close(released)
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- database.CreateOrUpdateAgent(fmt.Sprintf("agent-%d", i), "1.0.0")
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			errs <- database.SaveEgressCost("aws", "2024-01-01", fmt.Sprintf("svc-%d", i), "us-east-1", 1.5, nil)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	<-released
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Expected contended write to be retried, got: %v", err)
+		}
+	}
+
+	count, _ := database.GetAgentCount()
+	if count != 10 {
+		t.Errorf("Expected 10 agents, got %d", count)
+	}
+}
+
+func TestDB_WriteFailsWhenRetriesExhausted(t *testing.T) {
+	database, dbPath := openTestDB(t)
+	database.SetRetryPolicy(RetryPolicy{MaxRetries: 0})
+
+	locker, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open locking connection: %v", err)
+	}
+	defer locker.Close()
+	locker.SetMaxOpenConns(1)
+
+	if _, err := locker.Exec("BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("Failed to take write lock: %v", err)
+	}
+	defer locker.Exec("ROLLBACK")
+
+	err = database.CreateOrUpdateAgent("agent-1", "1.0.0")
+	if err == nil {
+		t.Fatal("Expected busy error with retries disabled")
+	}
+	if !isBusyError(err) {
+		t.Errorf("Expected busy error, got: %v", err)
+	}
+}
+
+func TestDB_ConstraintErrorNotRetried(t *testing.T) {
+	database, _ := openTestDB(t)
+	database.SetRetryPolicy(RetryPolicy{MaxRetries: 5, BaseDelay: time.Second})
+
+	if _, err := database.execWithRetry(`INSERT INTO cloud_configs (id, provider, config_json) VALUES ('dup', 'aws', '{}')`); err != nil {
+		t.Fatalf("Initial insert failed: %v", err)
+	}
+
+	start := time.Now()
+	_, err := database.execWithRetry(`INSERT INTO cloud_configs (id, provider, config_json) VALUES ('dup', 'aws', '{}')`)
+	if err == nil {
+		t.Fatal("Expected constraint violation")
+	}
+	if isBusyError(err) {
+		t.Errorf("Constraint violation misclassified as busy: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Constraint violation was retried (took %v)", elapsed)
+	}
+}
@@ -0,0 +1,55 @@
+package fleet
+
+import (
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/logging"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// Pruner deletes agents that haven't reported within maxAge, along with their
+// commands, state history and Prometheus series, as well as any events older
+// than maxAge. Unlike quarantine this is permanent: a pruned agent
+// re-registers from scratch on its next heartbeat.
+type Pruner struct {
+	database *db.DB
+	maxAge   time.Duration
+	prom     *metrics.Metrics // nil removes series from metrics.Default()
+}
+
+func NewPruner(database *db.DB, maxAge time.Duration) *Pruner {
+	return &Pruner{database: database, maxAge: maxAge}
+}
+
+// SetMetrics removes pruned agents' series from m instead of metrics.Default()
+func (p *Pruner) SetMetrics(m *metrics.Metrics) {
+	p.prom = m
+}
+
+func (p *Pruner) metrics() *metrics.Metrics {
+	if p.prom == nil {
+		return metrics.Default()
+	}
+	return p.prom
+}
+
+// Sweep removes every agent and event older than maxAge and returns how many
+// agents went
+func (p *Pruner) Sweep() (int, error) {
+	ids, err := p.database.DeleteStaleAgentIDs(p.maxAge)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		p.metrics().RemoveAgentMetrics(id)
+	}
+
+	events, err := p.database.DeleteAgentEventsBefore(p.database.Now().Add(-p.maxAge))
+	if err != nil {
+		return len(ids), err
+	}
+
+	logging.Infof("Pruned %d agents and %d events older than %s", len(ids), events, p.maxAge)
+	return len(ids), nil
+}
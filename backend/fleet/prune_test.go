@@ -0,0 +1,53 @@
+package fleet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func TestPruner_Sweep(t *testing.T) {
+	database, raw := setupTestDB(t)
+	seedAgent(t, database, raw, "abandoned", 30*24*time.Hour)
+	seedAgent(t, database, raw, "fresh", time.Minute)
+	database.SaveAgentEvents("fresh", []db.AgentEvent{
+		{Type: "anomaly", ReceivedAt: time.Now().Add(-30 * 24 * time.Hour)},
+		{Type: "anomaly", ReceivedAt: time.Now()},
+	})
+
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	m.UpdateAgentMetrics("abandoned", time.Now(), 1, 1, 1, 1, 0, 60)
+	m.UpdateAgentMetrics("fresh", time.Now(), 1, 1, 1, 1, 0, 60)
+
+	pruner := NewPruner(database, 7*24*time.Hour)
+	pruner.SetMetrics(m)
+	removed, err := pruner.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 agent pruned, got %d", removed)
+	}
+
+	if agent, err := database.GetAgent("abandoned"); err != nil || agent != nil {
+		t.Errorf("Expected abandoned agent to be deleted, got %+v, %v", agent, err)
+	}
+	if agent, err := database.GetAgent("fresh"); err != nil || agent == nil {
+		t.Errorf("Expected fresh agent to survive, got %+v, %v", agent, err)
+	}
+
+	if series := m.AgentSeriesIDs(); series["abandoned"] || !series["fresh"] {
+		t.Errorf("Expected only the pruned agent's series removed, got %v", series)
+	}
+	if events, _ := database.GetAgentEvents("fresh", 10); len(events) != 1 {
+		t.Errorf("Expected only the recent event kept, got %+v", events)
+	}
+
+	// Nothing left to prune on the next sweep
+	if removed, err := NewPruner(database, 7*24*time.Hour).Sweep(); err != nil || removed != 0 {
+		t.Errorf("Second sweep = %d, %v; want 0, nil", removed, err)
+	}
+}
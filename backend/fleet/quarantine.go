@@ -0,0 +1,98 @@
+// Package fleet manages the lifecycle of registered agents
+package fleet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/logging"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// Notifier is told which agents were just quarantined
+type Notifier func(agentIDs []string, deadline time.Duration) error
+
+// Quarantiner moves agents that miss their heartbeat deadline into the
+// quarantined state and drops their Prometheus series. This is separate from
+// retention: quarantined agents keep their records and return to active (and
+// to the metrics) on their next heartbeat.
+type Quarantiner struct {
+	database *db.DB
+	deadline time.Duration
+	notify   Notifier
+	prom     *metrics.Metrics // nil removes series from metrics.Default()
+}
+
+func NewQuarantiner(database *db.DB, deadline time.Duration) *Quarantiner {
+	return &Quarantiner{database: database, deadline: deadline}
+}
+
+// SetNotifier sets a callback fired after each sweep that quarantines agents
+func (q *Quarantiner) SetNotifier(notify Notifier) {
+	q.notify = notify
+}
+
+// SetMetrics removes quarantined agents' series from m instead of
+// metrics.Default()
+func (q *Quarantiner) SetMetrics(m *metrics.Metrics) {
+	q.prom = m
+}
+
+func (q *Quarantiner) metrics() *metrics.Metrics {
+	if q.prom == nil {
+		return metrics.Default()
+	}
+	return q.prom
+}
+
+// Sweep quarantines every active agent past the deadline and returns their IDs.
+// A notification failure is returned but doesn't undo the quarantine.
+func (q *Quarantiner) Sweep() ([]string, error) {
+	ids, err := q.database.QuarantineAgentsNotSeenSince(q.deadline)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	for _, id := range ids {
+		q.metrics().RemoveAgentMetrics(id)
+	}
+
+	logging.Warnf("Quarantined %d agents not seen for %s: %v", len(ids), q.deadline, ids)
+	if q.notify != nil {
+		if err := q.notify(ids, q.deadline); err != nil {
+			return ids, fmt.Errorf("quarantine notification failed: %w", err)
+		}
+	}
+	return ids, nil
+}
+
+// WebhookNotifier posts a JSON notification to url
+func WebhookNotifier(url string) Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(agentIDs []string, deadline time.Duration) error {
+		body, err := json.Marshal(map[string]interface{}{
+			"event":    "agents_quarantined",
+			"agents":   agentIDs,
+			"deadline": deadline.String(),
+		})
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+package fleet
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func setupTestDB(t *testing.T) (*db.DB, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.New(path)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open raw connection: %v", err)
+	}
+	t.Cleanup(func() {
+		raw.Close()
+		database.Close()
+	})
+	return database, raw
+}
+
+func seedAgent(t *testing.T, database *db.DB, raw *sql.DB, id string, age time.Duration) {
+	t.Helper()
+	if err := database.CreateOrUpdateAgent(id, "1.0.0"); err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	lastSeen := time.Now().UTC().Add(-age).Format("2006-01-02 15:04:05")
+	if _, err := raw.Exec(`UPDATE agents SET last_seen = ? WHERE id = ?`, lastSeen, id); err != nil {
+		t.Fatalf("Failed to backdate agent: %v", err)
+	}
+}
+
+func agentState(t *testing.T, database *db.DB, id string) string {
+	t.Helper()
+	agent, err := database.GetAgent(id)
+	if err != nil || agent == nil {
+		t.Fatalf("GetAgent(%s) = %v, %v", id, agent, err)
+	}
+	return agent.State
+}
+
+func TestQuarantiner_Sweep(t *testing.T) {
+	database, raw := setupTestDB(t)
+	seedAgent(t, database, raw, "absent", 3*time.Hour)
+	seedAgent(t, database, raw, "recent", time.Minute)
+
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	m.SetAgentGauges("absent", time.Now(), 1, 1, 1, 1, 0, 60)
+	m.SetAgentGauges("recent", time.Now(), 1, 1, 1, 1, 0, 60)
+
+	q := NewQuarantiner(database, time.Hour)
+	q.SetMetrics(m)
+	ids, err := q.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "absent" {
+		t.Errorf("Expected only the absent agent quarantined, got %v", ids)
+	}
+	if series := m.AgentSeriesIDs(); series["absent"] || !series["recent"] {
+		t.Errorf("Expected only the quarantined agent's series dropped, got %v", series)
+	}
+
+	if s := agentState(t, database, "absent"); s != db.AgentStateQuarantined {
+		t.Errorf("Expected absent agent quarantined, got %q", s)
+	}
+	if s := agentState(t, database, "recent"); s != db.AgentStateActive {
+		t.Errorf("Expected recent agent active, got %q", s)
+	}
+
+	transitions, err := database.GetAgentStateTransitions("absent")
+	if err != nil {
+		t.Fatalf("GetAgentStateTransitions failed: %v", err)
+	}
+	if len(transitions) != 1 || transitions[0].ToState != db.AgentStateQuarantined {
+		t.Errorf("Expected one recorded quarantine transition, got %+v", transitions)
+	}
+
+	// Already-quarantined agents aren't transitioned again
+	if ids, _ := q.Sweep(); len(ids) != 0 {
+		t.Errorf("Expected second sweep to be a no-op, got %v", ids)
+	}
+
+	// A heartbeat brings the agent back
+	if ok, err := database.ReactivateAgent("absent"); err != nil || !ok {
+		t.Fatalf("ReactivateAgent = %v, %v", ok, err)
+	}
+	if s := agentState(t, database, "absent"); s != db.AgentStateActive {
+		t.Errorf("Expected reactivated agent active, got %q", s)
+	}
+}
+
+func TestQuarantiner_WebhookNotification(t *testing.T) {
+	var got struct {
+		Event  string   `json:"event"`
+		Agents []string `json:"agents"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	database, raw := setupTestDB(t)
+	seedAgent(t, database, raw, "absent", 3*time.Hour)
+
+	q := NewQuarantiner(database, time.Hour)
+	q.SetNotifier(WebhookNotifier(server.URL))
+	if _, err := q.Sweep(); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	if got.Event != "agents_quarantined" || len(got.Agents) != 1 || got.Agents[0] != "absent" {
+		t.Errorf("Unexpected webhook payload: %+v", got)
+	}
+}
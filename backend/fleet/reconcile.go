@@ -0,0 +1,90 @@
+package fleet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/logging"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// MetricsDrift lists where the per-agent Prometheus gauges and the agents
+// table disagree. Missing series are expected briefly after a restart, until
+// each agent's next heartbeat; orphaned series belong to agents that were
+// deleted without their metrics being removed.
+type MetricsDrift struct {
+	Agents         int      `json:"agents"`          // Rows in the agents table
+	Series         int      `json:"series"`          // Agent IDs with a gauge series
+	MissingSeries  []string `json:"missing_series"`  // In the DB, no gauge series
+	OrphanedSeries []string `json:"orphaned_series"` // Gauge series, not in the DB
+}
+
+// ReconcileMetrics compares the agents in the database with the agents that
+// have gauge series in m. It only reports; nothing is changed.
+func ReconcileMetrics(database *db.DB, m *metrics.Metrics) (*MetricsDrift, error) {
+	ids, err := database.ListAgentIDs()
+	if err != nil {
+		return nil, err
+	}
+	series := m.AgentSeriesIDs()
+
+	drift := &MetricsDrift{
+		Agents:         len(ids),
+		Series:         len(series),
+		MissingSeries:  []string{},
+		OrphanedSeries: []string{},
+	}
+	inDB := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		inDB[id] = true
+		if !series[id] {
+			drift.MissingSeries = append(drift.MissingSeries, id)
+		}
+	}
+	for id := range series {
+		if !inDB[id] {
+			drift.OrphanedSeries = append(drift.OrphanedSeries, id)
+		}
+	}
+	sort.Strings(drift.OrphanedSeries)
+	return drift, nil
+}
+
+// RemoveOrphanedMetrics drops the series in m of agents that are no longer
+// in the database, catching deletions that bypassed the prune path, and of
+// agents that haven't reported within inactiveAfter, so series don't linger
+// for agents that went away without being pruned. Zero keeps inactive
+// agents' series. It returns how many agents' series went.
+func RemoveOrphanedMetrics(database *db.DB, m *metrics.Metrics, inactiveAfter time.Duration) (int, error) {
+	drift, err := ReconcileMetrics(database, m)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range drift.OrphanedSeries {
+		m.RemoveAgentMetrics(id)
+	}
+	if len(drift.OrphanedSeries) > 0 {
+		logging.Infof("Removed metrics for %d agents no longer in the database", len(drift.OrphanedSeries))
+	}
+	if inactiveAfter <= 0 {
+		return len(drift.OrphanedSeries), nil
+	}
+
+	inactive, err := database.GetAgentsNotSeenSince(database.Now().Add(-inactiveAfter))
+	if err != nil {
+		return len(drift.OrphanedSeries), err
+	}
+	series := m.AgentSeriesIDs()
+	removed := 0
+	for _, a := range inactive {
+		if series[a.ID] {
+			m.RemoveAgentMetrics(a.ID)
+			removed++
+		}
+	}
+	if removed > 0 {
+		logging.Infof("Removed metrics for %d agents not seen for %s", removed, inactiveAfter)
+	}
+	return len(drift.OrphanedSeries) + removed, nil
+}
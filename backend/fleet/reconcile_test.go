@@ -0,0 +1,88 @@
+package fleet
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func TestReconcileMetrics(t *testing.T) {
+	database, raw := setupTestDB(t)
+	seedAgent(t, database, raw, "reconcile-reporting", time.Minute)
+	seedAgent(t, database, raw, "reconcile-restarted", time.Minute)
+	metrics.SetAgentGauges("reconcile-reporting", 1, 1, 1, 1, 0, 60)
+	metrics.SetAgentGauges("reconcile-restarted", 1, 1, 1, 1, 0, 60)
+	metrics.SetAgentGauges("reconcile-deleted", 1, 1, 1, 1, 0, 60)
+	t.Cleanup(func() {
+		for _, id := range []string{"reconcile-reporting", "reconcile-restarted", "reconcile-deleted"} {
+			metrics.RemoveAgentMetrics(id)
+		}
+	})
+
+	// Simulate a server restart for one agent: its gauges are gone but the row stays
+	metrics.RemoveAgentMetrics("reconcile-restarted")
+
+	drift, err := ReconcileMetrics(database, metrics.Default())
+	if err != nil {
+		t.Fatalf("ReconcileMetrics failed: %v", err)
+	}
+	if !slices.Contains(drift.MissingSeries, "reconcile-restarted") {
+		t.Errorf("Expected reconcile-restarted to be missing series, got %v", drift.MissingSeries)
+	}
+	if slices.Contains(drift.MissingSeries, "reconcile-reporting") {
+		t.Errorf("Expected reconcile-reporting to have series, got %v", drift.MissingSeries)
+	}
+	if !slices.Contains(drift.OrphanedSeries, "reconcile-deleted") {
+		t.Errorf("Expected reconcile-deleted to be orphaned, got %v", drift.OrphanedSeries)
+	}
+	if drift.Agents != 2 {
+		t.Errorf("Expected 2 agents, got %d", drift.Agents)
+	}
+}
+
+func TestRemoveOrphanedMetrics(t *testing.T) {
+	database, raw := setupTestDB(t)
+	seedAgent(t, database, raw, "orphan-kept", time.Minute)
+	metrics.SetAgentGauges("orphan-kept", 1, 1, 1, 1, 0, 60)
+	metrics.SetAgentGauges("orphan-gone", 1, 1, 1, 1, 0, 60)
+	t.Cleanup(func() {
+		metrics.RemoveAgentMetrics("orphan-kept")
+		metrics.RemoveAgentMetrics("orphan-gone")
+	})
+
+	removed, err := RemoveOrphanedMetrics(database, metrics.Default(), 0)
+	if err != nil {
+		t.Fatalf("RemoveOrphanedMetrics failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 agent's series removed, got %d", removed)
+	}
+	series := metrics.AgentSeriesIDs()
+	if series["orphan-gone"] || !series["orphan-kept"] {
+		t.Errorf("Expected only orphan-kept's series to remain, got %v", series)
+	}
+}
+
+func TestRemoveOrphanedMetrics_InactiveAgents(t *testing.T) {
+	database, raw := setupTestDB(t)
+	seedAgent(t, database, raw, "inactive-live", time.Minute)
+	seedAgent(t, database, raw, "inactive-gone", 3*time.Hour)
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	m.SetAgentGauges("inactive-live", time.Now(), 1, 1, 1, 1, 0, 60)
+	m.SetAgentGauges("inactive-gone", time.Now(), 1, 1, 1, 1, 0, 60)
+
+	removed, err := RemoveOrphanedMetrics(database, m, time.Hour)
+	if err != nil {
+		t.Fatalf("RemoveOrphanedMetrics failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 agent's series removed, got %d", removed)
+	}
+	series := m.AgentSeriesIDs()
+	if series["inactive-gone"] || !series["inactive-live"] {
+		t.Errorf("Expected only inactive-live's series to remain, got %v", series)
+	}
+}
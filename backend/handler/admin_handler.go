@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sennet/sennet/backend/crypto"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/fleet"
+	"github.com/sennet/sennet/backend/logging"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// AdminHandler serves server maintenance endpoints
+type AdminHandler struct {
+	database *db.DB
+	prom     *metrics.Metrics // nil reads metrics.Default()
+}
+
+func NewAdminHandler(database *db.DB) *AdminHandler {
+	return &AdminHandler{database: database}
+}
+
+// SetMetrics reconciles the agent series in m instead of metrics.Default()
+func (h *AdminHandler) SetMetrics(m *metrics.Metrics) {
+	h.prom = m
+}
+
+func (h *AdminHandler) metrics() *metrics.Metrics {
+	if h.prom == nil {
+		return metrics.Default()
+	}
+	return h.prom
+}
+
+// ReEncryptFailure describes a config that could not be re-encrypted
+type ReEncryptFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// ReEncryptResponse reports the outcome of a re-encryption pass
+type ReEncryptResponse struct {
+	Total          int                `json:"total"`
+	ReEncrypted    int                `json:"reencrypted"`
+	AlreadyCurrent int                `json:"already_current"`
+	Plaintext      int                `json:"plaintext"` // Legacy unencrypted rows, left untouched
+	Failed         []ReEncryptFailure `json:"failed"`
+}
+
+// HandleReEncrypt re-encrypts every stored cloud config under the current
+// ENCRYPTION_KEY. Each config is swapped atomically, and configs already
+// under the current key are skipped, so the pass is safe to repeat or resume
+// after an interruption.
+func (h *AdminHandler) HandleReEncrypt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := crypto.GetEncryptionKey(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	configs, err := h.database.GetCloudConfigs()
+	if err != nil {
+		http.Error(w, "Failed to load cloud configs", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ReEncryptResponse{Total: len(configs), Failed: []ReEncryptFailure{}}
+	for i, c := range configs {
+		switch {
+		case json.Valid([]byte(c.ConfigJSON)):
+			resp.Plaintext++
+		case crypto.IsCurrent(c.ConfigJSON):
+			resp.AlreadyCurrent++
+		default:
+			if err := h.reEncryptConfig(c); err != nil {
+				resp.Failed = append(resp.Failed, ReEncryptFailure{ID: c.ID, Error: err.Error()})
+			} else {
+				resp.ReEncrypted++
+			}
+		}
+		logging.Debugf("Re-encrypt progress: %d/%d cloud configs", i+1, len(configs))
+	}
+	logging.Infof("Re-encrypted %d of %d cloud configs (%d current, %d plaintext, %d failed)",
+		resp.ReEncrypted, resp.Total, resp.AlreadyCurrent, resp.Plaintext, len(resp.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Failed) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleSchemaVersion reports the applied and latest schema migration, so
+// operators can confirm an upgrade migrated the database
+func (h *AdminHandler) HandleSchemaVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	version, err := h.database.GetSchemaVersion()
+	if err != nil {
+		http.Error(w, "Failed to read schema version", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// HandleMetricsReconcile reports agents whose Prometheus gauges have drifted
+// from the agents table (see fleet.MetricsDrift)
+func (h *AdminHandler) HandleMetricsReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	drift, err := fleet.ReconcileMetrics(h.database, h.metrics())
+	if err != nil {
+		http.Error(w, "Failed to list agents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drift)
+}
+
+// Audit log page sizes for HandleGetAuditLogs
+const (
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 1000
+)
+
+// AuditLogPage is one page of HandleGetAuditLogs results, newest first
+type AuditLogPage struct {
+	Entries []db.AuditLog `json:"entries"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+	HasMore bool          `json:"has_more"`
+}
+
+// HandleGetAuditLogs queries stored audit logs. Optional filters: user (user
+// ID), path_prefix, status, and start/end as RFC 3339 times (start inclusive,
+// end exclusive); limit and offset page through the results.
+func (h *AdminHandler) HandleGetAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := db.AuditLogFilter{
+		UserID:     query.Get("user"),
+		PathPrefix: query.Get("path_prefix"),
+		Limit:      DefaultAuditPageSize,
+	}
+	if v := query.Get("status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 100 || n > 599 {
+			http.Error(w, "status must be an HTTP status code", http.StatusBadRequest)
+			return
+		}
+		filter.StatusCode = n
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"start", &filter.Since}, {"end", &filter.Until}} {
+		if v := query.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = min(n, MaxAuditPageSize)
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		filter.Offset = n
+	}
+
+	// Fetch one extra entry to learn whether another page follows
+	page := AuditLogPage{Limit: filter.Limit, Offset: filter.Offset}
+	filter.Limit++
+	entries, err := h.database.GetAuditLogs(filter)
+	if err != nil {
+		http.Error(w, "Failed to query audit logs", http.StatusInternalServerError)
+		return
+	}
+	if len(entries) > page.Limit {
+		entries, page.HasMore = entries[:page.Limit], true
+	}
+	page.Entries = entries
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *AdminHandler) reEncryptConfig(c db.CloudConfig) error {
+	ciphertext, err := crypto.ReEncrypt(c.ConfigJSON)
+	if err != nil {
+		return err
+	}
+	swapped, err := h.database.ReplaceCloudConfigJSON(c.ID, c.ConfigJSON, ciphertext)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		return errConfigChanged
+	}
+	return nil
+}
+
+var errConfigChanged = errors.New("config changed during re-encryption; run again")
@@ -0,0 +1,206 @@
+package handler_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/crypto"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/fleet"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func postReEncrypt(t *testing.T, h *handler.AdminHandler) handler.ReEncryptResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.HandleReEncrypt(rec, httptest.NewRequest(http.MethodPost, "/api/admin/reencrypt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp handler.ReEncryptResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+func TestHandleReEncrypt_RotatesToPrimaryKey(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	oldKey, newKey := testKey(t), testKey(t)
+
+	// Configs written under the old key
+	t.Setenv("ENCRYPTION_KEY", oldKey)
+	for _, id := range []string{"aws-main", "gcp-main"} {
+		ciphertext, err := crypto.EncryptString(`{"id":"` + id + `"}`)
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		database.SaveCloudConfig(id, "aws", ciphertext)
+	}
+	database.SaveCloudConfig("legacy", "aws", `{"id":"legacy"}`)
+
+	// Rotate: new primary, old key kept for reading
+	t.Setenv("ENCRYPTION_KEY", newKey)
+	t.Setenv("ENCRYPTION_KEY_OLD", oldKey)
+
+	h := handler.NewAdminHandler(database)
+	resp := postReEncrypt(t, h)
+	if resp.Total != 3 || resp.ReEncrypted != 2 || resp.Plaintext != 1 || len(resp.Failed) != 0 {
+		t.Errorf("Unexpected result: %+v", resp)
+	}
+
+	// Everything must now decrypt under the new key alone
+	t.Setenv("ENCRYPTION_KEY_OLD", "")
+	configs, err := database.GetCloudConfigs()
+	if err != nil {
+		t.Fatalf("GetCloudConfigs failed: %v", err)
+	}
+	for _, c := range configs {
+		if c.ID == "legacy" {
+			continue
+		}
+		plaintext, err := crypto.DecryptString(c.ConfigJSON)
+		if err != nil {
+			t.Errorf("%s: failed to decrypt under new key: %v", c.ID, err)
+			continue
+		}
+		if plaintext != `{"id":"`+c.ID+`"}` {
+			t.Errorf("%s: unexpected plaintext %q", c.ID, plaintext)
+		}
+	}
+
+	// A second pass is a no-op
+	again := postReEncrypt(t, h)
+	if again.ReEncrypted != 0 || again.AlreadyCurrent != 2 {
+		t.Errorf("Expected idempotent second pass, got %+v", again)
+	}
+}
+
+func TestHandleReEncrypt_RequiresKey(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	t.Setenv("ENCRYPTION_KEY", "")
+
+	rec := httptest.NewRecorder()
+	handler.NewAdminHandler(database).HandleReEncrypt(rec, httptest.NewRequest(http.MethodPost, "/api/admin/reencrypt", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 without ENCRYPTION_KEY, got %d", rec.Code)
+	}
+}
+
+func TestHandleSchemaVersion_FreshDatabaseIsCurrent(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewAdminHandler(database)
+
+	rec := httptest.NewRecorder()
+	h.HandleSchemaVersion(rec, httptest.NewRequest(http.MethodGet, "/api/admin/schema-version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp db.SchemaVersion
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Latest == 0 || resp.Current != resp.Latest || resp.Pending {
+		t.Errorf("Expected a fresh database at the latest version, got %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleSchemaVersion(rec, httptest.NewRequest(http.MethodPost, "/api/admin/schema-version", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
+
+func TestHandleMetricsReconcile_ListsMissingSeries(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewAdminHandler(database)
+
+	database.CreateOrUpdateAgent("reconcile-agent", "1.0.0")
+	metrics.RemoveAgentMetrics("reconcile-agent")
+
+	rec := httptest.NewRecorder()
+	h.HandleMetricsReconcile(rec, httptest.NewRequest(http.MethodGet, "/api/admin/metrics-reconcile", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var drift fleet.MetricsDrift
+	if err := json.NewDecoder(rec.Body).Decode(&drift); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !slices.Contains(drift.MissingSeries, "reconcile-agent") {
+		t.Errorf("Expected reconcile-agent to be missing series, got %+v", drift)
+	}
+}
+
+func TestHandleGetAuditLogs_FiltersAndPages(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewAdminHandler(database)
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, status := range []int{200, 403, 200, 500} {
+		database.SaveAuditLog(db.AuditLog{
+			Timestamp:  base.Add(time.Duration(i) * time.Minute),
+			UserID:     "uid-" + strconv.Itoa(i%2),
+			Method:     "GET",
+			Path:       []string{"/api/clouds", "/api/admin/jobs"}[i%2],
+			StatusCode: status,
+		})
+	}
+
+	decode := func(target string) handler.AuditLogPage {
+		var page handler.AuditLogPage
+		if err := json.Unmarshal(getJSON(t, h.HandleGetAuditLogs, target), &page); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return page
+	}
+
+	page := decode("/api/admin/audit-logs?limit=3")
+	if len(page.Entries) != 3 || !page.HasMore || page.Entries[0].StatusCode != 500 {
+		t.Errorf("Expected the newest 3 of 4 entries with more to come, got %+v", page)
+	}
+	if page = decode("/api/admin/audit-logs?limit=3&offset=3"); len(page.Entries) != 1 || page.HasMore {
+		t.Errorf("Expected the last entry alone, got %+v", page)
+	}
+	if page = decode("/api/admin/audit-logs?user=uid-0&status=200"); len(page.Entries) != 2 {
+		t.Errorf("Expected 2 entries for uid-0 with status 200, got %+v", page)
+	}
+	if page = decode("/api/admin/audit-logs?path_prefix=/api/admin"); len(page.Entries) != 2 || page.Entries[0].Path != "/api/admin/jobs" {
+		t.Errorf("Expected the 2 admin entries, got %+v", page)
+	}
+	start, end := base.Add(time.Minute).Format(time.RFC3339), base.Add(3*time.Minute).Format(time.RFC3339)
+	if page = decode("/api/admin/audit-logs?start=" + start + "&end=" + end); len(page.Entries) != 2 {
+		t.Errorf("Expected 2 entries in range, got %+v", page)
+	}
+
+	for _, target := range []string{"?status=abc", "?start=yesterday", "?limit=0", "?offset=-1"} {
+		rec := httptest.NewRecorder()
+		h.HandleGetAuditLogs(rec, httptest.NewRequest(http.MethodGet, "/api/admin/audit-logs"+target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// DefaultAgentOnlineWindow matches the window used for the active agents gauge
+const DefaultAgentOnlineWindow = 5 * time.Minute
+
+// agentExportPageSize is how many agents the export reads per query
+const agentExportPageSize = 500
+
+// DefaultVersionWindow is how recently agents must have reported to count
+// in HandleVersionDistribution unless ?seen_within says otherwise
+const DefaultVersionWindow = 24 * time.Hour
+
+// Page sizes for HandleListAgents
+const (
+	DefaultAgentPageSize = 50
+	MaxAgentPageSize     = 500
+)
+
+// AgentHandler serves agent administration endpoints
+type AgentHandler struct {
+	database     *db.DB
+	onlineWindow time.Duration
+	prom         *metrics.Metrics // nil records into metrics.Default()
+}
+
+func NewAgentHandler(database *db.DB) *AgentHandler {
+	return &AgentHandler{database: database, onlineWindow: DefaultAgentOnlineWindow}
+}
+
+// SetOnlineWindow sets how recently an agent must have reported to be listed
+// as online; older agents are offline (stale)
+func (h *AgentHandler) SetOnlineWindow(d time.Duration) {
+	h.onlineWindow = d
+}
+
+// SetMetrics reads and removes agent series in m instead of metrics.Default()
+func (h *AgentHandler) SetMetrics(m *metrics.Metrics) {
+	h.prom = m
+}
+
+func (h *AgentHandler) metrics() *metrics.Metrics {
+	if h.prom == nil {
+		return metrics.Default()
+	}
+	return h.prom
+}
+
+// HandleDeleteStaleAgents removes agents not seen within ?older_than (e.g. 7d)
+// and clears their metrics
+func (h *AgentHandler) HandleDeleteStaleAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	olderThan, err := ParseAge(r.URL.Query().Get("older_than"))
+	if err != nil {
+		http.Error(w, "Invalid older_than: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	removed, err := h.database.DeleteStaleAgentIDs(olderThan)
+	if err != nil {
+		http.Error(w, "Failed to delete stale agents", http.StatusInternalServerError)
+		return
+	}
+	for _, id := range removed {
+		h.metrics().RemoveAgentMetrics(id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"older_than": olderThan.String(),
+		"removed":    len(removed),
+	})
+}
+
+// AgentRecord is one row of the agent inventory export
+type AgentRecord struct {
+	ID       string            `json:"id"`
+	Version  string            `json:"version"`
+	LastSeen time.Time         `json:"last_seen"`
+	Labels   map[string]string `json:"labels"`
+	Status   string            `json:"status"` // "online", "offline" or "quarantined"
+	Hostname string            `json:"hostname,omitempty"`
+	OS       string            `json:"os,omitempty"`
+	Kernel   string            `json:"kernel,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
+}
+
+func agentRecord(a db.Agent, now time.Time, onlineWindow time.Duration) AgentRecord {
+	status := "offline"
+	if a.State == db.AgentStateQuarantined {
+		status = db.AgentStateQuarantined
+	} else if now.Sub(a.LastSeen) <= onlineWindow {
+		status = "online"
+	}
+	return AgentRecord{
+		ID:       a.ID,
+		Version:  a.Version,
+		LastSeen: a.LastSeen,
+		Labels:   a.Labels,
+		Status:   status,
+		Hostname: a.Metadata.Hostname,
+		OS:       a.Metadata.OS,
+		Kernel:   a.Metadata.Kernel,
+		Arch:     a.Metadata.Arch,
+	}
+}
+
+// formatLabels renders labels as sorted "k=v" pairs joined by ";"
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+// HandleExportAgents streams every agent as JSON (default) or ?format=csv
+func (h *AgentHandler) HandleExportAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	// Read the first page before committing to a response so a DB failure
+	// can still be reported with a proper status
+	page, err := h.database.ListAgentsAfter("", agentExportPageSize)
+	if err != nil {
+		http.Error(w, "Failed to list agents", http.StatusInternalServerError)
+		return
+	}
+
+	// flush pushes buffered rows out; finish closes the document
+	var writeRow func(AgentRecord) error
+	var flush, finish func()
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"sennet-agents.csv\"")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "version", "last_seen", "labels", "status"})
+		writeRow = func(rec AgentRecord) error {
+			return cw.Write([]string{rec.ID, rec.Version, rec.LastSeen.UTC().Format(time.RFC3339), formatLabels(rec.Labels), rec.Status})
+		}
+		flush, finish = cw.Flush, cw.Flush
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"sennet-agents.json\"")
+		w.Write([]byte("["))
+		first := true
+		writeRow = func(rec AgentRecord) error {
+			row, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if !first {
+				w.Write([]byte(","))
+			}
+			first = false
+			_, err = w.Write(row)
+			return err
+		}
+		flush = func() {}
+		finish = func() { w.Write([]byte("]\n")) }
+	}
+
+	flusher, _ := w.(http.Flusher)
+	// Same clock that stamps last_seen
+	now := h.database.Now()
+	for len(page) > 0 {
+		for _, a := range page {
+			if err := writeRow(agentRecord(a, now, h.onlineWindow)); err != nil {
+				return
+			}
+		}
+		flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(page) < agentExportPageSize {
+			break
+		}
+		if page, err = h.database.ListAgentsAfter(page[len(page)-1].ID, agentExportPageSize); err != nil {
+			// Headers are already sent; truncate so the client sees a broken document
+			return
+		}
+	}
+	finish()
+}
+
+// AgentPage is a page of HandleListAgents
+type AgentPage struct {
+	Agents  []AgentRecord `json:"agents"`
+	Total   int           `json:"total"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+	Sort    string        `json:"sort"`
+	HasMore bool          `json:"has_more"`
+}
+
+// HandleListAgents returns a page of agents for the dashboard. ?limit
+// (default DefaultAgentPageSize, capped at MaxAgentPageSize) and ?offset
+// select the page and ?sort orders it by id (default), last_seen or version.
+func (h *AgentHandler) HandleListAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, offset := DefaultAgentPageSize, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, MaxAgentPageSize)
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	if _, ok := db.AgentSorts[sortBy]; !ok {
+		http.Error(w, "Unknown sort "+strconv.Quote(sortBy)+" (want id, last_seen or version)", http.StatusBadRequest)
+		return
+	}
+
+	total, err := h.database.GetAgentCount()
+	if err != nil {
+		http.Error(w, "Failed to count agents", http.StatusInternalServerError)
+		return
+	}
+	agents, err := h.database.ListAgents(limit, offset, sortBy)
+	if err != nil {
+		http.Error(w, "Failed to list agents", http.StatusInternalServerError)
+		return
+	}
+
+	now := h.database.Now()
+	page := AgentPage{
+		Agents:  make([]AgentRecord, 0, len(agents)),
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Sort:    sortBy,
+		HasMore: offset+len(agents) < total,
+	}
+	for _, a := range agents {
+		page.Agents = append(page.Agents, agentRecord(a, now, h.onlineWindow))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// VersionCount is the number of agents running a version
+type VersionCount struct {
+	Version string `json:"version"`
+	Count   int    `json:"count"`
+}
+
+// HandleVersionDistribution counts agents by the version they run, oldest
+// version first, so operators can size an upgrade. Only agents seen within
+// ?seen_within (e.g. 7d, default DefaultVersionWindow) count, so dead agents
+// don't skew it.
+func (h *AgentHandler) HandleVersionDistribution(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := DefaultVersionWindow
+	if v := r.URL.Query().Get("seen_within"); v != "" {
+		d, err := ParseAge(v)
+		if err != nil {
+			http.Error(w, "Invalid seen_within: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	counts, err := h.database.GetVersionDistribution(h.database.Now().Add(-window))
+	if err != nil {
+		http.Error(w, "Failed to count agent versions", http.StatusInternalServerError)
+		return
+	}
+
+	dist := make([]VersionCount, 0, len(counts))
+	for version, n := range counts {
+		dist = append(dist, VersionCount{Version: version, Count: n})
+	}
+	sort.Slice(dist, func(i, j int) bool {
+		if c := compareVersions(parseVersion(dist[i].Version), parseVersion(dist[j].Version)); c != 0 {
+			return c < 0
+		}
+		return dist[i].Version < dist[j].Version
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dist)
+}
+
+// metricComparisons are the operators accepted by HandleAgentsByMetric
+var metricComparisons = map[string]func(v, threshold float64) bool{
+	"gt":  func(v, t float64) bool { return v > t },
+	"gte": func(v, t float64) bool { return v >= t },
+	"lt":  func(v, t float64) bool { return v < t },
+	"lte": func(v, t float64) bool { return v <= t },
+	"eq":  func(v, t float64) bool { return v == t },
+}
+
+// sampleMetrics are the metrics accepted by HandleAgentsByMetric, read from a
+// stored sample
+var sampleMetrics = map[string]func(db.MetricsSample) uint64{
+	"rx_packets":     func(m db.MetricsSample) uint64 { return m.RxPackets },
+	"tx_packets":     func(m db.MetricsSample) uint64 { return m.TxPackets },
+	"rx_bytes":       func(m db.MetricsSample) uint64 { return m.RxBytes },
+	"tx_bytes":       func(m db.MetricsSample) uint64 { return m.TxBytes },
+	"drop_count":     func(m db.MetricsSample) uint64 { return m.DropCount },
+	"uptime_seconds": func(m db.MetricsSample) uint64 { return m.UptimeSeconds },
+}
+
+// AgentMetricMatch is an agent whose latest reported metric met a threshold
+type AgentMetricMatch struct {
+	AgentID string  `json:"agent_id"`
+	Value   float64 `json:"value"`
+}
+
+// HandleAgentsByMetric lists agents whose latest stored metrics sample
+// compares against a threshold, e.g. ?metric=drop_count&op=gt&value=1000
+func (h *AgentHandler) HandleAgentsByMetric(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	field, ok := sampleMetrics[metric]
+	if !ok {
+		http.Error(w, "Unknown metric "+strconv.Quote(metric)+" (want rx_packets, tx_packets, rx_bytes, tx_bytes, drop_count or uptime_seconds)", http.StatusBadRequest)
+		return
+	}
+	op := query.Get("op")
+	compare, ok := metricComparisons[op]
+	if !ok {
+		http.Error(w, "Unknown op "+strconv.Quote(op)+" (want gt, gte, lt, lte or eq)", http.StatusBadRequest)
+		return
+	}
+	threshold, err := strconv.ParseFloat(query.Get("value"), 64)
+	if err != nil || math.IsNaN(threshold) || math.IsInf(threshold, 0) {
+		http.Error(w, "value must be a finite number", http.StatusBadRequest)
+		return
+	}
+
+	samples, err := h.database.GetLatestMetricsSamples()
+	if err != nil {
+		http.Error(w, "Failed to load metrics", http.StatusInternalServerError)
+		return
+	}
+	matches := []AgentMetricMatch{}
+	for agentID, sample := range samples {
+		if v := float64(field(sample)); compare(v, threshold) {
+			matches = append(matches, AgentMetricMatch{AgentID: agentID, Value: v})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].AgentID < matches[j].AgentID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"metric": metric,
+		"op":     op,
+		"value":  threshold,
+		"agents": matches,
+	})
+}
+
+// ParseAge parses a positive duration, accepting a whole-day suffix ("7d")
+// in addition to the units understood by time.ParseDuration
+func ParseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("duration is required")
+	}
+
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d = parsed
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return d, nil
+}
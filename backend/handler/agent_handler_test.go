@@ -0,0 +1,373 @@
+package handler_test
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/handler"
+)
+
+// setupAgentDB opens a database alongside a raw connection for backdating rows
+func setupAgentDB(t *testing.T) (*db.DB, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.New(path)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open raw connection: %v", err)
+	}
+	t.Cleanup(func() {
+		raw.Close()
+		database.Close()
+	})
+	return database, raw
+}
+
+func seedAgent(t *testing.T, database *db.DB, raw *sql.DB, id string, age time.Duration) {
+	t.Helper()
+	if err := database.CreateOrUpdateAgent(id, "1.0.0"); err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+	lastSeen := time.Now().UTC().Add(-age).Format("2006-01-02 15:04:05")
+	if _, err := raw.Exec(`UPDATE agents SET last_seen = ? WHERE id = ?`, lastSeen, id); err != nil {
+		t.Fatalf("Failed to backdate agent: %v", err)
+	}
+}
+
+func TestHandleDeleteStaleAgents(t *testing.T) {
+	database, raw := setupAgentDB(t)
+	seedAgent(t, database, raw, "old-1", 10*24*time.Hour)
+	seedAgent(t, database, raw, "old-2", 8*24*time.Hour)
+	seedAgent(t, database, raw, "recent", time.Hour)
+
+	h := handler.NewAgentHandler(database)
+	rec := httptest.NewRecorder()
+	h.HandleDeleteStaleAgents(rec, httptest.NewRequest(http.MethodDelete, "/api/agents/stale?older_than=7d", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Removed int `json:"removed"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Removed != 2 {
+		t.Errorf("Expected 2 agents removed, got %d", resp.Removed)
+	}
+
+	for id, wantPresent := range map[string]bool{"old-1": false, "old-2": false, "recent": true} {
+		agent, err := database.GetAgent(id)
+		if err != nil {
+			t.Fatalf("GetAgent failed: %v", err)
+		}
+		if (agent != nil) != wantPresent {
+			t.Errorf("%s: expected present=%v, got %v", id, wantPresent, agent != nil)
+		}
+	}
+}
+
+func TestHandleDeleteStaleAgents_InvalidDuration(t *testing.T) {
+	database, _ := setupAgentDB(t)
+	h := handler.NewAgentHandler(database)
+
+	for _, q := range []string{"", "?older_than=soon", "?older_than=-1d", "?older_than=0s", "?older_than=1.5d"} {
+		rec := httptest.NewRecorder()
+		h.HandleDeleteStaleAgents(rec, httptest.NewRequest(http.MethodDelete, "/api/agents/stale"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", q, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleDeleteStaleAgents(rec, httptest.NewRequest(http.MethodGet, "/api/agents/stale?older_than=7d", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestHandleExportAgents(t *testing.T) {
+	database, _ := setupAgentDB(t)
+	// More than one internal page
+	for i := 0; i < 1203; i++ {
+		if err := database.CreateOrUpdateAgent(fmt.Sprintf("agent-%04d", i), "1.0.0"); err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+	}
+	if err := database.SetAgentLabels("agent-0007", map[string]string{"env": "prod", "team": "net"}); err != nil {
+		t.Fatalf("SetAgentLabels failed: %v", err)
+	}
+	count, err := database.GetAgentCount()
+	if err != nil {
+		t.Fatalf("GetAgentCount failed: %v", err)
+	}
+	h := handler.NewAgentHandler(database)
+
+	t.Run("csv", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleExportAgents(rec, httptest.NewRequest(http.MethodGet, "/api/agents/export?format=csv", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to parse CSV: %v", err)
+		}
+		want := []string{"id", "version", "last_seen", "labels", "status"}
+		if strings.Join(rows[0], ",") != strings.Join(want, ",") {
+			t.Errorf("Expected header %v, got %v", want, rows[0])
+		}
+		if len(rows)-1 != count {
+			t.Errorf("Expected %d rows, got %d", count, len(rows)-1)
+		}
+		if rows[8][0] != "agent-0007" || rows[8][3] != "env=prod;team=net" {
+			t.Errorf("Expected labels for agent-0007, got %v", rows[8])
+		}
+		if rows[1][4] != "online" {
+			t.Errorf("Expected freshly seen agent online, got %q", rows[1][4])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.HandleExportAgents(rec, httptest.NewRequest(http.MethodGet, "/api/agents/export", nil))
+
+		var records []handler.AgentRecord
+		if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+			t.Fatalf("Failed to decode JSON: %v", err)
+		}
+		if len(records) != count {
+			t.Errorf("Expected %d records, got %d", count, len(records))
+		}
+		if records[7].Labels["env"] != "prod" {
+			t.Errorf("Expected labels for agent-0007, got %v", records[7].Labels)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	h.HandleExportAgents(rec, httptest.NewRequest(http.MethodGet, "/api/agents/export?format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown format, got %d", rec.Code)
+	}
+}
+
+func TestHandleListAgents(t *testing.T) {
+	database, raw := setupAgentDB(t)
+	versions := []string{"1.2.0", "1.0.0", "1.1.0"}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("agent-%02d", i)
+		seedAgent(t, database, raw, id, time.Duration(i)*10*time.Minute)
+		if _, err := raw.Exec(`UPDATE agents SET version = ? WHERE id = ?`, versions[i%3], id); err != nil {
+			t.Fatalf("Failed to set version: %v", err)
+		}
+	}
+	h := handler.NewAgentHandler(database)
+	h.SetOnlineWindow(time.Hour)
+
+	list := func(query string) handler.AgentPage {
+		t.Helper()
+		var page handler.AgentPage
+		if err := json.Unmarshal(getJSON(t, h.HandleListAgents, "/api/agents"+query), &page); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return page
+	}
+	pageThrough := func(sort string) []handler.AgentRecord {
+		t.Helper()
+		var all []handler.AgentRecord
+		for offset := 0; ; offset += 7 {
+			page := list(fmt.Sprintf("?limit=7&offset=%d&sort=%s", offset, sort))
+			if page.Total != 20 || page.Limit != 7 || page.Offset != offset || page.Sort != sort {
+				t.Fatalf("Unexpected page metadata: %+v", page)
+			}
+			all = append(all, page.Agents...)
+			if !page.HasMore {
+				break
+			}
+		}
+		if len(all) != 20 {
+			t.Fatalf("%s: expected 20 agents across pages, got %d", sort, len(all))
+		}
+		seen := make(map[string]bool)
+		for _, a := range all {
+			if seen[a.ID] {
+				t.Errorf("%s: %s listed on more than one page", sort, a.ID)
+			}
+			seen[a.ID] = true
+		}
+		return all
+	}
+
+	byLastSeen := pageThrough("last_seen")
+	for i, a := range byLastSeen {
+		if want := fmt.Sprintf("agent-%02d", i); a.ID != want {
+			t.Errorf("last_seen: position %d is %s, want %s", i, a.ID, want)
+		}
+		// Agent i reported i*10 minutes ago; agent 6 sits on the boundary
+		want := "offline"
+		if i < 6 {
+			want = "online"
+		}
+		if i != 6 && a.Status != want {
+			t.Errorf("%s: expected %s, got %s", a.ID, want, a.Status)
+		}
+	}
+
+	byVersion := pageThrough("version")
+	for i := 1; i < len(byVersion); i++ {
+		prev, cur := byVersion[i-1], byVersion[i]
+		if cur.Version < prev.Version || (cur.Version == prev.Version && cur.ID < prev.ID) {
+			t.Errorf("version: %s (%s) listed after %s (%s)", cur.ID, cur.Version, prev.ID, prev.Version)
+		}
+	}
+
+	// Absurd limits are capped
+	if page := list("?limit=100000"); page.Limit != handler.MaxAgentPageSize || len(page.Agents) != 20 || page.HasMore {
+		t.Errorf("Expected the limit capped at %d, got %+v", handler.MaxAgentPageSize, page)
+	}
+
+	for _, query := range []string{"?sort=hostname", "?limit=0", "?limit=abc", "?offset=-1"} {
+		rec := httptest.NewRecorder()
+		h.HandleListAgents(rec, httptest.NewRequest(http.MethodGet, "/api/agents"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHandleVersionDistribution(t *testing.T) {
+	database, raw := setupAgentDB(t)
+	agents := []struct {
+		version string
+		age     time.Duration
+	}{
+		{"1.10.0", time.Hour},
+		{"1.10.0", 2 * time.Hour},
+		{"1.9.0", time.Minute},
+		{"1.9.0", 3 * time.Hour},
+		{"1.9.0", 5 * time.Hour},
+		{"2.0.0-rc.1", 10 * time.Minute},
+		// Dead agents, outside the default day
+		{"1.9.0", 72 * time.Hour},
+		{"0.9.0", 30 * 24 * time.Hour},
+	}
+	for i, a := range agents {
+		id := fmt.Sprintf("agent-%d", i)
+		seedAgent(t, database, raw, id, a.age)
+		if _, err := raw.Exec(`UPDATE agents SET version = ? WHERE id = ?`, a.version, id); err != nil {
+			t.Fatalf("Failed to set version: %v", err)
+		}
+	}
+	h := handler.NewAgentHandler(database)
+
+	distribution := func(query string) []handler.VersionCount {
+		t.Helper()
+		var dist []handler.VersionCount
+		if err := json.Unmarshal(getJSON(t, h.HandleVersionDistribution, "/api/agents/versions"+query), &dist); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return dist
+	}
+
+	// Semver order, so 1.10.0 follows 1.9.0
+	want := []handler.VersionCount{
+		{Version: "1.9.0", Count: 3},
+		{Version: "1.10.0", Count: 2},
+		{Version: "2.0.0-rc.1", Count: 1},
+	}
+	if got := distribution(""); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// A wider window brings the stale agents back
+	if got := distribution("?seen_within=60d"); len(got) != 4 || got[0] != (handler.VersionCount{Version: "0.9.0", Count: 1}) || got[1].Count != 4 {
+		t.Errorf("Expected stale agents counted within 60d, got %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleVersionDistribution(rec, httptest.NewRequest(http.MethodGet, "/api/agents/versions?seen_within=soon", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid window, got %d", rec.Code)
+	}
+}
+
+func TestHandleAgentsByMetric(t *testing.T) {
+	database, _ := setupAgentDB(t)
+	h := handler.NewAgentHandler(database)
+
+	// Each agent's latest sample counts, whatever it reported before
+	seeded := map[string]uint64{"threshold-quiet": 0, "threshold-edge": 1000, "threshold-noisy": 1001, "threshold-loud": 50000}
+	now := time.Now()
+	for id, drops := range seeded {
+		database.SaveMetrics(id, db.MetricsSample{DropCount: 99999, UptimeSeconds: 30}, now.Add(-time.Minute))
+		database.SaveMetrics(id, db.MetricsSample{DropCount: drops, UptimeSeconds: 60}, now)
+	}
+
+	matching := func(query string) []string {
+		t.Helper()
+		var resp struct {
+			Agents []handler.AgentMetricMatch `json:"agents"`
+		}
+		if err := json.Unmarshal(getJSON(t, h.HandleAgentsByMetric, "/api/agents/by-metric"+query), &resp); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		ids := []string{}
+		for _, m := range resp.Agents {
+			ids = append(ids, m.AgentID)
+			if m.Value != float64(seeded[m.AgentID]) {
+				t.Errorf("%s: expected value %d, got %v", m.AgentID, seeded[m.AgentID], m.Value)
+			}
+		}
+		return ids
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"?metric=drop_count&op=gt&value=1000", "[threshold-loud threshold-noisy]"},
+		{"?metric=drop_count&op=gte&value=1000", "[threshold-edge threshold-loud threshold-noisy]"},
+		{"?metric=drop_count&op=lt&value=1", "[threshold-quiet]"},
+		{"?metric=drop_count&op=eq&value=1000", "[threshold-edge]"},
+		{"?metric=uptime_seconds&op=gt&value=60", "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(matching(tt.query)); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHandleAgentsByMetric_Validation(t *testing.T) {
+	database, _ := setupAgentDB(t)
+	h := handler.NewAgentHandler(database)
+
+	for _, query := range []string{
+		"?metric=cpu&op=gt&value=1",
+		"?metric=drop_count&op=ne&value=1",
+		"?metric=drop_count&op=gt&value=lots",
+		"?metric=drop_count&op=gt",
+		"?metric=drop_count&op=gt&value=NaN",
+		"?metric=drop_count&op=lt&value=Inf",
+	} {
+		rec := httptest.NewRecorder()
+		h.HandleAgentsByMetric(rec, httptest.NewRequest(http.MethodGet, "/api/agents/by-metric"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Agent ID policy kinds accepted by ParseAgentIDPolicy
+const (
+	AgentIDPolicyNone     = "none"
+	AgentIDPolicyUUID     = "uuid"
+	AgentIDPolicyHostname = "hostname"
+	AgentIDPolicyRegex    = "regex"
+)
+
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// AgentIDPolicy decides which agent IDs Heartbeat accepts.
+// The zero value accepts anything.
+type AgentIDPolicy struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+// ParseAgentIDPolicy parses "none", "uuid", "hostname" or "regex:<pattern>".
+// Regex patterns are anchored to the whole ID.
+func ParseAgentIDPolicy(spec string) (AgentIDPolicy, error) {
+	kind, pattern, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", AgentIDPolicyNone:
+		return AgentIDPolicy{kind: AgentIDPolicyNone}, nil
+	case AgentIDPolicyUUID, AgentIDPolicyHostname:
+		if pattern != "" {
+			return AgentIDPolicy{}, fmt.Errorf("agent ID policy %q takes no argument", kind)
+		}
+		return AgentIDPolicy{kind: kind}, nil
+	case AgentIDPolicyRegex:
+		if pattern == "" {
+			return AgentIDPolicy{}, fmt.Errorf("agent ID policy regex requires a pattern, e.g. regex:^edge-[0-9]+$")
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return AgentIDPolicy{}, fmt.Errorf("invalid agent ID pattern: %w", err)
+		}
+		return AgentIDPolicy{kind: kind, pattern: re}, nil
+	}
+	return AgentIDPolicy{}, fmt.Errorf("unknown agent ID policy %q (want none, uuid, hostname or regex:<pattern>)", kind)
+}
+
+// Validate returns an error describing why id doesn't conform to the policy
+func (p AgentIDPolicy) Validate(id string) error {
+	switch p.kind {
+	case AgentIDPolicyUUID:
+		if len(id) != 36 {
+			return fmt.Errorf("agent ID %q is not a UUID", id)
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("agent ID %q is not a UUID", id)
+		}
+	case AgentIDPolicyHostname:
+		if !isHostname(id) {
+			return fmt.Errorf("agent ID %q is not a valid hostname", id)
+		}
+	case AgentIDPolicyRegex:
+		if !p.pattern.MatchString(id) {
+			return fmt.Errorf("agent ID %q does not match %s", id, p.pattern)
+		}
+	}
+	return nil
+}
+
+// isHostname checks RFC 1123 hostname syntax
+func isHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+)
+
+// CommandHandler lets operators queue commands for agents. Queued commands
+// are delivered on each agent's next heartbeat.
+type CommandHandler struct {
+	database *db.DB
+}
+
+func NewCommandHandler(database *db.DB) *CommandHandler {
+	return &CommandHandler{database: database}
+}
+
+// HandleCommandByVersion queues a command for every agent on the given version
+func (h *CommandHandler) HandleCommandByVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Version string `json:"version"`
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Version == "" {
+		http.Error(w, "Version is required", http.StatusBadRequest)
+		return
+	}
+	command, ok := ParseCommand(req.Command)
+	if !ok {
+		http.Error(w, "Unknown command: "+req.Command, http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.database.EnqueueCommandByVersion(command.String(), func(version string) bool {
+		return version != "" && sameVersion(version, req.Version)
+	})
+	if err != nil {
+		http.Error(w, "Failed to queue command", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version":  req.Version,
+		"command":  command.String(),
+		"enqueued": count,
+	})
+}
+
+// CommandHistoryEntry is a queued command and, once delivered, how long the
+// agent took to pick it up
+type CommandHistoryEntry struct {
+	ID                     int64      `json:"id"`
+	AgentID                string     `json:"agent_id"`
+	Command                string     `json:"command"`
+	IssuedAt               time.Time  `json:"issued_at"`
+	DeliveredAt            *time.Time `json:"delivered_at"`
+	DeliveryLatencySeconds *float64   `json:"delivery_latency_seconds"`
+}
+
+// HandleCommandHistory lists queued commands, newest first, optionally for
+// one ?agent_id
+func (h *CommandHandler) HandleCommandHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 500)
+	}
+
+	cmds, err := h.database.GetCommandHistory(r.URL.Query().Get("agent_id"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]CommandHistoryEntry, 0, len(cmds))
+	for _, c := range cmds {
+		entry := CommandHistoryEntry{
+			ID:          c.ID,
+			AgentID:     c.AgentID,
+			Command:     c.Command,
+			IssuedAt:    c.CreatedAt,
+			DeliveredAt: c.DeliveredAt,
+		}
+		if latency, ok := c.DeliveryLatency(); ok {
+			seconds := latency.Seconds()
+			entry.DeliveryLatencySeconds = &seconds
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,169 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/metrics"
+	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
+)
+
+func postCommandByVersion(h *handler.CommandHandler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/commands/by-version", bytes.NewBufferString(body))
+	h.HandleCommandByVersion(rec, req)
+	return rec
+}
+
+func TestHandleCommandByVersion_OnlyMatchingAgents(t *testing.T) {
+	sh, database, cleanup := setupTestHandler(t, "1.0.3")
+	defer cleanup()
+
+	agents := map[string]string{
+		"agent-a": "1.0.3",
+		"agent-b": "v1.0.3",
+		"agent-c": "1.0.2",
+		"agent-d": "1.0.30",
+	}
+	for id, version := range agents {
+		if err := database.CreateOrUpdateAgent(id, version); err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+	}
+
+	rec := postCommandByVersion(handler.NewCommandHandler(database), `{"version":"1.0.3","command":"RECONFIGURE"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Command  string `json:"command"`
+		Enqueued int    `json:"enqueued"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Enqueued != 2 {
+		t.Errorf("Expected 2 agents enqueued, got %d", resp.Enqueued)
+	}
+	if resp.Command != "COMMAND_RECONFIGURE" {
+		t.Errorf("Expected COMMAND_RECONFIGURE, got %s", resp.Command)
+	}
+
+	heartbeat := func(id string) sentinelv1.Command {
+		t.Helper()
+		out, err := sh.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        id,
+			CurrentVersion: agents[id],
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		return out.Msg.Command
+	}
+
+	for id, want := range map[string]sentinelv1.Command{
+		"agent-a": sentinelv1.Command_COMMAND_RECONFIGURE,
+		"agent-b": sentinelv1.Command_COMMAND_RECONFIGURE,
+		"agent-c": sentinelv1.Command_COMMAND_UPGRADE,
+		"agent-d": sentinelv1.Command_COMMAND_NOOP,
+	} {
+		if got := heartbeat(id); got != want {
+			t.Errorf("%s: expected %v, got %v", id, want, got)
+		}
+	}
+
+	// Queued commands are delivered once
+	if got := heartbeat("agent-a"); got != sentinelv1.Command_COMMAND_NOOP {
+		t.Errorf("Expected NOOP after delivery, got %v", got)
+	}
+}
+
+func TestHandleCommandByVersion_Validation(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCommandHandler(database)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing version", `{"command":"UPGRADE"}`},
+		{"unknown command", `{"version":"1.0.0","command":"SELF_DESTRUCT"}`},
+		{"unspecified command", `{"version":"1.0.0","command":"UNSPECIFIED"}`},
+		{"invalid body", `not json`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postCommandByVersion(h, tt.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400, got %d", rec.Code)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleCommandByVersion(rec, httptest.NewRequest(http.MethodGet, "/api/commands/by-version", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestCommandDeliveryLatency(t *testing.T) {
+	database, _ := setupAgentDB(t)
+	fake := clock.NewFake(time.Now())
+	database.SetClock(fake)
+	sh := handler.NewSentinelHandler(database, "1.0.0")
+	sh.SetClock(fake)
+	ch := handler.NewCommandHandler(database)
+
+	database.CreateOrUpdateAgent("latency-agent", "1.0.0")
+	if rec := postCommandByVersion(ch, `{"version":"1.0.0","command":"RECONFIGURE"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 queuing command, got %d", rec.Code)
+	}
+	// The next heartbeat arrives 30 seconds after the command was queued
+	fake.Advance(30 * time.Second)
+
+	var entries []handler.CommandHistoryEntry
+	if err := json.Unmarshal(getJSON(t, ch.HandleCommandHistory, "/api/commands/history?agent_id=latency-agent"), &entries); err != nil {
+		t.Fatalf("Invalid history: %v", err)
+	}
+	if len(entries) != 1 || entries[0].DeliveryLatencySeconds != nil {
+		t.Fatalf("Expected one undelivered command without latency, got %+v", entries)
+	}
+
+	resp, err := sh.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+		AgentId:        "latency-agent",
+		CurrentVersion: "1.0.0",
+	}))
+	if err != nil || resp.Msg.Command != sentinelv1.Command_COMMAND_RECONFIGURE {
+		t.Fatalf("Expected queued RECONFIGURE, got %v (err %v)", resp, err)
+	}
+
+	if err := json.Unmarshal(getJSON(t, ch.HandleCommandHistory, "/api/commands/history?agent_id=latency-agent"), &entries); err != nil {
+		t.Fatalf("Invalid history: %v", err)
+	}
+	latency := entries[0].DeliveryLatencySeconds
+	if latency == nil || *latency != 30 {
+		t.Fatalf("Expected a delivery latency of 30s, got %v", latency)
+	}
+	if entries[0].DeliveredAt == nil {
+		t.Error("Expected delivered_at once the command is delivered")
+	}
+
+	var m dto.Metric
+	if err := metrics.CommandDelivery.WithLabelValues("COMMAND_RECONFIGURE").(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Failed to read histogram: %v", err)
+	}
+	if m.GetHistogram().GetSampleCount() < 1 || m.GetHistogram().GetSampleSum() < 29 {
+		t.Errorf("Expected the histogram to record the latency, got %v", m.GetHistogram())
+	}
+}
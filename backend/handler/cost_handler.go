@@ -1,13 +1,25 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/sennet/sennet/backend/auth"
 	"github.com/sennet/sennet/backend/cloud"
 	"github.com/sennet/sennet/backend/correlation"
+	"github.com/sennet/sennet/backend/crypto"
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/metrics"
+	"github.com/sennet/sennet/backend/middleware"
+	"golang.org/x/sync/singleflight"
 )
 
 type CostHandler struct {
@@ -15,6 +27,13 @@ type CostHandler struct {
 	registry  *cloud.Registry
 	engine    *correlation.Engine
 	recEngine *correlation.RecommendationEngine
+	audit     middleware.AuditLogger
+
+	// newProvider builds providers for added configs; tests replace it
+	newProvider func(*cloud.CloudConfig) (cloud.Provider, error)
+
+	// syncs coalesces concurrent sync requests into a single provider fetch
+	syncs singleflight.Group
 }
 
 func NewCostHandler(database *db.DB, registry *cloud.Registry) *CostHandler {
@@ -25,9 +44,36 @@ func NewCostHandler(database *db.DB, registry *cloud.Registry) *CostHandler {
 		registry:  registry,
 		engine:    engine,
 		recEngine: recEngine,
+		audit:     middleware.DefaultAuditLogger(),
+
+		newProvider: cloud.CreateProvider,
 	}
 }
 
+// Close stops the handler's engine listening for cost changes, for handlers
+// replaced while their database stays open
+func (h *CostHandler) Close() {
+	h.engine.Close()
+}
+
+// SetMetrics records cost and savings gauges into m instead of
+// metrics.Default()
+func (h *CostHandler) SetMetrics(m *metrics.Metrics) {
+	h.engine.SetMetrics(m)
+	h.recEngine.SetMetrics(m)
+}
+
+// SetProviderFactory replaces how providers are built from added configs
+func (h *CostHandler) SetProviderFactory(factory func(*cloud.CloudConfig) (cloud.Provider, error)) {
+	h.newProvider = factory
+}
+
+// SetAuditLogger sets where sensitive accesses, such as revealing cloud
+// credentials, are recorded
+func (h *CostHandler) SetAuditLogger(logger middleware.AuditLogger) {
+	h.audit = logger
+}
+
 type CloudConfigRequest struct {
 	ID       string `json:"id"`
 	Provider string `json:"provider"`
@@ -55,15 +101,7 @@ func (h *CostHandler) HandleGetCosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	startDate := r.URL.Query().Get("start")
-	endDate := r.URL.Query().Get("end")
-
-	if startDate == "" {
-		startDate = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
-	}
-	if endDate == "" {
-		endDate = time.Now().Format("2006-01-02")
-	}
+	startDate, endDate := dateRange(r)
 
 	costs, err := h.database.GetEgressCosts(startDate, endDate)
 	if err != nil {
@@ -81,6 +119,150 @@ func (h *CostHandler) HandleGetCostsSummary(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	startDate, endDate := dateRange(r)
+
+	summary, err := h.engine.GetCostSummary(startDate, endDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// CostMatrix is a pivot of costs with one dimension as rows and another as
+// columns. Rows and Cols are sorted; missing cells mean no cost.
+type CostMatrix struct {
+	Start     string                        `json:"start"`
+	End       string                        `json:"end"`
+	RowsBy    string                        `json:"rows_by"`
+	ColsBy    string                        `json:"cols_by"`
+	Rows      []string                      `json:"rows"`
+	Cols      []string                      `json:"cols"`
+	Cells     map[string]map[string]float64 `json:"cells"`
+	RowTotals map[string]float64            `json:"row_totals"`
+	ColTotals map[string]float64            `json:"col_totals"`
+	Total     float64                       `json:"total"`
+}
+
+// HandleGetCostMatrix pivots costs by two dimensions, e.g.
+// ?rows=account&cols=service (the defaults)
+func (h *CostHandler) HandleGetCostMatrix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rowsBy := r.URL.Query().Get("rows")
+	if rowsBy == "" {
+		rowsBy = "account"
+	}
+	colsBy := r.URL.Query().Get("cols")
+	if colsBy == "" {
+		colsBy = "service"
+	}
+	for _, dim := range []string{rowsBy, colsBy} {
+		if _, ok := db.CostDimensions[dim]; !ok {
+			http.Error(w, "Invalid dimension "+strconv.Quote(dim)+" (want account, service, region or date)", http.StatusBadRequest)
+			return
+		}
+	}
+	if rowsBy == colsBy {
+		http.Error(w, "rows and cols must be different dimensions", http.StatusBadRequest)
+		return
+	}
+
+	startDate, endDate := dateRange(r)
+	cells, err := h.database.GetCostMatrix(startDate, endDate, rowsBy, colsBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matrix := CostMatrix{
+		Start:     startDate,
+		End:       endDate,
+		RowsBy:    rowsBy,
+		ColsBy:    colsBy,
+		Rows:      []string{},
+		Cols:      []string{},
+		Cells:     make(map[string]map[string]float64),
+		RowTotals: make(map[string]float64),
+		ColTotals: make(map[string]float64),
+	}
+	for _, c := range cells {
+		if matrix.Cells[c.Row] == nil {
+			matrix.Cells[c.Row] = make(map[string]float64)
+			matrix.Rows = append(matrix.Rows, c.Row)
+		}
+		if _, seen := matrix.ColTotals[c.Col]; !seen {
+			matrix.Cols = append(matrix.Cols, c.Col)
+		}
+		matrix.Cells[c.Row][c.Col] = c.CostUSD
+		matrix.RowTotals[c.Row] += c.CostUSD
+		matrix.ColTotals[c.Col] += c.CostUSD
+		matrix.Total += c.CostUSD
+	}
+	sort.Strings(matrix.Cols)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matrix)
+}
+
+// TagCostGroup is the cost allocated to one tag value
+type TagCostGroup struct {
+	Value   string  `json:"value"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// CostsByTag is egress cost grouped by the values of one tag key
+type CostsByTag struct {
+	Key    string         `json:"key"`
+	Start  string         `json:"start"`
+	End    string         `json:"end"`
+	Groups []TagCostGroup `json:"groups"`
+	Total  float64        `json:"total"`
+}
+
+// HandleGetCostsByTag groups costs by a resource tag, e.g. ?key=team.
+// Costs without the tag are reported under "untagged".
+func (h *CostHandler) HandleGetCostsByTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	startDate, endDate := dateRange(r)
+	costs, err := h.database.GetCostsByTag(key, startDate, endDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := CostsByTag{
+		Key:    key,
+		Start:  startDate,
+		End:    endDate,
+		Groups: make([]TagCostGroup, 0, len(costs)),
+	}
+	for _, c := range costs {
+		response.Groups = append(response.Groups, TagCostGroup{Value: c.Value, CostUSD: c.CostUSD})
+		response.Total += c.CostUSD
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// dateRange reads the start/end query params, defaulting to the last 30 days
+func dateRange(r *http.Request) (string, string) {
 	startDate := r.URL.Query().Get("start")
 	endDate := r.URL.Query().Get("end")
 
@@ -90,15 +272,89 @@ func (h *CostHandler) HandleGetCostsSummary(w http.ResponseWriter, r *http.Reque
 	if endDate == "" {
 		endDate = time.Now().Format("2006-01-02")
 	}
+	return startDate, endDate
+}
+
+// costBundleSchema documents the bundle layout inline so offline consumers
+// don't need the API docs to interpret it
+var costBundleSchema = map[string]string{
+	"version":         "1",
+	"period":          "start and end dates (YYYY-MM-DD, inclusive) covered by the bundle",
+	"summary":         "totals for the period, as returned by /api/costs/summary",
+	"recommendations": "recommendations that aren't dismissed or snoozed, as returned by /api/recommendations",
+	"costs":           "daily egress cost rows for the period, as returned by /api/costs; always the last field",
+}
+
+// HandleGetCostBundle returns costs, summary, and recommendations for a date
+// range as one JSON document. Cost rows are streamed straight from the
+// database so large ranges aren't buffered in memory.
+func (h *CostHandler) HandleGetCostBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startDate, endDate := dateRange(r)
 
 	summary, err := h.engine.GetCostSummary(startDate, endDate)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recs, err := h.database.GetRecommendations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header, err := json.Marshal(struct {
+		Schema          map[string]string        `json:"schema"`
+		Period          map[string]string        `json:"period"`
+		Summary         *correlation.CostSummary `json:"summary"`
+		Recommendations []db.Recommendation      `json:"recommendations"`
+	}{
+		Schema:          costBundleSchema,
+		Period:          map[string]string{"start": startDate, "end": endDate},
+		Summary:         summary,
+		Recommendations: recs,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(summary)
+	w.Header().Set("Content-Disposition", "attachment; filename=\"sennet-costs-"+startDate+"-"+endDate+".json\"")
+
+	// Reopen the header object to append the streamed costs array
+	w.Write(header[:len(header)-1])
+	w.Write([]byte(`,"costs":[`))
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+	err = h.database.ForEachEgressCost(startDate, endDate, func(c db.EgressCost) error {
+		if rows > 0 {
+			w.Write([]byte(","))
+		}
+		row, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+		rows++
+		if flusher != nil && rows%500 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// Headers are already sent; truncating the document makes the failure
+		// visible to the client as invalid JSON rather than silently short data
+		return
+	}
+	w.Write([]byte("]}\n"))
 }
 
 func (h *CostHandler) HandleGetRecommendations(w http.ResponseWriter, r *http.Request) {
@@ -107,7 +363,18 @@ func (h *CostHandler) HandleGetRecommendations(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	recs, err := h.database.GetRecommendations()
+	// Dismissed and snoozed recommendations are only listed with ?status=all
+	var recs []db.Recommendation
+	var err error
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		recs, err = h.database.GetRecommendations()
+	case "all":
+		recs, err = h.database.GetAllRecommendations()
+	default:
+		http.Error(w, "Invalid status: must be all or omitted", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -117,6 +384,344 @@ func (h *CostHandler) HandleGetRecommendations(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(recs)
 }
 
+// HandleGetCloudRecommendations lists the open recommendations raised by one
+// cloud config's costs
+func (h *CostHandler) HandleGetCloudRecommendations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	stored, err := h.database.GetCloudConfig(id)
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	if stored == nil {
+		http.Error(w, "Cloud config not found", http.StatusNotFound)
+		return
+	}
+
+	recs, err := h.database.GetAccountRecommendations(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recs == nil {
+		recs = []db.Recommendation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
+// UpdateRecommendationRequest is the body of PATCH /api/recommendations/{id}.
+// SnoozedUntil only applies to the snoozed status and defaults to
+// db.DefaultRecommendationSnooze from now.
+type UpdateRecommendationRequest struct {
+	Status       string     `json:"status"`
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+}
+
+// HandleUpdateRecommendation moves a recommendation to open, accepted,
+// dismissed or snoozed. A snoozed recommendation reopens once its snooze
+// runs out.
+func (h *CostHandler) HandleUpdateRecommendation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid recommendation id", http.StatusBadRequest)
+		return
+	}
+	var req UpdateRecommendationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.SnoozedUntil != nil {
+		if req.Status != db.RecommendationSnoozed {
+			http.Error(w, "snoozed_until is only valid with status snoozed", http.StatusBadRequest)
+			return
+		}
+		if !req.SnoozedUntil.After(time.Now()) {
+			http.Error(w, "snoozed_until must be in the future", http.StatusBadRequest)
+			return
+		}
+		err = h.recEngine.Snooze(id, *req.SnoozedUntil)
+	} else {
+		err = h.recEngine.UpdateStatus(id, req.Status)
+	}
+	switch {
+	case errors.Is(err, db.ErrInvalidRecommendationStatus):
+		http.Error(w, "Invalid status: must be open, accepted, dismissed or snoozed", http.StatusBadRequest)
+		return
+	case errors.Is(err, db.ErrRecommendationNotFound):
+		http.Error(w, "Recommendation not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update recommendation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     id,
+		"status": req.Status,
+	})
+}
+
+// HandleGetCostFreshness reports when each cloud config last synced costs
+func (h *CostHandler) HandleGetCostFreshness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	freshness, err := h.engine.Freshness()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(freshness)
+}
+
+// HandleGetAnomalies lists days on which a service's egress cost spiked
+// above its baseline (?lookback= days, default 30; ?threshold= standard
+// deviations, default 3)
+func (h *CostHandler) HandleGetAnomalies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lookback := 30
+	if v := r.URL.Query().Get("lookback"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			http.Error(w, "lookback must be between 1 and 365 days", http.StatusBadRequest)
+			return
+		}
+		lookback = n
+	}
+	threshold := 3.0
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || math.IsInf(f, 0) {
+			http.Error(w, "threshold must be a positive number", http.StatusBadRequest)
+			return
+		}
+		threshold = f
+	}
+
+	anomalies, err := h.engine.DetectAnomalies(lookback, threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anomalies)
+}
+
+// HandleGetAttribution lists cost attributions in a date range (?start=,
+// ?end=, default the last 30 days), largest first. ?entity_type= limits them
+// to one kind, such as "service" or "source_ip".
+func (h *CostHandler) HandleGetAttribution(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startDate, endDate := dateRange(r)
+	attrs, err := h.database.GetCostAttributions(startDate, endDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entityType := r.URL.Query().Get("entity_type")
+	out := make([]db.CostAttribution, 0, len(attrs))
+	for _, a := range attrs {
+		if entityType == "" || a.EntityType == entityType {
+			out = append(out, a)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// AttributeFlowCosts attributes yesterday's (UTC) egress cost to source IPs
+// from provider flow logs
+func (h *CostHandler) AttributeFlowCosts(ctx context.Context) error {
+	return h.engine.AttributeFlowCosts(ctx, time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02"))
+}
+
+// HandleGetSyncHistory lists recent cost syncs, newest first (?limit=, default 20, max 100)
+func (h *CostHandler) HandleGetSyncHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 100)
+	}
+
+	runs, err := h.database.GetSyncRuns(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if runs == nil {
+		runs = []db.SyncRun{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// BudgetRequest is the body of POST /api/budgets
+type BudgetRequest struct {
+	Provider   string  `json:"provider"`
+	MonthlyUSD float64 `json:"monthly_usd"`
+}
+
+// HandleBudgets lists (GET), sets (POST) and removes (DELETE ?provider=)
+// monthly egress budgets
+func (h *CostHandler) HandleBudgets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		budgets, err := h.database.GetBudgets()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if budgets == nil {
+			budgets = []db.Budget{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(budgets)
+
+	case http.MethodPost:
+		var req BudgetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Provider == "" {
+			http.Error(w, "provider is required", http.StatusBadRequest)
+			return
+		}
+		if req.MonthlyUSD <= 0 || math.IsInf(req.MonthlyUSD, 0) {
+			http.Error(w, "monthly_usd must be a positive number", http.StatusBadRequest)
+			return
+		}
+		if err := h.database.SaveBudget(req.Provider, req.MonthlyUSD); err != nil {
+			http.Error(w, "Failed to save budget: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "saved",
+			"provider":    req.Provider,
+			"monthly_usd": req.MonthlyUSD,
+		})
+
+	case http.MethodDelete:
+		provider := r.URL.Query().Get("provider")
+		if provider == "" {
+			http.Error(w, "provider query parameter required", http.StatusBadRequest)
+			return
+		}
+		deleted, err := h.database.DeleteBudget(provider)
+		if err != nil {
+			http.Error(w, "Failed to delete: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Budget not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":   "deleted",
+			"provider": provider,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleGetBudgetAlerts lists providers projected to exceed their monthly
+// budget, as of today or ?as_of=YYYY-MM-DD
+func (h *CostHandler) HandleGetBudgetAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	asOf := time.Now().UTC()
+	if v := r.URL.Query().Get("as_of"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "as_of must be a date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		asOf = t
+	}
+
+	alerts, err := h.engine.CheckBudgets(asOf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// SetStaleThreshold sets how old synced cost data may get before it is reported stale
+func (h *CostHandler) SetStaleThreshold(d time.Duration) {
+	h.engine.SetStaleThreshold(d)
+}
+
+// SetCostCacheTTL sets how long fetched provider costs are reused by later syncs
+func (h *CostHandler) SetCostCacheTTL(ttl time.Duration) {
+	h.engine.SetCostCacheTTL(ttl)
+}
+
+// SetSyncConcurrency sets how many providers a cost sync fetches at once
+func (h *CostHandler) SetSyncConcurrency(n int) {
+	h.engine.SetSyncConcurrency(n)
+}
+
+// SetSavingsBaselines sets the per-provider savings plan discounts used to
+// report realized savings in cost summaries
+func (h *CostHandler) SetSavingsBaselines(baselines map[string]float64) {
+	h.engine.SetSavingsBaselines(baselines)
+}
+
+// RefreshFreshnessMetrics updates the cost data age gauge
+func (h *CostHandler) RefreshFreshnessMetrics() error {
+	return h.engine.RefreshFreshnessMetrics()
+}
+
 func (h *CostHandler) HandleClouds(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -137,19 +742,77 @@ func (h *CostHandler) listClouds(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	freshness, err := h.engine.Freshness()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stale := make(map[string]bool, len(freshness))
+	for _, f := range freshness {
+		stale[f.ID] = f.Stale
+	}
+
 	response := make([]map[string]interface{}, 0, len(configs))
 	for _, c := range configs {
 		response = append(response, map[string]interface{}{
-			"id":         c.ID,
-			"provider":   c.Provider,
-			"created_at": c.CreatedAt,
+			"id":             c.ID,
+			"provider":       c.Provider,
+			"created_at":     c.CreatedAt,
+			"last_synced_at": c.LastSyncedAt,
+			"stale":          stale[c.ID],
 		})
 	}
 
+	// ?check=true tests each config's stored credentials
+	if r.URL.Query().Get("check") == "true" {
+		for i, err := range h.checkClouds(r.Context(), configs) {
+			response[i]["healthy"] = err == nil
+			if err != nil {
+				response[i]["error"] = err.Error()
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// cloudCheckTimeout bounds each provider's connection test when listing
+// clouds with ?check=true, so one unreachable provider can't stall the list
+const cloudCheckTimeout = 5 * time.Second
+
+// checkClouds rebuilds a provider from each stored config and tests its
+// connection, all concurrently, returning the errors in config order
+func (h *CostHandler) checkClouds(ctx context.Context, configs []db.CloudConfig) []error {
+	errs := make([]error, len(configs))
+	var wg sync.WaitGroup
+	for i, c := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config, err := cloud.ParseStoredConfig(c.ConfigJSON)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to decrypt config: %w", err)
+				return
+			}
+			provider, err := h.newProvider(config)
+			if err != nil {
+				errs[i] = fmt.Errorf("invalid provider config: %w", err)
+				return
+			}
+			checkCtx, cancel := context.WithTimeout(ctx, cloudCheckTimeout)
+			defer cancel()
+			errs[i] = provider.TestConnection(checkCtx)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// connectionTestTimeout bounds the credential check made before a cloud
+// config is saved
+const connectionTestTimeout = 15 * time.Second
+
 func (h *CostHandler) addCloud(w http.ResponseWriter, r *http.Request) {
 	var req CloudConfigRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -200,22 +863,40 @@ func (h *CostHandler) addCloud(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	// Credentials are never stored in the clear, so check before testing them
+	if _, err := crypto.GetEncryptionKey(); err != nil {
+		http.Error(w, "Cannot store cloud credentials: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	provider, err := h.newProvider(cloudConfig)
+	if err != nil {
+		http.Error(w, "Invalid provider config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), connectionTestTimeout)
+	defer cancel()
+	if err := provider.TestConnection(ctx); err != nil {
+		http.Error(w, "Connection test failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	configJSON, err := cloudConfig.ToJSON()
 	if err != nil {
 		http.Error(w, "Failed to serialize config", http.StatusInternalServerError)
 		return
 	}
-
-	if err := h.database.SaveCloudConfig(req.ID, req.Provider, configJSON); err != nil {
-		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+	encrypted, err := crypto.EncryptString(configJSON)
+	if err != nil {
+		http.Error(w, "Failed to encrypt config", http.StatusInternalServerError)
 		return
 	}
 
-	provider, err := cloud.CreateProvider(cloudConfig)
-	if err == nil {
-		h.registry.Register(req.ID, provider)
+	if err := h.database.SaveCloudConfig(req.ID, req.Provider, encrypted); err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	h.registry.Register(req.ID, provider)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -246,23 +927,285 @@ func (h *CostHandler) deleteCloud(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// HandleRevealCloud returns a stored cloud config with its secrets, so the
+// dashboard can pre-fill an edit form. The caller must pass ?confirm=true,
+// and every reveal is audited.
+func (h *CostHandler) HandleRevealCloud(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("confirm") != "true" {
+		http.Error(w, "confirm=true is required to reveal credentials", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	stored, err := h.database.GetCloudConfig(id)
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	if stored == nil {
+		http.Error(w, "Cloud config not found", http.StatusNotFound)
+		return
+	}
+	config, err := cloud.ParseStoredConfig(stored.ConfigJSON)
+	if err != nil {
+		http.Error(w, "Failed to decrypt config", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(middleware.AuditLog{
+		Timestamp:  time.Now(),
+		UserID:     h.auditUser(r),
+		Email:      auth.GetFirebaseEmail(r.Context()),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		StatusCode: http.StatusOK,
+		IP:         middleware.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Action:     "cloud_config.reveal",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(config)
+}
+
+// HandlePatchCloud applies a JSON merge patch to a stored cloud config, so
+// a single field (the region, a rotated secret) can change without resending
+// the rest. Empty secret fields in the patch keep the stored secret. The
+// result is re-validated and connection-tested before it replaces the config
+// and its provider.
+func (h *CostHandler) HandlePatchCloud(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	stored, err := h.database.GetCloudConfig(id)
+	if err != nil {
+		http.Error(w, "Failed to load config", http.StatusInternalServerError)
+		return
+	}
+	if stored == nil {
+		http.Error(w, "Cloud config not found", http.StatusNotFound)
+		return
+	}
+	current, err := cloud.ParseStoredConfig(stored.ConfigJSON)
+	if err != nil {
+		http.Error(w, "Failed to decrypt config", http.StatusInternalServerError)
+		return
+	}
+
+	// Decoding onto a copy of the current config overwrites only the fields
+	// present in the patch
+	merged, err := cloud.ParseStoredConfig(stored.ConfigJSON)
+	if err != nil {
+		http.Error(w, "Failed to decrypt config", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(merged); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if merged.ID != current.ID || merged.Provider != current.Provider {
+		http.Error(w, "id and provider cannot be changed", http.StatusBadRequest)
+		return
+	}
+	keepSecrets(merged, current)
+
+	if err := merged.Validate(); err != nil {
+		http.Error(w, "Validation error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	provider, err := h.newProvider(merged)
+	if err != nil {
+		http.Error(w, "Invalid provider config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), connectionTestTimeout)
+	defer cancel()
+	if err := provider.TestConnection(ctx); err != nil {
+		http.Error(w, "Connection test failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	configJSON, err := merged.ToJSON()
+	if err != nil {
+		http.Error(w, "Failed to serialize config", http.StatusInternalServerError)
+		return
+	}
+	// Configs stored encrypted stay encrypted, under the current key
+	if !json.Valid([]byte(stored.ConfigJSON)) {
+		if configJSON, err = crypto.EncryptString(configJSON); err != nil {
+			http.Error(w, "Failed to encrypt config", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	swapped, err := h.database.ReplaceCloudConfigJSON(id, stored.ConfigJSON, configJSON)
+	if err != nil {
+		http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !swapped {
+		http.Error(w, "Config changed during update; retry", http.StatusConflict)
+		return
+	}
+	h.registry.Register(id, provider)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "updated",
+		"id":     id,
+	})
+}
+
+// keepSecrets restores secrets that a patch blanked out, since edit forms
+// send empty values for secrets they never displayed
+func keepSecrets(patched, current *cloud.CloudConfig) {
+	keep := func(patched *string, current string) {
+		if *patched == "" {
+			*patched = current
+		}
+	}
+	if patched.AWS != nil && current.AWS != nil {
+		keep(&patched.AWS.SecretAccessKey, current.AWS.SecretAccessKey)
+	}
+	if patched.Azure != nil && current.Azure != nil {
+		keep(&patched.Azure.ClientSecret, current.Azure.ClientSecret)
+	}
+	if patched.GCP != nil && current.GCP != nil {
+		keep(&patched.GCP.ServiceAccountJSON, current.GCP.ServiceAccountJSON)
+	}
+}
+
+// auditUser identifies the caller for audit entries: the Firebase UID, or
+// the name of the API key used
+func (h *CostHandler) auditUser(r *http.Request) string {
+	if uid := auth.GetFirebaseUID(r.Context()); uid != "" {
+		return uid
+	}
+	if apiKey := middleware.GetAPIKey(r.Context()); apiKey != "" {
+		if key, err := h.database.GetAPIKey(apiKey); err == nil && key != nil {
+			return "key:" + key.Name
+		}
+	}
+	return ""
+}
+
 func (h *CostHandler) HandleSyncCosts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if err := h.engine.SyncCosts(r.Context(), 30); err != nil {
+	// Callers that arrive while a sync is running share its result. The sync
+	// is detached from the first caller's cancellation since others wait on it,
+	// but keeps its deadline (see middleware.Timeout), so a server-side timeout
+	// still stops it. force=true skips the provider cost cache, so it doesn't
+	// join a cached sync.
+	force := r.URL.Query().Get("force") == "true"
+	key := "sync"
+	if force {
+		key = "sync-force"
+	}
+	ctx := context.WithoutCancel(r.Context())
+	if deadline, ok := r.Context().Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	_, err, _ := h.syncs.Do(key, func() (interface{}, error) {
+		return nil, h.syncCosts(ctx, force, nil)
+	})
+	var syncErr *correlation.SyncError
+	if err != nil && !errors.As(err, &syncErr) {
 		http.Error(w, "Sync failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	startDate := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
-	endDate := time.Now().Format("2006-01-02")
-	h.recEngine.GenerateRecommendations(startDate, endDate)
-
+	// Some providers failing still leaves the others synced
 	w.Header().Set("Content-Type", "application/json")
+	if syncErr != nil {
+		failed := make(map[string]string, len(syncErr.Failed))
+		for id, err := range syncErr.Failed {
+			failed[id] = err.Error()
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "partial",
+			"errors": failed,
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "synced",
 	})
 }
+
+// syncCosts fetches the last 30 days from every provider and regenerates
+// recommendations, reporting per-provider progress to fn if it is non-nil.
+// Recommendations are regenerated even if some providers failed, in which
+// case their *correlation.SyncError is returned.
+func (h *CostHandler) syncCosts(ctx context.Context, force bool, fn func(correlation.SyncProgress)) error {
+	err := h.engine.SyncCostsWithProgress(ctx, 30, force, fn)
+	var syncErr *correlation.SyncError
+	if err != nil && !errors.As(err, &syncErr) {
+		return err
+	}
+
+	startDate := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	endDate := time.Now().Format("2006-01-02")
+	h.recEngine.GenerateRecommendations(startDate, endDate)
+	return err
+}
+
+// HandleSyncCostsStream runs a cost sync like HandleSyncCosts, streaming each
+// provider's progress as Server-Sent Events. Every correlation.SyncProgress is
+// sent as an event named after its stage, followed by a final "done" event
+// whose status is "synced", "partial" or "error". Streamed syncs don't join
+// concurrent HandleSyncCosts calls.
+func (h *CostHandler) HandleSyncCostsStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A sync can outlast the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	send := func(event string, data interface{}) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		rc.Flush()
+	}
+
+	// Keep syncing if the client goes away, as HandleSyncCosts does; writes
+	// to a closed connection just fail
+	err := h.syncCosts(context.WithoutCancel(r.Context()), r.URL.Query().Get("force") == "true",
+		func(p correlation.SyncProgress) { send(p.Stage, p) })
+
+	done := map[string]interface{}{"status": "synced"}
+	var syncErr *correlation.SyncError
+	switch {
+	case errors.As(err, &syncErr):
+		done["status"] = "partial"
+	case err != nil:
+		done["status"] = "error"
+		done["error"] = err.Error()
+	}
+	send("done", done)
+}
@@ -0,0 +1,888 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/correlation"
+	"github.com/sennet/sennet/backend/crypto"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func int64Ptr(v int64) *int64 { return &v }
+
+// getJSON calls a handler and returns the raw JSON response body
+func getJSON(t *testing.T, fn http.HandlerFunc, target string) []byte {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	fn(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected 200, got %d: %s", target, rec.Code, rec.Body.String())
+	}
+	return rec.Body.Bytes()
+}
+
+// canonical re-encodes JSON so documents can be compared independent of formatting
+func canonical(t *testing.T, raw []byte) string {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", raw, err)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+func TestHandleGetCostBundle(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 150, int64Ptr(1000))
+	database.SaveEgressCost("aws", "2024-01-11", "AmazonS3", "us-east-1", 25, nil)
+	database.SaveEgressCost("gcp", "2024-01-11", "Compute", "us-central1", 12.5, int64Ptr(42))
+	database.SaveRecommendation("cross_az_traffic", "Move replicas", 75)
+
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	query := "?start=2024-01-01&end=2024-01-31"
+
+	raw := getJSON(t, h.HandleGetCostBundle, "/api/costs/bundle"+query)
+	var bundle map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &bundle); err != nil {
+		t.Fatalf("Bundle is not valid JSON: %v\n%s", err, raw)
+	}
+	for _, section := range []string{"schema", "period", "summary", "recommendations", "costs"} {
+		if _, ok := bundle[section]; !ok {
+			t.Errorf("Bundle missing %q section", section)
+		}
+	}
+
+	sections := map[string]http.HandlerFunc{
+		"costs":           h.HandleGetCosts,
+		"summary":         h.HandleGetCostsSummary,
+		"recommendations": h.HandleGetRecommendations,
+	}
+	paths := map[string]string{
+		"costs":           "/api/costs",
+		"summary":         "/api/costs/summary",
+		"recommendations": "/api/recommendations",
+	}
+	for section, fn := range sections {
+		want := canonical(t, getJSON(t, fn, paths[section]+query))
+		if got := canonical(t, bundle[section]); got != want {
+			t.Errorf("%s section differs from %s:\n got: %s\nwant: %s", section, paths[section], got, want)
+		}
+	}
+}
+
+func TestHandleGetCostBundle_Empty(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	raw := getJSON(t, h.HandleGetCostBundle, "/api/costs/bundle?start=2024-01-01&end=2024-01-31")
+
+	var bundle struct {
+		Costs []json.RawMessage `json:"costs"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(raw)).Decode(&bundle); err != nil {
+		t.Fatalf("Empty bundle is not valid JSON: %v\n%s", err, raw)
+	}
+	if len(bundle.Costs) != 0 {
+		t.Errorf("Expected no costs, got %d", len(bundle.Costs))
+	}
+}
+
+// fakeProvider is a cloud.Provider returning canned costs
+type fakeProvider struct {
+	name    cloud.ProviderType
+	costs   []cloud.CostResult
+	err     error
+	delay   time.Duration
+	fetches atomic.Int32
+
+	waitForCancel bool          // FetchCosts blocks until ctx is done
+	cancelled     chan struct{} // Closed once a waiting fetch sees ctx done
+}
+
+func (p *fakeProvider) Name() cloud.ProviderType { return p.name }
+
+func (p *fakeProvider) FetchCosts(ctx context.Context, start, end time.Time) ([]cloud.CostResult, error) {
+	p.fetches.Add(1)
+	if p.waitForCancel {
+		<-ctx.Done()
+		close(p.cancelled)
+		return nil, ctx.Err()
+	}
+	if p.delay > 0 {
+		time.Sleep(p.delay)
+	}
+	return p.costs, p.err
+}
+
+func (p *fakeProvider) FetchFlowLogs(ctx context.Context, start, end time.Time) ([]cloud.FlowLogEntry, error) {
+	return nil, nil
+}
+
+func (p *fakeProvider) TestConnection(ctx context.Context) error { return p.err }
+
+func TestHandleSyncCosts_CoalescesConcurrentCalls(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	provider := &fakeProvider{
+		name:  cloud.ProviderAWS,
+		delay: 200 * time.Millisecond,
+		costs: []cloud.CostResult{{Date: time.Now(), Service: "AmazonEC2", Region: "us-east-1", CostUSD: 10}},
+	}
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", provider)
+	h := handler.NewCostHandler(database, registry)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	codes := make(chan int, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.HandleSyncCosts(rec, httptest.NewRequest(http.MethodPost, "/api/sync-costs", nil))
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Expected every caller to get 200, got %d", code)
+		}
+	}
+	if n := provider.fetches.Load(); n != 1 {
+		t.Errorf("Expected exactly 1 provider fetch for %d concurrent syncs, got %d", callers, n)
+	}
+
+	// A later sync within the cache TTL reuses the fetch; force bypasses it
+	h.HandleSyncCosts(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/sync-costs", nil))
+	if n := provider.fetches.Load(); n != 1 {
+		t.Errorf("Expected a later sync to use the cache, got %d fetches", n)
+	}
+	h.HandleSyncCosts(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/sync-costs?force=true", nil))
+	if n := provider.fetches.Load(); n != 2 {
+		t.Errorf("Expected a forced sync to fetch again, got %d fetches", n)
+	}
+}
+
+func TestHandleSyncCosts_CancelledByRequestTimeout(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	provider := &fakeProvider{name: cloud.ProviderAWS, waitForCancel: true, cancelled: make(chan struct{})}
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", provider)
+	h := handler.NewCostHandler(database, registry)
+
+	rec := httptest.NewRecorder()
+	middleware.Timeout(50*time.Millisecond)(http.HandlerFunc(h.HandleSyncCosts)).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/sync-costs", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the timeout passes, got %d: %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-provider.cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the provider fetch to be cancelled by the request timeout")
+	}
+}
+
+func TestHandleGetSyncHistory(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", &fakeProvider{name: cloud.ProviderAWS})
+	h := handler.NewCostHandler(database, registry)
+
+	var runs []db.SyncRun
+	json.Unmarshal(getJSON(t, h.HandleGetSyncHistory, "/api/costs/sync-history"), &runs)
+	if len(runs) != 0 {
+		t.Fatalf("Expected empty history, got %d runs", len(runs))
+	}
+
+	h.HandleSyncCosts(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/sync-costs", nil))
+	json.Unmarshal(getJSON(t, h.HandleGetSyncHistory, "/api/costs/sync-history"), &runs)
+	if len(runs) != 1 {
+		t.Fatalf("Expected 1 run after sync, got %d", len(runs))
+	}
+	if count, ok := runs[0].ProviderCounts["aws-main"]; !ok || count != 0 {
+		t.Errorf("Expected aws-main with 0 rows, got %v", runs[0].ProviderCounts)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleGetSyncHistory(rec, httptest.NewRequest(http.MethodGet, "/api/costs/sync-history?limit=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for limit=0, got %d", rec.Code)
+	}
+}
+
+func TestHandleGetCostMatrix(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	database.SaveEgressCost("aws", "2024-01-10", "AmazonEC2", "us-east-1", 100, nil)
+	database.SaveEgressCost("aws", "2024-01-11", "AmazonEC2", "us-west-2", 50, nil)
+	database.SaveEgressCost("aws", "2024-01-11", "AmazonS3", "us-east-1", 20, nil)
+	database.SaveEgressCost("gcp", "2024-01-10", "Compute", "us-central1", 30, nil)
+	database.SaveEgressCost("gcp", "2024-02-10", "Compute", "us-central1", 999, nil) // out of range
+
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	raw := getJSON(t, h.HandleGetCostMatrix, "/api/costs/matrix?start=2024-01-01&end=2024-01-31&rows=account&cols=service")
+
+	var matrix handler.CostMatrix
+	if err := json.Unmarshal(raw, &matrix); err != nil {
+		t.Fatalf("Invalid matrix JSON: %v", err)
+	}
+	if got := fmt.Sprint(matrix.Rows, matrix.Cols); got != "[aws gcp] [AmazonEC2 AmazonS3 Compute]" {
+		t.Errorf("Unexpected rows/cols: %s", got)
+	}
+	cells := []struct {
+		row, col string
+		want     float64
+	}{
+		{"aws", "AmazonEC2", 150},
+		{"aws", "AmazonS3", 20},
+		{"gcp", "Compute", 30},
+	}
+	for _, c := range cells {
+		if got := matrix.Cells[c.row][c.col]; got != c.want {
+			t.Errorf("cells[%s][%s] = %v, want %v", c.row, c.col, got, c.want)
+		}
+	}
+	if _, ok := matrix.Cells["gcp"]["AmazonEC2"]; ok {
+		t.Error("Expected empty cells to be omitted")
+	}
+	if matrix.RowTotals["aws"] != 170 || matrix.RowTotals["gcp"] != 30 {
+		t.Errorf("Unexpected row totals: %v", matrix.RowTotals)
+	}
+	if matrix.ColTotals["AmazonEC2"] != 150 || matrix.ColTotals["AmazonS3"] != 20 || matrix.ColTotals["Compute"] != 30 {
+		t.Errorf("Unexpected col totals: %v", matrix.ColTotals)
+	}
+	if matrix.Total != 200 {
+		t.Errorf("Expected total 200, got %v", matrix.Total)
+	}
+}
+
+func TestHandleGetCostsByTag(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	database.SaveEgressCostWithTags("aws", "2024-01-10", "AmazonEC2", "us-east-1", 100, nil,
+		[]db.CostTag{{Key: "team", Value: "net"}, {Key: "env", Value: "prod"}})
+	database.SaveEgressCostWithTags("aws", "2024-01-10", "AmazonS3", "us-east-1", 50, nil,
+		[]db.CostTag{{Key: "team", Value: "web"}})
+	// Shared cost: half to net, a quarter to web, the rest unallocated
+	database.SaveEgressCostWithTags("gcp", "2024-01-11", "Compute", "us-central1", 40, nil,
+		[]db.CostTag{{Key: "team", Value: "net", Weight: 0.5}, {Key: "team", Value: "web", Weight: 0.25}})
+	database.SaveEgressCost("gcp", "2024-01-12", "Storage", "us-central1", 30, nil)
+	database.SaveEgressCostWithTags("gcp", "2024-02-10", "Compute", "us-central1", 999, nil,
+		[]db.CostTag{{Key: "team", Value: "net"}}) // out of range
+
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	raw := getJSON(t, h.HandleGetCostsByTag, "/api/costs/by-tag?key=team&start=2024-01-01&end=2024-01-31")
+
+	var resp handler.CostsByTag
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	want := []handler.TagCostGroup{{"net", 120}, {"web", 60}, {"untagged", 40}}
+	if fmt.Sprint(resp.Groups) != fmt.Sprint(want) {
+		t.Errorf("Expected groups %v, got %v", want, resp.Groups)
+	}
+	if resp.Total != 220 {
+		t.Errorf("Expected total 220, got %v", resp.Total)
+	}
+
+	rec := httptest.NewRecorder()
+	h.HandleGetCostsByTag(rec, httptest.NewRequest(http.MethodGet, "/api/costs/by-tag", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without key, got %d", rec.Code)
+	}
+}
+
+func TestHandleGetCostMatrix_InvalidDimensions(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+
+	for _, query := range []string{"?rows=team", "?cols=provider", "?rows=service&cols=service"} {
+		rec := httptest.NewRecorder()
+		h.HandleGetCostMatrix(rec, httptest.NewRequest(http.MethodGet, "/api/costs/matrix"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHandleRevealCloud(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	t.Setenv("ENCRYPTION_KEY", testKey(t))
+	ciphertext, err := crypto.EncryptString(`{"id":"aws-main","provider":"aws","aws":{"access_key_id":"AKIA123","secret_access_key":"shh","region":"us-east-1"}}`)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	database.SaveCloudConfig("aws-main", "aws", ciphertext)
+
+	var audited []middleware.AuditLog
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	h.SetAuditLogger(func(entry middleware.AuditLog) { audited = append(audited, entry) })
+
+	reveal := func(id, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/clouds/"+id+"/reveal"+query, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		h.HandleRevealCloud(rec, req)
+		return rec
+	}
+
+	if rec := reveal("aws-main", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without confirm=true, got %d", rec.Code)
+	}
+	if len(audited) != 0 {
+		t.Errorf("Expected no audit entry for a refused reveal, got %+v", audited)
+	}
+
+	rec := reveal("aws-main", "?confirm=true")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var config cloud.CloudConfig
+	if err := json.NewDecoder(rec.Body).Decode(&config); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if config.AWS == nil || config.AWS.SecretAccessKey != "shh" || config.AWS.Region != "us-east-1" {
+		t.Errorf("Expected the decrypted config, got %+v", config.AWS)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Error("Expected revealed credentials not to be cached")
+	}
+
+	if len(audited) != 1 || audited[0].Action != "cloud_config.reveal" || audited[0].Path != "/api/clouds/aws-main/reveal" {
+		t.Errorf("Expected one reveal audit entry, got %+v", audited)
+	}
+
+	if rec := reveal("missing", "?confirm=true"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown config, got %d", rec.Code)
+	}
+}
+
+func TestAddCloud_TestsConnectionBeforeSaving(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	t.Setenv("ENCRYPTION_KEY", testKey(t))
+
+	registry := cloud.NewRegistry()
+	provider := &fakeProvider{name: cloud.ProviderAWS, err: fmt.Errorf("InvalidClientTokenId")}
+	h := handler.NewCostHandler(database, registry)
+	h.SetProviderFactory(func(*cloud.CloudConfig) (cloud.Provider, error) { return provider, nil })
+
+	add := func() *httptest.ResponseRecorder {
+		body := `{"id":"aws-main","provider":"aws","aws":{"access_key_id":"AKIA","secret_access_key":"s","region":"us-east-1"}}`
+		rec := httptest.NewRecorder()
+		h.HandleClouds(rec, httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader([]byte(body))))
+		return rec
+	}
+
+	rec := add()
+	if rec.Code != http.StatusBadRequest || !bytes.Contains(rec.Body.Bytes(), []byte("InvalidClientTokenId")) {
+		t.Errorf("Expected 400 with the connection error, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if stored, _ := database.GetCloudConfig("aws-main"); stored != nil {
+		t.Error("Expected a config failing its connection test not to be saved")
+	}
+	if _, ok := registry.Get("aws-main"); ok {
+		t.Error("Expected a config failing its connection test not to be registered")
+	}
+
+	provider.err = nil
+	if rec := add(); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 once the connection succeeds, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if stored, _ := database.GetCloudConfig("aws-main"); stored == nil {
+		t.Error("Expected the config to be saved")
+	}
+	if _, ok := registry.Get("aws-main"); !ok {
+		t.Error("Expected the provider to be registered")
+	}
+}
+
+func TestAddCloud_EncryptsCredentials(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	h.SetProviderFactory(func(*cloud.CloudConfig) (cloud.Provider, error) {
+		return &fakeProvider{name: cloud.ProviderAWS}, nil
+	})
+	add := func() *httptest.ResponseRecorder {
+		body := `{"id":"aws-main","provider":"aws","aws":{"access_key_id":"AKIA123","secret_access_key":"top-secret","region":"us-east-1"}}`
+		rec := httptest.NewRecorder()
+		h.HandleClouds(rec, httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader([]byte(body))))
+		return rec
+	}
+
+	t.Setenv("ENCRYPTION_KEY", "")
+	if rec := add(); rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "ENCRYPTION_KEY") {
+		t.Errorf("Expected 500 naming ENCRYPTION_KEY, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if stored, _ := database.GetCloudConfig("aws-main"); stored != nil {
+		t.Fatal("Expected nothing stored without an encryption key")
+	}
+
+	t.Setenv("ENCRYPTION_KEY", testKey(t))
+	if rec := add(); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	stored, err := database.GetCloudConfig("aws-main")
+	if err != nil || stored == nil {
+		t.Fatalf("Expected the config to be stored: %v", err)
+	}
+	if json.Valid([]byte(stored.ConfigJSON)) || strings.Contains(stored.ConfigJSON, "top-secret") {
+		t.Errorf("Expected the stored config to be ciphertext, got %q", stored.ConfigJSON)
+	}
+
+	config, err := cloud.ParseStoredConfig(stored.ConfigJSON)
+	if err != nil {
+		t.Fatalf("ParseStoredConfig failed: %v", err)
+	}
+	if config.AWS == nil || config.AWS.SecretAccessKey != "top-secret" {
+		t.Errorf("Expected the config to round-trip decrypted, got %+v", config.AWS)
+	}
+}
+
+func TestHandlePatchCloud(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	t.Setenv("ENCRYPTION_KEY", testKey(t))
+	ciphertext, _ := crypto.EncryptString(`{"id":"aws-main","provider":"aws","aws":{"access_key_id":"AKIA123","secret_access_key":"old-secret","region":"us-east-1"}}`)
+	database.SaveCloudConfig("aws-main", "aws", ciphertext)
+
+	registry := cloud.NewRegistry()
+	var tested []*cloud.CloudConfig
+	h := handler.NewCostHandler(database, registry)
+	h.SetProviderFactory(func(c *cloud.CloudConfig) (cloud.Provider, error) {
+		tested = append(tested, c)
+		return &fakeProvider{name: cloud.ProviderAWS}, nil
+	})
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/clouds/aws-main", bytes.NewReader([]byte(body)))
+		req.SetPathValue("id", "aws-main")
+		rec := httptest.NewRecorder()
+		h.HandlePatchCloud(rec, req)
+		return rec
+	}
+	storedAWS := func() *cloud.AWSConfig {
+		t.Helper()
+		stored, _ := database.GetCloudConfig("aws-main")
+		if json.Valid([]byte(stored.ConfigJSON)) {
+			t.Fatal("Expected the patched config to stay encrypted")
+		}
+		plaintext, err := crypto.DecryptString(stored.ConfigJSON)
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		config, _ := cloud.CloudConfigFromJSON(plaintext)
+		return config.AWS
+	}
+
+	// Only the region; the blank secret from the edit form is ignored
+	if rec := patch(`{"aws":{"region":"eu-west-1","secret_access_key":""}}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if aws := storedAWS(); aws.Region != "eu-west-1" || aws.SecretAccessKey != "old-secret" || aws.AccessKeyID != "AKIA123" {
+		t.Errorf("Expected only the region to change, got %+v", aws)
+	}
+	if _, ok := registry.Get("aws-main"); !ok {
+		t.Error("Expected the provider to be re-registered")
+	}
+
+	// Only the secret
+	if rec := patch(`{"aws":{"secret_access_key":"new-secret"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if aws := storedAWS(); aws.SecretAccessKey != "new-secret" || aws.Region != "eu-west-1" {
+		t.Errorf("Expected only the secret to change, got %+v", aws)
+	}
+	if last := tested[len(tested)-1]; last.AWS.SecretAccessKey != "new-secret" {
+		t.Error("Expected the connection test to use the rotated secret")
+	}
+
+	if rec := patch(`{"provider":"gcp"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 changing the provider, got %d", rec.Code)
+	}
+	if rec := patch(`{"aws":{"region":""}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a patch failing validation, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/clouds/missing", bytes.NewReader([]byte(`{}`)))
+	req.SetPathValue("id", "missing")
+	rec := httptest.NewRecorder()
+	h.HandlePatchCloud(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown config, got %d", rec.Code)
+	}
+}
+
+func TestHandleUpdateRecommendation(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+
+	database.SaveRecommendation("cross_az_traffic", "Move replicas", 100)
+	database.SaveRecommendation("use_vpc_endpoint", "Use VPC endpoints", 60)
+	recs, _ := database.GetRecommendations()
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 recommendations, got %d", len(recs))
+	}
+
+	update := func(id, body string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/api/recommendations/"+id, bytes.NewReader([]byte(body)))
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		h.HandleUpdateRecommendation(rec, req)
+		return rec.Code
+	}
+	listed := func(query string) map[int64]string {
+		t.Helper()
+		var recs []db.Recommendation
+		if err := json.Unmarshal(getJSON(t, h.HandleGetRecommendations, "/api/recommendations"+query), &recs); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		out := make(map[int64]string)
+		for _, r := range recs {
+			out[r.ID] = r.Status
+		}
+		return out
+	}
+
+	dismissed, accepted := recs[0].ID, recs[1].ID
+	if code := update(strconv.FormatInt(dismissed, 10), `{"status":"dismissed"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 dismissing, got %d", code)
+	}
+	if code := update(strconv.FormatInt(accepted, 10), `{"status":"accepted"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 accepting, got %d", code)
+	}
+
+	if got := listed(""); len(got) != 1 || got[accepted] != "accepted" {
+		t.Errorf("Expected only the accepted recommendation by default, got %v", got)
+	}
+	if got := listed("?status=all"); len(got) != 2 || got[dismissed] != "dismissed" {
+		t.Errorf("Expected both recommendations with status=all, got %v", got)
+	}
+
+	// Reopening brings it back into the default list
+	update(strconv.FormatInt(dismissed, 10), `{"status":"open"}`)
+	if got := listed(""); got[dismissed] != "open" {
+		t.Errorf("Expected the reopened recommendation listed, got %v", got)
+	}
+
+	// A snooze hides it until the given time
+	until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if code := update(strconv.FormatInt(dismissed, 10), `{"status":"snoozed","snoozed_until":"`+until+`"}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 snoozing, got %d", code)
+	}
+	if got := listed(""); got[dismissed] != "" {
+		t.Errorf("Expected the snoozed recommendation hidden, got %v", got)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if code := update(strconv.FormatInt(dismissed, 10), `{"status":"snoozed","snoozed_until":"`+past+`"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a snooze in the past, got %d", code)
+	}
+	if code := update(strconv.FormatInt(dismissed, 10), `{"status":"open","snoozed_until":"`+until+`"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for snoozed_until without the snoozed status, got %d", code)
+	}
+
+	if code := update(strconv.FormatInt(dismissed, 10), `{"status":"deleted"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown status, got %d", code)
+	}
+	if code := update("9999", `{"status":"dismissed"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing recommendation, got %d", code)
+	}
+	if code := update("abc", `{"status":"dismissed"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-numeric id, got %d", code)
+	}
+}
+
+func TestHandleGetCloudRecommendations(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+
+	database.SaveCloudConfig("aws-busy", "aws", "{}")
+	database.SaveCloudConfig("aws-quiet", "aws", "{}")
+	database.SaveAccountRecommendation("aws-busy", "cross_az_traffic", "Move replicas", 150)
+	database.SaveRecommendation("use_vpc_endpoint", "Use VPC endpoints", 60)
+
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/clouds/"+id+"/recommendations", nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		h.HandleGetCloudRecommendations(rec, req)
+		return rec
+	}
+
+	rec := get("aws-busy")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var recs []db.Recommendation
+	if err := json.Unmarshal(rec.Body.Bytes(), &recs); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(recs) != 1 || recs[0].Type != "cross_az_traffic" || recs[0].AccountID != "aws-busy" {
+		t.Errorf("Expected only the busy account's cross-AZ recommendation, got %+v", recs)
+	}
+
+	rec = get("aws-quiet")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("Expected an empty list for the quiet account, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := get("missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown config, got %d", rec.Code)
+	}
+}
+
+func TestHandleSyncCostsStream(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	registry := cloud.NewRegistry()
+	registry.Register("aws-main", &fakeProvider{name: cloud.ProviderAWS, costs: []cloud.CostResult{
+		{Date: time.Now(), Service: "AmazonEC2", Region: "us-east-1", CostUSD: 10},
+		{Date: time.Now(), Service: "AmazonS3", Region: "us-east-1", CostUSD: 5},
+	}})
+	registry.Register("gcp-main", &fakeProvider{name: cloud.ProviderGCP, err: fmt.Errorf("quota exceeded")})
+	h := handler.NewCostHandler(database, registry)
+
+	rec := httptest.NewRecorder()
+	h.HandleSyncCostsStream(rec, httptest.NewRequest(http.MethodGet, "/api/sync-costs/stream", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	// Parse "event:" / "data:" pairs
+	type event struct {
+		name string
+		data map[string]interface{}
+	}
+	var events []event
+	for _, block := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		var ev event
+		for _, line := range strings.Split(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				ev.name = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				if err := json.Unmarshal([]byte(data), &ev.data); err != nil {
+					t.Fatalf("Invalid event data %q: %v", data, err)
+				}
+			}
+		}
+		events = append(events, ev)
+	}
+
+	stages := map[string][]string{}
+	for _, ev := range events[:len(events)-1] {
+		provider, _ := ev.data["provider"].(string)
+		stages[provider] = append(stages[provider], ev.name)
+	}
+	if got := strings.Join(stages["aws-main"], ","); got != "started,fetched,completed" {
+		t.Errorf("Expected aws-main to start, fetch and complete, got %s", got)
+	}
+	if got := strings.Join(stages["gcp-main"], ","); got != "started,failed" {
+		t.Errorf("Expected gcp-main to start and fail, got %s", got)
+	}
+
+	last := events[len(events)-1]
+	if last.name != "done" || last.data["status"] != "partial" {
+		t.Errorf("Expected a final partial done event, got %+v", last)
+	}
+	for _, ev := range events {
+		if ev.name == "completed" && ev.data["rows"] != float64(2) {
+			t.Errorf("Expected aws-main to complete with 2 rows, got %v", ev.data["rows"])
+		}
+	}
+}
+
+func TestHandleGetAnomalies(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+
+	for i := 1; i <= 7; i++ {
+		database.SaveEgressCost("aws", time.Now().UTC().AddDate(0, 0, -i).Format("2006-01-02"), "AmazonEC2", "us-east-1", 10, nil)
+	}
+	database.SaveEgressCost("aws", time.Now().UTC().Format("2006-01-02"), "AmazonEC2", "us-east-1", 100, nil)
+
+	var anomalies []correlation.Anomaly
+	if err := json.Unmarshal(getJSON(t, h.HandleGetAnomalies, "/api/costs/anomalies?lookback=14"), &anomalies); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].CostUSD != 100 {
+		t.Errorf("Expected today's spike, got %+v", anomalies)
+	}
+
+	for _, query := range []string{"?lookback=0", "?threshold=-1", "?threshold=abc"} {
+		rec := httptest.NewRecorder()
+		h.HandleGetAnomalies(rec, httptest.NewRequest(http.MethodGet, "/api/costs/anomalies"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHandleBudgets(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.HandleBudgets(rec, httptest.NewRequest(http.MethodPost, "/api/budgets", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := post(`{"provider":"aws","monthly_usd":100}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 saving a budget, got %d", code)
+	}
+	if code := post(`{"provider":"aws","monthly_usd":250}`); code != http.StatusOK {
+		t.Fatalf("Expected 200 replacing a budget, got %d", code)
+	}
+	for _, body := range []string{`{"monthly_usd":100}`, `{"provider":"aws","monthly_usd":0}`, `not json`} {
+		if code := post(body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+
+	var budgets []db.Budget
+	if err := json.Unmarshal(getJSON(t, h.HandleBudgets, "/api/budgets"), &budgets); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(budgets) != 1 || budgets[0].Provider != "aws" || budgets[0].MonthlyUSD != 250 {
+		t.Fatalf("Expected the replaced aws budget, got %+v", budgets)
+	}
+
+	// Spend of $20/day on the 5th projects a 30-day month to $600
+	for day := 1; day <= 5; day++ {
+		database.SaveEgressCost("aws", time.Date(2024, 6, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02"), "AmazonEC2", "us-east-1", 20, nil)
+	}
+	var alerts []correlation.BudgetAlert
+	if err := json.Unmarshal(getJSON(t, h.HandleGetBudgetAlerts, "/api/budgets/alerts?as_of=2024-06-05"), &alerts); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].ProjectedUSD != 600 {
+		t.Errorf("Expected aws projected at $600, got %+v", alerts)
+	}
+
+	for _, tt := range []struct {
+		query string
+		code  int
+	}{
+		{"?provider=aws", http.StatusOK},
+		{"?provider=aws", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		h.HandleBudgets(rec, httptest.NewRequest(http.MethodDelete, "/api/budgets"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("DELETE %q: expected %d, got %d", tt.query, tt.code, rec.Code)
+		}
+	}
+}
+
+func TestHandleGetAttribution(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+
+	database.SaveCostAttribution("2024-01-10", "source_ip", "10.0.0.1", 75, nil, "aws", "")
+	database.SaveCostAttribution("2024-01-10", "service", "AmazonEC2", 60, nil, "aws", "us-east-1")
+	database.SaveCostAttribution("2024-02-01", "source_ip", "10.0.0.2", 5, nil, "aws", "")
+
+	var attrs []db.CostAttribution
+	if err := json.Unmarshal(getJSON(t, h.HandleGetAttribution, "/api/costs/attribution?start=2024-01-01&end=2024-01-31"), &attrs); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(attrs) != 2 || attrs[0].EntityName != "10.0.0.1" {
+		t.Errorf("Expected January's attributions, largest first, got %+v", attrs)
+	}
+
+	if err := json.Unmarshal(getJSON(t, h.HandleGetAttribution, "/api/costs/attribution?start=2024-01-01&end=2024-02-28&entity_type=source_ip"), &attrs); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if len(attrs) != 2 || attrs[1].EntityName != "10.0.0.2" {
+		t.Errorf("Expected only source attributions, got %+v", attrs)
+	}
+}
+
+func TestListClouds_Check(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	t.Setenv("ENCRYPTION_KEY", testKey(t))
+
+	for _, id := range []string{"aws-healthy", "aws-revoked"} {
+		ciphertext, _ := crypto.EncryptString(`{"id":"` + id + `","provider":"aws","aws":{"access_key_id":"AKIA","secret_access_key":"s","region":"us-east-1"}}`)
+		database.SaveCloudConfig(id, "aws", ciphertext)
+	}
+	database.SaveCloudConfig("aws-corrupt", "aws", "not-ciphertext")
+
+	h := handler.NewCostHandler(database, cloud.NewRegistry())
+	h.SetProviderFactory(func(c *cloud.CloudConfig) (cloud.Provider, error) {
+		if c.ID == "aws-revoked" {
+			return &fakeProvider{name: cloud.ProviderAWS, err: fmt.Errorf("InvalidClientTokenId")}, nil
+		}
+		return &fakeProvider{name: cloud.ProviderAWS}, nil
+	})
+
+	var clouds []map[string]interface{}
+	if err := json.Unmarshal(getJSON(t, h.HandleClouds, "/api/clouds?check=true"), &clouds); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	byID := make(map[string]map[string]interface{})
+	for _, c := range clouds {
+		byID[c["id"].(string)] = c
+	}
+	if c := byID["aws-healthy"]; c["healthy"] != true || c["error"] != nil {
+		t.Errorf("Expected aws-healthy to be healthy, got %v", c)
+	}
+	if c := byID["aws-revoked"]; c["healthy"] != false || !strings.Contains(fmt.Sprint(c["error"]), "InvalidClientTokenId") {
+		t.Errorf("Expected aws-revoked to report its connection error, got %v", c)
+	}
+	if c := byID["aws-corrupt"]; c["healthy"] != false || c["error"] == nil {
+		t.Errorf("Expected aws-corrupt to report a config error, got %v", c)
+	}
+
+	// Without check, nothing is tested
+	var plain []map[string]interface{}
+	json.Unmarshal(getJSON(t, h.HandleClouds, "/api/clouds"), &plain)
+	for _, c := range plain {
+		if _, ok := c["healthy"]; ok {
+			t.Errorf("Expected no health without ?check=true, got %v", c)
+		}
+	}
+}
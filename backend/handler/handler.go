@@ -5,19 +5,55 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"log"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"connectrpc.com/connect"
+	"github.com/sennet/sennet/backend/asyncwrite"
+	"github.com/sennet/sennet/backend/clock"
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/logging"
 	"github.com/sennet/sennet/backend/metrics"
+	"github.com/sennet/sennet/backend/middleware"
 	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
 )
 
+// TenantHeader lets an agent name its tenant explicitly when agent
+// namespacing is enabled. On authenticated requests it must match the tenant
+// bound to the API key; it names the tenant outright only when no key was
+// presented.
+const TenantHeader = "X-Sennet-Tenant"
+
+// MaxHeartbeatEvents bounds the event batch one heartbeat may carry. Agents
+// with more pending events should send the rest on later heartbeats.
+const MaxHeartbeatEvents = 100
+
+// MaxEventDetailBytes caps the detail stored with each event; longer details
+// are truncated
+const MaxEventDetailBytes = 1024
+
 // SentinelHandler implements the SentinelService
 type SentinelHandler struct {
-	db            *db.DB
-	latestVersion string
-	configHash    string
+	db              *db.DB
+	versionMu       sync.RWMutex // Guards latestVersion and configHash, which change at runtime
+	latestVersion   string
+	maxVersion      string // Agents above this are told to downgrade; empty for no pin
+	canaryVersion   string // Offered to agents in the first canaryPercent buckets
+	canaryPercent   int
+	configHash      string
+	versionFile     string // Where HandleSetLatestVersion persists changes; empty keeps them in memory
+	namespaceAgents bool
+	agentIDPolicy   AgentIDPolicy
+	upgradeWindow   *UpgradeWindow // nil issues upgrades at any time
+	clock           clock.Clock
+	events          *asyncwrite.Writer[AgentEventBatch] // nil saves events during the heartbeat
+	prom            *metrics.Metrics                    // nil records into metrics.Default()
+	stats           *StatsHandler                       // nil leaves dashboard stats alone
 }
 
 // NewSentinelHandler creates a new handler with the given database and version
@@ -30,6 +66,7 @@ func NewSentinelHandler(database *db.DB, latestVersion string) *SentinelHandler
 		db:            database,
 		latestVersion: latestVersion,
 		configHash:    configHash,
+		clock:         clock.Real,
 	}
 }
 
@@ -38,19 +75,33 @@ func (h *SentinelHandler) Heartbeat(
 	ctx context.Context,
 	req *connect.Request[sentinelv1.HeartbeatRequest],
 ) (*connect.Response[sentinelv1.HeartbeatResponse], error) {
-	agentID := req.Msg.AgentId
+	start := h.clock.Now()
+	if err := h.agentIDPolicy.Validate(req.Msg.AgentId); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if n := len(req.Msg.GetEvents()); n > MaxHeartbeatEvents {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("heartbeat carries %d events, at most %d allowed", n, MaxHeartbeatEvents))
+	}
+	agentID, err := h.effectiveAgentID(ctx, req.Header().Get(TenantHeader), req.Msg.AgentId)
+	if err != nil {
+		return nil, err
+	}
 	currentVersion := req.Msg.CurrentVersion
 	agentMetrics := req.Msg.Metrics
 
 	// Log the heartbeat
-	log.Printf("Heartbeat from agent %s (v%s)", agentID, currentVersion)
+	logging.Debugf("Heartbeat from agent %s (v%s)", agentID, currentVersion)
 	if agentMetrics != nil {
-		log.Printf("  Metrics: rx=%d tx=%d drops=%d uptime=%ds",
+		logging.Debugf("  Metrics: rx=%d tx=%d drops=%d uptime=%ds",
 			agentMetrics.RxPackets, agentMetrics.TxPackets, agentMetrics.DropCount, agentMetrics.UptimeSeconds)
-		
-		// Update Prometheus metrics
-		metrics.UpdateAgentMetrics(
+
+		// Update Prometheus metrics; implausible reports are counted and
+		// kept out of both the gauges and the history
+		now := h.clock.Now()
+		accepted := h.metrics().UpdateAgentMetrics(
 			agentID,
+			now,
 			agentMetrics.RxPackets,
 			agentMetrics.TxPackets,
 			agentMetrics.RxBytes,
@@ -58,86 +109,474 @@ func (h *SentinelHandler) Heartbeat(
 			agentMetrics.DropCount,
 			agentMetrics.UptimeSeconds,
 		)
+
+		if accepted && h.stats != nil {
+			h.stats.RecordAgent(agentID, agentMetrics.RxPackets, agentMetrics.TxPackets,
+				agentMetrics.RxBytes, agentMetrics.TxBytes, agentMetrics.DropCount, agentMetrics.UptimeSeconds)
+		}
+		if !accepted {
+			logging.Warnf("Rejected implausible metrics from agent %s", agentID)
+		} else if err := h.db.SaveMetrics(agentID, db.MetricsSample{
+			RxPackets:     agentMetrics.RxPackets,
+			RxBytes:       agentMetrics.RxBytes,
+			TxPackets:     agentMetrics.TxPackets,
+			TxBytes:       agentMetrics.TxBytes,
+			DropCount:     agentMetrics.DropCount,
+			UptimeSeconds: agentMetrics.UptimeSeconds,
+		}, now); err != nil {
+			logging.Errorf("Failed to record metrics history for %s: %v", agentID, err)
+		}
 	}
 
+	counted := h.recordEventTotals(agentID, agentMetrics)
+	h.recordEvents(agentID, req.Msg.GetEvents(), counted)
+
 	// Update agent in database
 	if err := h.db.CreateOrUpdateAgent(agentID, currentVersion); err != nil {
-		log.Printf("Failed to update agent %s: %v", agentID, err)
+		logging.Errorf("Failed to update agent %s: %v", agentID, err)
 		// Continue anyway - don't fail the heartbeat
 	}
+	if md := req.Msg.GetMetadata(); md != nil {
+		if err := h.db.UpsertAgentMetadata(agentID, db.AgentMetadata{
+			Hostname: md.GetHostname(),
+			OS:       md.GetOs(),
+			Kernel:   md.GetKernel(),
+			Arch:     md.GetArch(),
+		}); err != nil {
+			logging.Errorf("Failed to update metadata for %s: %v", agentID, err)
+		}
+	}
+	if reactivated, err := h.db.ReactivateAgent(agentID); err != nil {
+		logging.Errorf("Failed to reactivate agent %s: %v", agentID, err)
+	} else if reactivated {
+		logging.Infof("Agent %s returned from quarantine", agentID)
+	}
+
+	// Read once, so the command, target and hash all match even if the
+	// latest version changes mid-heartbeat
+	latest, configHash := h.versionSnapshot()
+	target := h.targetVersion(agentID, latest)
 
-	// Determine command based on version comparison
-	command := h.determineCommand(currentVersion)
+	// Operator-queued commands take precedence over the version check
+	command := h.pendingCommand(agentID)
+	if command == sentinelv1.Command_COMMAND_UNSPECIFIED {
+		command = h.determineCommand(agentID, currentVersion, target)
+	}
 
 	response := &sentinelv1.HeartbeatResponse{
 		Command:       command,
-		LatestVersion: h.latestVersion,
-		ConfigHash:    h.configHash,
+		LatestVersion: target,
+		ConfigHash:    configHash,
 	}
 
+	h.metrics().ObserveHeartbeat(command.String(), h.clock.Now().Sub(start))
 	return connect.NewResponse(response), nil
 }
 
-// determineCommand compares versions and decides what command to send
-func (h *SentinelHandler) determineCommand(currentVersion string) sentinelv1.Command {
+// eventTypes maps the event types agents report to their stored names
+var eventTypes = map[sentinelv1.EventType]string{
+	sentinelv1.EventType_EVENT_TYPE_ANOMALY:      "anomaly",
+	sentinelv1.EventType_EVENT_TYPE_LARGE_PACKET: "large_packet",
+}
+
+// recordEventTotals advances the event counters from the cumulative totals
+// in an agent's metrics, returning the event types it covered
+func (h *SentinelHandler) recordEventTotals(agentID string, summary *sentinelv1.MetricsSummary) map[sentinelv1.EventType]bool {
+	counted := make(map[sentinelv1.EventType]bool)
+	if summary == nil {
+		return counted
+	}
+	if summary.AnomalyEvents != nil {
+		h.metrics().RecordAnomalyTotal(agentID, summary.GetAnomalyEvents(), summary.GetUptimeSeconds())
+		counted[sentinelv1.EventType_EVENT_TYPE_ANOMALY] = true
+	}
+	if summary.LargePacketEvents != nil {
+		h.metrics().RecordLargePacketTotal(agentID, summary.GetLargePacketEvents(), summary.GetUptimeSeconds())
+		counted[sentinelv1.EventType_EVENT_TYPE_LARGE_PACKET] = true
+	}
+	return counted
+}
+
+// recordEvents counts an agent's reported events and persists them. Types in
+// counted were already counted from the agent's totals, so their events are
+// only persisted. Events of unknown type are dropped, events without a
+// timestamp are stamped with the receive time, and long details are truncated.
+func (h *SentinelHandler) recordEvents(agentID string, events []*sentinelv1.AgentEvent, counted map[sentinelv1.EventType]bool) {
+	if len(events) == 0 {
+		return
+	}
+
+	now := h.clock.Now()
+	saved := make([]db.AgentEvent, 0, len(events))
+	for _, e := range events {
+		name, ok := eventTypes[e.GetType()]
+		if !ok {
+			logging.Debugf("Ignoring event of unknown type %v from agent %s", e.GetType(), agentID)
+			continue
+		}
+		switch {
+		case counted[e.GetType()]:
+		case e.GetType() == sentinelv1.EventType_EVENT_TYPE_ANOMALY:
+			h.metrics().RecordAnomalyEvent(agentID, e.GetTraceId())
+		case e.GetType() == sentinelv1.EventType_EVENT_TYPE_LARGE_PACKET:
+			h.metrics().RecordLargePacketEvent(agentID, e.GetTraceId())
+		}
+		occurred := now
+		if ms := e.GetTimestampMs(); ms != 0 {
+			occurred = time.UnixMilli(ms)
+		}
+		saved = append(saved, db.AgentEvent{
+			Type:       name,
+			Detail:     truncateUTF8(e.GetDetail(), MaxEventDetailBytes),
+			OccurredAt: occurred,
+			ReceivedAt: now,
+		})
+	}
+
+	if h.events != nil {
+		if !h.events.Write(AgentEventBatch{AgentID: agentID, Events: saved}) {
+			logging.Errorf("Dropped %d events from %s", len(saved), agentID)
+		}
+		return
+	}
+	if err := h.db.SaveAgentEvents(agentID, saved); err != nil {
+		logging.Errorf("Failed to record events for %s: %v", agentID, err)
+	}
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// AgentEventBatch is one heartbeat's events, queued for an event writer
+type AgentEventBatch struct {
+	AgentID string
+	Events  []db.AgentEvent
+}
+
+// NewEventWriter starts an async writer that saves queued event batches to
+// database, buffering up to size heartbeats
+func NewEventWriter(database *db.DB, size int) *asyncwrite.Writer[AgentEventBatch] {
+	return asyncwrite.New("agent event", size, func(batches []AgentEventBatch) error {
+		var errs []error
+		for _, b := range batches {
+			if err := database.SaveAgentEvents(b.AgentID, b.Events); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", b.AgentID, err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// SetEventWriter queues reported events on w instead of saving them before
+// the heartbeat responds. The caller drains w on shutdown.
+func (h *SentinelHandler) SetEventWriter(w *asyncwrite.Writer[AgentEventBatch]) {
+	h.events = w
+}
+
+// SetAgentNamespacing scopes agent identities to the tenant (or API key) that
+// reported them, so deployments reusing the same agent ID don't collide.
+// Disabled by default to keep single-tenant agent IDs unchanged.
+func (h *SentinelHandler) SetAgentNamespacing(enabled bool) {
+	h.namespaceAgents = enabled
+}
+
+// SetAgentIDPolicy restricts the agent IDs Heartbeat accepts. Rejected
+// heartbeats fail with CodeInvalidArgument.
+func (h *SentinelHandler) SetAgentIDPolicy(policy AgentIDPolicy) {
+	h.agentIDPolicy = policy
+}
+
+// SetUpgradeWindow limits UPGRADE commands to a daily window; nil removes
+// the limit. Downgrades and queued commands are not affected.
+func (h *SentinelHandler) SetUpgradeWindow(window *UpgradeWindow) {
+	h.upgradeWindow = window
+}
+
+// SetMetrics records heartbeat metrics into m instead of metrics.Default()
+func (h *SentinelHandler) SetMetrics(m *metrics.Metrics) {
+	h.prom = m
+}
+
+func (h *SentinelHandler) metrics() *metrics.Metrics {
+	if h.prom == nil {
+		return metrics.Default()
+	}
+	return h.prom
+}
+
+// SetStatsHandler feeds each accepted metrics report into the dashboard
+// stats served by s
+func (h *SentinelHandler) SetStatsHandler(s *StatsHandler) {
+	h.stats = s
+}
+
+// SetClock replaces the time source used for upgrade windows and metrics
+// sample timestamps
+func (h *SentinelHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// effectiveAgentID returns the ID an agent is stored under. With namespacing
+// enabled it is prefixed by the tenant bound to the authenticating API key,
+// or else by a fingerprint of the key (never the key itself). A requested
+// tenant other than the key's is refused, so one tenant can't report as
+// another's agents.
+func (h *SentinelHandler) effectiveAgentID(ctx context.Context, requested, agentID string) (string, error) {
+	namespace, err := h.agentNamespace(ctx, requested)
+	if err != nil || namespace == "" {
+		return agentID, err
+	}
+	return namespace + "/" + agentID, nil
+}
+
+// agentNamespace returns the prefix effectiveAgentID puts on agent IDs, or
+// "" for none
+func (h *SentinelHandler) agentNamespace(ctx context.Context, requested string) (string, error) {
+	if !h.namespaceAgents {
+		return "", nil
+	}
+	apiKey := middleware.GetAPIKey(ctx)
+	if apiKey == "" {
+		// Nothing authenticated the caller, so there is no binding to check
+		return requested, nil
+	}
+
+	key, err := h.db.GetAPIKey(apiKey)
+	if err != nil {
+		return "", connect.NewError(connect.CodeInternal, fmt.Errorf("failed to look up API key: %w", err))
+	}
+	tenant := ""
+	if key != nil {
+		tenant = key.Tenant
+	}
+	if requested != "" && requested != tenant {
+		return "", connect.NewError(connect.CodePermissionDenied,
+			fmt.Errorf("tenant %q is not bound to this API key", requested))
+	}
+	if tenant == "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		tenant = "key-" + hex.EncodeToString(sum[:6])
+	}
+	return tenant, nil
+}
+
+// determineCommand compares the agent's version with its target version (see
+// targetVersion) and decides what command to send
+func (h *SentinelHandler) determineCommand(agentID, currentVersion, target string) sentinelv1.Command {
 	if currentVersion == "" {
 		return sentinelv1.Command_COMMAND_NOOP
 	}
 
-	// Simple version comparison
-	if needsUpgrade(currentVersion, h.latestVersion) {
-		log.Printf("Agent version %s < %s, issuing UPGRADE command", currentVersion, h.latestVersion)
+	if h.maxVersion != "" && needsUpgrade(h.maxVersion, currentVersion) {
+		logging.Infof("Agent version %s > pinned %s, issuing DOWNGRADE command", currentVersion, h.maxVersion)
+		return sentinelv1.Command_COMMAND_DOWNGRADE
+	}
+
+	if needsUpgrade(currentVersion, target) {
+		if h.upgradeWindow != nil && !h.upgradeWindow.Allows(agentID, h.clock.Now()) {
+			logging.Debugf("Agent %s is outdated but outside its upgrade window", agentID)
+			return sentinelv1.Command_COMMAND_NOOP
+		}
+		logging.Infof("Agent version %s < %s, issuing UPGRADE command", currentVersion, target)
 		return sentinelv1.Command_COMMAND_UPGRADE
 	}
 
 	return sentinelv1.Command_COMMAND_NOOP
 }
 
+// targetVersion is the version an agent should run: the canary version if
+// it's selected for one, otherwise latest, capped at the pinned maximum
+// either way
+func (h *SentinelHandler) targetVersion(agentID, latest string) string {
+	target := latest
+	if h.inCanary(agentID) {
+		target = h.canaryVersion
+	}
+	if h.maxVersion != "" && needsUpgrade(h.maxVersion, target) {
+		return h.maxVersion
+	}
+	return target
+}
+
+// pendingCommand delivers the agent's oldest queued command, if any
+func (h *SentinelHandler) pendingCommand(agentID string) sentinelv1.Command {
+	pending, err := h.db.TakePendingCommand(agentID)
+	if err != nil {
+		logging.Errorf("Failed to fetch pending command for %s: %v", agentID, err)
+		return sentinelv1.Command_COMMAND_UNSPECIFIED
+	}
+	if pending == nil {
+		return sentinelv1.Command_COMMAND_UNSPECIFIED
+	}
+	command, ok := ParseCommand(pending.Command)
+	if !ok {
+		logging.Warnf("Dropping unknown pending command %q for %s", pending.Command, agentID)
+		return sentinelv1.Command_COMMAND_UNSPECIFIED
+	}
+	if latency, ok := pending.DeliveryLatency(); ok {
+		h.metrics().ObserveCommandDelivery(command.String(), latency)
+	}
+	logging.Infof("Delivering queued %s to agent %s", command, agentID)
+	return command
+}
+
+// ParseCommand parses a command name such as "RECONFIGURE" or
+// "COMMAND_RECONFIGURE". UNSPECIFIED is never valid.
+func ParseCommand(name string) (sentinelv1.Command, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "COMMAND_") {
+		name = "COMMAND_" + name
+	}
+	value, ok := sentinelv1.Command_value[name]
+	if !ok || value == int32(sentinelv1.Command_COMMAND_UNSPECIFIED) {
+		return sentinelv1.Command_COMMAND_UNSPECIFIED, false
+	}
+	return sentinelv1.Command(value), true
+}
+
+// sameVersion reports whether two version strings compare equal
+func sameVersion(a, b string) bool {
+	return !needsUpgrade(a, b) && !needsUpgrade(b, a)
+}
+
 // needsUpgrade compares semver strings and returns true if current < latest
 func needsUpgrade(current, latest string) bool {
-	// Parse versions (simple implementation)
-	currParts := parseVersion(current)
-	latestParts := parseVersion(latest)
-
-	for i := 0; i < 3; i++ {
-		currVal := 0
-		latestVal := 0
-		if i < len(currParts) {
-			currVal = currParts[i]
-		}
-		if i < len(latestParts) {
-			latestVal = latestParts[i]
+	return compareVersions(parseVersion(current), parseVersion(latest)) < 0
+}
+
+// semver is a parsed version string. Build metadata is discarded since it
+// doesn't affect precedence.
+type semver struct {
+	core       [3]int
+	preRelease []string // nil for a release version
+}
+
+// parseVersion parses "1.2.3-rc.1+build5" into core {1, 2, 3} and
+// pre-release ["rc", "1"]. Missing or malformed core parts read as 0.
+func parseVersion(v string) semver {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "+")
+	core, pre, hasPre := strings.Cut(v, "-")
+
+	var parsed semver
+	for i, part := range strings.SplitN(core, ".", 3) {
+		n := 0
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				break
+			}
+			n = n*10 + int(c-'0')
 		}
+		parsed.core[i] = n
+	}
+	if hasPre {
+		parsed.preRelease = strings.Split(pre, ".")
+	}
+	return parsed
+}
 
-		if currVal < latestVal {
-			return true
+// compareVersions orders versions by semver precedence, returning -1, 0 or 1
+func compareVersions(a, b semver) int {
+	for i := range a.core {
+		if a.core[i] != b.core[i] {
+			return cmpInt(a.core[i], b.core[i])
 		}
-		if currVal > latestVal {
-			return false
+	}
+
+	// A pre-release sorts before the release with the same core
+	switch {
+	case a.preRelease == nil && b.preRelease == nil:
+		return 0
+	case a.preRelease == nil:
+		return 1
+	case b.preRelease == nil:
+		return -1
+	}
+
+	for i := 0; i < len(a.preRelease) && i < len(b.preRelease); i++ {
+		if c := compareIdentifiers(a.preRelease[i], b.preRelease[i]); c != 0 {
+			return c
 		}
 	}
-	return false // Equal versions
+	return cmpInt(len(a.preRelease), len(b.preRelease))
 }
 
-// parseVersion parses "1.2.3" into []int{1, 2, 3}
-func parseVersion(v string) []int {
-	parts := make([]int, 0, 3)
-	current := 0
-	for _, c := range v {
-		if c >= '0' && c <= '9' {
-			current = current*10 + int(c-'0')
-		} else if c == '.' {
-			parts = append(parts, current)
-			current = 0
-		}
+// compareIdentifiers compares pre-release identifiers: numeric ones
+// numerically, alphanumeric ones lexically, and numeric before alphanumeric
+func compareIdentifiers(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmpInt(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
 	}
-	parts = append(parts, current)
-	return parts
+	return strings.Compare(a, b)
 }
 
-// SetLatestVersion updates the advertised latest version
-func (h *SentinelHandler) SetLatestVersion(version string) {
-	h.latestVersion = version
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// SetLatestVersion updates the advertised latest version. The version must
+// be valid semver; a leading "v" is stripped. It is safe to call while
+// heartbeats are being served.
+func (h *SentinelHandler) SetLatestVersion(version string) error {
+	version, err := NormalizeVersion(version)
+	if err != nil {
+		return err
+	}
 	hash := sha256.Sum256([]byte(version))
+	h.versionMu.Lock()
+	defer h.versionMu.Unlock()
+	h.latestVersion = version
 	h.configHash = hex.EncodeToString(hash[:8])
+	return nil
+}
+
+// LatestVersion returns the advertised latest version
+func (h *SentinelHandler) LatestVersion() string {
+	h.versionMu.RLock()
+	defer h.versionMu.RUnlock()
+	return h.latestVersion
+}
+
+// versionSnapshot returns the latest version and its config hash together
+func (h *SentinelHandler) versionSnapshot() (latest, configHash string) {
+	h.versionMu.RLock()
+	defer h.versionMu.RUnlock()
+	return h.latestVersion, h.configHash
+}
+
+// SetMaxVersion pins the newest version agents may run. Agents reporting a
+// newer version get COMMAND_DOWNGRADE with the pinned version as the target.
+// An empty version removes the pin.
+func (h *SentinelHandler) SetMaxVersion(version string) error {
+	if version == "" {
+		h.maxVersion = ""
+		return nil
+	}
+	version, err := NormalizeVersion(version)
+	if err != nil {
+		return err
+	}
+	h.maxVersion = version
+	return nil
 }
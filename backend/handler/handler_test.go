@@ -4,11 +4,19 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sennet/sennet/backend/clock"
 	"github.com/sennet/sennet/backend/db"
 	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/metrics"
+	"github.com/sennet/sennet/backend/middleware"
 	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
 )
 
@@ -129,6 +137,213 @@ func TestHeartbeat_NewerVersion(t *testing.T) {
 	}
 }
 
+func TestHeartbeat_DowngradeAbovePinnedVersion(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "2.1.0")
+	defer cleanup()
+	if err := h.SetMaxVersion("2.0.0"); err != nil {
+		t.Fatalf("SetMaxVersion failed: %v", err)
+	}
+
+	heartbeat := func(version string) *sentinelv1.HeartbeatResponse {
+		resp, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "pinned-agent",
+			CurrentVersion: version,
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		return resp.Msg
+	}
+
+	resp := heartbeat("2.1.0")
+	if resp.Command != sentinelv1.Command_COMMAND_DOWNGRADE {
+		t.Errorf("Expected DOWNGRADE for agent above the pin, got: %v", resp.Command)
+	}
+	if resp.LatestVersion != "2.0.0" {
+		t.Errorf("Expected downgrade target 2.0.0, got: %s", resp.LatestVersion)
+	}
+
+	if resp := heartbeat("2.0.0"); resp.Command != sentinelv1.Command_COMMAND_NOOP {
+		t.Errorf("Expected NOOP at the pinned version, got: %v", resp.Command)
+	}
+	// Upgrades stop at the pin rather than the newer latest version
+	resp = heartbeat("1.9.0")
+	if resp.Command != sentinelv1.Command_COMMAND_UPGRADE || resp.LatestVersion != "2.0.0" {
+		t.Errorf("Expected UPGRADE to 2.0.0, got: %v to %s", resp.Command, resp.LatestVersion)
+	}
+
+	if err := h.SetMaxVersion(""); err != nil {
+		t.Fatalf("Clearing max version failed: %v", err)
+	}
+	if resp := heartbeat("2.1.0"); resp.Command != sentinelv1.Command_COMMAND_NOOP {
+		t.Errorf("Expected NOOP once the pin is cleared, got: %v", resp.Command)
+	}
+	if err := h.SetMaxVersion("latest"); err == nil {
+		t.Error("Expected an invalid max version to be rejected")
+	}
+}
+
+func TestHeartbeat_RecordsMetricsHistory(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	h.SetClock(fake)
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			fake.Advance(time.Minute)
+		}
+		_, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "history-agent",
+			CurrentVersion: "1.0.0",
+			Metrics:        &sentinelv1.MetricsSummary{RxPackets: uint64(i + 1), UptimeSeconds: uint64(i * 60)},
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+	}
+	// Heartbeats without metrics don't add samples
+	h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{AgentId: "history-agent", CurrentVersion: "1.0.0"}))
+
+	history, err := database.GetMetricsHistory("history-agent", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetMetricsHistory failed: %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 samples, got %d", len(history))
+	}
+	for i, m := range history {
+		if m.RxPackets != uint64(i+1) {
+			t.Errorf("Sample %d: expected rx_packets %d, got %d", i, i+1, m.RxPackets)
+		}
+	}
+}
+
+func TestHeartbeat_RecordsEvents(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	const agentID = "event-agent"
+	before := testutil.ToFloat64(metrics.AnomalyEvents.WithLabelValues(agentID))
+
+	detected := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var events []*sentinelv1.AgentEvent
+	for i := 0; i < 3; i++ {
+		events = append(events, &sentinelv1.AgentEvent{
+			Type:        sentinelv1.EventType_EVENT_TYPE_ANOMALY,
+			TimestampMs: detected.Add(time.Duration(i) * time.Second).UnixMilli(),
+			Detail:      "syn flood",
+		})
+	}
+	_, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+		AgentId:        agentID,
+		CurrentVersion: "1.0.0",
+		Events:         events,
+	}))
+	if err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.AnomalyEvents.WithLabelValues(agentID)) - before; got != 3 {
+		t.Errorf("Expected anomaly counter to increase by 3, got %v", got)
+	}
+
+	saved, err := database.GetAgentEvents(agentID, 10)
+	if err != nil {
+		t.Fatalf("GetAgentEvents failed: %v", err)
+	}
+	if len(saved) != 3 {
+		t.Fatalf("Expected 3 persisted events, got %d", len(saved))
+	}
+	for _, e := range saved {
+		if e.Type != "anomaly" || e.Detail != "syn flood" {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	}
+	if !saved[0].OccurredAt.Equal(detected.Add(2 * time.Second)) {
+		t.Errorf("Expected newest event at %v, got %v", detected.Add(2*time.Second), saved[0].OccurredAt)
+	}
+}
+
+func TestHeartbeat_EventDefaults(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	_, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+		AgentId:        "event-defaults-agent",
+		CurrentVersion: "1.0.0",
+		Events: []*sentinelv1.AgentEvent{{
+			Type:   sentinelv1.EventType_EVENT_TYPE_LARGE_PACKET,
+			Detail: strings.Repeat("é", handler.MaxEventDetailBytes),
+		}},
+	}))
+	if err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+
+	saved, err := database.GetAgentEvents("event-defaults-agent", 10)
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("Expected 1 persisted event, got %+v, %v", saved, err)
+	}
+	if age := time.Since(saved[0].OccurredAt); age < 0 || age > time.Minute {
+		t.Errorf("Expected a missing timestamp to default to the receive time, got %v", saved[0].OccurredAt)
+	}
+	if d := saved[0].Detail; len(d) > handler.MaxEventDetailBytes || !utf8.ValidString(d) {
+		t.Errorf("Expected the detail truncated to %d bytes of valid UTF-8, got %d bytes", handler.MaxEventDetailBytes, len(d))
+	}
+}
+
+func TestHeartbeat_RejectsOversizedEventBatch(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	events := make([]*sentinelv1.AgentEvent, handler.MaxHeartbeatEvents+1)
+	for i := range events {
+		events[i] = &sentinelv1.AgentEvent{Type: sentinelv1.EventType_EVENT_TYPE_LARGE_PACKET}
+	}
+	_, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+		AgentId:        "flood-agent",
+		CurrentVersion: "1.0.0",
+		Events:         events,
+	}))
+	if connect.CodeOf(err) != connect.CodeInvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	if saved, _ := database.GetAgentEvents("flood-agent", 10); len(saved) != 0 {
+		t.Errorf("Expected no events persisted, got %d", len(saved))
+	}
+}
+
+func TestHeartbeat_RecordsMetadata(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	ctx := context.Background()
+
+	// First heartbeat from an older agent carries no metadata
+	if _, err := h.Heartbeat(ctx, connect.NewRequest(&sentinelv1.HeartbeatRequest{AgentId: "meta-agent", CurrentVersion: "1.0.0"})); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	agent, _ := database.GetAgent("meta-agent")
+	if agent == nil || agent.Metadata != (db.AgentMetadata{}) {
+		t.Fatalf("Expected agent without metadata, got %+v", agent)
+	}
+
+	_, err := h.Heartbeat(ctx, connect.NewRequest(&sentinelv1.HeartbeatRequest{
+		AgentId:        "meta-agent",
+		CurrentVersion: "1.0.0",
+		Metadata:       &sentinelv1.AgentMetadata{Hostname: "node-7", Os: "linux", Kernel: "6.8.0-31-generic", Arch: "x86_64"},
+	}))
+	if err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	agent, _ = database.GetAgent("meta-agent")
+	want := db.AgentMetadata{Hostname: "node-7", OS: "linux", Kernel: "6.8.0-31-generic", Arch: "x86_64"}
+	if agent.Metadata != want {
+		t.Errorf("Expected metadata %+v, got %+v", want, agent.Metadata)
+	}
+}
+
 func TestHeartbeat_AgentPersisted(t *testing.T) {
 	h, database, cleanup := setupTestHandler(t, "1.0.0")
 	defer cleanup()
@@ -242,3 +457,282 @@ func TestHeartbeat_PatchVersionUpgrade(t *testing.T) {
 		t.Errorf("Expected UPGRADE for patch version bump, got: %v", resp.Msg.Command)
 	}
 }
+
+func TestHeartbeat_SemverPrecedence(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	tests := []struct {
+		current, latest string
+		upgrade         bool
+	}{
+		{"1.0.0-alpha", "1.0.0", true},
+		{"1.0.0", "1.0.0-alpha", false},
+		{"1.0.0-alpha.1", "1.0.0-alpha.2", true},
+		{"1.0.0-alpha", "1.0.0-alpha.1", true},
+		{"1.0.0-alpha.beta", "1.0.0-beta", true},
+		{"1.0.0-alpha.2", "1.0.0-alpha.10", true},
+		{"1.0.0-2", "1.0.0-alpha", true},
+		{"1.0.0-rc.1", "1.0.0-beta.11", false},
+		{"1.4.0-rc.2", "1.4.0", true},
+		{"1.3.9", "1.4.0-rc.2", true},
+		{"1.4.0+build42", "1.4.0", false},
+		{"1.4.0", "1.4.0+build43", false},
+		{"1.4.0-rc.1+build1", "1.4.0-rc.1+build2", false},
+	}
+	for _, tt := range tests {
+		if err := h.SetLatestVersion(tt.latest); err != nil {
+			t.Fatalf("SetLatestVersion(%q) failed: %v", tt.latest, err)
+		}
+		resp, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "semver-agent",
+			CurrentVersion: tt.current,
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		if got := resp.Msg.Command == sentinelv1.Command_COMMAND_UPGRADE; got != tt.upgrade {
+			t.Errorf("current %s, latest %s: upgrade = %v, want %v", tt.current, tt.latest, got, tt.upgrade)
+		}
+	}
+}
+
+func TestHeartbeat_NamespacedAgentsPerKey(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h.SetAgentNamespacing(true)
+
+	for _, key := range []string{"sk_tenant_a", "sk_tenant_b"} {
+		ctx := context.WithValue(context.Background(), middleware.APIKeyContextKey, key)
+		req := connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "shared-agent-id",
+			CurrentVersion: "1.0.0",
+		})
+		if _, err := h.Heartbeat(ctx, req); err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+	}
+
+	count, _ := database.GetAgentCount()
+	if count != 2 {
+		t.Errorf("Expected 2 distinct agents with namespacing, got %d", count)
+	}
+	if agent, _ := database.GetAgent("shared-agent-id"); agent != nil {
+		t.Error("Expected bare agent ID to not be stored when namespacing is enabled")
+	}
+}
+
+func TestHeartbeat_NamespacedAgentsExplicitTenant(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h.SetAgentNamespacing(true)
+
+	req := connect.NewRequest(&sentinelv1.HeartbeatRequest{
+		AgentId:        "shared-agent-id",
+		CurrentVersion: "1.0.0",
+	})
+	req.Header().Set(handler.TenantHeader, "acme")
+	if _, err := h.Heartbeat(context.Background(), req); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+
+	agent, _ := database.GetAgent("acme/shared-agent-id")
+	if agent == nil {
+		t.Fatal("Expected agent to be stored under its tenant")
+	}
+}
+
+func TestHeartbeat_TenantBoundToKey(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h.SetAgentNamespacing(true)
+
+	keyA, _ := database.CreateAPIKey("tenant-a-agents")
+	if err := database.SetAPIKeyTenant(keyA, "tenant-a"); err != nil {
+		t.Fatalf("SetAPIKeyTenant failed: %v", err)
+	}
+	unbound, _ := database.CreateAPIKey("unbound")
+	heartbeat := func(key, tenant string) error {
+		ctx := context.WithValue(context.Background(), middleware.APIKeyContextKey, key)
+		req := connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "shared-agent-id",
+			CurrentVersion: "1.0.0",
+		})
+		if tenant != "" {
+			req.Header().Set(handler.TenantHeader, tenant)
+		}
+		_, err := h.Heartbeat(ctx, req)
+		return err
+	}
+
+	for _, tt := range []struct{ key, tenant string }{{keyA, "tenant-b"}, {unbound, "tenant-a"}} {
+		if err := heartbeat(tt.key, tt.tenant); connect.CodeOf(err) != connect.CodePermissionDenied {
+			t.Errorf("tenant %q: expected PermissionDenied for a tenant not bound to the key, got %v", tt.tenant, err)
+		}
+	}
+	if agent, _ := database.GetAgent("tenant-b/shared-agent-id"); agent != nil {
+		t.Error("Expected no agent stored under the foreign tenant")
+	}
+
+	// The bound tenant applies whether or not the agent names it
+	for _, tenant := range []string{"", "tenant-a"} {
+		if err := heartbeat(keyA, tenant); err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+	}
+	if agent, _ := database.GetAgent("tenant-a/shared-agent-id"); agent == nil {
+		t.Error("Expected the agent stored under the key's tenant")
+	}
+	if count, _ := database.GetAgentCount(); count != 1 {
+		t.Errorf("Expected 1 agent, got %d", count)
+	}
+}
+
+func TestHeartbeat_SingleTenantByDefault(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	for _, key := range []string{"sk_tenant_a", "sk_tenant_b"} {
+		ctx := context.WithValue(context.Background(), middleware.APIKeyContextKey, key)
+		req := connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "shared-agent-id",
+			CurrentVersion: "1.0.0",
+		})
+		h.Heartbeat(ctx, req)
+	}
+
+	count, _ := database.GetAgentCount()
+	if count != 1 {
+		t.Errorf("Expected agents to share an identity by default, got %d", count)
+	}
+}
+
+func TestHeartbeat_AgentIDPolicy(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	policy, err := handler.ParseAgentIDPolicy("uuid")
+	if err != nil {
+		t.Fatalf("ParseAgentIDPolicy failed: %v", err)
+	}
+	h.SetAgentIDPolicy(policy)
+
+	heartbeat := func(id string) error {
+		_, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        id,
+			CurrentVersion: "1.0.0",
+		}))
+		return err
+	}
+
+	err = heartbeat("not-a-uuid")
+	if connect.CodeOf(err) != connect.CodeInvalidArgument {
+		t.Errorf("Expected CodeInvalidArgument for non-UUID, got %v", err)
+	}
+	if err := heartbeat("3f2504e0-4f89-11d3-9a0c-0305e82c3301"); err != nil {
+		t.Errorf("Expected valid UUID to be accepted, got %v", err)
+	}
+}
+
+func TestParseAgentIDPolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		id      string
+		wantErr bool
+	}{
+		{"none", "anything goes", false},
+		{"", "anything goes", false},
+		{"hostname", "edge-01.example.com", false},
+		{"hostname", "-bad-.example.com", true},
+		{"regex:edge-[0-9]+", "edge-42", false},
+		{"regex:edge-[0-9]+", "edge-42x", true},
+		{"uuid", "{3f2504e0-4f89-11d3-9a0c-0305e82c3301}", true},
+	}
+	for _, tt := range tests {
+		policy, err := handler.ParseAgentIDPolicy(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseAgentIDPolicy(%q) failed: %v", tt.spec, err)
+		}
+		if err := policy.Validate(tt.id); (err != nil) != tt.wantErr {
+			t.Errorf("%s.Validate(%q) = %v, wantErr %v", tt.spec, tt.id, err, tt.wantErr)
+		}
+	}
+
+	for _, spec := range []string{"guid", "regex:", "regex:(", "uuid:v4"} {
+		if _, err := handler.ParseAgentIDPolicy(spec); err == nil {
+			t.Errorf("ParseAgentIDPolicy(%q) expected error", spec)
+		}
+	}
+}
+
+func TestHeartbeat_EventTotalsAdvanceCounters(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	h.SetMetrics(m)
+
+	const agentID = "totals-agent"
+	beat := eventTotalsBeat(t, h, agentID)
+	counts := func() (float64, float64) {
+		return testutil.ToFloat64(m.AnomalyEvents.WithLabelValues(agentID)),
+			testutil.ToFloat64(m.LargePacketEvents.WithLabelValues(agentID))
+	}
+
+	// A freshly started agent's first totals are all new
+	beat(30, 5, 1)
+	// Listed events are already in the totals, so they aren't counted again
+	beat(60, 8, 1, &sentinelv1.AgentEvent{Type: sentinelv1.EventType_EVENT_TYPE_ANOMALY})
+	if anomalies, large := counts(); anomalies != 8 || large != 1 {
+		t.Errorf("Expected counters at 8 and 1, got %v and %v", anomalies, large)
+	}
+
+	// The agent restarted: its totals began again from zero
+	beat(10, 2, 0)
+	if anomalies, large := counts(); anomalies != 10 || large != 1 {
+		t.Errorf("Expected the post-restart total added in full (10 and 1), got %v and %v", anomalies, large)
+	}
+	beat(40, 4, 3)
+	if anomalies, large := counts(); anomalies != 12 || large != 4 {
+		t.Errorf("Expected counters at 12 and 4, got %v and %v", anomalies, large)
+	}
+}
+
+func TestHeartbeat_EventTotalsBaselineAfterServerRestart(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	h.SetMetrics(m)
+
+	// A long-running agent reporting to a server that just started
+	const agentID = "baseline-agent"
+	beat := eventTotalsBeat(t, h, agentID)
+	beat(3600, 500, 40)
+	if got := testutil.ToFloat64(m.AnomalyEvents.WithLabelValues(agentID)); got != 0 {
+		t.Errorf("Expected the first total to be the baseline, got %v", got)
+	}
+	beat(3630, 503, 40)
+	if got := testutil.ToFloat64(m.AnomalyEvents.WithLabelValues(agentID)); got != 3 {
+		t.Errorf("Expected 3 anomalies since the baseline, got %v", got)
+	}
+}
+
+// eventTotalsBeat returns a function sending a heartbeat with the given
+// uptime and event totals
+func eventTotalsBeat(t *testing.T, h *handler.SentinelHandler, agentID string) func(uptime, anomalies, largePackets uint64, events ...*sentinelv1.AgentEvent) {
+	return func(uptime, anomalies, largePackets uint64, events ...*sentinelv1.AgentEvent) {
+		t.Helper()
+		_, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        agentID,
+			CurrentVersion: "1.0.0",
+			Metrics: &sentinelv1.MetricsSummary{
+				UptimeSeconds:     uptime,
+				AnomalyEvents:     &anomalies,
+				LargePacketEvents: &largePackets,
+			},
+			Events: events,
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+	}
+}
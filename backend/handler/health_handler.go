@@ -2,17 +2,25 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"runtime"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/crypto"
 	"github.com/sennet/sennet/backend/db"
 )
 
 type HealthHandler struct {
 	database  *db.DB
+	registry  *cloud.Registry
 	startTime time.Time
 	version   string
+	versionFn func() string // Overrides version when set, see SetVersionSource
 }
 
 func NewHealthHandler(database *db.DB, version string) *HealthHandler {
@@ -23,6 +31,45 @@ func NewHealthHandler(database *db.DB, version string) *HealthHandler {
 	}
 }
 
+// SetProviderRegistry enables the providers readiness check, which fails if
+// any stored cloud config has no provider loaded in the registry
+func (h *HealthHandler) SetProviderRegistry(registry *cloud.Registry) {
+	h.registry = registry
+}
+
+// SetVersionSource reports fn's result as the advertised version instead of
+// the version given at startup, so a runtime change (see
+// SentinelHandler.HandleSetLatestVersion) shows up here too
+func (h *HealthHandler) SetVersionSource(fn func() string) {
+	h.versionFn = fn
+}
+
+func (h *HealthHandler) currentVersion() string {
+	if h.versionFn != nil {
+		return h.versionFn()
+	}
+	return h.version
+}
+
+// Readiness check outcomes. A skipped check does not affect readiness.
+const (
+	CheckPass = "pass"
+	CheckFail = "fail"
+	CheckSkip = "skip"
+)
+
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status    string           `json:"status"`
+	Checks    []ReadinessCheck `json:"checks"`
+	Timestamp string           `json:"timestamp"`
+}
+
 type HealthResponse struct {
 	Status    string            `json:"status"`
 	Version   string            `json:"version"`
@@ -34,7 +81,7 @@ type HealthResponse struct {
 func (h *HealthHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	response := HealthResponse{
 		Status:    "ok",
-		Version:   h.version,
+		Version:   h.currentVersion(),
 		Uptime:    time.Since(h.startTime).Round(time.Second).String(),
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Checks:    make(map[string]string),
@@ -55,12 +102,87 @@ func (h *HealthHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HealthHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{
+		Status: "ready",
+		Checks: []ReadinessCheck{
+			h.checkDatabase(),
+			h.checkProviders(),
+			checkEncryption(),
+		},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, check := range response.Checks {
+		if check.Status == CheckFail {
+			response.Status = "not ready"
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *HealthHandler) checkDatabase() ReadinessCheck {
+	check := ReadinessCheck{Name: "db", Status: CheckPass}
 	if err := h.database.Ping(); err != nil {
-		http.Error(w, "not ready", http.StatusServiceUnavailable)
-		return
+		check.Status = CheckFail
+		check.Detail = err.Error()
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("ready"))
+	return check
+}
+
+func (h *HealthHandler) checkProviders() ReadinessCheck {
+	check := ReadinessCheck{Name: "providers", Status: CheckPass}
+	if h.registry == nil {
+		check.Status = CheckSkip
+		check.Detail = "provider registry not configured"
+		return check
+	}
+
+	configs, err := h.database.GetCloudConfigs()
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = err.Error()
+		return check
+	}
+
+	var missing []string
+	for _, cfg := range configs {
+		if _, ok := h.registry.Get(cfg.ID); !ok {
+			missing = append(missing, cfg.ID)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		check.Status = CheckFail
+		check.Detail = "providers not loaded: " + strings.Join(missing, ", ")
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%d loaded", len(configs))
+	return check
+}
+
+func checkEncryption() ReadinessCheck {
+	check := ReadinessCheck{Name: "encryption", Status: CheckPass}
+	ciphertext, err := crypto.Encrypt([]byte("readiness"))
+	if errors.Is(err, crypto.ErrNoEncryptionKey) {
+		check.Status = CheckSkip
+		check.Detail = "ENCRYPTION_KEY not set"
+		return check
+	}
+	if err == nil {
+		_, err = crypto.Decrypt(ciphertext)
+	}
+	if err != nil {
+		check.Status = CheckFail
+		check.Detail = err.Error()
+	}
+	return check
 }
 
 func (h *HealthHandler) HandleLive(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +190,50 @@ func (h *HealthHandler) HandleLive(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("live"))
 }
 
+// VersionResponse is the agent version the server advertises
+type VersionResponse struct {
+	LatestVersion string `json:"latest_version"`
+}
+
+// HandleVersion reports the agent version the server advertises. It changes
+// rarely (on redeploy or a version reload), so it is served with caching
+// headers.
+func (h *HealthHandler) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionResponse{LatestVersion: h.currentVersion()})
+}
+
+// TimeResponse is the server clock, for agents estimating their skew before
+// signing requests
+type TimeResponse struct {
+	Unix   int64  `json:"unix"`
+	UnixMS int64  `json:"unix_ms"`
+	Time   string `json:"time"`
+}
+
+// HandleTime reports the server's current time. It needs no auth, so an
+// agent whose clock has drifted out of the signature window can still use it.
+func (h *HealthHandler) HandleTime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := h.database.Now().UTC()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TimeResponse{
+		Unix:   now.Unix(),
+		UnixMS: now.UnixMilli(),
+		Time:   now.Format(time.RFC3339Nano),
+	})
+}
+
 type RuntimeInfo struct {
 	GoVersion    string `json:"go_version"`
 	NumGoroutine int    `json:"goroutines"`
@@ -0,0 +1,184 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func getReadiness(t *testing.T, h *handler.HealthHandler) (int, handler.ReadinessResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.HandleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	var resp handler.ReadinessResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode readiness body: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func checkStatuses(resp handler.ReadinessResponse) map[string]string {
+	statuses := make(map[string]string)
+	for _, c := range resp.Checks {
+		statuses[c.Name] = c.Status
+	}
+	return statuses
+}
+
+func TestHandleReady_ListsEachCheck(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", testKey(t))
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	h := handler.NewHealthHandler(database, "1.0.0")
+	h.SetProviderRegistry(cloud.NewRegistry())
+
+	code, resp := getReadiness(t, h)
+	if code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if resp.Status != "ready" {
+		t.Errorf("Expected status ready, got %q", resp.Status)
+	}
+
+	statuses := checkStatuses(resp)
+	for _, name := range []string{"db", "providers", "encryption"} {
+		if statuses[name] != handler.CheckPass {
+			t.Errorf("Expected %s check to pass, got %q", name, statuses[name])
+		}
+	}
+}
+
+func TestHandleReady_SkippedChecksStayReady(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "")
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	code, resp := getReadiness(t, handler.NewHealthHandler(database, "1.0.0"))
+	if code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+
+	statuses := checkStatuses(resp)
+	if statuses["providers"] != handler.CheckSkip {
+		t.Errorf("Expected providers check skipped without registry, got %q", statuses["providers"])
+	}
+	if statuses["encryption"] != handler.CheckSkip {
+		t.Errorf("Expected encryption check skipped without key, got %q", statuses["encryption"])
+	}
+}
+
+func TestHandleReady_UnloadedProviderFails(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	if err := database.SaveCloudConfig("aws-main", "aws", `{}`); err != nil {
+		t.Fatalf("Failed to save cloud config: %v", err)
+	}
+
+	h := handler.NewHealthHandler(database, "1.0.0")
+	h.SetProviderRegistry(cloud.NewRegistry())
+
+	code, resp := getReadiness(t, h)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", code)
+	}
+	if resp.Status != "not ready" {
+		t.Errorf("Expected status not ready, got %q", resp.Status)
+	}
+	if s := checkStatuses(resp)["providers"]; s != handler.CheckFail {
+		t.Errorf("Expected providers check to fail, got %q", s)
+	}
+}
+
+func TestHandleReady_DatabaseDownFails(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	database.Close()
+
+	code, resp := getReadiness(t, handler.NewHealthHandler(database, "1.0.0"))
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", code)
+	}
+	if s := checkStatuses(resp)["db"]; s != handler.CheckFail {
+		t.Errorf("Expected db check to fail, got %q", s)
+	}
+}
+
+func TestHandleTime(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewHealthHandler(database, "1.0.0")
+
+	rec := httptest.NewRecorder()
+	h.HandleTime(rec, httptest.NewRequest(http.MethodGet, "/time", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var resp handler.TimeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode time body: %v", err)
+	}
+	if skew := time.Since(time.Unix(resp.Unix, 0)); skew < -time.Second || skew > 5*time.Second {
+		t.Errorf("Expected a current timestamp, got %d (skew %s)", resp.Unix, skew)
+	}
+	if resp.UnixMS/1000 != resp.Unix {
+		t.Errorf("unix_ms %d disagrees with unix %d", resp.UnixMS, resp.Unix)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleTime(rec, httptest.NewRequest(http.MethodPost, "/time", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
+
+func TestHandleVersion_Cacheable(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := middleware.Cacheable(10 * time.Minute)(http.HandlerFunc(handler.NewHealthHandler(database, "1.2.3").HandleVersion))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=600" {
+		t.Errorf("Expected public max-age=600, got %q", got)
+	}
+	var resp handler.VersionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.LatestVersion != "1.2.3" {
+		t.Fatalf("Expected latest_version 1.2.3, got %+v (%v)", resp, err)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304 for a matching ETag, got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304 body, got %q", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a stale ETag, got %d", rec.Code)
+	}
+}
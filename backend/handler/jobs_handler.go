@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sennet/sennet/backend/jobs"
+)
+
+type JobsHandler struct {
+	registry *jobs.Registry
+}
+
+func NewJobsHandler(registry *jobs.Registry) *JobsHandler {
+	return &JobsHandler{registry: registry}
+}
+
+type JobStatusResponse struct {
+	Name         string `json:"name"`
+	Interval     string `json:"interval,omitempty"`
+	Runs         int64  `json:"runs"`
+	LastRun      string `json:"last_run,omitempty"`
+	LastDuration string `json:"last_duration,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+	SinceLastRun string `json:"since_last_run,omitempty"`
+}
+
+// HandleListJobs reports the last run of every background job
+func (h *JobsHandler) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := h.registry.Statuses()
+	response := make([]JobStatusResponse, 0, len(statuses))
+	for _, s := range statuses {
+		job := JobStatusResponse{
+			Name:      s.Name,
+			Runs:      s.Runs,
+			LastError: s.LastError,
+		}
+		if s.Interval > 0 {
+			job.Interval = s.Interval.String()
+		}
+		if s.LastRun != nil {
+			job.LastRun = s.LastRun.UTC().Format(time.RFC3339)
+			job.LastDuration = s.LastDuration.String()
+			job.SinceLastRun = time.Since(*s.LastRun).Round(time.Second).String()
+		}
+		response = append(response, job)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
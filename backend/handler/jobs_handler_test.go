@@ -0,0 +1,41 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/jobs"
+)
+
+func TestHandleListJobs(t *testing.T) {
+	registry := jobs.NewRegistry()
+	registry.Register("active-agents", 30*time.Second)
+	registry.Run("retention", func() error { return errors.New("disk full") })
+
+	h := handler.NewJobsHandler(registry)
+	rec := httptest.NewRecorder()
+	h.HandleListJobs(rec, httptest.NewRequest(http.MethodGet, "/api/admin/jobs", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var resp []handler.JobStatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(resp) != 2 {
+		t.Fatalf("Expected 2 jobs, got %d", len(resp))
+	}
+	if resp[0].Name != "active-agents" || resp[0].LastRun != "" || resp[0].Interval != "30s" {
+		t.Errorf("Unexpected status for job that hasn't run: %+v", resp[0])
+	}
+	if resp[1].Name != "retention" || resp[1].LastError != "disk full" || resp[1].Runs != 1 {
+		t.Errorf("Unexpected status for failed job: %+v", resp[1])
+	}
+}
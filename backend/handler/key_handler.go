@@ -2,13 +2,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/sennet/sennet/backend/auth"
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/middleware"
 )
 
 type KeyHandler struct {
 	database *db.DB
+	limiter  *middleware.RateLimiter
 }
 
 func NewKeyHandler(database *db.DB) *KeyHandler {
@@ -17,6 +23,11 @@ func NewKeyHandler(database *db.DB) *KeyHandler {
 	}
 }
 
+// SetRateLimiter sets the limiter whose tier whoami reports for API keys
+func (h *KeyHandler) SetRateLimiter(rl *middleware.RateLimiter) {
+	h.limiter = rl
+}
+
 // HandleGetKeys lists all API keys
 func (h *KeyHandler) HandleGetKeys(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -34,7 +45,8 @@ func (h *KeyHandler) HandleGetKeys(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(keys)
 }
 
-// HandleCreateKey creates a new API key
+// HandleCreateKey creates a new API key, optionally bound to a tenant that
+// namespaces the agents reporting with it
 func (h *KeyHandler) HandleCreateKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -42,7 +54,8 @@ func (h *KeyHandler) HandleCreateKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name string `json:"name"`
+		Name   string `json:"name"`
+		Tenant string `json:"tenant"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -54,25 +67,181 @@ func (h *KeyHandler) HandleCreateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.Contains(req.Tenant, "/") {
+		http.Error(w, "tenant must not contain '/'", http.StatusBadRequest)
+		return
+	}
+
 	key, err := h.database.CreateAPIKey(req.Name)
 	if err != nil {
 		http.Error(w, "Failed to create key", http.StatusInternalServerError)
 		return
 	}
+	if req.Tenant != "" {
+		if err := h.database.SetAPIKeyTenant(key, req.Tenant); err != nil {
+			if created, _ := h.database.GetAPIKey(key); created != nil {
+				h.database.DeleteAPIKey(created.Key)
+			}
+			http.Error(w, "Failed to create key", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"key":  key,
-		"name": req.Name,
+		"key":    key,
+		"name":   req.Name,
+		"tenant": req.Tenant,
 	})
 }
 
-// HandleDeleteKey deletes an API key (actually just marks it or deletes - specific logic depends on DB implementation)
-// Note: The current DB implementation doesn't have a DeleteAPIKey method, but we can add one or use Execute directly.
-// For now, let's implement a direct SQL delete for expediency, or better, add it to DB.
-// Let's assume we'll just return not implemented until we add it to DB, or I'll implement it here via DB access.
-// Actually, looking at db.go, there is no DeleteAPIKey. Let's add it there first??
-// No, I can't edit `db.go` and `handler` in one turn efficiently without context.
-// I will start with List and Create, as those are the most critical.
-// I'll skip Delete for this exact step to keep it atomic, or I can try to use raw Exec if I had access.
-// Let's stick to List and Create for now.
+// WhoAmIResponse describes the credential used to make a request.
+// API key callers get the key fields, Firebase callers get the user fields.
+type WhoAmIResponse struct {
+	Type string `json:"type"` // "api_key" or "firebase"
+
+	Name          string   `json:"name,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	ReadOnly      bool     `json:"read_only"`
+	RateLimitTier string   `json:"rate_limit_tier,omitempty"`
+
+	UID   string `json:"uid,omitempty"`
+	Email string `json:"email,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// HandleWhoAmI returns the identity and permissions of the caller's credential.
+// The key itself is never echoed back.
+func (h *KeyHandler) HandleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp WhoAmIResponse
+	if apiKey := middleware.GetAPIKey(r.Context()); apiKey != "" {
+		key, err := h.database.GetAPIKey(apiKey)
+		if err != nil {
+			http.Error(w, "Failed to look up key", http.StatusInternalServerError)
+			return
+		}
+		if key == nil {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		resp = WhoAmIResponse{
+			Type:     "api_key",
+			Name:     key.Name,
+			Scopes:   key.Scopes,
+			ReadOnly: middleware.ReadOnly(key.Scopes),
+		}
+		if h.limiter != nil {
+			resp.RateLimitTier = h.limiter.Tier()
+		}
+	} else if uid := auth.GetFirebaseUID(r.Context()); uid != "" {
+		resp = WhoAmIResponse{
+			Type:  "firebase",
+			UID:   uid,
+			Email: auth.GetFirebaseEmail(r.Context()),
+			Role:  h.firebaseRole(r, uid),
+		}
+	} else {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// firebaseRole resolves a Firebase user's role from the token's custom
+// claims, falling back to the stored user record
+func (h *KeyHandler) firebaseRole(r *http.Request, uid string) string {
+	if token := auth.GetFirebaseToken(r.Context()); token != nil {
+		if role, ok := token.Claims["role"].(string); ok && role != "" {
+			return role
+		}
+	}
+	if user, err := h.database.GetUserByFirebaseUID(uid); err == nil && user != nil && user.Role != "" {
+		return user.Role
+	}
+	return "user"
+}
+
+// HandleDeleteKey deletes the API key whose ID (as listed by HandleGetKeys)
+// is the last path segment of /api/keys/{id}. Keys are never addressed by
+// their secret, which the request logs and audit trail would then record.
+// The key stops authenticating immediately.
+func (h *KeyHandler) HandleDeleteKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/keys/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "key ID is required", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(id, "kid_") {
+		http.Error(w, "Keys are deleted by their kid_ ID, not the key itself", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.database.DeleteAPIKey(id); err != nil {
+		if errors.Is(err, db.ErrKeyNotFound) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// HandleRevokeKeys revokes every active key matching a name glob and/or
+// created more than older_than (e.g. "90d") ago. The last active key is
+// never revoked, so operators can't lock themselves out.
+func (h *KeyHandler) HandleRevokeKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		NamePattern string `json:"name_pattern"`
+		OlderThan   string `json:"older_than"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.NamePattern == "" && req.OlderThan == "" {
+		http.Error(w, "name_pattern or older_than is required", http.StatusBadRequest)
+		return
+	}
+
+	var olderThan time.Duration
+	if req.OlderThan != "" {
+		var err error
+		if olderThan, err = ParseAge(req.OlderThan); err != nil {
+			http.Error(w, "Invalid older_than: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	revoked, err := h.database.RevokeAPIKeys(req.NamePattern, olderThan)
+	if err != nil {
+		if errors.Is(err, db.ErrLastActiveKey) {
+			http.Error(w, "Refusing to revoke the last active key", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to revoke keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
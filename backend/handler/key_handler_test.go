@@ -0,0 +1,210 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sennet/sennet/backend/auth"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestWhoAmI_APIKey(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	key, err := database.CreateAPIKey("ci-runner")
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	h := handler.NewKeyHandler(database)
+	h.SetRateLimiter(middleware.NewRateLimiter(100, 20))
+	srv := middleware.NewHTTPAuthMiddleware(database)(http.HandlerFunc(h.HandleWhoAmI))
+	whoami := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := whoami(key)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), key) {
+		t.Error("Response must not contain the API key secret")
+	}
+
+	var resp handler.WhoAmIResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if resp.Type != "api_key" || resp.Name != "ci-runner" {
+		t.Errorf("Unexpected identity: %+v", resp)
+	}
+	if len(resp.Scopes) != 1 || resp.Scopes[0] != "*" {
+		t.Errorf("Expected full-access scopes, got %v", resp.Scopes)
+	}
+	if resp.ReadOnly {
+		t.Error("Expected key to not be read-only")
+	}
+	if resp.RateLimitTier != "100/min, burst 20" {
+		t.Errorf("Expected the limiter's tier, got %q", resp.RateLimitTier)
+	}
+
+	// A key without write scopes is read-only
+	readKey, err := database.CreateScopedAPIKey("reporting", 0, []string{middleware.ScopeCostsRead})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	rec = whoami(readKey)
+	resp = handler.WhoAmIResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if !resp.ReadOnly {
+		t.Errorf("Expected a costs:read key to be read-only, got %+v", resp)
+	}
+}
+
+func TestWhoAmI_Firebase(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	if _, err := database.CreateUser("fb-uid-1", "ops@example.com", "Ops", "admin"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	h := handler.NewKeyHandler(database)
+
+	// Simulate what auth.FirebaseMiddleware puts on the context
+	ctx := context.WithValue(context.Background(), auth.FirebaseUIDKey, "fb-uid-1")
+	ctx = context.WithValue(ctx, auth.FirebaseEmailKey, "ops@example.com")
+	req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	h.HandleWhoAmI(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp handler.WhoAmIResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if resp.Type != "firebase" || resp.UID != "fb-uid-1" || resp.Email != "ops@example.com" {
+		t.Errorf("Unexpected identity: %+v", resp)
+	}
+	if resp.Role != "admin" {
+		t.Errorf("Expected admin role, got %q", resp.Role)
+	}
+}
+
+func TestWhoAmI_Unauthenticated(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	h := handler.NewKeyHandler(database)
+	rec := httptest.NewRecorder()
+	h.HandleWhoAmI(rec, httptest.NewRequest(http.MethodGet, "/api/whoami", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rec.Code)
+	}
+}
+
+func TestHandleDeleteKey(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewKeyHandler(database)
+
+	key, _ := database.CreateAPIKey("doomed")
+	del := func(target string) int {
+		rec := httptest.NewRecorder()
+		h.HandleDeleteKey(rec, httptest.NewRequest(http.MethodDelete, target, nil))
+		return rec.Code
+	}
+
+	// Delete the key by the ID the list endpoint shows
+	rec := httptest.NewRecorder()
+	h.HandleGetKeys(rec, httptest.NewRequest(http.MethodGet, "/api/keys", nil))
+	var listed []struct{ Key string }
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil || len(listed) != 1 {
+		t.Fatalf("Expected 1 listed key, got %v (%v)", listed, err)
+	}
+	id := listed[0].Key
+
+	if code := del("/api/keys/" + key); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a key in the path, got %d", code)
+	}
+	if code := del("/api/keys/" + id); code != http.StatusOK {
+		t.Errorf("Expected 200 deleting by listed ID, got %d", code)
+	}
+	if valid, _ := database.ValidateAPIKey(key); valid {
+		t.Error("Expected the key to stop validating after delete")
+	}
+
+	if code := del("/api/keys/" + id); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an already deleted key, got %d", code)
+	}
+	if code := del("/api/keys/"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a key ID, got %d", code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleDeleteKey(rec, httptest.NewRequest(http.MethodGet, "/api/keys/"+id, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestHandleRevokeKeys(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewKeyHandler(database)
+
+	leaked1, _ := database.CreateAPIKey("contractor-alice")
+	leaked2, _ := database.CreateAPIKey("contractor-bob")
+	keep, _ := database.CreateAPIKey("prod-agents")
+
+	revoke := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.HandleRevokeKeys(rec, httptest.NewRequest(http.MethodPost, "/api/keys/revoke", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := revoke(`{"name_pattern":"contractor-*"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]int
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["revoked"] != 2 {
+		t.Errorf("Expected 2 keys revoked, got %v", resp)
+	}
+	for _, key := range []string{leaked1, leaked2} {
+		if valid, _ := database.ValidateAPIKey(key); valid {
+			t.Errorf("Expected %s to be revoked", key)
+		}
+	}
+
+	if rec := revoke(`{"name_pattern":"*"}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 revoking the last active key, got %d", rec.Code)
+	}
+	if valid, _ := database.ValidateAPIKey(keep); !valid {
+		t.Error("Expected the last active key to survive")
+	}
+
+	for _, body := range []string{`{}`, `{"older_than":"soon"}`, `not json`} {
+		if rec := revoke(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// LatestVersionRequest is the body of HandleSetLatestVersion
+type LatestVersionRequest struct {
+	Version string `json:"version"`
+}
+
+// LatestVersionResponse reports a change of the advertised latest version
+type LatestVersionResponse struct {
+	LatestVersion   string `json:"latest_version"`
+	PreviousVersion string `json:"previous_version"`
+}
+
+// HandleSetLatestVersion changes the advertised latest version without a
+// restart. Older agents get COMMAND_UPGRADE on their next heartbeat. The new
+// version is written to the version file (see SetVersionFile) before it is
+// applied, so a reload or restart keeps it; without one the change is held
+// in memory only and lost on restart.
+func (h *SentinelHandler) HandleSetLatestVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req LatestVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	version, err := NormalizeVersion(req.Version)
+	if err != nil {
+		http.Error(w, "Invalid version: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.versionFile != "" {
+		if err := writeVersionFile(h.versionFile, version); err != nil {
+			logging.Errorf("Failed to persist latest version to %s: %v", h.versionFile, err)
+			http.Error(w, "Failed to persist version", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	previous := h.LatestVersion()
+	if err := h.SetLatestVersion(version); err != nil {
+		http.Error(w, "Invalid version: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.versionFile != "" {
+		logging.Infof("Latest agent version changed from %s to %s (saved to %s)", previous, version, h.versionFile)
+	} else {
+		logging.Warnf("Latest agent version changed from %s to %s in memory only; it reverts on restart without -version-file", previous, version)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LatestVersionResponse{LatestVersion: version, PreviousVersion: previous})
+}
+
+// SetVersionFile makes HandleSetLatestVersion write changes to path, the
+// file the latest version is loaded (and reloaded) from
+func (h *SentinelHandler) SetVersionFile(path string) {
+	h.versionFile = path
+}
+
+// writeVersionFile replaces the version file's contents atomically, so a
+// concurrent reload never reads a partial version
+func writeVersionFile(path, version string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if info, err := os.Stat(path); err == nil {
+		tmp.Chmod(info.Mode().Perm())
+	}
+	if _, err := tmp.WriteString(version + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,106 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/sennet/sennet/backend/handler"
+	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
+)
+
+func setLatestVersion(h *handler.SentinelHandler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.HandleSetLatestVersion(rec, httptest.NewRequest(http.MethodPost, "/api/admin/latest-version", bytes.NewReader([]byte(body))))
+	return rec
+}
+
+func TestHandleSetLatestVersion_UpgradesOlderAgents(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	heartbeat := func() *sentinelv1.HeartbeatResponse {
+		t.Helper()
+		resp, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        "reload-agent",
+			CurrentVersion: "1.0.0",
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		return resp.Msg
+	}
+	before := heartbeat()
+	if before.Command != sentinelv1.Command_COMMAND_NOOP {
+		t.Fatalf("Expected NOOP while current, got %v", before.Command)
+	}
+
+	rec := setLatestVersion(h, `{"version":"v1.2.0"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp handler.LatestVersionResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.LatestVersion != "1.2.0" || resp.PreviousVersion != "1.0.0" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	after := heartbeat()
+	if after.Command != sentinelv1.Command_COMMAND_UPGRADE || after.LatestVersion != "1.2.0" {
+		t.Errorf("Expected UPGRADE to 1.2.0, got %v to %s", after.Command, after.LatestVersion)
+	}
+	if after.ConfigHash == before.ConfigHash {
+		t.Error("Expected the config hash to change with the version")
+	}
+}
+
+func TestHandleSetLatestVersion_RejectsInvalidVersion(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	for _, body := range []string{`{"version":"latest"}`, `{"version":""}`, `not json`} {
+		if rec := setLatestVersion(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if got := h.LatestVersion(); got != "1.0.0" {
+		t.Errorf("Expected the version unchanged, got %s", got)
+	}
+}
+
+func TestHandleSetLatestVersion_PersistsToVersionFile(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	path := filepath.Join(t.TempDir(), "latest-version")
+	if err := os.WriteFile(path, []byte("1.0.0\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	h.SetVersionFile(path)
+
+	if rec := setLatestVersion(h, `{"version":"v1.3.0"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "1.3.0" {
+		t.Errorf("Expected the version file to hold 1.3.0, got %q", got)
+	}
+
+	// A version file that can't be written leaves the version unchanged
+	h.SetVersionFile(filepath.Join(t.TempDir(), "missing", "latest-version"))
+	if rec := setLatestVersion(h, `{"version":"1.4.0"}`); rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the version can't be saved, got %d", rec.Code)
+	}
+	if got := h.LatestVersion(); got != "1.3.0" {
+		t.Errorf("Expected the version to stay 1.3.0, got %s", got)
+	}
+}
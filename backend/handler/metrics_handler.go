@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/logging"
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// MaxBulkMetrics caps how many agents one bulk push may report
+const MaxBulkMetrics = 1000
+
+// MetricsHandler ingests metrics pushed by collectors on behalf of agents
+type MetricsHandler struct {
+	database *db.DB
+	agents   *SentinelHandler // nil stores agent IDs as pushed
+	prom     *metrics.Metrics // nil records into metrics.Default()
+}
+
+func NewMetricsHandler(database *db.DB) *MetricsHandler {
+	return &MetricsHandler{database: database}
+}
+
+// SetAgentIdentity validates and namespaces pushed agent IDs the way s does
+// for heartbeats, so its agent ID policy and tenant scoping also hold here
+func (h *MetricsHandler) SetAgentIdentity(s *SentinelHandler) {
+	h.agents = s
+}
+
+// SetMetrics records pushed metrics into m instead of metrics.Default()
+func (h *MetricsHandler) SetMetrics(m *metrics.Metrics) {
+	h.prom = m
+}
+
+func (h *MetricsHandler) metrics() *metrics.Metrics {
+	if h.prom == nil {
+		return metrics.Default()
+	}
+	return h.prom
+}
+
+// BulkMetric is one agent's entry in a bulk push
+type BulkMetric struct {
+	AgentID string           `json:"agent_id"`
+	Metrics db.MetricsSample `json:"metrics"`
+}
+
+// HandleBulkMetrics updates the metric gauges and history for every agent in
+// a batch. Entries without a timestamp are recorded at the time of the push.
+// Unlike heartbeats it doesn't touch agent versions or deliver commands.
+// Implausible entries are skipped and reported in the "rejected" count;
+// entries older than an agent's latest sample only go into the history.
+func (h *MetricsHandler) HandleBulkMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch []BulkMetric
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(batch) == 0 {
+		http.Error(w, "Batch is empty", http.StatusBadRequest)
+		return
+	}
+	if len(batch) > MaxBulkMetrics {
+		http.Error(w, fmt.Sprintf("Batch too large: %d entries (max %d)", len(batch), MaxBulkMetrics), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for i, entry := range batch {
+		if entry.AgentID == "" {
+			http.Error(w, fmt.Sprintf("Entry %d is missing agent_id", i), http.StatusBadRequest)
+			return
+		}
+	}
+	agentIDs, err := h.agentIDs(r, batch)
+	if err != nil {
+		var connectErr *connect.Error
+		if errors.As(err, &connectErr) {
+			http.Error(w, connectErr.Message(), connectStatus(connectErr.Code()))
+			return
+		}
+		http.Error(w, "Invalid agent_id: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := h.database.Now()
+	accepted := 0
+	for i, entry := range batch {
+		agentID := agentIDs[i]
+		m := entry.Metrics
+		ts := m.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+		if !h.metrics().SetAgentGauges(agentID, ts, m.RxPackets, m.TxPackets, m.RxBytes, m.TxBytes, m.DropCount, m.UptimeSeconds) {
+			logging.Warnf("Rejected implausible metrics from agent %s", agentID)
+			continue
+		}
+		if err := h.database.SaveMetrics(agentID, m, ts); err != nil {
+			http.Error(w, "Failed to record metrics", http.StatusInternalServerError)
+			return
+		}
+		accepted++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"accepted": accepted, "rejected": len(batch) - accepted})
+}
+
+// agentIDs returns the ID each entry's agent is stored under, after checking
+// it against the agent ID policy
+func (h *MetricsHandler) agentIDs(r *http.Request, batch []BulkMetric) ([]string, error) {
+	ids := make([]string, len(batch))
+	if h.agents == nil {
+		for i, entry := range batch {
+			ids[i] = entry.AgentID
+		}
+		return ids, nil
+	}
+
+	namespace, err := h.agents.agentNamespace(r.Context(), r.Header.Get(TenantHeader))
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range batch {
+		if err := h.agents.agentIDPolicy.Validate(entry.AgentID); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		ids[i] = entry.AgentID
+		if namespace != "" {
+			ids[i] = namespace + "/" + entry.AgentID
+		}
+	}
+	return ids, nil
+}
+
+// connectStatus maps the connect codes agentNamespace returns to HTTP statuses
+func connectStatus(code connect.Code) int {
+	switch code {
+	case connect.CodeInvalidArgument:
+		return http.StatusBadRequest
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
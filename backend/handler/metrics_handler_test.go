@@ -0,0 +1,172 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/metrics"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestHandleBulkMetrics(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewMetricsHandler(database)
+
+	body := `[
+		{"agent_id": "bulk-a", "metrics": {"rx_packets": 10, "tx_bytes": 2048, "drop_count": 1}},
+		{"agent_id": "bulk-b", "metrics": {"rx_packets": 20, "uptime_seconds": 300}}
+	]`
+	rec := httptest.NewRecorder()
+	h.HandleBulkMetrics(rec, httptest.NewRequest(http.MethodPost, "/api/metrics/bulk", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	checks := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"bulk-a rx_packets", testutil.ToFloat64(metrics.RxPackets.WithLabelValues("bulk-a")), 10},
+		{"bulk-a tx_bytes", testutil.ToFloat64(metrics.TxBytes.WithLabelValues("bulk-a")), 2048},
+		{"bulk-a drop_count", testutil.ToFloat64(metrics.DropCount.WithLabelValues("bulk-a")), 1},
+		{"bulk-b rx_packets", testutil.ToFloat64(metrics.RxPackets.WithLabelValues("bulk-b")), 20},
+		{"bulk-b uptime", testutil.ToFloat64(metrics.UptimeSeconds.WithLabelValues("bulk-b")), 300},
+		{"bulk-a heartbeats", testutil.ToFloat64(metrics.HeartbeatTotal.WithLabelValues("bulk-a")), 0},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	history, err := database.GetMetricsHistory("bulk-a", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetMetricsHistory failed: %v", err)
+	}
+	if len(history) != 1 || history[0].TxBytes != 2048 {
+		t.Errorf("Expected one history sample for bulk-a, got %+v", history)
+	}
+}
+
+func TestHandleBulkMetrics_RecordsIntoInjectedMetrics(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	// Two servers in one process, each with its own registry
+	first, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	second, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	h := handler.NewMetricsHandler(database)
+	h.SetMetrics(first)
+
+	body := `[{"agent_id": "bulk-injected", "metrics": {"rx_packets": 7}}]`
+	rec := httptest.NewRecorder()
+	h.HandleBulkMetrics(rec, httptest.NewRequest(http.MethodPost, "/api/metrics/bulk", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if got := testutil.ToFloat64(first.RxPackets.WithLabelValues("bulk-injected")); got != 7 {
+		t.Errorf("Injected rx_packets = %v, want 7", got)
+	}
+	if n := testutil.CollectAndCount(second.RxPackets); n != 0 {
+		t.Errorf("Expected no series in the other server's metrics, got %d", n)
+	}
+	if values, _ := metrics.Default().AgentGaugeValues("rx_packets"); values["bulk-injected"] != 0 {
+		t.Error("Expected nothing recorded into the default metrics")
+	}
+}
+
+func TestHandleBulkMetrics_AppliesAgentIdentity(t *testing.T) {
+	sentinel, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	policy, err := handler.ParseAgentIDPolicy("regex:bulk-[a-z]+")
+	if err != nil {
+		t.Fatalf("ParseAgentIDPolicy failed: %v", err)
+	}
+	sentinel.SetAgentIDPolicy(policy)
+	sentinel.SetAgentNamespacing(true)
+	h := handler.NewMetricsHandler(database)
+	h.SetAgentIdentity(sentinel)
+
+	key, _ := database.CreateAPIKey("collector")
+	if err := database.SetAPIKeyTenant(key, "tenant-a"); err != nil {
+		t.Fatalf("SetAPIKeyTenant failed: %v", err)
+	}
+	push := func(body, tenant string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/metrics/bulk", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.APIKeyContextKey, key))
+		if tenant != "" {
+			req.Header.Set(handler.TenantHeader, tenant)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleBulkMetrics(rec, req)
+		return rec
+	}
+
+	if rec := push(`[{"agent_id": "not_allowed", "metrics": {}}]`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an agent ID outside the policy, got %d", rec.Code)
+	}
+	if rec := push(`[{"agent_id": "bulk-ns", "metrics": {}}]`, "tenant-b"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a tenant not bound to the key, got %d", rec.Code)
+	}
+	if rec := push(`[{"agent_id": "bulk-ns", "metrics": {"rx_packets": 3}}]`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	t.Cleanup(func() { metrics.RemoveAgentMetrics("tenant-a/bulk-ns") })
+
+	history, err := database.GetMetricsHistory("tenant-a/bulk-ns", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetMetricsHistory failed: %v", err)
+	}
+	if len(history) != 1 {
+		t.Errorf("Expected the sample stored under the key's tenant, got %+v", history)
+	}
+	if history, _ := database.GetMetricsHistory("bulk-ns", time.Now().Add(-time.Hour), time.Now().Add(time.Hour)); len(history) != 0 {
+		t.Error("Expected nothing stored under the bare agent ID")
+	}
+}
+
+func TestHandleBulkMetrics_ValidatesBatch(t *testing.T) {
+	_, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	h := handler.NewMetricsHandler(database)
+
+	var oversized strings.Builder
+	oversized.WriteString("[")
+	for i := 0; i <= handler.MaxBulkMetrics; i++ {
+		if i > 0 {
+			oversized.WriteString(",")
+		}
+		fmt.Fprintf(&oversized, `{"agent_id": "agent-%d"}`, i)
+	}
+	oversized.WriteString("]")
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty", `[]`, http.StatusBadRequest},
+		{"not an array", `{"agent_id": "a"}`, http.StatusBadRequest},
+		{"missing agent_id", `[{"metrics": {"rx_packets": 1}}]`, http.StatusBadRequest},
+		{"too large", oversized.String(), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.HandleBulkMetrics(rec, httptest.NewRequest(http.MethodPost, "/api/metrics/bulk", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+	}
+}
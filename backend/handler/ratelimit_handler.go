@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+type RateLimitHandler struct {
+	limiter *middleware.RateLimiter
+}
+
+func NewRateLimitHandler(limiter *middleware.RateLimiter) *RateLimitHandler {
+	return &RateLimitHandler{limiter: limiter}
+}
+
+type RateLimitBucketResponse struct {
+	Key        string  `json:"key"`
+	Tokens     float64 `json:"tokens"`
+	Capacity   int     `json:"capacity"`
+	LastUpdate string  `json:"last_update"`
+}
+
+// HandleRateLimits lists the active rate-limit buckets on GET and resets the
+// bucket named by ?key= on DELETE
+func (h *RateLimitHandler) HandleRateLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		buckets := h.limiter.Buckets()
+		response := make([]RateLimitBucketResponse, 0, len(buckets))
+		for _, b := range buckets {
+			response = append(response, RateLimitBucketResponse{
+				Key:        b.Key,
+				Tokens:     b.Tokens,
+				Capacity:   b.Capacity,
+				LastUpdate: b.LastUpdate.UTC().Format(time.RFC3339),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		if h.limiter.Reset(key) == 0 {
+			http.Error(w, "Bucket not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,97 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestHandleRateLimits_InspectAndReset(t *testing.T) {
+	// One token a minute, so a drained bucket stays drained for the test
+	limiter := middleware.NewRateLimiter(1, 2)
+	limited := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/costs", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Authorization", "Bearer sk_supersecretvalue")
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < 3; i++ {
+		call()
+	}
+	if rec := call(); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected key to be throttled, got %d", rec.Code)
+	}
+
+	h := handler.NewRateLimitHandler(limiter)
+	rec := httptest.NewRecorder()
+	h.HandleRateLimits(rec, httptest.NewRequest(http.MethodGet, "/api/admin/ratelimits", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "supersecret") {
+		t.Errorf("Expected auth header to be masked, got %s", rec.Body.String())
+	}
+
+	var buckets []handler.RateLimitBucketResponse
+	if err := json.NewDecoder(rec.Body).Decode(&buckets); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %d", len(buckets))
+	}
+	b := buckets[0]
+	if b.Key != "10.0.0.1:Bearer sk_sup****" {
+		t.Errorf("Unexpected masked key %q", b.Key)
+	}
+	if b.Tokens >= 1 || b.Capacity != 2 || b.LastUpdate == "" {
+		t.Errorf("Expected a depleted bucket, got %+v", b)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleRateLimits(rec, httptest.NewRequest(http.MethodDelete, "/api/admin/ratelimits?key="+url.QueryEscape(b.Key), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 on reset, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The full burst is available again
+	for i := 0; i < 2; i++ {
+		if rec := call(); rec.Code != http.StatusOK {
+			t.Fatalf("Expected request %d after reset to pass, got %d", i+1, rec.Code)
+		}
+	}
+}
+
+func TestHandleRateLimits_ResetErrors(t *testing.T) {
+	h := handler.NewRateLimitHandler(middleware.NewRateLimiter(60, 5))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{"missing key", http.MethodDelete, "/api/admin/ratelimits", http.StatusBadRequest},
+		{"unknown key", http.MethodDelete, "/api/admin/ratelimits?key=nope", http.StatusNotFound},
+		{"bad method", http.MethodPost, "/api/admin/ratelimits", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.HandleRateLimits(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
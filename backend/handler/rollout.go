@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// RolloutBucket assigns an agent to one of 100 rollout buckets. It depends
+// only on the agent ID, so assignments survive restarts and an agent that
+// gets a canary at 5% keeps it at 20%.
+func RolloutBucket(agentID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(agentID))
+	return int(h.Sum32() % 100)
+}
+
+// SetRolloutPlan offers the canary version to the given percentage of
+// agents; the rest stay on the latest version. A percentage of 0 ends the
+// rollout.
+func (h *SentinelHandler) SetRolloutPlan(latest string, percentage int) error {
+	if percentage < 0 || percentage > 100 {
+		return fmt.Errorf("rollout percentage must be between 0 and 100, got %d", percentage)
+	}
+	if percentage == 0 {
+		h.canaryVersion, h.canaryPercent = "", 0
+		return nil
+	}
+	version, err := NormalizeVersion(latest)
+	if err != nil {
+		return err
+	}
+	h.canaryVersion, h.canaryPercent = version, percentage
+	return nil
+}
+
+// inCanary reports whether the agent is selected for the canary version
+func (h *SentinelHandler) inCanary(agentID string) bool {
+	return h.canaryVersion != "" && RolloutBucket(agentID) < h.canaryPercent
+}
@@ -0,0 +1,99 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/sennet/sennet/backend/handler"
+	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
+)
+
+func TestRolloutBucket_Distribution(t *testing.T) {
+	selected := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("agent-%04d", i)
+		bucket := handler.RolloutBucket(id)
+		if bucket != handler.RolloutBucket(id) {
+			t.Fatalf("Bucket for %s is not reproducible", id)
+		}
+		if bucket < 20 {
+			selected++
+		}
+	}
+	if selected < 150 || selected > 250 {
+		t.Errorf("Expected roughly 200 of 1000 agents at 20%%, got %d", selected)
+	}
+}
+
+func TestHeartbeat_RolloutPlan(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	targets := func() map[string]string {
+		got := make(map[string]string)
+		for i := 0; i < 100; i++ {
+			id := fmt.Sprintf("canary-%d", i)
+			resp, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+				AgentId:        id,
+				CurrentVersion: "1.0.0",
+			}))
+			if err != nil {
+				t.Fatalf("Heartbeat failed: %v", err)
+			}
+			wantCommand := sentinelv1.Command_COMMAND_NOOP
+			if resp.Msg.LatestVersion == "1.1.0" {
+				wantCommand = sentinelv1.Command_COMMAND_UPGRADE
+			}
+			if resp.Msg.Command != wantCommand {
+				t.Errorf("%s: target %s but command %v", id, resp.Msg.LatestVersion, resp.Msg.Command)
+			}
+			got[id] = resp.Msg.LatestVersion
+		}
+		return got
+	}
+
+	if err := h.SetRolloutPlan("1.1.0", 20); err != nil {
+		t.Fatalf("SetRolloutPlan failed: %v", err)
+	}
+	first := targets()
+	if again := targets(); fmt.Sprint(again) != fmt.Sprint(first) {
+		t.Error("Expected canary assignment to be reproducible")
+	}
+	for id, version := range first {
+		want := "1.0.0"
+		if handler.RolloutBucket(id) < 20 {
+			want = "1.1.0"
+		}
+		if version != want {
+			t.Errorf("%s (bucket %d): got %s, want %s", id, handler.RolloutBucket(id), version, want)
+		}
+	}
+
+	// Growing the rollout keeps every agent already on the canary
+	if err := h.SetRolloutPlan("1.1.0", 50); err != nil {
+		t.Fatalf("SetRolloutPlan failed: %v", err)
+	}
+	for id, version := range targets() {
+		if first[id] == "1.1.0" && version != "1.1.0" {
+			t.Errorf("%s dropped out of the canary when the rollout grew", id)
+		}
+	}
+
+	if err := h.SetRolloutPlan("", 0); err != nil {
+		t.Fatalf("Ending rollout failed: %v", err)
+	}
+	for id, version := range targets() {
+		if version != "1.0.0" {
+			t.Errorf("%s: expected stable version after the rollout ended, got %s", id, version)
+		}
+	}
+
+	if err := h.SetRolloutPlan("1.1.0", 101); err == nil {
+		t.Error("Expected percentage above 100 to be rejected")
+	}
+	if err := h.SetRolloutPlan("next", 10); err == nil {
+		t.Error("Expected invalid canary version to be rejected")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/sennet/sennet/backend/db"
 )
@@ -12,15 +13,36 @@ type StatsHandler struct {
 	database *db.DB
 	mu       sync.RWMutex
 	stats    *DashboardStats
+	agents   map[string]AgentCounters // Latest report per agent, see RecordAgent
 }
 
 func NewStatsHandler(database *db.DB) *StatsHandler {
 	return &StatsHandler{
 		database: database,
 		stats:    &DashboardStats{},
+		agents:   make(map[string]AgentCounters),
 	}
 }
 
+// AgentCounters are an agent's cumulative counters from its latest heartbeat
+type AgentCounters struct {
+	RxPackets     uint64 `json:"rx_packets"`
+	TxPackets     uint64 `json:"tx_packets"`
+	RxBytes       uint64 `json:"rx_bytes"`
+	TxBytes       uint64 `json:"tx_bytes"`
+	DropCount     uint64 `json:"drop_count"`
+	UptimeSeconds uint64 `json:"uptime_seconds"`
+}
+
+// AgentStats is one agent's latest counters alongside its inventory details.
+// Metrics is nil until the agent reports to this server instance.
+type AgentStats struct {
+	AgentID  string         `json:"agent_id"`
+	Version  string         `json:"version"`
+	LastSeen time.Time      `json:"last_seen"`
+	Metrics  *AgentCounters `json:"metrics"`
+}
+
 type DashboardStats struct {
 	ActiveAgents  int    `json:"active_agents"`
 	RxPackets     uint64 `json:"rx_packets"`
@@ -52,6 +74,41 @@ func (h *StatsHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+// HandleAgentStats returns one agent's latest counters, version and last
+// heartbeat time, selected by ?agent_id. Unknown agents get 404.
+func (h *StatsHandler) HandleAgentStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agentID := r.URL.Query().Get("agent_id")
+	if agentID == "" {
+		http.Error(w, "agent_id is required", http.StatusBadRequest)
+		return
+	}
+
+	agent, err := h.database.GetAgent(agentID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if agent == nil {
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+
+	stats := AgentStats{AgentID: agent.ID, Version: agent.Version, LastSeen: agent.LastSeen}
+	h.mu.RLock()
+	if counters, ok := h.agents[agentID]; ok {
+		stats.Metrics = &counters
+	}
+	h.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(stats)
+}
+
 func (h *StatsHandler) UpdateStats(rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -75,3 +132,48 @@ func (h *StatsHandler) SetStats(rxPkts, txPkts, rxBytes, txBytes, drops, uptime
 	h.stats.DropCount = drops
 	h.stats.UptimeSeconds = uptime
 }
+
+// RecordAgent folds an agent's latest cumulative counters into the fleet
+// totals. Agents report running totals, so only the change since the
+// agent's previous report is added; a restarted agent's reset counters
+// subtract what it had reported before.
+func (h *StatsHandler) RecordAgent(agentID string, rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev := h.agents[agentID]
+	h.agents[agentID] = AgentCounters{rxPkts, txPkts, rxBytes, txBytes, drops, uptime}
+
+	h.stats.RxPackets += rxPkts - prev.RxPackets
+	h.stats.TxPackets += txPkts - prev.TxPackets
+	h.stats.RxBytes += rxBytes - prev.RxBytes
+	h.stats.TxBytes += txBytes - prev.TxBytes
+	h.stats.DropCount += drops - prev.DropCount
+	if uptime > h.stats.UptimeSeconds {
+		h.stats.UptimeSeconds = uptime
+	}
+}
+
+// RemoveAgent takes an agent's latest counters back out of the fleet totals
+// and forgets it. It is registered with metrics.Metrics.OnAgentRemoved, so
+// agents leave the totals whenever their series are dropped.
+func (h *StatsHandler) RemoveAgent(agentID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prev, ok := h.agents[agentID]
+	if !ok {
+		return
+	}
+	delete(h.agents, agentID)
+
+	h.stats.RxPackets -= prev.RxPackets
+	h.stats.TxPackets -= prev.TxPackets
+	h.stats.RxBytes -= prev.RxBytes
+	h.stats.TxBytes -= prev.TxBytes
+	h.stats.DropCount -= prev.DropCount
+	if prev.UptimeSeconds == h.stats.UptimeSeconds {
+		h.stats.UptimeSeconds = 0
+		for _, c := range h.agents {
+			h.stats.UptimeSeconds = max(h.stats.UptimeSeconds, c.UptimeSeconds)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/metrics"
+	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
+)
+
+func heartbeatWithMetrics(t *testing.T, h *handler.SentinelHandler, agentID string, m *sentinelv1.MetricsSummary) {
+	t.Helper()
+	req := connect.NewRequest(&sentinelv1.HeartbeatRequest{AgentId: agentID, CurrentVersion: "1.0.0", Metrics: m})
+	if _, err := h.Heartbeat(context.Background(), req); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+}
+
+func TestHeartbeat_FeedsMetricsAndStats(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	stats := handler.NewStatsHandler(database)
+	h.SetMetrics(m)
+	h.SetStatsHandler(stats)
+
+	heartbeatWithMetrics(t, h, "stats-a", &sentinelv1.MetricsSummary{RxPackets: 100, TxPackets: 50, RxBytes: 1000, TxBytes: 500, DropCount: 1, UptimeSeconds: 60})
+	heartbeatWithMetrics(t, h, "stats-b", &sentinelv1.MetricsSummary{RxPackets: 10, TxPackets: 5, RxBytes: 100, TxBytes: 50, UptimeSeconds: 30})
+	// Counters are cumulative, so a second report replaces stats-a's first
+	heartbeatWithMetrics(t, h, "stats-a", &sentinelv1.MetricsSummary{RxPackets: 150, TxPackets: 60, RxBytes: 1500, TxBytes: 600, DropCount: 2, UptimeSeconds: 90})
+
+	if got := testutil.ToFloat64(m.RxPackets.WithLabelValues("stats-a")); got != 150 {
+		t.Errorf("Expected stats-a rx_packets gauge 150, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.HeartbeatTotal.WithLabelValues("stats-a")); got != 2 {
+		t.Errorf("Expected 2 heartbeats counted for stats-a, got %v", got)
+	}
+
+	var got handler.DashboardStats
+	if err := json.Unmarshal(getJSON(t, stats.HandleStats, "/api/stats"), &got); err != nil {
+		t.Fatalf("Invalid stats: %v", err)
+	}
+	if got.RxPackets != 160 || got.TxBytes != 650 || got.DropCount != 2 || got.UptimeSeconds != 90 {
+		t.Errorf("Expected fleet totals from each agent's latest report, got %+v", got)
+	}
+	if got.ActiveAgents != 2 {
+		t.Errorf("Expected 2 active agents, got %d", got.ActiveAgents)
+	}
+}
+
+func TestHandleAgentStats_PerAgent(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	stats := handler.NewStatsHandler(database)
+	h.SetMetrics(m)
+	h.SetStatsHandler(stats)
+
+	heartbeatWithMetrics(t, h, "noisy-host", &sentinelv1.MetricsSummary{RxPackets: 9000, DropCount: 400, UptimeSeconds: 60})
+	heartbeatWithMetrics(t, h, "quiet-host", &sentinelv1.MetricsSummary{RxPackets: 10, UptimeSeconds: 30})
+
+	for agentID, want := range map[string]handler.AgentCounters{
+		"noisy-host": {RxPackets: 9000, DropCount: 400, UptimeSeconds: 60},
+		"quiet-host": {RxPackets: 10, UptimeSeconds: 30},
+	} {
+		var got handler.AgentStats
+		if err := json.Unmarshal(getJSON(t, stats.HandleAgentStats, "/api/stats/agent?agent_id="+agentID), &got); err != nil {
+			t.Fatalf("Invalid agent stats: %v", err)
+		}
+		if got.AgentID != agentID || got.Version != "1.0.0" || got.LastSeen.IsZero() {
+			t.Errorf("%s: unexpected inventory details %+v", agentID, got)
+		}
+		if got.Metrics == nil || *got.Metrics != want {
+			t.Errorf("%s: expected counters %+v, got %+v", agentID, want, got.Metrics)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	stats.HandleAgentStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/agent?agent_id=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown agent, got %d", rec.Code)
+	}
+}
+
+func TestStatsHandler_RemovedAgentsLeaveTotals(t *testing.T) {
+	h, database, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	stats := handler.NewStatsHandler(database)
+	m.OnAgentRemoved(stats.RemoveAgent)
+	h.SetMetrics(m)
+	h.SetStatsHandler(stats)
+
+	heartbeatWithMetrics(t, h, "staying", &sentinelv1.MetricsSummary{RxPackets: 100, TxBytes: 500, UptimeSeconds: 60})
+	heartbeatWithMetrics(t, h, "leaving", &sentinelv1.MetricsSummary{RxPackets: 40, TxBytes: 200, DropCount: 3, UptimeSeconds: 600})
+	m.RemoveAgentMetrics("leaving")
+
+	var got handler.DashboardStats
+	if err := json.Unmarshal(getJSON(t, stats.HandleStats, "/api/stats"), &got); err != nil {
+		t.Fatalf("Invalid stats: %v", err)
+	}
+	if got.RxPackets != 100 || got.TxBytes != 500 || got.DropCount != 0 || got.UptimeSeconds != 60 {
+		t.Errorf("Expected only the remaining agent in the totals, got %+v", got)
+	}
+
+	var agent handler.AgentStats
+	if err := json.Unmarshal(getJSON(t, stats.HandleAgentStats, "/api/stats/agent?agent_id=leaving"), &agent); err != nil {
+		t.Fatalf("Invalid agent stats: %v", err)
+	}
+	if agent.Metrics != nil {
+		t.Errorf("Expected the removed agent's counters forgotten, got %+v", agent.Metrics)
+	}
+}
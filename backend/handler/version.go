@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// semverPattern matches MAJOR.MINOR.PATCH with optional pre-release and
+// build metadata, per semver 2.0.0
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// NormalizeVersion validates a semver string and strips a leading "v",
+// so "v1.2.3-rc1" becomes "1.2.3-rc1"
+func NormalizeVersion(version string) (string, error) {
+	v := strings.TrimSpace(version)
+	if v == "" {
+		return "", fmt.Errorf("version is required")
+	}
+	v = strings.TrimPrefix(v, "v")
+	if !semverPattern.MatchString(v) {
+		return "", fmt.Errorf("invalid version %q: expected semver like 1.2.3", version)
+	}
+	return v, nil
+}
@@ -0,0 +1,44 @@
+package handler_test
+
+import (
+	"testing"
+
+	"github.com/sennet/sennet/backend/handler"
+)
+
+func TestNormalizeVersion(t *testing.T) {
+	valid := map[string]string{
+		"1.2.3":            "1.2.3",
+		"v1.2.3-rc1":       "1.2.3-rc1",
+		"0.10.0":           "0.10.0",
+		"2.0.0-beta.2+abc": "2.0.0-beta.2+abc",
+	}
+	for in, want := range valid {
+		got, err := handler.NormalizeVersion(in)
+		if err != nil {
+			t.Errorf("NormalizeVersion(%q) returned error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("NormalizeVersion(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	for _, in := range []string{"", "1.0.x", "v1.0", "1.2", "01.2.3", "1.2.3-", "latest"} {
+		if _, err := handler.NormalizeVersion(in); err == nil {
+			t.Errorf("NormalizeVersion(%q) expected error", in)
+		}
+	}
+}
+
+func TestSetLatestVersion_RejectsInvalid(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "1.0.0")
+	defer cleanup()
+
+	if err := h.SetLatestVersion("1.0.x"); err == nil {
+		t.Error("Expected error for invalid version")
+	}
+	if err := h.SetLatestVersion("v2.0.0"); err != nil {
+		t.Errorf("Expected v2.0.0 to be accepted, got %v", err)
+	}
+}
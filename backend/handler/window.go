@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// UpgradeWindow is a daily UTC time range during which UPGRADE commands are
+// issued. A window whose end is before its start wraps past midnight.
+type UpgradeWindow struct {
+	Start time.Duration // Offset from midnight UTC
+	End   time.Duration
+}
+
+// ParseUpgradeWindow parses a window such as "02:00-04:00"
+func ParseUpgradeWindow(s string) (UpgradeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return UpgradeWindow{}, fmt.Errorf("invalid upgrade window %q: expected HH:MM-HH:MM", s)
+	}
+	start, err := parseClockTime(from)
+	if err != nil {
+		return UpgradeWindow{}, fmt.Errorf("invalid upgrade window %q: %w", s, err)
+	}
+	end, err := parseClockTime(to)
+	if err != nil {
+		return UpgradeWindow{}, fmt.Errorf("invalid upgrade window %q: %w", s, err)
+	}
+	if start == end {
+		return UpgradeWindow{}, fmt.Errorf("invalid upgrade window %q: start and end are equal", s)
+	}
+	return UpgradeWindow{Start: start, End: end}, nil
+}
+
+func parseClockTime(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// length returns how long the window stays open each day
+func (w UpgradeWindow) length() time.Duration {
+	if w.End > w.Start {
+		return w.End - w.Start
+	}
+	return 24*time.Hour - w.Start + w.End
+}
+
+// Allows reports whether agentID may be told to upgrade at t. Each agent
+// waits a stable pseudo-random offset into the first half of the window, so
+// a fleet spreads its upgrades out while every agent still gets at least
+// half the window to act.
+func (w UpgradeWindow) Allows(agentID string, t time.Time) bool {
+	t = t.UTC()
+	sinceMidnight := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	elapsed := sinceMidnight - w.Start
+	if elapsed < 0 {
+		elapsed += 24 * time.Hour
+	}
+	if elapsed >= w.length() {
+		return false
+	}
+	return elapsed >= w.agentOffset(agentID)
+}
+
+func (w UpgradeWindow) agentOffset(agentID string) time.Duration {
+	spread := w.length() / 2
+	if spread <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(agentID))
+	return time.Duration(h.Sum64() % uint64(spread))
+}
+
+func (w UpgradeWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End) + " UTC"
+}
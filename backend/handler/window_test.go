@@ -0,0 +1,85 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/handler"
+	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
+)
+
+func TestHeartbeat_UpgradeWindow(t *testing.T) {
+	h, _, cleanup := setupTestHandler(t, "2.0.0")
+	defer cleanup()
+
+	window, err := handler.ParseUpgradeWindow("02:00-04:00")
+	if err != nil {
+		t.Fatalf("ParseUpgradeWindow failed: %v", err)
+	}
+	h.SetUpgradeWindow(&window)
+
+	fake := clock.NewFake(time.Time{})
+	h.SetClock(fake)
+	commandAt := func(agentID string, at time.Time) sentinelv1.Command {
+		fake.Set(at)
+		resp, err := h.Heartbeat(context.Background(), connect.NewRequest(&sentinelv1.HeartbeatRequest{
+			AgentId:        agentID,
+			CurrentVersion: "1.0.0",
+		}))
+		if err != nil {
+			t.Fatalf("Heartbeat failed: %v", err)
+		}
+		return resp.Msg.Command
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	// Past the offset spread (first half of the window), every agent may upgrade
+	if got := commandAt("agent-1", day.Add(3*time.Hour+30*time.Minute)); got != sentinelv1.Command_COMMAND_UPGRADE {
+		t.Errorf("Expected UPGRADE inside the window, got %v", got)
+	}
+	for _, at := range []time.Time{day.Add(time.Hour), day.Add(4 * time.Hour), day.Add(12 * time.Hour)} {
+		if got := commandAt("agent-1", at); got != sentinelv1.Command_COMMAND_NOOP {
+			t.Errorf("Expected NOOP at %s, outside the window, got %v", at.Format("15:04"), got)
+		}
+	}
+
+	// Offsets spread agents across the start of the window
+	early := 0
+	for i := 0; i < 50; i++ {
+		if commandAt(fmt.Sprintf("agent-%d", i), day.Add(2*time.Hour+5*time.Minute)) == sentinelv1.Command_COMMAND_UPGRADE {
+			early++
+		}
+	}
+	if early == 0 || early == 50 {
+		t.Errorf("Expected some but not all agents to upgrade 5 minutes in, got %d of 50", early)
+	}
+
+	h.SetUpgradeWindow(nil)
+	if got := commandAt("agent-1", day.Add(12*time.Hour)); got != sentinelv1.Command_COMMAND_UPGRADE {
+		t.Errorf("Expected UPGRADE with no window, got %v", got)
+	}
+}
+
+func TestUpgradeWindow_WrapsMidnight(t *testing.T) {
+	window, err := handler.ParseUpgradeWindow("23:00-01:00")
+	if err != nil {
+		t.Fatalf("ParseUpgradeWindow failed: %v", err)
+	}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if !window.Allows("agent", day.Add(30*time.Minute)) {
+		t.Error("Expected 00:30 to be inside 23:00-01:00")
+	}
+	if window.Allows("agent", day.Add(2*time.Hour)) {
+		t.Error("Expected 02:00 to be outside 23:00-01:00")
+	}
+
+	for _, bad := range []string{"", "02:00", "2am-4am", "02:00-25:00", "03:00-03:00"} {
+		if _, err := handler.ParseUpgradeWindow(bad); err == nil {
+			t.Errorf("ParseUpgradeWindow(%q) expected error", bad)
+		}
+	}
+}
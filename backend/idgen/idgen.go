@@ -0,0 +1,127 @@
+// Package idgen generates request and record identifiers with a selectable
+// strategy, so busy deployments can trade the short default for IDs that
+// don't collide
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sennet/sennet/backend/clock"
+)
+
+// Strategy names accepted by New
+const (
+	StrategyShort = "short" // First 8 hex digits of a UUID; the historical request ID
+	StrategyUUID  = "uuid"  // Random (v4) UUID
+	StrategyULID  = "ulid"  // Lexicographically sortable ULID
+)
+
+// Strategies lists the accepted strategy names
+var Strategies = []string{StrategyShort, StrategyUUID, StrategyULID}
+
+// Generator produces identifiers. Implementations are safe for concurrent use.
+type Generator interface {
+	NewID() string
+}
+
+// New returns the generator for a strategy name
+func New(strategy string) (Generator, error) {
+	switch strategy {
+	case StrategyShort:
+		return Short{}, nil
+	case StrategyUUID:
+		return UUID{}, nil
+	case StrategyULID:
+		return NewULID(clock.Real), nil
+	}
+	return nil, fmt.Errorf("unknown ID strategy %q (want short, uuid or ulid)", strategy)
+}
+
+// Short generates 8 hex character IDs. With 32 random bits, collisions
+// become likely after tens of thousands of IDs.
+type Short struct{}
+
+func (Short) NewID() string { return uuid.New().String()[:8] }
+
+// UUID generates random UUIDs
+type UUID struct{}
+
+func (UUID) NewID() string { return uuid.New().String() }
+
+// crockford is the ULID base32 alphabet, which omits I, L, O and U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates 26 character ULIDs: a 48-bit millisecond timestamp followed
+// by 80 random bits. IDs from one generator are strictly increasing; within
+// a millisecond the random part is incremented instead of redrawn.
+type ULID struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	lastMS  uint64
+	entropy [10]byte
+}
+
+// NewULID returns a ULID generator that timestamps IDs with c
+func NewULID(c clock.Clock) *ULID {
+	return &ULID{clock: c}
+}
+
+func (g *ULID) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.clock.Now().UnixMilli())
+	if ms < g.lastMS {
+		ms = g.lastMS // The clock stepped back; don't let IDs go with it
+	}
+	if ms > g.lastMS || !incrementEntropy(&g.entropy) {
+		// A new millisecond, or the random part overflowed and the
+		// timestamp moves on to stay increasing
+		if ms == g.lastMS {
+			ms++
+		}
+		if _, err := rand.Read(g.entropy[:]); err != nil {
+			panic(fmt.Sprintf("idgen: reading random bytes: %v", err))
+		}
+	}
+	g.lastMS = ms
+
+	var raw [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(raw[:6], ts[2:])
+	copy(raw[6:], g.entropy[:])
+	return encodeBase32(raw)
+}
+
+// incrementEntropy adds one to the big-endian random part, reporting false
+// if it wrapped around
+func incrementEntropy(e *[10]byte) bool {
+	for i := len(e) - 1; i >= 0; i-- {
+		e[i]++
+		if e[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBase32 renders 128 bits as 26 Crockford base32 digits, most
+// significant first (the top two bits of the first digit are always zero)
+func encodeBase32(raw [16]byte) string {
+	hi := binary.BigEndian.Uint64(raw[:8])
+	lo := binary.BigEndian.Uint64(raw[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
@@ -0,0 +1,81 @@
+package idgen_test
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/idgen"
+)
+
+var ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+func TestULID_SortableAndUnique(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	g := idgen.NewULID(fake)
+
+	// Many IDs per millisecond, across millisecond boundaries, and across a
+	// clock step backwards must all stay increasing
+	var ids []string
+	for i := 0; i < 5000; i++ {
+		if i%1000 == 999 {
+			fake.Advance(time.Millisecond)
+		}
+		if i == 3500 {
+			fake.Advance(-time.Second)
+		}
+		ids = append(ids, g.NewID())
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		if !ulidPattern.MatchString(id) {
+			t.Fatalf("ID %q is not a ULID", id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate ID %q", id)
+		}
+		seen[id] = true
+		if i > 0 && id <= ids[i-1] {
+			t.Fatalf("ID %d (%s) does not sort after %s", i, id, ids[i-1])
+		}
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Error("Expected IDs in generation order to be sorted")
+	}
+}
+
+func TestULID_EncodesTimestamp(t *testing.T) {
+	earlier := idgen.NewULID(clock.NewFake(time.UnixMilli(1_700_000_000_000))).NewID()
+	later := idgen.NewULID(clock.NewFake(time.UnixMilli(1_700_000_000_001))).NewID()
+	if earlier[:10] >= later[:10] {
+		t.Errorf("Expected timestamp prefix %s to sort before %s", earlier[:10], later[:10])
+	}
+	// Unix epoch milliseconds 1700000000000 in Crockford base32
+	if earlier[:10] != "01HF7YAT00" {
+		t.Errorf("Expected timestamp prefix 01HF7YAT00, got %s", earlier[:10])
+	}
+}
+
+func TestShort_PreservesFormat(t *testing.T) {
+	g, err := idgen.New(idgen.StrategyShort)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if id := g.NewID(); !regexp.MustCompile(`^[0-9a-f]{8}$`).MatchString(id) {
+		t.Errorf("Expected 8 hex characters, got %q", id)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, s := range idgen.Strategies {
+		if _, err := idgen.New(s); err != nil {
+			t.Errorf("New(%q) failed: %v", s, err)
+		}
+	}
+	if _, err := idgen.New("snowflake"); err == nil {
+		t.Error("Expected an unknown strategy to be rejected")
+	}
+}
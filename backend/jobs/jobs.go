@@ -0,0 +1,134 @@
+// Package jobs tracks the health of the server's background workers
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// Status is the last known state of a background job
+type Status struct {
+	Name         string
+	Interval     time.Duration
+	Runs         int64
+	LastRun      *time.Time // nil until the job has run once
+	LastDuration time.Duration
+	LastError    string
+}
+
+// Registry records when each registered job last ran and whether it failed.
+// It also implements prometheus.Collector, exporting the time since each
+// job last ran so stalled workers can be alerted on.
+type Registry struct {
+	mu   sync.RWMutex
+	jobs map[string]*Status
+	now  func() time.Time
+	wg   sync.WaitGroup
+}
+
+var sinceLastRunDesc = prometheus.NewDesc(
+	"sennet_job_seconds_since_last_run",
+	"Seconds since the background job last ran",
+	[]string{"job"}, nil,
+)
+
+// NewRegistry creates an empty job registry
+func NewRegistry() *Registry {
+	return &Registry{
+		jobs: make(map[string]*Status),
+		now:  time.Now,
+	}
+}
+
+// Register adds a job so it is reported even before its first run
+func (r *Registry) Register(name string, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.jobs[name]; !ok {
+		r.jobs[name] = &Status{Name: name, Interval: interval}
+	}
+}
+
+// Run executes fn as one run of the named job and records its outcome
+func (r *Registry) Run(name string, fn func() error) error {
+	start := r.now()
+	err := fn()
+	duration := r.now().Sub(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.jobs[name]
+	if !ok {
+		status = &Status{Name: name}
+		r.jobs[name] = status
+	}
+	status.Runs++
+	status.LastRun = &start
+	status.LastDuration = duration
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	return err
+}
+
+// Every registers the job and runs it on the given interval until ctx is
+// cancelled. Errors are logged and recorded but don't stop the loop.
+func (r *Registry) Every(ctx context.Context, name string, interval time.Duration, fn func() error) {
+	r.Register(name, interval)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Run(name, fn); err != nil {
+					logging.Errorf("Job %s failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// Wait blocks until every loop started by Every has returned. Cancel their
+// context first; a run already in progress is allowed to finish.
+func (r *Registry) Wait() {
+	r.wg.Wait()
+}
+
+// Statuses returns a snapshot of every registered job, sorted by name
+func (r *Registry) Statuses() []Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	statuses := make([]Status, 0, len(r.jobs))
+	for _, s := range r.jobs {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Describe implements prometheus.Collector
+func (r *Registry) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sinceLastRunDesc
+}
+
+// Collect implements prometheus.Collector. Jobs that have never run are omitted.
+func (r *Registry) Collect(ch chan<- prometheus.Metric) {
+	now := r.now()
+	for _, s := range r.Statuses() {
+		if s.LastRun == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(sinceLastRunDesc, prometheus.GaugeValue, now.Sub(*s.LastRun).Seconds(), s.Name)
+	}
+}
@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegistry_RecordsRuns(t *testing.T) {
+	r := NewRegistry()
+	r.Register("retention", time.Hour)
+
+	statuses := r.Statuses()
+	if len(statuses) != 1 || statuses[0].LastRun != nil {
+		t.Fatalf("Expected one job that hasn't run yet, got %+v", statuses)
+	}
+
+	if err := r.Run("retention", func() error { return nil }); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	s := r.Statuses()[0]
+	if s.Runs != 1 || s.LastRun == nil || s.LastError != "" {
+		t.Errorf("Expected a successful run to be recorded, got %+v", s)
+	}
+}
+
+func TestRegistry_RecordsFailure(t *testing.T) {
+	r := NewRegistry()
+	r.Register("cost-sync", time.Hour)
+
+	err := r.Run("cost-sync", func() error { return errors.New("provider throttled") })
+	if err == nil {
+		t.Fatal("Expected Run to return the job's error")
+	}
+	if s := r.Statuses()[0]; s.LastError != "provider throttled" {
+		t.Errorf("Expected last error to be recorded, got %q", s.LastError)
+	}
+
+	// A later success clears the error
+	r.Run("cost-sync", func() error { return nil })
+	if s := r.Statuses()[0]; s.LastError != "" || s.Runs != 2 {
+		t.Errorf("Expected error cleared after success, got %+v", s)
+	}
+}
+
+func TestRegistry_SinceLastRunMetric(t *testing.T) {
+	r := NewRegistry()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return base }
+	r.Register("never-run", time.Minute)
+	r.Run("metrics", func() error { return nil })
+
+	r.now = func() time.Time { return base.Add(90 * time.Second) }
+
+	expected := `
+# HELP sennet_job_seconds_since_last_run Seconds since the background job last ran
+# TYPE sennet_job_seconds_since_last_run gauge
+sennet_job_seconds_since_last_run{job="metrics"} 90
+`
+	if err := testutil.CollectAndCompare(r, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegistry_WaitForLoops(t *testing.T) {
+	r := NewRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.Every(ctx, "agent-prune", time.Millisecond, func() error { return nil })
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		r.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,146 @@
+// Package logging provides the leveled logger used across the server
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log severity. Messages below the logger's level are dropped.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int32(l))
+}
+
+// ParseLevel parses debug, info, warn or error (case-insensitive)
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+}
+
+// Logger writes leveled, timestamped lines to an output
+type Logger struct {
+	level atomic.Int32
+	out   *log.Logger
+}
+
+// New creates a logger writing messages at or above level to w
+func New(w io.Writer, level Level) *Logger {
+	l := &Logger{out: log.New(w, "", log.LstdFlags)}
+	l.SetLevel(level)
+	return l
+}
+
+// SetLevel changes the minimum level written
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(int32(level))
+}
+
+// Enabled reports whether messages at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(l.level.Load())
+}
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.out.Output(3, level.String()+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(LevelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(LevelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(LevelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(LevelError, format, args...) }
+
+// Fatalf logs at error level regardless of the configured level, then exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.out.Output(2, LevelError.String()+" "+fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// StdLogger adapts the logger for APIs that take a *log.Logger, writing
+// each line at the given level
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(levelWriter{l: l, level: level}, "", 0)
+}
+
+type levelWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	w.l.logf(w.level, "%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Open returns the writer for a -log-output value: "stderr", "stdout", or a
+// file path opened for appending. Close it on shutdown; closing stderr or
+// stdout is a no-op.
+func Open(output string) (io.WriteCloser, error) {
+	switch output {
+	case "", "stderr":
+		return nopCloser{os.Stderr}, nil
+	case "stdout":
+		return nopCloser{os.Stdout}, nil
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log output: %w", err)
+	}
+	return f, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+var std atomic.Pointer[Logger]
+
+func init() {
+	std.Store(New(os.Stderr, LevelInfo))
+}
+
+// Default returns the process-wide logger used by the package-level functions
+func Default() *Logger { return std.Load() }
+
+// SetDefault replaces the process-wide logger
+func SetDefault(l *Logger) { std.Store(l) }
+
+func Debugf(format string, args ...interface{}) { Default().logf(LevelDebug, format, args...) }
+func Infof(format string, args ...interface{})  { Default().logf(LevelInfo, format, args...) }
+func Warnf(format string, args ...interface{})  { Default().logf(LevelWarn, format, args...) }
+func Errorf(format string, args ...interface{}) { Default().logf(LevelError, format, args...) }
+func Fatalf(format string, args ...interface{}) { Default().Fatalf(format, args...) }
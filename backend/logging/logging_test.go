@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogger_SuppressesBelowLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo)
+
+	l.Debugf("debug %d", 1)
+	if buf.Len() != 0 {
+		t.Errorf("Expected debug to be suppressed at info level, got %q", buf.String())
+	}
+
+	l.Infof("info %d", 2)
+	l.Errorf("error %d", 3)
+	out := buf.String()
+	if !strings.Contains(out, "INFO info 2") || !strings.Contains(out, "ERROR error 3") {
+		t.Errorf("Expected info and error lines, got %q", out)
+	}
+
+	buf.Reset()
+	l.SetLevel(LevelDebug)
+	l.Debugf("now visible")
+	if !strings.Contains(buf.String(), "DEBUG now visible") {
+		t.Errorf("Expected debug after lowering level, got %q", buf.String())
+	}
+}
+
+func TestLogger_StdLoggerUsesLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelWarn)
+
+	l.StdLogger(LevelInfo).Printf("request log")
+	if buf.Len() != 0 {
+		t.Errorf("Expected info std logger to be suppressed at warn, got %q", buf.String())
+	}
+	l.StdLogger(LevelError).Printf("bad thing")
+	if !strings.Contains(buf.String(), "ERROR bad thing\n") {
+		t.Errorf("Expected a single error line, got %q", buf.String())
+	}
+}
+
+func TestOpen_WritesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	New(w, LevelInfo).Warnf("disk %s", "full")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(data), "WARN disk full") {
+		t.Errorf("Expected log line in file, got %q", data)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]Level{"debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarn, "error": LevelError} {
+		if got, err := ParseLevel(in); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
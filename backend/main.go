@@ -6,22 +6,33 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/sennet/sennet/backend/auth"
 	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/correlation"
 	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/fleet"
 	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/idgen"
+	"github.com/sennet/sennet/backend/jobs"
+	"github.com/sennet/sennet/backend/logging"
 	"github.com/sennet/sennet/backend/metrics"
 	"github.com/sennet/sennet/backend/middleware"
+	"github.com/sennet/sennet/backend/tlsutil"
 
 	"github.com/sennet/sennet/gen/go/sentinel/v1/sentinelv1connect"
 )
@@ -35,133 +46,508 @@ const (
 func main() {
 	// CLI flags
 	port := flag.String("port", defaultPort, "Server port")
-	dbPath := flag.String("db", defaultDBPath, "SQLite database path")
+	dbPath := flag.String("db", defaultDBPath, "SQLite database path, or the connection string with -db-driver postgres")
+	dbDriver := flag.String("db-driver", db.DriverSQLite, "Database driver: "+strings.Join(db.Drivers, " or "))
 	latestVersion := flag.String("version", defaultVersion, "Latest agent version to advertise")
+	versionFile := flag.String("version-file", "", "File holding the latest agent version, overriding -version and re-read on SIGHUP (empty disables)")
+	upgradeWindow := flag.String("upgrade-window", "", "Only issue UPGRADE commands during this daily UTC window, e.g. 02:00-04:00 (empty allows any time)")
+	canaryVersion := flag.String("canary-version", "", "Agent version to offer to the canary share of the fleet")
+	canaryPercent := flag.Int("canary-percent", 0, "Percentage of agents (0-100) that get -canary-version")
+	maxVersion := flag.String("max-version", "", "Pin the newest agent version; newer agents are told to downgrade (empty disables)")
+	dbRetries := flag.Int("db-write-retries", db.DefaultRetryPolicy().MaxRetries, "Retries for database writes that hit SQLITE_BUSY")
+	dbRetryDelay := flag.Duration("db-retry-delay", db.DefaultRetryPolicy().BaseDelay, "Initial backoff between database write retries")
+	namespaceAgents := flag.Bool("namespace-agents", false, "Scope agent IDs to the reporting tenant/API key")
+	tlsMinVersion := flag.String("tls-min-version", "1.2", "Minimum TLS version to accept (1.2 or 1.3)")
+	tlsCipherPolicy := flag.String("tls-cipher-policy", tlsutil.PolicyIntermediate, "TLS cipher policy (modern or intermediate)")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file; with -tls-key, serves HTTPS and reloads the pair when it changes")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	requiredHeaders := flag.String("require-headers", "", "Comma-separated request headers every non-health request must send (e.g. X-Sennet-Agent-Version)")
+	savingsBaseline := flag.String("savings-baseline", "", "Comma-separated provider=discount savings plan rates (e.g. aws=0.28) for realized savings")
+	costCacheTTL := flag.Duration("cost-cache-ttl", correlation.DefaultCostCacheTTL, "How long fetched provider costs are reused by later syncs (0 disables)")
+	syncConcurrency := flag.Int("sync-concurrency", correlation.DefaultSyncConcurrency, "How many cloud providers a cost sync fetches at once")
+	costStaleAfter := flag.Duration("cost-stale-after", correlation.DefaultStaleAfter, "Age after which synced cost data is reported stale")
+	quarantineAfter := flag.Duration("quarantine-after", 0, "Quarantine agents not seen for this long (0 disables)")
+	quarantineWebhook := flag.String("quarantine-webhook", "", "URL notified with a JSON POST when agents are quarantined")
+	pruneInterval := flag.Duration("prune-interval", 0, "How often to delete agents older than -prune-age (0 disables)")
+	cacheMaxAge := flag.Duration("cache-max-age", middleware.DefaultCacheMaxAge, "How long clients may cache low-churn responses such as /version (0 forces revalidation)")
+	metricCeiling := flag.Uint64("metric-ceiling", metrics.DefaultMetricCeiling, "Largest counter value accepted from an agent; larger reports count as suspicious")
+	pruneAge := flag.Duration("prune-age", 30*24*time.Hour, "Age after which the prune sweeper deletes an agent")
+	csrf := flag.Bool("csrf", false, "Require a double-submit CSRF token on browser-originated mutating admin requests")
+	requireSignatures := flag.Bool("require-signatures", false, "Require HMAC request signatures on the routes in -signed-routes")
+	requireNonce := flag.Bool("require-nonce", false, "Reject signed requests without an X-Sennet-Nonce, so none can be replayed")
+	requestTimeout := flag.Duration("request-timeout", middleware.DefaultRequestTimeout, "Answer 503 and cancel the request context when a handler hasn't responded within this long (0 disables)")
+	maxBody := flag.Int64("max-body", middleware.DefaultMaxBodyBytes, "Largest request body in bytes accepted on any route")
+	maxSignedBody := flag.Int64("max-signed-body", middleware.DefaultMaxSignedBodyBytes, "Largest request body in bytes buffered for signature verification")
+	signedRoutes := flag.String("signed-routes", strings.Join(middleware.DefaultSignedRoutes, ","), "Comma-separated paths whose mutating requests must be signed")
+	agentIDPolicy := flag.String("agent-id-policy", handler.AgentIDPolicyNone, "Agent ID format to accept: none, uuid, hostname or regex:<pattern>")
+	idStrategy := flag.String("id-strategy", idgen.StrategyShort, "How request IDs are generated: "+strings.Join(idgen.Strategies, ", "))
+	keyIDStrategy := flag.String("key-id-strategy", "", "Generate new API key IDs with this strategy instead of deriving them from the key hash: "+strings.Join(idgen.Strategies, ", "))
+	agentOnlineWindow := flag.Duration("agent-online-window", handler.DefaultAgentOnlineWindow, "How recently an agent must have reported to be listed as online")
+	eventBuffer := flag.Int("event-buffer", 0, "Queue up to this many heartbeats' agent events for a background writer instead of saving them inline (0 disables)")
+	auditFormat := flag.String("audit-format", "text", "Audit log format on the standard logger: text, or json for one JSON object per line on stdout")
+	auditFile := flag.String("audit-file", "", "Write audit entries as JSON lines to this file instead, rotating it at -audit-max-size-mb")
+	auditMaxSizeMB := flag.Int("audit-max-size-mb", 100, "Size in megabytes at which -audit-file is rotated")
+	rateLimitRedis := flag.String("rate-limit-redis", "", "Share rate-limit buckets between replicas through the Redis server at this redis:// or rediss:// URL (or host:port), falling back to in-memory buckets while it is unreachable")
+	auditDB := flag.Bool("audit-db", false, "Also store audit entries in the database, queryable at /api/admin/audit-logs")
+	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn or error)")
+	logOutput := flag.String("log-output", "stderr", "Log destination: stderr, stdout or a file path")
 
 	// Subcommands
 	keygenCmd := flag.NewFlagSet("keygen", flag.ExitOnError)
 	keygenName := keygenCmd.String("name", "", "Name/description for the API key")
+	keygenTTL := keygenCmd.Duration("ttl", 0, "Lifetime of the API key, e.g. 720h (0 means it never expires)")
+	keygenScopes := keygenCmd.String("scopes", db.ScopeAll, "Comma-separated scopes granted to the API key: "+strings.Join(middleware.KnownScopes, ", "))
 
 	flag.Parse()
 
+	logOut, err := setupLogging(*logLevel, *logOutput)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer logOut.Close()
+
 	// Handle subcommands
 	if len(os.Args) >= 2 {
 		switch os.Args[1] {
 		case "keygen":
 			keygenCmd.Parse(os.Args[2:])
-			runKeygen(*dbPath, *keygenName)
+			runKeygen(*dbDriver, *dbPath, *keygenName, *keygenTTL, splitList(*keygenScopes))
 			return
 		}
 	}
 
 	// Run server
-	runServer(*port, *dbPath, *latestVersion)
+	runServer(serverConfig{
+		port:          *port,
+		dbPath:        *dbPath,
+		dbDriver:      *dbDriver,
+		latestVersion: *latestVersion,
+		versionFile:   *versionFile,
+		maxVersion:    *maxVersion,
+		upgradeWindow: *upgradeWindow,
+		canaryVersion: *canaryVersion,
+		canaryPercent: *canaryPercent,
+		dbRetry: db.RetryPolicy{
+			MaxRetries: *dbRetries,
+			BaseDelay:  *dbRetryDelay,
+		},
+		namespaceAgents:   *namespaceAgents,
+		tlsMinVersion:     *tlsMinVersion,
+		tlsCipherPolicy:   *tlsCipherPolicy,
+		tlsCert:           *tlsCert,
+		tlsKey:            *tlsKey,
+		costStaleAfter:    *costStaleAfter,
+		syncConcurrency:   *syncConcurrency,
+		costCacheTTL:      *costCacheTTL,
+		savingsBaseline:   *savingsBaseline,
+		requiredHeaders:   splitList(*requiredHeaders),
+		quarantineAfter:   *quarantineAfter,
+		quarantineWebhook: *quarantineWebhook,
+		pruneInterval:     *pruneInterval,
+		pruneAge:          *pruneAge,
+		metricCeiling:     *metricCeiling,
+		cacheMaxAge:       *cacheMaxAge,
+		signedRoutes:      signedRouteList(*requireSignatures, *signedRoutes),
+		maxSignedBody:     *maxSignedBody,
+		requireNonce:      *requireNonce,
+		maxBody:           *maxBody,
+		requestTimeout:    *requestTimeout,
+		csrf:              *csrf,
+		agentIDPolicy:     *agentIDPolicy,
+		idStrategy:        *idStrategy,
+		keyIDStrategy:     *keyIDStrategy,
+		eventBuffer:       *eventBuffer,
+		agentOnlineWindow: *agentOnlineWindow,
+		auditFormat:       *auditFormat,
+		auditFile:         *auditFile,
+		auditMaxSizeMB:    *auditMaxSizeMB,
+		auditDB:           *auditDB,
+		rateLimitRedis:    *rateLimitRedis,
+	})
 }
 
-func runKeygen(dbPath, name string) {
-	if name == "" {
-		name = "unnamed-key"
+// serverConfig holds the options parsed from the command line for runServer
+type serverConfig struct {
+	port          string
+	dbPath        string
+	dbDriver      string
+	latestVersion string
+	versionFile   string
+	maxVersion    string
+	upgradeWindow string
+	canaryVersion string
+	canaryPercent int
+	dbRetry       db.RetryPolicy
+
+	namespaceAgents bool
+
+	tlsMinVersion   string
+	tlsCipherPolicy string
+	tlsCert         string
+	tlsKey          string
+
+	costStaleAfter  time.Duration
+	syncConcurrency int
+	costCacheTTL    time.Duration
+	savingsBaseline string
+
+	requiredHeaders []string
+
+	quarantineAfter   time.Duration
+	quarantineWebhook string
+
+	pruneInterval time.Duration
+	pruneAge      time.Duration
+
+	metricCeiling uint64
+
+	cacheMaxAge time.Duration
+
+	signedRoutes   []string // Nil when signatures are optional everywhere
+	maxSignedBody  int64
+	requireNonce   bool
+	maxBody        int64
+	requestTimeout time.Duration // 0 lets handlers run until the write timeout
+	csrf           bool
+
+	agentIDPolicy string
+
+	idStrategy    string
+	keyIDStrategy string // Empty keeps hash-derived key IDs
+
+	eventBuffer int // 0 saves agent events inline
+
+	agentOnlineWindow time.Duration
+
+	auditFormat    string
+	auditFile      string // Empty logs audit entries per auditFormat
+	auditMaxSizeMB int
+	auditDB        bool // Also store entries in audit_logs, written asynchronously
+
+	rateLimitRedis string // Empty keeps rate-limit buckets in memory
+}
+
+func runKeygen(dbDriver, dbPath, name string, ttl time.Duration, scopes []string) {
+	if err := middleware.ValidateScopes(scopes); err != nil {
+		logging.Fatalf("Invalid -scopes: %v", err)
 	}
 
-	database, err := db.New(dbPath)
+	database, err := db.NewWithDriver(dbDriver, dbPath)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		logging.Fatalf("Failed to open database: %v", err)
 	}
 	defer database.Close()
 
-	key, err := database.CreateAPIKey(name)
+	if err := keygen(os.Stdout, database, name, ttl, scopes); err != nil {
+		logging.Fatalf("Failed to create API key: %v", err)
+	}
+}
+
+// keygen creates an API key and prints it, with its scopes, to out
+func keygen(out io.Writer, database *db.DB, name string, ttl time.Duration, scopes []string) error {
+	if name == "" {
+		name = "unnamed-key"
+	}
+	if len(scopes) == 0 {
+		scopes = []string{db.ScopeAll}
+	}
+
+	key, err := database.CreateScopedAPIKey(name, ttl, scopes)
 	if err != nil {
-		log.Fatalf("Failed to create API key: %v", err)
+		return err
 	}
 
-	fmt.Printf("Created API key: %s\n", key)
-	fmt.Printf("Name: %s\n", name)
-	fmt.Println("\nAdd this to your agent config:")
-	fmt.Printf("  api_key: %s\n", key)
+	fmt.Fprintf(out, "Created API key: %s\n", key)
+	fmt.Fprintf(out, "Name: %s\n", name)
+	fmt.Fprintf(out, "Scopes: %s\n", strings.Join(scopes, ","))
+	if ttl > 0 {
+		fmt.Fprintf(out, "Expires: %s\n", time.Now().Add(ttl).UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintln(out, "\nAdd this to your agent config:")
+	fmt.Fprintf(out, "  api_key: %s\n", key)
+	return nil
 }
 
-func runServer(port, dbPath, latestVersion string) {
-	log.Printf("Sennet Control Plane starting...")
-	log.Printf("  Port: %s", port)
-	log.Printf("  Database: %s", dbPath)
-	log.Printf("  Latest Version: %s", latestVersion)
+func runServer(cfg serverConfig) {
+	port, dbPath, latestVersion := cfg.port, cfg.dbPath, cfg.latestVersion
+
+	if cfg.versionFile != "" {
+		version, err := readVersionFile(cfg.versionFile)
+		if err != nil {
+			logging.Fatalf("Invalid -version-file: %v", err)
+		}
+		latestVersion = version
+	}
+	latestVersion, err := handler.NormalizeVersion(latestVersion)
+	if err != nil {
+		logging.Fatalf("Invalid -version: %v", err)
+	}
 
-	// Initialize Prometheus metrics
-	metrics.Init()
-	log.Printf("  Prometheus metrics: enabled")
+	logging.Infof("Sennet Control Plane starting...")
+	logging.Infof("  Port: %s", port)
+	if cfg.dbDriver == db.DriverSQLite {
+		logging.Infof("  Database: %s", dbPath)
+	} else {
+		logging.Infof("  Database: %s", cfg.dbDriver) // The DSN may carry a password
+	}
+	logging.Infof("  Latest Version: %s", latestVersion)
+
+	// Validate TLS policy up front so a bad combination fails fast
+	tlsConfig, err := tlsutil.NewConfig(cfg.tlsMinVersion, cfg.tlsCipherPolicy)
+	if err != nil {
+		logging.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	logging.Infof("  TLS policy: min %s, %s ciphers", cfg.tlsMinVersion, cfg.tlsCipherPolicy)
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		logging.Fatalf("Invalid -tls-cert/-tls-key: both are required to serve HTTPS")
+	}
+	scheme := "http"
+	if cfg.tlsCert != "" {
+		reloader, err := tlsutil.NewCertReloader(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			logging.Fatalf("Invalid -tls-cert/-tls-key: %v", err)
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+		scheme = "https"
+		logging.Infof("  TLS: serving %s (reloaded on change)", cfg.tlsCert)
+	}
+
+	// Initialize Prometheus metrics on a registry owned by this server; each
+	// handler below records into serverMetrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	serverMetrics, metricsEndpoint := metrics.NewMetrics(registry)
+	if cfg.metricCeiling == 0 {
+		logging.Fatalf("Invalid -metric-ceiling: must be positive")
+	}
+	serverMetrics.SetMetricCeiling(cfg.metricCeiling)
+	logging.Infof("  Prometheus metrics: enabled")
 
 	// Initialize database
-	database, err := db.New(dbPath)
+	database, err := db.NewWithDriver(cfg.dbDriver, dbPath)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logging.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
+	database.SetRetryPolicy(cfg.dbRetry)
+	if cfg.keyIDStrategy != "" {
+		keyIDs, err := idgen.New(cfg.keyIDStrategy)
+		if err != nil {
+			logging.Fatalf("Invalid -key-id-strategy: %v", err)
+		}
+		database.SetKeyIDGenerator(keyIDs)
+	}
 
 	// Check for INIT_API_KEY environment variable (for ephemeral deployments like Render)
 	if initKey := os.Getenv("INIT_API_KEY"); initKey != "" {
-		log.Printf("  Found INIT_API_KEY (length=%d, prefix=%s...)", len(initKey), initKey[:min(10, len(initKey))])
+		logging.Infof("  Found INIT_API_KEY (length=%d, prefix=%s...)", len(initKey), initKey[:min(10, len(initKey))])
 		if err := database.EnsureAPIKey(initKey, "init-key"); err != nil {
-			log.Printf("Warning: Failed to seed initial API key: %v", err)
+			logging.Warnf("Failed to seed initial API key: %v", err)
 		} else {
-			log.Printf("  Initial API key loaded successfully")
+			logging.Infof("  Initial API key loaded successfully")
 		}
 	} else {
-		log.Printf("  No INIT_API_KEY environment variable set")
+		logging.Infof("  No INIT_API_KEY environment variable set")
 	}
 
 	// Initialize Firebase Auth (optional - for dashboard users)
-	var firebaseAuth *auth.FirebaseAuth
-	if os.Getenv("FIREBASE_SERVICE_ACCOUNT_JSON") != "" || os.Getenv("FIREBASE_SERVICE_ACCOUNT_PATH") != "" {
-		fa, err := auth.NewFirebaseAuth()
+	firebaseAuth := initFirebaseAuth()
+
+	// Background jobs report into a shared registry, exposed via /api/admin/jobs
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobRegistry := jobs.NewRegistry()
+	serverMetrics.Register(jobRegistry)
+
+	jobRegistry.Every(jobCtx, "active-agents", 30*time.Second, func() error {
+		count, err := database.GetActiveAgentCount(5)
 		if err != nil {
-			log.Printf("Warning: Firebase Auth failed to initialize: %v", err)
-			log.Printf("  Dashboard will use API key authentication")
-		} else {
-			firebaseAuth = fa
-			log.Printf("  Firebase Auth: enabled")
+			return err
 		}
-	} else {
-		log.Printf("  Firebase Auth: disabled (no service account configured)")
+		serverMetrics.SetActiveAgents(count)
+		return nil
+	})
+
+	// Catches series left behind by agents deleted outside the prune path,
+	// or by agents offline for longer than the dashboard's online window
+	jobRegistry.Every(jobCtx, "metrics-reconcile", 10*time.Minute, func() error {
+		_, err := fleet.RemoveOrphanedMetrics(database, serverMetrics, cfg.agentOnlineWindow)
+		return err
+	})
+
+	if cfg.quarantineAfter > 0 {
+		quarantiner := fleet.NewQuarantiner(database, cfg.quarantineAfter)
+		quarantiner.SetMetrics(serverMetrics)
+		if cfg.quarantineWebhook != "" {
+			quarantiner.SetNotifier(fleet.WebhookNotifier(cfg.quarantineWebhook))
+		}
+		jobRegistry.Every(jobCtx, "agent-quarantine", time.Minute, func() error {
+			_, err := quarantiner.Sweep()
+			return err
+		})
+		logging.Infof("  Agent quarantine: after %s", cfg.quarantineAfter)
 	}
 
-	// Create handler
+	if cfg.pruneInterval > 0 {
+		if cfg.pruneAge <= 0 {
+			logging.Fatalf("Invalid -prune-age: must be positive")
+		}
+		pruner := fleet.NewPruner(database, cfg.pruneAge)
+		pruner.SetMetrics(serverMetrics)
+		jobRegistry.Every(jobCtx, "agent-prune", cfg.pruneInterval, func() error {
+			_, err := pruner.Sweep()
+			return err
+		})
+		logging.Infof("  Agent pruning: every %s, after %s", cfg.pruneInterval, cfg.pruneAge)
+	}
+
+	// Create handler; heartbeats feed the dashboard stats
+	statsHandler := handler.NewStatsHandler(database)
+	serverMetrics.OnAgentRemoved(statsHandler.RemoveAgent)
 	sentinelHandler := handler.NewSentinelHandler(database, latestVersion)
+	sentinelHandler.SetMetrics(serverMetrics)
+	sentinelHandler.SetStatsHandler(statsHandler)
+	if err := sentinelHandler.SetMaxVersion(cfg.maxVersion); err != nil {
+		logging.Fatalf("Invalid -max-version: %v", err)
+	}
+	if cfg.maxVersion != "" {
+		logging.Infof("  Pinned max version: %s", cfg.maxVersion)
+	}
+	if cfg.canaryPercent > 0 {
+		if err := sentinelHandler.SetRolloutPlan(cfg.canaryVersion, cfg.canaryPercent); err != nil {
+			logging.Fatalf("Invalid canary rollout: %v", err)
+		}
+		logging.Infof("  Canary rollout: %s to %d%% of agents", cfg.canaryVersion, cfg.canaryPercent)
+	}
+	if cfg.upgradeWindow != "" {
+		window, err := handler.ParseUpgradeWindow(cfg.upgradeWindow)
+		if err != nil {
+			logging.Fatalf("Invalid -upgrade-window: %v", err)
+		}
+		sentinelHandler.SetUpgradeWindow(&window)
+		logging.Infof("  Upgrade window: %s", window)
+	}
+	idPolicy, err := handler.ParseAgentIDPolicy(cfg.agentIDPolicy)
+	if err != nil {
+		logging.Fatalf("Invalid -agent-id-policy: %v", err)
+	}
+	sentinelHandler.SetAgentIDPolicy(idPolicy)
+	if cfg.agentIDPolicy != handler.AgentIDPolicyNone {
+		logging.Infof("  Agent ID policy: %s", cfg.agentIDPolicy)
+	}
+	if cfg.namespaceAgents {
+		sentinelHandler.SetAgentNamespacing(true)
+		logging.Infof("  Agent namespacing: enabled")
+	}
+	// Async writers are drained after the server stops taking requests
+	var writers []drainer
+	if cfg.eventBuffer < 0 {
+		logging.Fatalf("Invalid -event-buffer: %d", cfg.eventBuffer)
+	}
+	if cfg.eventBuffer > 0 {
+		eventWriter := handler.NewEventWriter(database, cfg.eventBuffer)
+		sentinelHandler.SetEventWriter(eventWriter)
+		writers = append(writers, eventWriter)
+		logging.Infof("  Async agent events: buffer of %d heartbeats", cfg.eventBuffer)
+	}
 
 	// Initialize cloud provider registry
 	cloudRegistry := cloud.NewRegistry()
-	log.Printf("  Cloud provider registry initialized")
+	logging.Infof("  Cloud provider registry initialized")
 
 	// Load existing cloud configs from database
 	cloudConfigs, err := database.GetCloudConfigs()
 	if err != nil {
-		log.Printf("Warning: Failed to load cloud configs: %v", err)
+		logging.Warnf("Failed to load cloud configs: %v", err)
 	} else {
 		for _, cfg := range cloudConfigs {
-			parsed, err := cloud.CloudConfigFromJSON(cfg.ConfigJSON)
+			parsed, err := cloud.ParseStoredConfig(cfg.ConfigJSON)
 			if err != nil {
-				log.Printf("Warning: Failed to parse cloud config %s: %v", cfg.ID, err)
+				logging.Warnf("Failed to parse cloud config %s: %v", cfg.ID, err)
 				continue
 			}
 			provider, err := cloud.CreateProvider(parsed)
 			if err != nil {
-				log.Printf("Warning: Failed to create provider %s: %v", cfg.ID, err)
+				logging.Warnf("Failed to create provider %s: %v", cfg.ID, err)
 				continue
 			}
 			cloudRegistry.Register(cfg.ID, provider)
-			log.Printf("  Loaded cloud config: %s (%s)", cfg.ID, cfg.Provider)
+			logging.Infof("  Loaded cloud config: %s (%s)", cfg.ID, cfg.Provider)
 		}
 	}
 
 	// Create cost handler
 	costHandler := handler.NewCostHandler(database, cloudRegistry)
+	costHandler.SetMetrics(serverMetrics)
+	costHandler.SetStaleThreshold(cfg.costStaleAfter)
+	if cfg.syncConcurrency <= 0 {
+		logging.Fatalf("Invalid -sync-concurrency: %d (must be positive)", cfg.syncConcurrency)
+	}
+	costHandler.SetSyncConcurrency(cfg.syncConcurrency)
+	costHandler.SetCostCacheTTL(cfg.costCacheTTL)
+	if cfg.savingsBaseline != "" {
+		baselines, err := correlation.ParseSavingsBaselines(cfg.savingsBaseline)
+		if err != nil {
+			logging.Fatalf("Invalid -savings-baseline: %v", err)
+		}
+		costHandler.SetSavingsBaselines(baselines)
+		logging.Infof("  Savings plan baselines: %s", cfg.savingsBaseline)
+	}
+	jobRegistry.Every(jobCtx, "cost-freshness", time.Minute, costHandler.RefreshFreshnessMetrics)
+	// Re-runs replace the day's attributions, so late flow log deliveries are picked up
+	jobRegistry.Every(jobCtx, "flow-attribution", 6*time.Hour, func() error {
+		return costHandler.AttributeFlowCosts(jobCtx)
+	})
 
 	// Create health handler
 	healthHandler := handler.NewHealthHandler(database, latestVersion)
+	healthHandler.SetVersionSource(sentinelHandler.LatestVersion)
+	healthHandler.SetProviderRegistry(cloudRegistry)
 
 	// Initialize middleware
 	rateLimiter := middleware.NewRateLimiter(100, 20) // 100 req/min, burst 20
-	loggingMiddleware := middleware.NewLoggingMiddleware(log.Default())
+	rateLimit := rateLimiter.Middleware
+	if cfg.rateLimitRedis != "" {
+		distributed, err := middleware.NewDistributedRateLimiter(cfg.rateLimitRedis, rateLimiter)
+		if err != nil {
+			logging.Fatalf("Invalid -rate-limit-redis: %v", err)
+		}
+		defer distributed.Close()
+		rateLimit = distributed.Middleware
+		logging.Infof("  Rate limiting: shared through Redis at %s", distributed.Addr())
+	}
+	loggingMiddleware := middleware.NewLoggingMiddleware(logging.Default().StdLogger(logging.LevelInfo))
+	requestIDs, err := idgen.New(cfg.idStrategy)
+	if err != nil {
+		logging.Fatalf("Invalid -id-strategy: %v", err)
+	}
+	loggingMiddleware.SetIDGenerator(requestIDs)
 	corsMiddleware := middleware.CORS(middleware.DefaultCORSConfig())
+	if cfg.csrf {
+		logging.Infof("  CSRF protection on: %s", strings.Join(middleware.DefaultCSRFRoutes, ", "))
+	}
+	if len(cfg.signedRoutes) > 0 {
+		logging.Infof("  Signatures required on: %s", strings.Join(cfg.signedRoutes, ", "))
+	}
+	if cfg.maxSignedBody <= 0 {
+		logging.Fatalf("Invalid -max-signed-body: %d (must be positive)", cfg.maxSignedBody)
+	}
+	middleware.SetNonceRequired(cfg.requireNonce)
+	if cfg.requireNonce {
+		logging.Infof("  Signed requests must carry a nonce")
+	}
+	if cfg.maxBody <= 0 {
+		logging.Fatalf("Invalid -max-body: %d (must be positive)", cfg.maxBody)
+	}
+	if cfg.requestTimeout < 0 {
+		logging.Fatalf("Invalid -request-timeout: %s", cfg.requestTimeout)
+	}
+	headersConfig := middleware.DefaultRequiredHeadersConfig()
+	headersConfig.Headers = cfg.requiredHeaders
+	if len(headersConfig.Headers) > 0 {
+		logging.Infof("  Required headers: %s", strings.Join(headersConfig.Headers, ", "))
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -170,12 +556,20 @@ func runServer(port, dbPath, latestVersion string) {
 	mux.HandleFunc("/health", healthHandler.HandleHealth)
 	mux.HandleFunc("/ready", healthHandler.HandleReady)
 	mux.HandleFunc("/live", healthHandler.HandleLive)
+	mux.HandleFunc("/time", healthHandler.HandleTime)
 	mux.HandleFunc("/debug", healthHandler.HandleDebug)
 
+	// Low-churn reads carry Cache-Control and an ETag; /api/stats stays no-cache
+	if cfg.cacheMaxAge < 0 {
+		logging.Fatalf("Invalid -cache-max-age: must not be negative")
+	}
+	cacheable := middleware.Cacheable(cfg.cacheMaxAge)
+	mux.Handle("/version", cacheable(http.HandlerFunc(healthHandler.HandleVersion)))
+
 	// Prometheus metrics endpoint (no auth required)
-	mux.Handle("/metrics", metrics.Handler())
-	log.Printf("  Metrics endpoint: GET http://localhost:%s/metrics", port)
-	log.Printf("  Health endpoints: /health, /ready, /live")
+	mux.Handle("/metrics", metricsEndpoint)
+	logging.Infof("  Metrics endpoint: GET http://localhost:%s/metrics", port)
+	logging.Infof("  Health endpoints: /health, /ready, /live, /time, /version")
 
 	// ConnectRPC handler with auth middleware
 	path, connectHandler := sentinelv1connect.NewSentinelServiceHandler(
@@ -188,42 +582,86 @@ func runServer(port, dbPath, latestVersion string) {
 	authWrapper := middleware.NewHTTPAuthMiddleware(database)
 	mux.Handle("/api/costs", authWrapper(http.HandlerFunc(costHandler.HandleGetCosts)))
 	mux.Handle("/api/costs/summary", authWrapper(http.HandlerFunc(costHandler.HandleGetCostsSummary)))
-	mux.Handle("/api/clouds", authWrapper(http.HandlerFunc(costHandler.HandleClouds)))
+	mux.Handle("/api/costs/matrix", authWrapper(http.HandlerFunc(costHandler.HandleGetCostMatrix)))
+	mux.Handle("/api/costs/by-tag", authWrapper(http.HandlerFunc(costHandler.HandleGetCostsByTag)))
+	mux.Handle("/api/costs/bundle", authWrapper(http.HandlerFunc(costHandler.HandleGetCostBundle)))
+	mux.Handle("/api/costs/freshness", authWrapper(http.HandlerFunc(costHandler.HandleGetCostFreshness)))
+	mux.Handle("/api/costs/sync-history", authWrapper(http.HandlerFunc(costHandler.HandleGetSyncHistory)))
+	mux.Handle("/api/costs/anomalies", authWrapper(http.HandlerFunc(costHandler.HandleGetAnomalies)))
+	mux.Handle("/api/costs/attribution", authWrapper(http.HandlerFunc(costHandler.HandleGetAttribution)))
+	mux.Handle("/api/budgets", authWrapper(http.HandlerFunc(costHandler.HandleBudgets)))
+	mux.Handle("/api/budgets/alerts", authWrapper(http.HandlerFunc(costHandler.HandleGetBudgetAlerts)))
+	metricsHandler := handler.NewMetricsHandler(database)
+	metricsHandler.SetMetrics(serverMetrics)
+	metricsHandler.SetAgentIdentity(sentinelHandler)
+	mux.Handle("/api/metrics/bulk", authWrapper(http.HandlerFunc(metricsHandler.HandleBulkMetrics)))
+	mux.Handle("/api/clouds", authWrapper(cacheable(http.HandlerFunc(costHandler.HandleClouds))))
+	mux.Handle("PATCH /api/clouds/{id}", authWrapper(http.HandlerFunc(costHandler.HandlePatchCloud)))
+	mux.Handle("GET /api/clouds/{id}/recommendations", authWrapper(http.HandlerFunc(costHandler.HandleGetCloudRecommendations)))
 	mux.Handle("/api/recommendations", authWrapper(http.HandlerFunc(costHandler.HandleGetRecommendations)))
+	mux.Handle("PATCH /api/recommendations/{id}", authWrapper(http.HandlerFunc(costHandler.HandleUpdateRecommendation)))
 	mux.Handle("/api/sync-costs", authWrapper(http.HandlerFunc(costHandler.HandleSyncCosts)))
-	log.Printf("  Cost API endpoints: /api/costs, /api/clouds, /api/recommendations")
+	mux.Handle("GET /api/sync-costs/stream", authWrapper(http.HandlerFunc(costHandler.HandleSyncCostsStream)))
+	logging.Infof("  Cost API endpoints: /api/costs, /api/clouds, /api/clouds/{id}/recommendations, /api/recommendations, /api/recommendations/{id}, /api/budgets, /api/budgets/alerts")
 
 	// Dashboard endpoints (stats requires auth, dashboard is public)
-	statsHandler := handler.NewStatsHandler(database)
-
-	// Use Firebase auth for dashboard if available, otherwise API key
-	var dashboardAuthWrapper func(http.Handler) http.Handler
-	if firebaseAuth != nil {
-		dashboardAuthWrapper = auth.FirebaseMiddleware(firebaseAuth)
-		log.Printf("  Dashboard auth: Firebase")
-	} else {
-		dashboardAuthWrapper = authWrapper
-		log.Printf("  Dashboard auth: API Key")
+	jobsHandler := handler.NewJobsHandler(jobRegistry)
+	adminHandler := handler.NewAdminHandler(database)
+	adminHandler.SetMetrics(serverMetrics)
+	agentHandler := handler.NewAgentHandler(database)
+	agentHandler.SetMetrics(serverMetrics)
+	if cfg.agentOnlineWindow <= 0 {
+		logging.Fatalf("Invalid -agent-online-window: must be positive")
 	}
-
-	// Create key handler
+	agentHandler.SetOnlineWindow(cfg.agentOnlineWindow)
 	keyHandler := handler.NewKeyHandler(database)
-	mux.Handle("/api/keys", dashboardAuthWrapper(http.HandlerFunc(keyHandler.HandleGetKeys)))
-	mux.Handle("/api/keys/create", dashboardAuthWrapper(http.HandlerFunc(keyHandler.HandleCreateKey)))
-	log.Printf("  Key API endpoints: /api/keys, /api/keys/create")
-
-	mux.Handle("/api/stats", dashboardAuthWrapper(http.HandlerFunc(statsHandler.HandleStats)))
+	keyHandler.SetRateLimiter(rateLimiter)
+	dashboard := dashboardRoutes{
+		keys:     keyHandler,
+		stats:    statsHandler,
+		jobs:     jobsHandler,
+		admin:    adminHandler,
+		sentinel: sentinelHandler,
+		// With -rate-limit-redis, the buckets listed are the in-memory fallback's
+		rateLimits: handler.NewRateLimitHandler(rateLimiter),
+		commands:   handler.NewCommandHandler(database),
+		agents:     agentHandler,
+		costs:      costHandler,
+	}
+	dashboard.register(mux, database, authWrapper, firebaseAuth, cfg.maxSignedBody)
 	mux.HandleFunc("/dashboard", serveDashboard)
 	mux.HandleFunc("/dashboard/", serveDashboard)
-	log.Printf("  Dashboard: http://localhost:%s/dashboard", port)
+	logging.Infof("  Dashboard: %s://localhost:%s/dashboard", scheme, port)
 
-	// Wrap mux with middleware chain: Security Heads -> Audit -> Signature -> CORS -> logging -> rate limiting -> mux
+	// Wrap mux with middleware chain: Security Heads -> Audit -> body limit -> Signature -> CORS -> CSRF (opt-in) -> logging -> panic recovery -> body sizes -> required headers -> compression -> rate limiting -> timeout -> mux
 	var finalHandler http.Handler = mux
-	finalHandler = rateLimiter.Middleware(finalHandler)
+	if cfg.requestTimeout > 0 {
+		finalHandler = middleware.Timeout(cfg.requestTimeout)(finalHandler)
+	}
+	finalHandler = rateLimit(finalHandler)
+	finalHandler = middleware.Compression()(finalHandler) // Inside body sizes, so they count bytes on the wire
+	finalHandler = middleware.RequireHeaders(headersConfig)(finalHandler)
+	finalHandler = middleware.BodySizeMetrics(serverMetrics, func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	})(finalHandler)
+	finalHandler = middleware.Recover(logging.Default().StdLogger(logging.LevelError))(finalHandler)
 	finalHandler = loggingMiddleware.Middleware(finalHandler)
+	if cfg.csrf {
+		finalHandler = middleware.CSRF(middleware.MutatingRoutes(middleware.DefaultCSRFRoutes))(finalHandler)
+	}
 	finalHandler = corsMiddleware(finalHandler)
-	finalHandler = middleware.SignatureMiddleware(database)(finalHandler)
-	finalHandler = middleware.AuditMiddleware(middleware.DefaultAuditLogger())(finalHandler)
+	finalHandler = middleware.SignaturePolicyMiddleware(database, middleware.MutatingRoutes(cfg.signedRoutes), cfg.maxSignedBody)(finalHandler)
+	finalHandler = middleware.MaxBodyBytes(cfg.maxBody)(finalHandler)
+	auditLogger, auditClose := newAuditLogger(cfg)
+	defer auditClose()
+	if cfg.auditDB {
+		dbLogger, auditWriter := middleware.DBAuditLogger(database)
+		auditLogger = middleware.MultiAuditLogger(auditLogger, dbLogger)
+		writers = append(writers, auditWriter)
+		logging.Infof("  Audit log: database (flushed every %s)", middleware.DefaultAuditFlushInterval)
+	}
+	finalHandler = middleware.AuditMiddleware(auditLogger)(finalHandler)
 	finalHandler = middleware.SecurityHeaders()(finalHandler)
 
 	// Create server
@@ -233,6 +671,21 @@ func runServer(port, dbPath, latestVersion string) {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		TLSConfig:    tlsConfig,
+	}
+
+	// SIGHUP re-reads the latest version from -version-file, which admin
+	// version changes are saved to
+	if cfg.versionFile != "" {
+		sentinelHandler.SetVersionFile(cfg.versionFile)
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				reloadLatestVersion(sentinelHandler, cfg.versionFile)
+			}
+		}()
+		logging.Infof("  Version file: %s (reloaded on SIGHUP)", cfg.versionFile)
 	}
 
 	// Graceful shutdown
@@ -242,27 +695,186 @@ func runServer(port, dbPath, latestVersion string) {
 
 	go func() {
 		<-quit
-		log.Println("Server shutting down...")
+		logging.Infof("Server shutting down...")
+		stopJobs()
+		jobRegistry.Wait()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
-			log.Fatalf("Server forced to shutdown: %v", err)
+			logging.Fatalf("Server forced to shutdown: %v", err)
 		}
+		drainWriters(ctx, writers)
 		close(done)
 	}()
 
 	// Start server
-	log.Printf("Server listening on http://localhost:%s", port)
-	log.Printf("Heartbeat endpoint: POST http://localhost:%s%sHeartbeat", port, path)
+	logging.Infof("Server listening on %s://localhost:%s", scheme, port)
+	logging.Infof("Heartbeat endpoint: POST %s://localhost:%s%sHeartbeat", scheme, port, path)
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server failed: %v", err)
+	serve := server.ListenAndServe
+	if cfg.tlsCert != "" {
+		// The certificate comes from tlsConfig.GetCertificate
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil && err != http.ErrServerClosed {
+		logging.Fatalf("Server failed: %v", err)
 	}
 
 	<-done
-	log.Println("Server stopped")
+	logging.Infof("Server stopped")
+}
+
+// newAuditLogger builds the audit logger selected by the -audit-* flags,
+// along with a function releasing its file
+func newAuditLogger(cfg serverConfig) (middleware.AuditLogger, func()) {
+	if cfg.auditFile != "" {
+		logger, rf, err := middleware.RotatingFileAuditLogger(cfg.auditFile, cfg.auditMaxSizeMB)
+		if err != nil {
+			logging.Fatalf("Invalid -audit-file: %v", err)
+		}
+		logging.Infof("  Audit log: %s (rotated at %d MB)", cfg.auditFile, cfg.auditMaxSizeMB)
+		return logger, func() { rf.Close() }
+	}
+	switch cfg.auditFormat {
+	case "text":
+		return middleware.DefaultAuditLogger(), func() {}
+	case "json":
+		return middleware.JSONAuditLogger(os.Stdout), func() {}
+	}
+	logging.Fatalf("Invalid -audit-format: %q (want text or json)", cfg.auditFormat)
+	return nil, nil
+}
+
+// drainer is an async writer flushed on shutdown
+type drainer interface {
+	Drain(ctx context.Context) int
+}
+
+// drainWriters closes every writer to new entries and waits, within ctx,
+// for them to flush what they have buffered. Entries left unflushed when ctx
+// ends are logged by the writer and lost.
+func drainWriters(ctx context.Context, writers []drainer) {
+	lost := 0
+	for _, w := range writers {
+		lost += w.Drain(ctx)
+	}
+	if lost > 0 {
+		logging.Errorf("Shutdown lost %d buffered entries", lost)
+	}
+}
+
+// initFirebaseAuth returns the Firebase client for dashboard users, or nil
+// when Firebase is unconfigured or fails to initialize
+func initFirebaseAuth() *auth.FirebaseAuth {
+	firebaseAuth, err := auth.NewFirebaseAuth()
+	switch {
+	case errors.Is(err, auth.ErrNotConfigured):
+		logging.Infof("  Firebase Auth: disabled (no service account configured)")
+		return nil
+	case err != nil:
+		logging.Warnf("Firebase Auth failed to initialize: %v", err)
+		logging.Infof("  Dashboard will use API key authentication")
+		return nil
+	}
+	logging.Infof("  Firebase Auth: enabled")
+	return firebaseAuth
+}
+
+// dashboardRoutes holds the handlers behind the dashboard and admin endpoints
+type dashboardRoutes struct {
+	keys       *handler.KeyHandler
+	stats      *handler.StatsHandler
+	jobs       *handler.JobsHandler
+	admin      *handler.AdminHandler
+	sentinel   *handler.SentinelHandler
+	rateLimits *handler.RateLimitHandler
+	commands   *handler.CommandHandler
+	agents     *handler.AgentHandler
+	costs      *handler.CostHandler
+}
+
+// register mounts the dashboard and admin endpoints on mux. They use Firebase
+// auth when it's configured, and under Firebase the /api/admin routes also
+// require the admin role. Otherwise they take API keys, and Firebase ID
+// tokens get a 501.
+func (d dashboardRoutes) register(mux *http.ServeMux, database *db.DB, apiKeyAuth func(http.Handler) http.Handler, firebaseAuth *auth.FirebaseAuth, maxSignedBody int64) {
+	var dashboardAuth, adminAuth func(http.Handler) http.Handler
+	if firebaseAuth != nil {
+		dashboardAuth = auth.FirebaseMiddleware(firebaseAuth)
+		requireAdmin := auth.RequireRole(firebaseAuth, "admin")
+		adminAuth = func(next http.Handler) http.Handler {
+			return dashboardAuth(requireAdmin(next))
+		}
+		logging.Infof("  Dashboard auth: Firebase (admin role for /api/admin)")
+	} else {
+		dashboardAuth = apiKeyOrFirebase(apiKeyAuth, nil)
+		adminAuth = dashboardAuth
+		logging.Infof("  Dashboard auth: API Key")
+	}
+
+	mux.Handle("/api/keys", dashboardAuth(http.HandlerFunc(d.keys.HandleGetKeys)))
+	mux.Handle("/api/keys/create", dashboardAuth(http.HandlerFunc(d.keys.HandleCreateKey)))
+	mux.Handle("/api/keys/revoke", dashboardAuth(http.HandlerFunc(d.keys.HandleRevokeKeys)))
+	mux.Handle("/api/keys/", dashboardAuth(http.HandlerFunc(d.keys.HandleDeleteKey)))
+	whoami := apiKeyOrFirebase(apiKeyAuth, firebaseAuth)(http.HandlerFunc(d.keys.HandleWhoAmI))
+	mux.Handle("/api/whoami", whoami)
+	mux.Handle("/whoami", whoami)
+	logging.Infof("  Key API endpoints: /api/keys, /api/keys/create, /api/keys/revoke, DELETE /api/keys/{id}, /api/whoami (also /whoami)")
+
+	mux.Handle("/api/stats", dashboardAuth(http.HandlerFunc(d.stats.HandleStats)))
+	mux.Handle("/api/stats/agent", dashboardAuth(http.HandlerFunc(d.stats.HandleAgentStats)))
+
+	mux.Handle("/api/admin/jobs", adminAuth(http.HandlerFunc(d.jobs.HandleListJobs)))
+	mux.Handle("/api/admin/reencrypt", adminAuth(http.HandlerFunc(d.admin.HandleReEncrypt)))
+	mux.Handle("/api/admin/schema-version", adminAuth(http.HandlerFunc(d.admin.HandleSchemaVersion)))
+	mux.Handle("/api/admin/latest-version", adminAuth(http.HandlerFunc(d.sentinel.HandleSetLatestVersion)))
+	mux.Handle("/api/admin/metrics-reconcile", adminAuth(http.HandlerFunc(d.admin.HandleMetricsReconcile)))
+	mux.Handle("/api/admin/audit-logs", adminAuth(http.HandlerFunc(d.admin.HandleGetAuditLogs)))
+	mux.Handle("/api/admin/ratelimits", adminAuth(http.HandlerFunc(d.rateLimits.HandleRateLimits)))
+	mux.Handle("/api/commands/by-version", dashboardAuth(http.HandlerFunc(d.commands.HandleCommandByVersion)))
+	mux.Handle("/api/commands/history", dashboardAuth(http.HandlerFunc(d.commands.HandleCommandHistory)))
+	mux.Handle("/api/agents", dashboardAuth(http.HandlerFunc(d.agents.HandleListAgents)))
+	mux.Handle("/api/agents/versions", dashboardAuth(http.HandlerFunc(d.agents.HandleVersionDistribution)))
+	mux.Handle("/api/agents/stale", dashboardAuth(http.HandlerFunc(d.agents.HandleDeleteStaleAgents)))
+	mux.Handle("/api/agents/export", dashboardAuth(http.HandlerFunc(d.agents.HandleExportAgents)))
+	mux.Handle("/api/agents/by-metric", dashboardAuth(http.HandlerFunc(d.agents.HandleAgentsByMetric)))
+	// Reveal returns credentials: API keys must also sign the request, and
+	// Firebase users need the admin role and a recent sign-in
+	signedKeyAuth := func(next http.Handler) http.Handler {
+		return apiKeyAuth(middleware.RequireSignatureWithLimit(database, maxSignedBody)(next))
+	}
+	revealAuth := apiKeyOrFirebase(signedKeyAuth, firebaseAuth, auth.RequireRole(firebaseAuth, "admin"), auth.RequireRecentSignIn(firebaseAuth, revealSignInWindow))
+	mux.Handle("GET /api/clouds/{id}/reveal", revealAuth(http.HandlerFunc(d.costs.HandleRevealCloud)))
+	logging.Infof("  Admin endpoints: /api/admin/jobs, /api/admin/reencrypt, /api/admin/schema-version, /api/admin/latest-version, /api/admin/metrics-reconcile, /api/admin/audit-logs, /api/admin/ratelimits, /api/commands/by-version, /api/commands/history, /api/agents, /api/agents/versions, /api/agents/stale, /api/agents/export, /api/clouds/{id}/reveal")
+}
+
+// revealSignInWindow is how recently a Firebase user must have signed in to
+// reveal stored credentials
+const revealSignInWindow = 5 * time.Minute
+
+// apiKeyOrFirebase authenticates bearer tokens other than sk_ API keys as
+// Firebase ID tokens, which then pass through firebaseChecks in order, and
+// everything else with apiKeyAuth. With Firebase unconfigured, ID tokens get
+// a 501.
+func apiKeyOrFirebase(apiKeyAuth func(http.Handler) http.Handler, firebaseAuth *auth.FirebaseAuth, firebaseChecks ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		viaKey := apiKeyAuth(next)
+		viaFirebase := next
+		for i := len(firebaseChecks) - 1; i >= 0; i-- {
+			viaFirebase = firebaseChecks[i](viaFirebase)
+		}
+		viaFirebase = auth.FirebaseMiddleware(firebaseAuth)(viaFirebase)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if strings.HasPrefix(header, "Bearer ") && !strings.HasPrefix(header, "Bearer sk_") {
+				viaFirebase.ServeHTTP(w, r)
+				return
+			}
+			viaKey.ServeHTTP(w, r)
+		})
+	}
 }
 
 //go:embed dashboard/index.html
@@ -272,3 +884,62 @@ func serveDashboard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write(dashboardHTML)
 }
+
+// setupLogging installs the process-wide leveled logger. Output from the
+// standard log package (used by dependencies) goes to the same destination.
+func setupLogging(level, output string) (io.Closer, error) {
+	lvl, err := logging.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	w, err := logging.Open(output)
+	if err != nil {
+		return nil, err
+	}
+	logging.SetDefault(logging.New(w, lvl))
+	log.SetOutput(w)
+	return w, nil
+}
+
+// signedRouteList returns the routes requiring signatures, or nil if disabled
+func signedRouteList(enabled bool, routes string) []string {
+	if !enabled {
+		return nil
+	}
+	return splitList(routes)
+}
+
+// splitList parses a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// readVersionFile returns the version held in path, ignoring surrounding
+// whitespace
+func readVersionFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// reloadLatestVersion applies the version in path, keeping the current one
+// if the file can't be read or doesn't hold valid semver
+func reloadLatestVersion(h *handler.SentinelHandler, path string) {
+	version, err := readVersionFile(path)
+	if err == nil {
+		previous := h.LatestVersion()
+		if err = h.SetLatestVersion(version); err == nil {
+			logging.Infof("Latest agent version changed from %s to %s", previous, h.LatestVersion())
+			return
+		}
+	}
+	logging.Errorf("Failed to reload latest version from %s: %v", path, err)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	fbauth "firebase.google.com/go/v4/auth"
+	"github.com/sennet/sennet/backend/auth"
+	"github.com/sennet/sennet/backend/cloud"
+	"github.com/sennet/sennet/backend/crypto"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/handler"
+	"github.com/sennet/sennet/backend/jobs"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestKeygen_Scopes(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+
+	var out bytes.Buffer
+	if err := keygen(&out, database, "ci", 0, []string{middleware.ScopeCostsRead}); err != nil {
+		t.Fatalf("keygen failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Scopes: costs:read\n") {
+		t.Errorf("Expected the scopes in the output, got:\n%s", out.String())
+	}
+
+	var key string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if k, ok := strings.CutPrefix(line, "Created API key: "); ok {
+			key = k
+		}
+	}
+	stored, err := database.GetAPIKey(key)
+	if err != nil || stored == nil {
+		t.Fatalf("Expected the printed key to exist, got %v (%v)", stored, err)
+	}
+	if !reflect.DeepEqual(stored.Scopes, []string{middleware.ScopeCostsRead}) {
+		t.Errorf("Expected the key limited to costs:read, got %v", stored.Scopes)
+	}
+
+	// Without -scopes the key keeps full access
+	out.Reset()
+	if err := keygen(&out, database, "admin", 0, nil); err != nil {
+		t.Fatalf("keygen failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Scopes: *\n") {
+		t.Errorf("Expected full access by default, got:\n%s", out.String())
+	}
+}
+
+func TestDrainWriters_FlushesBufferedEvents(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+
+	w := handler.NewEventWriter(database, 100)
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		w.Write(handler.AgentEventBatch{AgentID: "agent-1", Events: []db.AgentEvent{
+			{Type: "anomaly", Detail: "spike", OccurredAt: now, ReceivedAt: now},
+		}})
+	}
+
+	// Whatever is still buffered at shutdown reaches the database
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	drainWriters(ctx, []drainer{w})
+
+	events, err := database.GetAgentEvents("agent-1", 100)
+	if err != nil {
+		t.Fatalf("GetAgentEvents failed: %v", err)
+	}
+	if len(events) != 20 {
+		t.Errorf("Expected all 20 buffered events flushed, got %d", len(events))
+	}
+}
+
+func TestReloadLatestVersion(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+	h := handler.NewSentinelHandler(database, "1.0.0")
+
+	path := filepath.Join(t.TempDir(), "version")
+	os.WriteFile(path, []byte("v1.3.0\n"), 0o644)
+	reloadLatestVersion(h, path)
+	if got := h.LatestVersion(); got != "1.3.0" {
+		t.Errorf("Expected 1.3.0 after reload, got %s", got)
+	}
+
+	os.WriteFile(path, []byte("not-a-version"), 0o644)
+	reloadLatestVersion(h, path)
+	if got := h.LatestVersion(); got != "1.3.0" {
+		t.Errorf("Expected an invalid file to keep 1.3.0, got %s", got)
+	}
+}
+
+// fakeVerifier accepts the ID tokens it maps to a Firebase token
+type fakeVerifier map[string]*fbauth.Token
+
+func (v fakeVerifier) VerifyIDToken(ctx context.Context, idToken string) (*fbauth.Token, error) {
+	if token, ok := v[idToken]; ok {
+		return token, nil
+	}
+	return nil, errors.New("unknown token")
+}
+
+// newTestDashboard mounts the dashboard routes on a mux backed by a fresh database
+func newTestDashboard(t *testing.T, firebaseAuth *auth.FirebaseAuth) (*http.ServeMux, *db.DB) {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	d := dashboardRoutes{
+		keys:       handler.NewKeyHandler(database),
+		stats:      handler.NewStatsHandler(database),
+		jobs:       handler.NewJobsHandler(jobs.NewRegistry()),
+		admin:      handler.NewAdminHandler(database),
+		sentinel:   handler.NewSentinelHandler(database, "1.0.0"),
+		rateLimits: handler.NewRateLimitHandler(middleware.NewRateLimiter(100, 20)),
+		commands:   handler.NewCommandHandler(database),
+		agents:     handler.NewAgentHandler(database),
+		costs:      handler.NewCostHandler(database, cloud.NewRegistry()),
+	}
+	mux := http.NewServeMux()
+	d.register(mux, database, middleware.NewHTTPAuthMiddleware(database), firebaseAuth, middleware.DefaultMaxSignedBodyBytes)
+	return mux, database
+}
+
+func serveWithToken(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminRoutes_RequireFirebaseAdminRole(t *testing.T) {
+	firebaseAuth := auth.NewFirebaseAuthWithVerifier(fakeVerifier{
+		"user-token":  {UID: "user-1", Claims: map[string]interface{}{"role": "user"}},
+		"admin-token": {UID: "admin-1", Claims: map[string]interface{}{"role": "admin"}},
+	})
+	mux, _ := newTestDashboard(t, firebaseAuth)
+
+	adminRoutes := []string{
+		"/api/admin/jobs",
+		"/api/admin/reencrypt",
+		"/api/admin/schema-version",
+		"/api/admin/latest-version",
+		"/api/admin/metrics-reconcile",
+		"/api/admin/audit-logs",
+		"/api/admin/ratelimits",
+	}
+	for _, path := range adminRoutes {
+		if rec := serveWithToken(mux, http.MethodGet, path, "user-token"); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403 for a non-admin token, got %d", path, rec.Code)
+		}
+		if rec := serveWithToken(mux, http.MethodGet, path, "admin-token"); rec.Code == http.StatusForbidden || rec.Code == http.StatusUnauthorized {
+			t.Errorf("%s: expected an admin token through, got %d", path, rec.Code)
+		}
+	}
+
+	// The rest of the dashboard stays open to any signed-in user
+	if rec := serveWithToken(mux, http.MethodGet, "/api/stats", "user-token"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a non-admin token to read /api/stats, got %d", rec.Code)
+	}
+}
+
+func TestRevealRoute_APIKeyAndFirebase(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	t.Setenv("ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(key))
+
+	now := time.Now().Unix()
+	firebaseAuth := auth.NewFirebaseAuthWithVerifier(fakeVerifier{
+		"admin-token": {UID: "admin-1", AuthTime: now, Claims: map[string]interface{}{"role": "admin"}},
+		"stale-token": {UID: "admin-2", AuthTime: now - 3600, Claims: map[string]interface{}{"role": "admin"}},
+		"user-token":  {UID: "user-1", AuthTime: now, Claims: map[string]interface{}{"role": "user"}},
+	})
+	mux, database := newTestDashboard(t, firebaseAuth)
+	ciphertext, err := crypto.EncryptString(`{"id":"aws-main","provider":"aws","aws":{"access_key_id":"AKIA123","secret_access_key":"shh","region":"us-east-1"}}`)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	database.SaveCloudConfig("aws-main", "aws", ciphertext)
+	apiKey, err := database.CreateAPIKey("admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	const path = "/api/clouds/aws-main/reveal?confirm=true"
+
+	// API keys must sign the request
+	if rec := serveWithToken(mux, http.MethodGet, path, apiKey); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unsigned API key request, got %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write(binary.LittleEndian.AppendUint64(nil, uint64(now)))
+	req.Header.Set(middleware.TimestampHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(middleware.SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "shh") {
+		t.Errorf("Expected a signed API key request to reveal the config, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Firebase users need the admin role and a recent sign-in instead
+	if rec := serveWithToken(mux, http.MethodGet, path, "admin-token"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "shh") {
+		t.Errorf("Expected a recently signed-in admin to reveal the config, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveWithToken(mux, http.MethodGet, path, "user-token"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a non-admin Firebase user, got %d", rec.Code)
+	}
+	if rec := serveWithToken(mux, http.MethodGet, path, "stale-token"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an admin who signed in an hour ago, got %d", rec.Code)
+	}
+	if rec := serveWithToken(mux, http.MethodGet, "/api/clouds/aws-main/reveal", "admin-token"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without confirm=true, got %d", rec.Code)
+	}
+}
+
+func TestWhoAmI_ServedAtBothPaths(t *testing.T) {
+	mux, database := newTestDashboard(t, nil)
+	apiKey, err := database.CreateScopedAPIKey("ci", 0, []string{middleware.ScopeHeartbeat})
+	if err != nil {
+		t.Fatalf("CreateScopedAPIKey failed: %v", err)
+	}
+
+	for _, path := range []string{"/api/whoami", "/whoami"} {
+		rec := serveWithToken(mux, http.MethodGet, path, apiKey)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"ci"`) {
+			t.Errorf("%s: expected the caller's key, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestDashboard_WithoutFirebaseCredentials(t *testing.T) {
+	t.Setenv("FIREBASE_SERVICE_ACCOUNT_JSON", "")
+	t.Setenv("FIREBASE_SERVICE_ACCOUNT_PATH", "")
+	firebaseAuth := initFirebaseAuth()
+	if firebaseAuth != nil {
+		t.Fatal("Expected Firebase to be disabled without credentials")
+	}
+	mux, database := newTestDashboard(t, firebaseAuth)
+	apiKey, err := database.CreateAPIKey("admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	// Firebase ID tokens get a clear 501 everywhere they'd be accepted...
+	for _, path := range []string{"/api/stats", "/api/agents", "/api/admin/jobs", "/api/whoami", "/whoami", "/api/clouds/aws-main/reveal?confirm=true"} {
+		if rec := serveWithToken(mux, http.MethodGet, path, "firebase-id-token"); rec.Code != http.StatusNotImplemented {
+			t.Errorf("%s: expected 501 for a Firebase token, got %d", path, rec.Code)
+		}
+	}
+
+	// ...while API keys keep working
+	for _, path := range []string{"/api/stats", "/api/admin/jobs", "/api/whoami"} {
+		if rec := serveWithToken(mux, http.MethodGet, path, apiKey); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 for an API key, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without credentials, got %d", rec.Code)
+	}
+}
@@ -4,152 +4,680 @@ package metrics
 import (
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
-var (
+// Metrics holds the backend's collectors, registered into one registry and
+// served by the handler NewMetrics returns. Handlers take one through
+// SetMetrics; the package-level functions record into Default.
+type Metrics struct {
 	// Agent metrics - updated on heartbeat
-	RxPackets = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "rx_packets_total",
-			Help:      "Total received packets reported by agent",
-		},
-		[]string{"agent_id"},
-	)
+	RxPackets     *prometheus.GaugeVec
+	TxPackets     *prometheus.GaugeVec
+	RxBytes       *prometheus.GaugeVec
+	TxBytes       *prometheus.GaugeVec
+	DropCount     *prometheus.GaugeVec
+	UptimeSeconds *prometheus.GaugeVec
 
-	TxPackets = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "tx_packets_total",
-			Help:      "Total transmitted packets reported by agent",
-		},
-		[]string{"agent_id"},
-	)
+	// Event counters from RingBuf
+	AnomalyEvents     *prometheus.CounterVec
+	LargePacketEvents *prometheus.CounterVec
 
-	RxBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "rx_bytes_total",
-			Help:      "Total received bytes reported by agent",
-		},
-		[]string{"agent_id"},
-	)
+	// Backend metrics
+	HeartbeatTotal    *prometheus.CounterVec
+	HeartbeatDuration *prometheus.HistogramVec
+	SuspiciousMetrics *prometheus.CounterVec
+	ActiveAgents      prometheus.Gauge
 
-	TxBytes = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "tx_bytes_total",
-			Help:      "Total transmitted bytes reported by agent",
-		},
-		[]string{"agent_id"},
-	)
+	// HTTP payload sizes, labelled by matched route pattern
+	HTTPRequestBytes  *prometheus.HistogramVec
+	HTTPResponseBytes *prometheus.HistogramVec
+	CommandDelivery   *prometheus.HistogramVec
 
-	DropCount = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "drop_count_total",
-			Help:      "Total dropped packets reported by agent",
-		},
-		[]string{"agent_id"},
-	)
+	// Cost metrics
+	RecommendationSavings *prometheus.GaugeVec
+	CostDataAge           *prometheus.GaugeVec
+	ProviderCostUSD       *prometheus.GaugeVec
+	LastCostSyncTimestamp *prometheus.GaugeVec
 
-	UptimeSeconds = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "uptime_seconds",
-			Help:      "Agent uptime in seconds",
-		},
-		[]string{"agent_id"},
-	)
+	registerer prometheus.Registerer
+	handler    http.Handler
 
-	// Event counters from RingBuf
-	AnomalyEvents = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "sennet",
-			Name:      "anomaly_events_total",
-			Help:      "Total anomaly events detected by eBPF",
-		},
-		[]string{"agent_id"},
-	)
+	ceiling     atomic.Uint64
+	lastMu      sync.Mutex
+	lastSamples map[string]agentSample   // See plausible
+	lastTotals  map[eventTotalKey]uint64 // See addEventTotal
 
-	LargePacketEvents = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "sennet",
-			Name:      "large_packet_events_total",
-			Help:      "Total large packet events detected by eBPF",
-		},
-		[]string{"agent_id"},
-	)
+	removedMu   sync.RWMutex
+	removedNext uint64
+	removedFns  map[uint64]func(agentID string) // See OnAgentRemoved
+}
 
-	// Backend metrics
-	HeartbeatTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "sennet",
-			Name:      "heartbeat_total",
-			Help:      "Total heartbeat requests received",
-		},
-		[]string{"agent_id"},
-	)
+// eventTotalKey identifies one agent's cumulative total of one event kind
+type eventTotalKey struct {
+	agentID string
+	counter *prometheus.CounterVec
+}
+
+// NewMetrics creates a set of collectors registered into reg, along with a
+// handler serving them. Scrapers that accept application/openmetrics-text get
+// OpenMetrics, including exemplars and _created samples. reg should also be a
+// prometheus.Gatherer, as *prometheus.Registry is; otherwise the handler
+// serves the default gatherer. Registering two sets into one registry panics.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, http.Handler) {
+	m := newMetrics()
+	m.register(reg)
+	return m, m.handler
+}
+
+// newMetrics creates a set of collectors without registering them
+func newMetrics() *Metrics {
+	m := &Metrics{
+		// Agent metrics - updated on heartbeat
+		RxPackets: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "rx_packets_total",
+				Help:      "Total received packets reported by agent",
+			},
+			[]string{"agent_id"},
+		),
+
+		TxPackets: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "tx_packets_total",
+				Help:      "Total transmitted packets reported by agent",
+			},
+			[]string{"agent_id"},
+		),
+
+		RxBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "rx_bytes_total",
+				Help:      "Total received bytes reported by agent",
+			},
+			[]string{"agent_id"},
+		),
+
+		TxBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "tx_bytes_total",
+				Help:      "Total transmitted bytes reported by agent",
+			},
+			[]string{"agent_id"},
+		),
+
+		DropCount: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "drop_count_total",
+				Help:      "Total dropped packets reported by agent",
+			},
+			[]string{"agent_id"},
+		),
+
+		UptimeSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "uptime_seconds",
+				Help:      "Agent uptime in seconds",
+			},
+			[]string{"agent_id"},
+		),
+
+		// Event counters from RingBuf
+		AnomalyEvents: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sennet",
+				Name:      "anomaly_events_total",
+				Help:      "Total anomaly events detected by eBPF",
+			},
+			[]string{"agent_id"},
+		),
+
+		LargePacketEvents: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sennet",
+				Name:      "large_packet_events_total",
+				Help:      "Total large packet events detected by eBPF",
+			},
+			[]string{"agent_id"},
+		),
+
+		// Backend metrics
+		HeartbeatTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sennet",
+				Name:      "heartbeat_total",
+				Help:      "Total heartbeat requests received",
+			},
+			[]string{"agent_id"},
+		),
+
+		HeartbeatDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sennet",
+				Name:      "heartbeat_duration_seconds",
+				Help:      "Time taken to handle a heartbeat, by the command returned",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms to ~8s
+			},
+			[]string{"command"},
+		),
+
+		SuspiciousMetrics: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "sennet",
+				Name:      "suspicious_metrics_total",
+				Help:      "Agent metric reports rejected as implausible instead of updating the gauges",
+			},
+			[]string{"agent_id"},
+		),
+
+		ActiveAgents: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "active_agents",
+				Help:      "Number of agents that sent heartbeat in last 5 minutes",
+			},
+		),
 
-	ActiveAgents = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Namespace: "sennet",
-			Name:      "active_agents",
-			Help:      "Number of agents that sent heartbeat in last 5 minutes",
-		},
+		// HTTP payload sizes, labelled by matched route pattern
+		HTTPRequestBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sennet",
+				Name:      "http_request_bytes",
+				Help:      "Size of HTTP request bodies read by handlers",
+				Buckets:   prometheus.ExponentialBuckets(64, 4, 10), // 64B to 16MiB
+			},
+			[]string{"route"},
+		),
+
+		HTTPResponseBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sennet",
+				Name:      "http_response_bytes",
+				Help:      "Size of HTTP response bodies written",
+				Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+			},
+			[]string{"route"},
+		),
+
+		CommandDelivery: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "sennet",
+				Name:      "command_delivery_seconds",
+				Help:      "Time from queuing a command to an agent picking it up on heartbeat",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 12), // 1s to ~34m
+			},
+			[]string{"command"},
+		),
+
+		// Cost metrics
+		RecommendationSavings: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "recommendation_savings_usd",
+				Help:      "Estimated savings of stored recommendations by type and status",
+			},
+			[]string{"type", "status"},
+		),
+
+		CostDataAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "cost_data_age_seconds",
+				Help:      "Seconds since costs were last synced from each cloud config (+Inf if never synced)",
+			},
+			[]string{"provider"},
+		),
+		ProviderCostUSD: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "provider_cost_usd",
+				Help:      "Cost over the last 30 days by provider and service, refreshed after each cost sync",
+			},
+			[]string{"provider", "service"},
+		),
+
+		LastCostSyncTimestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "sennet",
+				Name:      "last_cost_sync_timestamp_seconds",
+				Help:      "Unix time costs were last synced from each cloud config (absent if never synced)",
+			},
+			[]string{"cloud_id"},
+		),
+
+		lastSamples: make(map[string]agentSample),
+		lastTotals:  make(map[eventTotalKey]uint64),
+	}
+	m.ceiling.Store(DefaultMetricCeiling)
+	return m
+}
+
+// register adds m's collectors to reg and builds the handler serving them
+func (m *Metrics) register(reg prometheus.Registerer) {
+	m.registerer = reg
+	reg.MustRegister(
+		m.RxPackets,
+		m.TxPackets,
+		m.RxBytes,
+		m.TxBytes,
+		m.DropCount,
+		m.UptimeSeconds,
+		m.AnomalyEvents,
+		m.LargePacketEvents,
+		m.HeartbeatTotal,
+		m.HeartbeatDuration,
+		m.SuspiciousMetrics,
+		m.ActiveAgents,
+		m.HTTPRequestBytes,
+		m.HTTPResponseBytes,
+		m.CommandDelivery,
+		m.RecommendationSavings,
+		m.CostDataAge,
+		m.ProviderCostUSD,
+		m.LastCostSyncTimestamp,
 	)
 
-	initOnce sync.Once
+	gatherer, ok := reg.(prometheus.Gatherer)
+	if !ok {
+		gatherer = prometheus.DefaultGatherer
+	}
+	m.handler = promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	}))
+}
+
+var (
+	defaultMetrics = newMetrics()
+	defaultOnce    sync.Once
 )
 
-// Init registers all metrics with Prometheus
-func Init() {
-	initOnce.Do(func() {
-		prometheus.MustRegister(
-			RxPackets,
-			TxPackets,
-			RxBytes,
-			TxBytes,
-			DropCount,
-			UptimeSeconds,
-			AnomalyEvents,
-			LargePacketEvents,
-			HeartbeatTotal,
-			ActiveAgents,
-		)
+// Default returns the Metrics the package-level functions and collectors
+// record into. It is registered on the default Prometheus registry on first
+// use. Servers should create their own with NewMetrics and hand it to each
+// handler instead.
+func Default() *Metrics {
+	defaultOnce.Do(func() {
+		defaultMetrics.register(prometheus.DefaultRegisterer)
 	})
+	return defaultMetrics
 }
 
-// Handler returns the Prometheus HTTP handler
-func Handler() http.Handler {
-	return promhttp.Handler()
+// The collectors of Default, from before Metrics existed. They are only
+// registered once Default or Init has been called.
+var (
+	// Deprecated: use Metrics.RxPackets.
+	RxPackets = defaultMetrics.RxPackets
+	// Deprecated: use Metrics.TxPackets.
+	TxPackets = defaultMetrics.TxPackets
+	// Deprecated: use Metrics.RxBytes.
+	RxBytes = defaultMetrics.RxBytes
+	// Deprecated: use Metrics.TxBytes.
+	TxBytes = defaultMetrics.TxBytes
+	// Deprecated: use Metrics.DropCount.
+	DropCount = defaultMetrics.DropCount
+	// Deprecated: use Metrics.UptimeSeconds.
+	UptimeSeconds = defaultMetrics.UptimeSeconds
+	// Deprecated: use Metrics.AnomalyEvents.
+	AnomalyEvents = defaultMetrics.AnomalyEvents
+	// Deprecated: use Metrics.LargePacketEvents.
+	LargePacketEvents = defaultMetrics.LargePacketEvents
+	// Deprecated: use Metrics.HeartbeatTotal.
+	HeartbeatTotal = defaultMetrics.HeartbeatTotal
+	// Deprecated: use Metrics.SuspiciousMetrics.
+	SuspiciousMetrics = defaultMetrics.SuspiciousMetrics
+	// Deprecated: use Metrics.ActiveAgents.
+	ActiveAgents = defaultMetrics.ActiveAgents
+	// Deprecated: use Metrics.HTTPRequestBytes.
+	HTTPRequestBytes = defaultMetrics.HTTPRequestBytes
+	// Deprecated: use Metrics.HTTPResponseBytes.
+	HTTPResponseBytes = defaultMetrics.HTTPResponseBytes
+	// Deprecated: use Metrics.CommandDelivery.
+	CommandDelivery = defaultMetrics.CommandDelivery
+	// Deprecated: use Metrics.RecommendationSavings.
+	RecommendationSavings = defaultMetrics.RecommendationSavings
+	// Deprecated: use Metrics.CostDataAge.
+	CostDataAge = defaultMetrics.CostDataAge
+)
+
+// Init registers the default metrics with Prometheus
+func Init() {
+	Default()
+}
+
+// Register adds extra collectors (such as the job registry) to m's registry
+func (m *Metrics) Register(collectors ...prometheus.Collector) {
+	m.registerer.MustRegister(collectors...)
+}
+
+// Handler returns the HTTP handler serving m's registry
+func (m *Metrics) Handler() http.Handler {
+	return m.handler
+}
+
+// UpdateAgentMetrics updates all metrics for an agent from a report taken
+// at the given time and counts the heartbeat. It reports false when the
+// values were rejected as implausible.
+func (m *Metrics) UpdateAgentMetrics(agentID string, at time.Time, rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) bool {
+	m.HeartbeatTotal.WithLabelValues(agentID).Inc()
+	return m.SetAgentGauges(agentID, at, rxPkts, txPkts, rxBytes, txBytes, drops, uptime)
 }
 
-// UpdateAgentMetrics updates all metrics for an agent
-func UpdateAgentMetrics(agentID string, rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) {
-	RxPackets.WithLabelValues(agentID).Set(float64(rxPkts))
-	TxPackets.WithLabelValues(agentID).Set(float64(txPkts))
-	RxBytes.WithLabelValues(agentID).Set(float64(rxBytes))
-	TxBytes.WithLabelValues(agentID).Set(float64(txBytes))
-	DropCount.WithLabelValues(agentID).Set(float64(drops))
-	UptimeSeconds.WithLabelValues(agentID).Set(float64(uptime))
-	HeartbeatTotal.WithLabelValues(agentID).Inc()
+// SetAgentGauges sets an agent's traffic gauges from a report taken at the
+// given time, without counting a heartbeat. Implausible reports (see
+// plausible) leave the gauges untouched, increment SuspiciousMetrics and
+// return false. Reports older than the last accepted one are plausible but
+// also leave the gauges alone, as they no longer describe the agent.
+func (m *Metrics) SetAgentGauges(agentID string, at time.Time, rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) bool {
+	sample := agentSample{rxPkts, txPkts, rxBytes, txBytes, drops, uptime, at}
+
+	m.lastMu.Lock()
+	prev, seen := m.lastSamples[agentID]
+	if !plausible(sample, prev, seen, m.MetricCeiling()) {
+		m.lastMu.Unlock()
+		m.SuspiciousMetrics.WithLabelValues(agentID).Inc()
+		return false
+	}
+	if seen && at.Before(prev.at) {
+		m.lastMu.Unlock()
+		return true
+	}
+	m.lastSamples[agentID] = sample
+	m.lastMu.Unlock()
+
+	m.RxPackets.WithLabelValues(agentID).Set(float64(rxPkts))
+	m.TxPackets.WithLabelValues(agentID).Set(float64(txPkts))
+	m.RxBytes.WithLabelValues(agentID).Set(float64(rxBytes))
+	m.TxBytes.WithLabelValues(agentID).Set(float64(txBytes))
+	m.DropCount.WithLabelValues(agentID).Set(float64(drops))
+	m.UptimeSeconds.WithLabelValues(agentID).Set(float64(uptime))
+	return true
 }
 
-// RecordAnomalyEvent increments the anomaly counter for an agent
-func RecordAnomalyEvent(agentID string) {
-	AnomalyEvents.WithLabelValues(agentID).Inc()
+// RemoveAgentMetrics drops every series labelled with the agent's ID
+func (m *Metrics) RemoveAgentMetrics(agentID string) {
+	for _, g := range m.agentGauges() {
+		g.DeleteLabelValues(agentID)
+	}
+	for _, c := range []*prometheus.CounterVec{m.AnomalyEvents, m.LargePacketEvents, m.HeartbeatTotal, m.SuspiciousMetrics} {
+		c.DeleteLabelValues(agentID)
+	}
+	m.lastMu.Lock()
+	delete(m.lastSamples, agentID)
+	for _, c := range []*prometheus.CounterVec{m.AnomalyEvents, m.LargePacketEvents} {
+		delete(m.lastTotals, eventTotalKey{agentID, c})
+	}
+	m.lastMu.Unlock()
+
+	m.removedMu.RLock()
+	defer m.removedMu.RUnlock()
+	for _, fn := range m.removedFns {
+		fn(agentID)
+	}
 }
 
-// RecordLargePacketEvent increments the large packet counter for an agent
-func RecordLargePacketEvent(agentID string) {
-	LargePacketEvents.WithLabelValues(agentID).Inc()
+// OnAgentRemoved registers fn to be called with each agent whose series
+// RemoveAgentMetrics drops, so state kept per agent alongside the series
+// (such as the dashboard's fleet totals) goes with them. The returned func
+// unregisters fn; it is safe to call more than once.
+func (m *Metrics) OnAgentRemoved(fn func(agentID string)) (unregister func()) {
+	m.removedMu.Lock()
+	defer m.removedMu.Unlock()
+	if m.removedFns == nil {
+		m.removedFns = make(map[uint64]func(string))
+	}
+	id := m.removedNext
+	m.removedNext++
+	m.removedFns[id] = fn
+	return func() {
+		m.removedMu.Lock()
+		defer m.removedMu.Unlock()
+		delete(m.removedFns, id)
+	}
+}
+
+// agentGauges maps the per-agent gauge names accepted by AgentGaugeValues
+func (m *Metrics) agentGauges() map[string]*prometheus.GaugeVec {
+	return map[string]*prometheus.GaugeVec{
+		"rx_packets":     m.RxPackets,
+		"tx_packets":     m.TxPackets,
+		"rx_bytes":       m.RxBytes,
+		"tx_bytes":       m.TxBytes,
+		"drop_count":     m.DropCount,
+		"uptime_seconds": m.UptimeSeconds,
+	}
+}
+
+// AgentGaugeValues returns the latest value of a per-agent gauge (such as
+// "drop_count") keyed by agent ID. ok is false for unknown metric names.
+func (m *Metrics) AgentGaugeValues(metric string) (values map[string]float64, ok bool) {
+	gauge, ok := m.agentGauges()[metric]
+	if !ok {
+		return nil, false
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		gauge.Collect(ch)
+		close(ch)
+	}()
+
+	values = make(map[string]float64)
+	for metric := range ch {
+		var pb dto.Metric
+		if err := metric.Write(&pb); err != nil {
+			continue
+		}
+		for _, label := range pb.GetLabel() {
+			if label.GetName() == "agent_id" {
+				values[label.GetValue()] = pb.GetGauge().GetValue()
+			}
+		}
+	}
+	return values, true
+}
+
+// AgentSeriesIDs returns the agent IDs that have a series in any per-agent gauge
+func (m *Metrics) AgentSeriesIDs() map[string]bool {
+	ids := make(map[string]bool)
+	for name := range m.agentGauges() {
+		values, _ := m.AgentGaugeValues(name)
+		for id := range values {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// RecordAnomalyEvent increments the anomaly counter for an agent. A
+// non-empty traceID is attached as an exemplar.
+func (m *Metrics) RecordAnomalyEvent(agentID, traceID string) {
+	incWithTrace(m.AnomalyEvents.WithLabelValues(agentID), traceID)
+}
+
+// RecordLargePacketEvent increments the large packet counter for an agent. A
+// non-empty traceID is attached as an exemplar.
+func (m *Metrics) RecordLargePacketEvent(agentID, traceID string) {
+	incWithTrace(m.LargePacketEvents.WithLabelValues(agentID), traceID)
+}
+
+// EventBaselineUptime is the longest uptime, in seconds, at which an agent's
+// first reported event totals are counted in full. An agent up longer has
+// most likely reported them to this server before it restarted, so they
+// become the baseline instead. It is twice the agent's default heartbeat
+// interval.
+const EventBaselineUptime uint64 = 60
+
+// RecordAnomalyTotal advances the anomaly counter to an agent's cumulative
+// anomaly total, see addEventTotal
+func (m *Metrics) RecordAnomalyTotal(agentID string, total, uptime uint64) {
+	m.addEventTotal(m.AnomalyEvents, agentID, total, uptime)
+}
+
+// RecordLargePacketTotal advances the large packet counter to an agent's
+// cumulative large packet total, see addEventTotal
+func (m *Metrics) RecordLargePacketTotal(agentID string, total, uptime uint64) {
+	m.addEventTotal(m.LargePacketEvents, agentID, total, uptime)
+}
+
+// addEventTotal increments the agent's counter by how far total has moved
+// since its last report. A total below the last one means the agent
+// restarted and its count began again from zero, so all of it is new. The
+// first total seen from an agent is only counted if the agent's uptime is
+// within EventBaselineUptime; otherwise it is the baseline for later reports,
+// so a server restart doesn't add every agent's lifetime count at once.
+func (m *Metrics) addEventTotal(counter *prometheus.CounterVec, agentID string, total, uptime uint64) {
+	key := eventTotalKey{agentID, counter}
+	m.lastMu.Lock()
+	prev, seen := m.lastTotals[key]
+	m.lastTotals[key] = total
+	m.lastMu.Unlock()
+
+	var delta uint64
+	switch {
+	case !seen && uptime > EventBaselineUptime:
+		delta = 0
+	case !seen || total < prev:
+		delta = total
+	default:
+		delta = total - prev
+	}
+	if delta > 0 {
+		counter.WithLabelValues(agentID).Add(float64(delta))
+	}
+}
+
+func incWithTrace(c prometheus.Counter, traceID string) {
+	if traceID == "" {
+		c.Inc()
+		return
+	}
+	c.(prometheus.ExemplarAdder).AddWithExemplar(1, prometheus.Labels{"trace_id": traceID})
+}
+
+// ObserveCommandDelivery records how long a queued command waited for delivery
+func (m *Metrics) ObserveCommandDelivery(command string, latency time.Duration) {
+	m.CommandDelivery.WithLabelValues(command).Observe(latency.Seconds())
+}
+
+// ObserveHeartbeat records how long a heartbeat took, labelled by the
+// command it returned
+func (m *Metrics) ObserveHeartbeat(command string, duration time.Duration) {
+	m.HeartbeatDuration.WithLabelValues(command).Observe(duration.Seconds())
 }
 
 // SetActiveAgents sets the number of active agents
-func SetActiveAgents(count int) {
-	ActiveAgents.Set(float64(count))
+func (m *Metrics) SetActiveAgents(count int) {
+	m.ActiveAgents.Set(float64(count))
+}
+
+// SetRecommendationSavings replaces the recommendation savings gauge with the
+// given totals, keyed by recommendation type then status
+func (m *Metrics) SetRecommendationSavings(savings map[string]map[string]float64) {
+	m.RecommendationSavings.Reset()
+	for recType, byStatus := range savings {
+		for status, total := range byStatus {
+			m.RecommendationSavings.WithLabelValues(recType, status).Set(total)
+		}
+	}
+}
+
+// SetCostDataAge replaces the cost data age gauge with the given ages, keyed
+// by cloud config ID
+func (m *Metrics) SetCostDataAge(ages map[string]float64) {
+	m.CostDataAge.Reset()
+	for provider, age := range ages {
+		m.CostDataAge.WithLabelValues(provider).Set(age)
+	}
+}
+
+// SetProviderCosts replaces the provider cost gauge with the given totals,
+// keyed by provider then service
+func (m *Metrics) SetProviderCosts(costs map[string]map[string]float64) {
+	m.ProviderCostUSD.Reset()
+	for provider, byService := range costs {
+		for service, total := range byService {
+			m.ProviderCostUSD.WithLabelValues(provider, service).Set(total)
+		}
+	}
+}
+
+// SetLastCostSync replaces the last cost sync gauge with the given times,
+// keyed by cloud config ID
+func (m *Metrics) SetLastCostSync(times map[string]time.Time) {
+	m.LastCostSyncTimestamp.Reset()
+	for cloudID, syncedAt := range times {
+		m.LastCostSyncTimestamp.WithLabelValues(cloudID).Set(float64(syncedAt.UnixNano()) / 1e9)
+	}
+}
+
+// ObserveHTTPBytes records the request and response body sizes for a route
+func (m *Metrics) ObserveHTTPBytes(route string, requestBytes, responseBytes int64) {
+	m.HTTPRequestBytes.WithLabelValues(route).Observe(float64(requestBytes))
+	m.HTTPResponseBytes.WithLabelValues(route).Observe(float64(responseBytes))
+}
+
+// The functions below record into Default
+
+// Register adds extra collectors to the default registry
+func Register(collectors ...prometheus.Collector) { Default().Register(collectors...) }
+
+// Handler returns the HTTP handler serving the default metrics
+func Handler() http.Handler { return Default().Handler() }
+
+func UpdateAgentMetrics(agentID string, rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) bool {
+	return Default().UpdateAgentMetrics(agentID, time.Now(), rxPkts, txPkts, rxBytes, txBytes, drops, uptime)
+}
+
+func SetAgentGauges(agentID string, rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64) bool {
+	return Default().SetAgentGauges(agentID, time.Now(), rxPkts, txPkts, rxBytes, txBytes, drops, uptime)
+}
+
+func RemoveAgentMetrics(agentID string) { Default().RemoveAgentMetrics(agentID) }
+
+func AgentGaugeValues(metric string) (map[string]float64, bool) {
+	return Default().AgentGaugeValues(metric)
+}
+
+func AgentSeriesIDs() map[string]bool { return Default().AgentSeriesIDs() }
+
+func RecordAnomalyEvent(agentID, traceID string) { Default().RecordAnomalyEvent(agentID, traceID) }
+
+func RecordLargePacketEvent(agentID, traceID string) {
+	Default().RecordLargePacketEvent(agentID, traceID)
+}
+
+func ObserveCommandDelivery(command string, latency time.Duration) {
+	Default().ObserveCommandDelivery(command, latency)
+}
+
+func ObserveHeartbeat(command string, duration time.Duration) {
+	Default().ObserveHeartbeat(command, duration)
+}
+
+func SetActiveAgents(count int) { Default().SetActiveAgents(count) }
+
+func SetRecommendationSavings(savings map[string]map[string]float64) {
+	Default().SetRecommendationSavings(savings)
+}
+
+func SetCostDataAge(ages map[string]float64) { Default().SetCostDataAge(ages) }
+
+func SetProviderCosts(costs map[string]map[string]float64) { Default().SetProviderCosts(costs) }
+
+func SetLastCostSync(times map[string]time.Time) { Default().SetLastCostSync(times) }
+
+func ObserveHTTPBytes(route string, requestBytes, responseBytes int64) {
+	Default().ObserveHTTPBytes(route, requestBytes, responseBytes)
 }
@@ -0,0 +1,141 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func TestHandler_OpenMetricsExemplars(t *testing.T) {
+	metrics.Init()
+	metrics.RecordAnomalyEvent("exemplar-agent", "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	metrics.Handler().ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("Expected OpenMetrics content type, got %q", ct)
+	}
+	body := rec.Body.String()
+	var line string
+	for _, l := range strings.Split(body, "\n") {
+		if strings.HasPrefix(l, `sennet_anomaly_events_total{agent_id="exemplar-agent"}`) {
+			line = l
+		}
+	}
+	if !strings.Contains(line, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 1`) {
+		t.Errorf("Expected an exemplar on the anomaly counter, got %q", line)
+	}
+	if !strings.Contains(body, `sennet_anomaly_events_created{agent_id="exemplar-agent"}`) {
+		t.Error("Expected a _created sample for the anomaly counter")
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Error("Expected the OpenMetrics # EOF terminator")
+	}
+}
+
+func TestHandler_DefaultTextFormat(t *testing.T) {
+	metrics.Init()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected the Prometheus text format by default, got %q", ct)
+	}
+}
+
+func TestRemoveAgentMetrics_DropsSeries(t *testing.T) {
+	metrics.Init()
+	metrics.UpdateAgentMetrics("removed-agent", 10, 10, 100, 100, 1, 60)
+	metrics.RecordAnomalyEvent("removed-agent", "")
+
+	if !hasAgentSeries(t, "removed-agent") {
+		t.Fatal("Expected series for removed-agent before removal")
+	}
+	metrics.RemoveAgentMetrics("removed-agent")
+	if hasAgentSeries(t, "removed-agent") {
+		t.Error("Expected no series for removed-agent after removal")
+	}
+}
+
+// hasAgentSeries reports whether any gathered metric carries the agent's ID
+func hasAgentSeries(t *testing.T, agentID string) bool {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "agent_id" && label.GetValue() == agentID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func TestNewMetrics_IndependentRegistries(t *testing.T) {
+	first, firstHandler := metrics.NewMetrics(prometheus.NewRegistry())
+	second, secondHandler := metrics.NewMetrics(prometheus.NewRegistry())
+
+	first.UpdateAgentMetrics("first-agent", time.Now(), 1, 1, 1, 1, 0, 60)
+	second.UpdateAgentMetrics("second-agent", time.Now(), 2, 2, 2, 2, 0, 60)
+	second.SetMetricCeiling(10)
+	if first.MetricCeiling() != metrics.DefaultMetricCeiling {
+		t.Errorf("Expected the first ceiling untouched, got %d", first.MetricCeiling())
+	}
+
+	scrape := func(h http.Handler) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	firstBody, secondBody := scrape(firstHandler), scrape(secondHandler)
+	if !strings.Contains(firstBody, `agent_id="first-agent"`) || strings.Contains(firstBody, `agent_id="second-agent"`) {
+		t.Errorf("Expected the first registry to hold only first-agent, got:\n%s", firstBody)
+	}
+	if !strings.Contains(secondBody, `agent_id="second-agent"`) || strings.Contains(secondBody, `agent_id="first-agent"`) {
+		t.Errorf("Expected the second registry to hold only second-agent, got:\n%s", secondBody)
+	}
+	if ids := metrics.AgentSeriesIDs(); ids["first-agent"] || ids["second-agent"] {
+		t.Errorf("Expected the default metrics untouched, got %v", ids)
+	}
+}
+
+func TestObserveHeartbeat_LabelledByCommand(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, _ := metrics.NewMetrics(reg)
+	m.ObserveHeartbeat("COMMAND_UPGRADE", 30*time.Millisecond)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "sennet_heartbeat_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			label := metric.GetLabel()[0]
+			if label.GetName() != "command" || label.GetValue() != "COMMAND_UPGRADE" {
+				t.Errorf("Unexpected label %s=%q", label.GetName(), label.GetValue())
+			}
+			if h := metric.GetHistogram(); h.GetSampleCount() != 1 || h.GetSampleSum() != 0.03 {
+				t.Errorf("Expected one 30ms sample, got %v", h)
+			}
+		}
+		return
+	}
+	t.Error("Expected sennet_heartbeat_duration_seconds in the gathered metrics")
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import "time"
+
+// DefaultMetricCeiling is the largest counter value accepted from an agent.
+// 2^53 is where float64 gauges stop representing integers exactly; anything
+// above it is far beyond what a real interface can have counted.
+const DefaultMetricCeiling uint64 = 1 << 53
+
+// SetMetricCeiling sets the largest counter value accepted from an agent.
+// Zero restores DefaultMetricCeiling.
+func (m *Metrics) SetMetricCeiling(ceiling uint64) {
+	if ceiling == 0 {
+		ceiling = DefaultMetricCeiling
+	}
+	m.ceiling.Store(ceiling)
+}
+
+// MetricCeiling returns the largest counter value accepted from an agent
+func (m *Metrics) MetricCeiling() uint64 {
+	return m.ceiling.Load()
+}
+
+// SetMetricCeiling sets the default metrics' ceiling
+func SetMetricCeiling(ceiling uint64) { Default().SetMetricCeiling(ceiling) }
+
+// MetricCeiling returns the default metrics' ceiling
+func MetricCeiling() uint64 { return Default().MetricCeiling() }
+
+// agentSample is the last accepted report from an agent
+type agentSample struct {
+	rxPkts, txPkts, rxBytes, txBytes, drops, uptime uint64
+	at                                              time.Time // When the agent took it
+}
+
+// plausible reports whether sample is believable given the agent's latest
+// accepted report. Values above ceiling are rejected, as are counters that
+// went backwards while uptime kept increasing (an agent restart, signalled by
+// uptime going backwards, legitimately resets them). Samples older than prev
+// are only checked against the ceiling, as a restart may lie between them.
+// An uptime of 0 is taken as not reported rather than as a restart.
+func plausible(sample, prev agentSample, seen bool, ceiling uint64) bool {
+	for _, v := range []uint64{sample.rxPkts, sample.txPkts, sample.rxBytes, sample.txBytes, sample.drops} {
+		if v > ceiling {
+			return false
+		}
+	}
+	if !seen || sample.at.Before(prev.at) {
+		return true
+	}
+	if sample.uptime != 0 && sample.uptime < prev.uptime {
+		return true
+	}
+	return sample.rxPkts >= prev.rxPkts &&
+		sample.txPkts >= prev.txPkts &&
+		sample.rxBytes >= prev.rxBytes &&
+		sample.txBytes >= prev.txBytes &&
+		sample.drops >= prev.drops
+}
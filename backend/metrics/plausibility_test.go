@@ -0,0 +1,94 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+func suspiciousCount(t *testing.T, agentID string) float64 {
+	t.Helper()
+	var pb dto.Metric
+	if err := metrics.SuspiciousMetrics.WithLabelValues(agentID).Write(&pb); err != nil {
+		t.Fatalf("Failed to read counter: %v", err)
+	}
+	return pb.GetCounter().GetValue()
+}
+
+func rxBytes(t *testing.T, agentID string) float64 {
+	t.Helper()
+	values, _ := metrics.AgentGaugeValues("rx_bytes")
+	return values[agentID]
+}
+
+func TestSetAgentGauges_Plausibility(t *testing.T) {
+	metrics.SetMetricCeiling(1 << 40)
+	t.Cleanup(func() { metrics.SetMetricCeiling(0) })
+
+	const agent = "plausibility-agent"
+	t.Cleanup(func() { metrics.RemoveAgentMetrics(agent) })
+
+	if !metrics.SetAgentGauges(agent, 10, 10, 5000, 5000, 0, 60) {
+		t.Fatal("Expected a normal report to be accepted")
+	}
+	if got := rxBytes(t, agent); got != 5000 {
+		t.Errorf("Expected rx_bytes 5000, got %v", got)
+	}
+
+	if metrics.SetAgentGauges(agent, 10, 10, 1<<41, 5000, 0, 120) {
+		t.Error("Expected a value above the ceiling to be rejected")
+	}
+	if metrics.SetAgentGauges(agent, 10, 10, 100, 5000, 0, 120) {
+		t.Error("Expected rx_bytes going backwards without a restart to be rejected")
+	}
+	if got := rxBytes(t, agent); got != 5000 {
+		t.Errorf("Expected rejected reports to leave rx_bytes at 5000, got %v", got)
+	}
+	if got := suspiciousCount(t, agent); got != 2 {
+		t.Errorf("Expected 2 suspicious reports, got %v", got)
+	}
+
+	// Uptime going backwards means the agent restarted, so counters reset
+	if !metrics.SetAgentGauges(agent, 1, 1, 100, 100, 0, 5) {
+		t.Error("Expected a report after a restart to be accepted")
+	}
+	if got := rxBytes(t, agent); got != 100 {
+		t.Errorf("Expected rx_bytes 100 after restart, got %v", got)
+	}
+}
+
+func TestSetAgentGauges_OrdersByTimestamp(t *testing.T) {
+	m, _ := metrics.NewMetrics(prometheus.NewRegistry())
+	const agent = "ordered-agent"
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	rx := func() float64 {
+		values, _ := m.AgentGaugeValues("rx_bytes")
+		return values[agent]
+	}
+
+	if !m.SetAgentGauges(agent, now, 10, 10, 5000, 5000, 0, 600) {
+		t.Fatal("Expected a normal report to be accepted")
+	}
+	// A backfilled sample from before the live one, with no uptime
+	if !m.SetAgentGauges(agent, now.Add(-time.Hour), 1, 1, 100, 100, 0, 0) {
+		t.Error("Expected an older sample to be accepted for the history")
+	}
+	if got := rx(); got != 5000 {
+		t.Errorf("Expected the older sample to leave rx_bytes at 5000, got %v", got)
+	}
+
+	// A newer sample without uptime isn't taken for a restart
+	if m.SetAgentGauges(agent, now.Add(time.Minute), 1, 1, 100, 100, 0, 0) {
+		t.Error("Expected counters going backwards with no uptime to be rejected")
+	}
+	if !m.SetAgentGauges(agent, now.Add(2*time.Minute), 12, 12, 6000, 6000, 0, 0) {
+		t.Error("Expected a newer increasing sample to be accepted")
+	}
+	if got := rx(); got != 6000 {
+		t.Errorf("Expected rx_bytes 6000, got %v", got)
+	}
+}
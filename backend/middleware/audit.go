@@ -2,31 +2,53 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/sennet/sennet/backend/asyncwrite"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/logging"
 )
 
 // AuditLog represents an audit log entry
 type AuditLog struct {
-	Timestamp  time.Time
-	UserID     string
-	Email      string
-	Method     string
-	Path       string
-	StatusCode int
-	Duration   time.Duration
-	IP         string
-	UserAgent  string
+	Timestamp  time.Time     `json:"timestamp"`
+	UserID     string        `json:"user_id"`
+	Email      string        `json:"email"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	StatusCode int           `json:"status_code"`
+	Duration   time.Duration `json:"duration_ns"`
+	IP         string        `json:"ip"`
+	UserAgent  string        `json:"user_agent"`
+	Action     string        `json:"action,omitempty"` // Set by handlers recording a sensitive operation, e.g. "cloud_config.reveal"
 }
 
 // AuditLogger is a function type for logging audit events
 type AuditLogger func(log AuditLog)
 
-// DefaultAuditLogger logs to standard logger
+// DefaultAuditLogger logs to standard logger. Sensitive actions are logged
+// as warnings so they stand out.
 func DefaultAuditLogger() AuditLogger {
 	return func(entry AuditLog) {
-		log.Printf("AUDIT user=%s email=%s method=%s path=%s status=%d duration=%s ip=%s",
+		if entry.Action != "" {
+			logging.Warnf("AUDIT sensitive action=%s user=%s email=%s method=%s path=%s ip=%s",
+				entry.Action,
+				entry.UserID,
+				entry.Email,
+				entry.Method,
+				entry.Path,
+				entry.IP,
+			)
+			return
+		}
+		logging.Infof("AUDIT user=%s email=%s method=%s path=%s status=%d duration=%s ip=%s",
 			entry.UserID,
 			entry.Email,
 			entry.Method,
@@ -38,12 +60,164 @@ func DefaultAuditLogger() AuditLogger {
 	}
 }
 
+// JSONAuditLogger writes each entry to w as one JSON object per line. Writes
+// are serialized, so w needn't be safe for concurrent use.
+func JSONAuditLogger(w io.Writer) AuditLogger {
+	var mu sync.Mutex
+	return func(entry AuditLog) {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			logging.Errorf("Failed to encode audit entry: %v", err)
+			return
+		}
+		line = append(line, '\n')
+
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := w.Write(line); err != nil {
+			logging.Errorf("Failed to write audit entry: %v", err)
+		}
+	}
+}
+
+// RotatingFile is an append-only file that is renamed aside, with a UTC
+// timestamp suffix, and started afresh once a write would take it past
+// maxBytes. It is safe for concurrent use.
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens (or creates) path for appending
+func OpenRotatingFile(path string, maxBytes int64) (*RotatingFile, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("max size must be positive")
+	}
+	rf := &RotatingFile{path: path, maxBytes: maxBytes}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file, rf.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if the file would exceed its maximum. A
+// single write larger than the maximum still goes to a file of its own.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("failed to rotate %s: %w", rf.path, err)
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	rotated := rf.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(rf.path, rotated); err != nil {
+		// Keep appending to the oversized file rather than losing entries
+		return errors.Join(err, rf.open())
+	}
+	return rf.open()
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// RotatingFileAuditLogger writes JSON lines (see JSONAuditLogger) to path,
+// rotating it at maxSizeMB megabytes. Close the returned file on shutdown.
+func RotatingFileAuditLogger(path string, maxSizeMB int) (AuditLogger, *RotatingFile, error) {
+	rf, err := OpenRotatingFile(path, int64(maxSizeMB)<<20)
+	if err != nil {
+		return nil, nil, err
+	}
+	return JSONAuditLogger(rf), rf, nil
+}
+
+// DefaultAuditFlushInterval is how often DBAuditLogger writes buffered
+// entries to the database
+const DefaultAuditFlushInterval = time.Second
+
+// DBAuditLogger stores entries in the audit_logs table. Entries are buffered
+// and written in batches off the request path, so a slow database doesn't
+// hold up responses; drain the returned writer on shutdown to flush the rest.
+func DBAuditLogger(database *db.DB) (AuditLogger, *asyncwrite.Writer[db.AuditLog]) {
+	return BufferedDBAuditLogger(database, 1000, DefaultAuditFlushInterval)
+}
+
+// BufferedDBAuditLogger is DBAuditLogger with a buffer of size entries,
+// flushed every interval. Entries arriving while the buffer is full are
+// dropped with a warning.
+func BufferedDBAuditLogger(database *db.DB, size int, interval time.Duration) (AuditLogger, *asyncwrite.Writer[db.AuditLog]) {
+	writer := asyncwrite.NewBatching("audit log", size, interval, database.SaveAuditLogs)
+	return func(entry AuditLog) {
+		writer.Write(db.AuditLog(entry))
+	}, writer
+}
+
+// MultiAuditLogger sends each entry to every logger in turn
+func MultiAuditLogger(loggers ...AuditLogger) AuditLogger {
+	return func(entry AuditLog) {
+		for _, logger := range loggers {
+			logger(entry)
+		}
+	}
+}
+
+// ClientIP returns the client address recorded in audit entries
+func ClientIP(r *http.Request) string {
+	return getClientIP(r)
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type auditResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *auditResponseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
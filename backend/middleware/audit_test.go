@@ -0,0 +1,195 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func sampleAuditLog() middleware.AuditLog {
+	return middleware.AuditLog{
+		Timestamp:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		UserID:     "uid-1",
+		Email:      "ops@example.com",
+		Method:     "POST",
+		Path:       "/api/clouds",
+		StatusCode: 201,
+		Duration:   1500 * time.Millisecond,
+		IP:         "10.0.0.1",
+		UserAgent:  "curl/8.0",
+		Action:     "cloud_config.create",
+	}
+}
+
+func TestJSONAuditLogger_WritesAllFields(t *testing.T) {
+	var buf bytes.Buffer
+	middleware.JSONAuditLogger(&buf)(sampleAuditLog())
+
+	line := buf.Bytes()
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		t.Fatalf("Expected one newline-terminated line, got %q", line)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		t.Fatalf("Expected valid JSON, got %v: %s", err, line)
+	}
+	want := map[string]interface{}{
+		"timestamp":   "2024-03-01T12:00:00Z",
+		"user_id":     "uid-1",
+		"email":       "ops@example.com",
+		"method":      "POST",
+		"path":        "/api/clouds",
+		"status_code": float64(201),
+		"duration_ns": float64(1500 * time.Millisecond),
+		"ip":          "10.0.0.1",
+		"user_agent":  "curl/8.0",
+		"action":      "cloud_config.create",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, fields[k])
+		}
+	}
+
+	// Ordinary requests omit the action
+	buf.Reset()
+	entry := sampleAuditLog()
+	entry.Action = ""
+	middleware.JSONAuditLogger(&buf)(entry)
+	if bytes.Contains(buf.Bytes(), []byte(`"action"`)) {
+		t.Errorf("Expected no action field, got %s", buf.Bytes())
+	}
+}
+
+func TestRotatingFile_RotatesAfterThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	line, _ := json.Marshal(sampleAuditLog())
+	line = append(line, '\n')
+
+	// Room for three entries per file
+	rf, err := middleware.OpenRotatingFile(path, int64(3*len(line)))
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	defer rf.Close()
+	logger := middleware.JSONAuditLogger(rf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger(sampleAuditLog())
+		}()
+	}
+	wg.Wait()
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(files) < 4 {
+		t.Fatalf("Expected at least 4 files for 10 entries of 3 per file, got %v", files)
+	}
+	total := 0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if len(data) > 3*len(line) {
+			t.Errorf("%s exceeds the threshold: %d bytes", f, len(data))
+		}
+		for _, l := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			if !json.Valid(l) {
+				t.Errorf("%s: interleaved or corrupt line %q", f, l)
+			}
+			total++
+		}
+	}
+	if total != 10 {
+		t.Errorf("Expected 10 entries across files, got %d", total)
+	}
+}
+
+func TestRotatingFileAuditLogger_AppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, rf, err := middleware.RotatingFileAuditLogger(path, 1)
+	if err != nil {
+		t.Fatalf("RotatingFileAuditLogger failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		entry := sampleAuditLog()
+		entry.Path = fmt.Sprintf("/api/costs/%d", i)
+		logger(entry)
+	}
+	rf.Close()
+
+	data, _ := os.ReadFile(path)
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Errorf("Expected 3 JSON lines under 1MB in one file, got %d", lines)
+	}
+}
+
+func openAuditDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestDBAuditLogger_BuffersUntilDrain(t *testing.T) {
+	database := openAuditDB(t)
+	logger, writer := middleware.BufferedDBAuditLogger(database, 10, time.Hour)
+
+	logger(sampleAuditLog())
+	logger(sampleAuditLog())
+	if saved, _ := database.GetAuditLogs(db.AuditLogFilter{}); len(saved) != 0 {
+		t.Fatalf("Expected entries held in the buffer, got %d saved", len(saved))
+	}
+
+	if lost := writer.Drain(context.Background()); lost != 0 {
+		t.Errorf("Expected nothing lost on drain, got %d", lost)
+	}
+	saved, err := database.GetAuditLogs(db.AuditLogFilter{})
+	if err != nil || len(saved) != 2 {
+		t.Fatalf("Expected 2 entries after drain, got %d (%v)", len(saved), err)
+	}
+	if saved[0].Action != "cloud_config.create" || saved[0].Duration != 1500*time.Millisecond {
+		t.Errorf("Entry did not round-trip: %+v", saved[0])
+	}
+}
+
+func TestDBAuditLogger_FlushesOnInterval(t *testing.T) {
+	database := openAuditDB(t)
+	logger, writer := middleware.BufferedDBAuditLogger(database, 10, 10*time.Millisecond)
+	defer writer.Drain(context.Background())
+
+	logger(sampleAuditLog())
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		saved, err := database.GetAuditLogs(db.AuditLogFilter{UserID: "uid-1"})
+		if err != nil {
+			t.Fatalf("GetAuditLogs failed: %v", err)
+		}
+		if len(saved) == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the entry to be flushed by the timer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -4,6 +4,7 @@ package middleware
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -39,9 +40,12 @@ func (a *AuthInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
 		if !valid {
 			return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("invalid API key"))
 		}
+		if err := a.authorize(apiKey, req.Spec().Procedure); err != nil {
+			return nil, err
+		}
 
 		// Key is valid, proceed with request
-		return next(ctx, req)
+		return next(context.WithValue(ctx, APIKeyContextKey, apiKey), req)
 	}
 }
 
@@ -68,9 +72,24 @@ func (a *AuthInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc
 		if !valid {
 			return connect.NewError(connect.CodeUnauthenticated, errors.New("invalid API key"))
 		}
+		if err := a.authorize(apiKey, conn.Spec().Procedure); err != nil {
+			return err
+		}
+
+		return next(context.WithValue(ctx, APIKeyContextKey, apiKey), conn)
+	}
+}
 
-		return next(ctx, conn)
+// authorize checks that the key's scopes cover the procedure
+func (a *AuthInterceptor) authorize(apiKey, procedure string) error {
+	scopes, err := a.db.GetAPIKeyScopes(apiKey)
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, errors.New("failed to load API key scopes"))
+	}
+	if required := ProcedureScope(procedure); !hasScope(scopes, required) {
+		return connect.NewError(connect.CodePermissionDenied, fmt.Errorf("API key lacks scope %q", required))
 	}
+	return nil
 }
 
 // extractBearerToken extracts the token from "Bearer <token>" format
@@ -116,8 +135,32 @@ func NewHTTPAuthMiddleware(database *db.DB) func(http.Handler) http.Handler {
 				http.Error(w, "invalid API key", http.StatusUnauthorized)
 				return
 			}
+			if required := RouteScope(r); required != "" {
+				scopes, err := database.GetAPIKeyScopes(apiKey)
+				if err != nil {
+					http.Error(w, "failed to load API key scopes", http.StatusInternalServerError)
+					return
+				}
+				if !hasScope(scopes, required) {
+					http.Error(w, fmt.Sprintf("API key lacks scope %q", required), http.StatusForbidden)
+					return
+				}
+			}
 
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), APIKeyContextKey, apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// APIKeyContextKey is the context key for the authenticated API key
+const APIKeyContextKey contextKey = "api_key"
+
+// GetAPIKey returns the API key authenticated by NewHTTPAuthMiddleware or
+// AuthInterceptor, if any
+func GetAPIKey(ctx context.Context) string {
+	if key, ok := ctx.Value(APIKeyContextKey).(string); ok {
+		return key
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCacheMaxAge is how long clients may reuse a Cacheable response
+// before revalidating it
+const DefaultCacheMaxAge = 5 * time.Minute
+
+// bufferedResponse holds a handler's response so it can be hashed before
+// anything is sent
+type bufferedResponse struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.statusCode == 0 {
+		b.statusCode = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.statusCode == 0 {
+		b.statusCode = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// Cacheable marks successful GET and HEAD responses of low-churn endpoints
+// as cacheable for maxAge and tags them with an ETag of the body, answering
+// a matching If-None-Match with 304 Not Modified. Responses to requests
+// carrying credentials are cacheable by the client only (private); a zero
+// maxAge still sends the ETag but requires revalidation on every use.
+// Other methods and statuses pass through untouched.
+func Cacheable(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buf := &bufferedResponse{header: w.Header()}
+			next.ServeHTTP(buf, r)
+			if buf.statusCode == 0 {
+				buf.statusCode = http.StatusOK
+			}
+
+			if buf.statusCode == http.StatusOK {
+				sum := sha256.Sum256(buf.body.Bytes())
+				etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Cache-Control", cacheControl(r, maxAge))
+				w.Header().Add("Vary", "Authorization")
+
+				if etagMatches(r.Header.Get("If-None-Match"), etag) {
+					w.Header().Del("Content-Length")
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+
+			w.WriteHeader(buf.statusCode)
+			w.Write(buf.body.Bytes())
+		})
+	}
+}
+
+func cacheControl(r *http.Request, maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	scope := "public"
+	if r.Header.Get("Authorization") != "" {
+		scope = "private"
+	}
+	return scope + ", max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match their strong form, as RFC 9110 requires for GET.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
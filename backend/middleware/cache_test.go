@@ -0,0 +1,61 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestCacheable_SkipsErrorsAndWrites(t *testing.T) {
+	h := middleware.Cacheable(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		url    string
+		code   int
+	}{
+		{"error response", http.MethodGet, "/clouds?fail=1", http.StatusInternalServerError},
+		{"write", http.MethodPost, "/clouds", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.code, rec.Code)
+		}
+		if rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "" {
+			t.Errorf("%s: expected no caching headers, got %v", tt.name, rec.Header())
+		}
+	}
+}
+
+func TestCacheable_PrivateWithCredentials(t *testing.T) {
+	list := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	h := middleware.Cacheable(time.Minute)(list)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/clouds", nil)
+	req.Header.Set("Authorization", "Bearer sk_test")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Cache-Control"); got != "private, max-age=60" {
+		t.Errorf("Expected private max-age=60, got %q", got)
+	}
+
+	// A zero max age still revalidates by ETag
+	rec = httptest.NewRecorder()
+	middleware.Cacheable(0)(list).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/clouds", nil))
+	if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Expected no-cache, got %q", got)
+	}
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the smallest response body Compression
+// compresses; below it the encoding overhead isn't worth it
+const DefaultCompressionMinSize = 1024
+
+// incompressibleTypes are Content-Type prefixes that are already compressed,
+// or whose protocols handle compression themselves
+var incompressibleTypes = []string{
+	"image/", "video/", "audio/",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/grpc", "application/connect+",
+}
+
+// Compression gzip- or deflate-encodes responses for clients that accept it,
+// preferring gzip. Responses under DefaultCompressionMinSize, of an
+// incompressible type, or already carrying a Content-Encoding (such as
+// the Prometheus handler's) are sent as they are.
+func Compression() func(http.Handler) http.Handler {
+	return CompressionWithMinSize(DefaultCompressionMinSize)
+}
+
+// CompressionWithMinSize is Compression with a custom size threshold
+func CompressionWithMinSize(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize, statusCode: http.StatusOK}
+			next.ServeHTTP(cw, r)
+			// Not deferred: after a panic the held-back response must stay
+			// unsent, so Recover can still answer with its 500
+			cw.Close()
+		})
+	}
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// honouring q=0, or returns "" if neither is accepted
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[encoding]; listed {
+			if ok {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the body is big enough to compress, then either encodes everything or
+// passes it through
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	minSize    int
+	statusCode int
+
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // Set once compression is chosen
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		return
+	}
+	cw.statusCode = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing if asked and the response allows it,
+// and writes out the held-back body
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		compress = false
+	} else {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		// Sniff the type now, as net/http would otherwise sniff the
+		// compressed bytes
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		compress = compressible(h.Get("Content-Type"))
+	}
+
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// The encoded bytes differ, so the validator can only be weak
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.enc, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	held := cw.buf
+	cw.buf = nil
+	if len(held) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(held)
+	} else {
+		_, err = cw.ResponseWriter.Write(held)
+	}
+	return err
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends what has been written so far, compressing it if any body has
+// been written, so streaming handlers aren't held back by the threshold
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(len(cw.buf) > 0)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the response: a body still under the threshold is sent as it
+// is, and a compressed one is terminated
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sennet/sennet/backend/metrics"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func compressedGet(t *testing.T, h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/costs/summary", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	middleware.Compression()(h).ServeHTTP(rec, req)
+	return rec
+}
+
+func jsonHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})
+}
+
+func TestCompression_GzipRoundTrips(t *testing.T) {
+	body := `{"agents":[` + strings.Repeat(`{"id":"agent","version":"1.0.0"},`, 200) + `{}]}`
+	rec := compressedGet(t, jsonHandler(body), "br, gzip;q=0.8")
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", got)
+	}
+	if rec.Body.Len() >= len(body) {
+		t.Errorf("Expected a smaller body, got %d bytes for %d", rec.Body.Len(), len(body))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip body: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil || string(plain) != body {
+		t.Errorf("Expected the original body back, got %d bytes (%v)", len(plain), err)
+	}
+}
+
+func TestCompression_Deflate(t *testing.T) {
+	body := strings.Repeat("sennet ", 500)
+	rec := compressedGet(t, jsonHandler(body), "deflate")
+	if got := rec.Header().Get("Content-Encoding"); got != "deflate" {
+		t.Fatalf("Expected deflate encoding, got %q", got)
+	}
+	plain, err := io.ReadAll(flate.NewReader(rec.Body))
+	if err != nil || string(plain) != body {
+		t.Errorf("Expected the original body back, got %d bytes (%v)", len(plain), err)
+	}
+}
+
+func TestCompression_PlaintextWhenNotAccepted(t *testing.T) {
+	body := strings.Repeat("sennet ", 500)
+	for _, accept := range []string{"", "identity", "gzip;q=0, br"} {
+		rec := compressedGet(t, jsonHandler(body), accept)
+		if got := rec.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("Accept-Encoding %q: expected no encoding, got %q", accept, got)
+		}
+		if rec.Body.String() != body {
+			t.Errorf("Accept-Encoding %q: expected the plain body", accept)
+		}
+	}
+}
+
+func TestCompression_SkipsTinyAndCompressedResponses(t *testing.T) {
+	rec := compressedGet(t, jsonHandler(`{"status":"ok"}`), "gzip")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != `{"status":"ok"}` {
+		t.Errorf("Expected a tiny response sent as is, got %q", rec.Body.String())
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 4096)...)
+	rec = compressedGet(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	}), "gzip")
+	if rec.Header().Get("Content-Encoding") != "" || !bytes.Equal(rec.Body.Bytes(), png) {
+		t.Error("Expected an image response sent as is")
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Expected the sniffed content type, got %q", got)
+	}
+}
+
+func TestCompression_DoesNotDoubleCompressMetrics(t *testing.T) {
+	metrics.ActiveAgents.Set(1)
+	rec := compressedGet(t, metrics.Handler(), "gzip")
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Expected the metrics handler's own gzip, got %q", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid gzip body: %v", err)
+	}
+	plain, _ := io.ReadAll(zr)
+	if !strings.Contains(string(plain), "# HELP") {
+		t.Errorf("Expected plain exposition text after one decompression, got %q", plain[:min(len(plain), 64)])
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"strings"
 )
 
-// CORSConfig holds CORS configuration
+// CORSConfig holds CORS configuration. An allowed origin is "*" (any
+// origin), an exact origin, or a pattern such as "https://*.example.com"
+// matching any subdomain of example.com, however deep, but not example.com
+// itself.
 type CORSConfig struct {
 	AllowedOrigins   []string
 	AllowedMethods   []string
@@ -19,7 +22,7 @@ func DefaultCORSConfig() CORSConfig {
 	return CORSConfig{
 		AllowedOrigins:   []string{"*"}, // TODO: Set specific origins in production
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Sennet-Timestamp", "X-Sennet-Signature"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Sennet-Timestamp", "X-Sennet-Signature", "X-Sennet-Nonce", "X-CSRF-Token"},
 		AllowCredentials: true,
 	}
 }
@@ -29,7 +32,7 @@ func ProductionCORSConfig(origins []string) CORSConfig {
 	return CORSConfig{
 		AllowedOrigins:   origins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Sennet-Timestamp", "X-Sennet-Signature"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Sennet-Timestamp", "X-Sennet-Signature", "X-Sennet-Nonce", "X-CSRF-Token"},
 		AllowCredentials: true,
 	}
 }
@@ -43,12 +46,17 @@ func CORS(config CORSConfig) func(http.Handler) http.Handler {
 			// Check if origin is allowed
 			allowed := false
 			for _, allowedOrigin := range config.AllowedOrigins {
-				if allowedOrigin == "*" || allowedOrigin == origin {
+				if originAllowed(allowedOrigin, origin) {
 					allowed = true
 					break
 				}
 			}
 
+			if origin != "" {
+				// Whether the origin is echoed depends on it, so caches
+				// must key on it
+				w.Header().Add("Vary", "Origin")
+			}
 			if allowed && origin != "" {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
@@ -69,3 +77,22 @@ func CORS(config CORSConfig) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// originAllowed reports whether origin matches an AllowedOrigins entry
+func originAllowed(allowed, origin string) bool {
+	if allowed == "*" || allowed == origin {
+		return true
+	}
+	scheme, host, ok := strings.Cut(allowed, "://*.")
+	if !ok || origin == "" {
+		return false
+	}
+	// Require at least one label in front of the domain, so the bare domain
+	// and look-alikes such as "https://evil-example.com" don't match
+	rest, ok := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+	if !ok {
+		return false
+	}
+	label, ok := strings.CutSuffix(rest, "."+strings.ToLower(host))
+	return ok && label != "" && !strings.ContainsAny(label, "/:@") && !strings.HasPrefix(label, ".") && !strings.HasSuffix(label, ".")
+}
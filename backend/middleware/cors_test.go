@@ -0,0 +1,65 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func corsResponse(config middleware.CORSConfig, origin string) http.Header {
+	h := middleware.CORS(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/costs", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Header()
+}
+
+func TestCORS_WildcardSubdomains(t *testing.T) {
+	config := middleware.ProductionCORSConfig([]string{"https://*.app.example.com", "https://dashboard.example.org"})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://pr-123.app.example.com", true},
+		{"https://a.b.app.example.com", true},
+		{"https://PR-123.App.Example.com", true},
+		{"https://dashboard.example.org", true},
+		{"https://app.example.com", false}, // The bare domain needs its own entry
+		{"http://pr-123.app.example.com", false},
+		{"https://evil.com", false},
+		{"https://evilapp.example.com", false},
+		{"https://pr-123.app.example.com.evil.com", false},
+		{"https://pr-123.app.example.com:8443", false},
+		{"https://user@pr-1.app.example.com", false},
+	}
+	for _, tt := range tests {
+		header := corsResponse(config, tt.origin)
+		if got := header.Get("Access-Control-Allow-Origin"); (got == tt.origin) != tt.allowed {
+			t.Errorf("%s: expected allowed=%v, got Access-Control-Allow-Origin %q", tt.origin, tt.allowed, got)
+		}
+		if got := header.Get("Access-Control-Allow-Credentials"); (got == "true") != tt.allowed {
+			t.Errorf("%s: expected credentials only for allowed origins, got %q", tt.origin, got)
+		}
+		if header.Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin", tt.origin)
+		}
+	}
+}
+
+func TestCORS_LiteralWildcardAllowsAnyOrigin(t *testing.T) {
+	header := corsResponse(middleware.DefaultCORSConfig(), "http://localhost:5173")
+	if got := header.Get("Access-Control-Allow-Origin"); got != "http://localhost:5173" {
+		t.Errorf("Expected the dev config to echo any origin, got %q", got)
+	}
+	if header := corsResponse(middleware.DefaultCORSConfig(), ""); header.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("Expected no CORS headers without an Origin")
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// CSRFCookieName holds the token the browser must echo back
+	CSRFCookieName = "sennet_csrf"
+	// CSRFHeader carries the echoed token on mutating requests
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// DefaultCSRFRoutes are the browser-facing endpoints that change keys,
+// cloud configuration or fleet state
+var DefaultCSRFRoutes = []string{"/api/keys", "/api/clouds", "/api/admin/", "/api/commands/", "/api/agents/"}
+
+// CSRF applies double-submit cookie protection. Every response to a client
+// without a token cookie sets one; requests matched by protect must echo
+// the cookie's value in the X-CSRF-Token header. Requests carrying an
+// Authorization header are exempt, since browsers never attach one on
+// their own.
+func CSRF(protect func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if c, err := r.Cookie(CSRFCookieName); err == nil {
+				token = c.Value
+			}
+			if token == "" {
+				issued, err := newCSRFToken()
+				if err != nil {
+					http.Error(w, "Failed to issue CSRF token", http.StatusInternalServerError)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     CSRFCookieName,
+					Value:    issued,
+					Path:     "/",
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteStrictMode,
+					// Readable by the dashboard's JS so it can echo the header
+					HttpOnly: false,
+				})
+			}
+
+			if protect(r) && r.Header.Get("Authorization") == "" {
+				sent := r.Header.Get(CSRFHeader)
+				if token == "" || sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					http.Error(w, "CSRF token missing or invalid", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,74 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestCSRF(t *testing.T) {
+	handler := middleware.CSRF(middleware.MutatingRoutes(middleware.DefaultCSRFRoutes))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	// A page load hands the browser its token
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/keys", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected GET to pass, got %d", rec.Code)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == middleware.CSRFCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("Expected a CSRF cookie to be issued")
+	}
+
+	post := func(token string, withCookie bool, authorization string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/keys/create", nil)
+		if withCookie {
+			req.AddCookie(cookie)
+		}
+		if token != "" {
+			req.Header.Set(middleware.CSRFHeader, token)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		withCookie    bool
+		authorization string
+		want          int
+	}{
+		{"no token", "", true, "", http.StatusForbidden},
+		{"wrong token", "forged", true, "", http.StatusForbidden},
+		{"token without cookie", cookie.Value, false, "", http.StatusForbidden},
+		{"matching token", cookie.Value, true, "", http.StatusOK},
+		{"API key request", "", false, "Bearer sk_test", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := post(tt.token, tt.withCookie, tt.authorization); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+
+	// Routes outside the protected set are untouched
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/sync-costs", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected unprotected route to pass, got %d", rec.Code)
+	}
+}
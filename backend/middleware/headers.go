@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// AgentVersionHeader carries the agent's version on every request
+const AgentVersionHeader = "X-Sennet-Agent-Version"
+
+// RequiredHeadersConfig holds the headers every request must carry
+type RequiredHeadersConfig struct {
+	Headers     []string // Header names that must be present and non-empty
+	ExemptPaths []string // Exact paths that skip the check
+}
+
+// DefaultRequiredHeadersConfig requires nothing, for compatibility with
+// older agents, and exempts the health, probe, time-sync and version endpoints
+func DefaultRequiredHeadersConfig() RequiredHeadersConfig {
+	return RequiredHeadersConfig{
+		ExemptPaths: []string{"/health", "/ready", "/live", "/time", "/version"},
+	}
+}
+
+// RequireHeaders rejects requests missing any configured header with 400.
+// CORS preflight requests are never checked.
+func RequireHeaders(config RequiredHeadersConfig) func(http.Handler) http.Handler {
+	exempt := make(map[string]bool, len(config.ExemptPaths))
+	for _, path := range config.ExemptPaths {
+		exempt[path] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(config.Headers) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions || exempt[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, header := range config.Headers {
+				if r.Header.Get(header) == "" {
+					http.Error(w, "Missing required header: "+header, http.StatusBadRequest)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func requireAgentVersion() http.Handler {
+	config := middleware.DefaultRequiredHeadersConfig()
+	config.Headers = []string{middleware.AgentVersionHeader}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return middleware.RequireHeaders(config)(ok)
+}
+
+func TestRequireHeaders_MissingHeaderRejected(t *testing.T) {
+	rec := httptest.NewRecorder()
+	requireAgentVersion().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sentinel.v1.SentinelService/Heartbeat", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), middleware.AgentVersionHeader) {
+		t.Errorf("Expected error to name the missing header, got %q", rec.Body.String())
+	}
+}
+
+func TestRequireHeaders_PresentHeaderPasses(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/sentinel.v1.SentinelService/Heartbeat", nil)
+	req.Header.Set(middleware.AgentVersionHeader, "1.2.3")
+	rec := httptest.NewRecorder()
+	requireAgentVersion().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+}
+
+func TestRequireHeaders_ExemptPaths(t *testing.T) {
+	h := requireAgentVersion()
+	for _, path := range []string{"/health", "/ready", "/live", "/time"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected exempt (200), got %d", path, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/api/costs", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected preflight to pass, got %d", rec.Code)
+	}
+}
+
+func TestRequireHeaders_DefaultRequiresNothing(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rec := httptest.NewRecorder()
+	middleware.RequireHeaders(middleware.DefaultRequiredHeadersConfig())(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/costs", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with default config, got %d", rec.Code)
+	}
+}
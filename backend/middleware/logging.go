@@ -6,7 +6,7 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/sennet/sennet/backend/idgen"
 )
 
 type contextKey string
@@ -15,10 +15,17 @@ const RequestIDKey contextKey = "request_id"
 
 type LoggingMiddleware struct {
 	logger *log.Logger
+	ids    idgen.Generator
 }
 
 func NewLoggingMiddleware(logger *log.Logger) *LoggingMiddleware {
-	return &LoggingMiddleware{logger: logger}
+	return &LoggingMiddleware{logger: logger, ids: idgen.Short{}}
+}
+
+// SetIDGenerator sets how request IDs are generated for requests that don't
+// send X-Request-ID. The default is idgen.Short.
+func (lm *LoggingMiddleware) SetIDGenerator(g idgen.Generator) {
+	lm.ids = g
 }
 
 func (lm *LoggingMiddleware) Middleware(next http.Handler) http.Handler {
@@ -27,7 +34,7 @@ func (lm *LoggingMiddleware) Middleware(next http.Handler) http.Handler {
 
 		requestID := r.Header.Get("X-Request-ID")
 		if requestID == "" {
-			requestID = uuid.New().String()[:8]
+			requestID = lm.ids.NewID()
 		}
 
 		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
@@ -52,14 +59,36 @@ func (lm *LoggingMiddleware) Middleware(next http.Handler) http.Handler {
 
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	bytes       int64 // Body bytes written
+	wroteHeader bool  // The response has started, so its status is fixed
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
+	rw.wroteHeader = true
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func GetRequestID(ctx context.Context) string {
 	if id, ok := ctx.Value(RequestIDKey).(string); ok {
 		return id
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// DefaultNonceCapacity bounds the nonces remembered at once. At capacity the
+// entry closest to expiry is forgotten early to make room.
+const DefaultNonceCapacity = 100_000
+
+// NonceCache remembers nonces until they expire, so a signed request can only
+// be used once while its timestamp is still accepted. It is safe for
+// concurrent use.
+type NonceCache struct {
+	capacity int
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+	queue   nonceQueue // Same entries as expires, soonest expiry first
+}
+
+// NewNonceCache returns an empty cache holding at most capacity nonces
+func NewNonceCache(capacity int) *NonceCache {
+	return &NonceCache{capacity: capacity, expires: make(map[string]time.Time)}
+}
+
+// Use records nonce as seen until expires, reporting false if it was already
+// seen and hasn't expired by now
+func (c *NonceCache) Use(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.queue) > 0 && !c.queue[0].expires.After(now) {
+		delete(c.expires, heap.Pop(&c.queue).(nonceEntry).nonce)
+	}
+	if _, seen := c.expires[nonce]; seen {
+		return false
+	}
+	if len(c.queue) >= c.capacity {
+		logging.Warnf("Nonce cache full (%d entries), forgetting the oldest early", c.capacity)
+		delete(c.expires, heap.Pop(&c.queue).(nonceEntry).nonce)
+	}
+	c.expires[nonce] = expires
+	heap.Push(&c.queue, nonceEntry{nonce: nonce, expires: expires})
+	return true
+}
+
+// Len returns the number of nonces remembered, including any that have
+// expired since the last Use
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// nonceQueue is a min-heap of entries by expiry
+type nonceQueue []nonceEntry
+
+func (q nonceQueue) Len() int           { return len(q) }
+func (q nonceQueue) Less(i, j int) bool { return q[i].expires.Before(q[j].expires) }
+func (q nonceQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *nonceQueue) Push(x any)        { *q = append(*q, x.(nonceEntry)) }
+func (q *nonceQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/sennet/sennet/backend/clock"
 )
 
 type RateLimiter struct {
@@ -13,11 +19,13 @@ type RateLimiter struct {
 	rate     float64
 	capacity int
 	cleanup  time.Duration
+	clock    clock.Clock
 }
 
 type tokenBucket struct {
 	tokens     float64
 	lastUpdate time.Time
+	label      string // Key as shown by Buckets, with credentials masked
 }
 
 func NewRateLimiter(requestsPerMinute int, burstSize int) *RateLimiter {
@@ -26,17 +34,30 @@ func NewRateLimiter(requestsPerMinute int, burstSize int) *RateLimiter {
 		rate:     float64(requestsPerMinute) / 60.0,
 		capacity: burstSize,
 		cleanup:  5 * time.Minute,
+		clock:    clock.Real,
 	}
 	go rl.cleanupLoop()
 	return rl
 }
 
+// Tier describes the limit applied to each client, such as "100/min, burst 20".
+// Every client currently shares the one tier.
+func (rl *RateLimiter) Tier() string {
+	return fmt.Sprintf("%d/min, burst %d", int(math.Round(rl.rate*60)), rl.capacity)
+}
+
+// SetClock replaces the time source used to refill buckets. It should be
+// called before the limiter is in use.
+func (rl *RateLimiter) SetClock(c clock.Clock) {
+	rl.clock = c
+}
+
 func (rl *RateLimiter) cleanupLoop() {
 	ticker := time.NewTicker(rl.cleanup)
 	defer ticker.Stop()
 	for range ticker.C {
 		rl.mu.Lock()
-		now := time.Now()
+		now := rl.clock.Now()
 		for key, bucket := range rl.buckets {
 			if now.Sub(bucket.lastUpdate) > rl.cleanup {
 				delete(rl.buckets, key)
@@ -47,43 +68,171 @@ func (rl *RateLimiter) cleanupLoop() {
 }
 
 func (rl *RateLimiter) Allow(key string) bool {
+	return rl.Take(key).Allowed
+}
+
+// Quota describes a bucket's state after a request was counted against it
+type Quota struct {
+	Allowed   bool
+	Limit     int
+	Remaining int // Whole tokens left
+	// Reset is how long until a token is available, zero if one is now
+	Reset time.Duration
+}
+
+// Take counts a request against the key's bucket and reports what's left
+func (rl *RateLimiter) Take(key string) Quota {
+	return rl.take(key, key)
+}
+
+// Peek reports the key's bucket as it stands now, without counting a
+// request against it. Allowed tells whether a request would be let through.
+func (rl *RateLimiter) Peek(key string) Quota {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	tokens := float64(rl.capacity)
+	if bucket, exists := rl.buckets[key]; exists {
+		tokens = rl.refilled(bucket, rl.clock.Now())
+	}
+	return rl.quota(tokens, tokens >= 1)
+}
+
+// refilled returns the bucket's tokens topped up to now, capped at capacity
+func (rl *RateLimiter) refilled(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.lastUpdate).Seconds()*rl.rate
+	return math.Min(tokens, float64(rl.capacity))
+}
+
+func (rl *RateLimiter) quota(tokens float64, allowed bool) Quota {
+	quota := Quota{
+		Allowed:   allowed,
+		Limit:     rl.capacity,
+		Remaining: int(math.Max(tokens, 0)),
+	}
+	if missing := 1 - tokens; missing > 0 && rl.rate > 0 {
+		quota.Reset = time.Duration(missing / rl.rate * float64(time.Second))
+	}
+	return quota
+}
+
+func (rl *RateLimiter) take(key, label string) Quota {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	bucket, exists := rl.buckets[key]
-	now := time.Now()
+	now := rl.clock.Now()
 
+	allowed := false
 	if !exists {
-		rl.buckets[key] = &tokenBucket{
+		bucket = &tokenBucket{
 			tokens:     float64(rl.capacity) - 1,
 			lastUpdate: now,
+			label:      label,
+		}
+		rl.buckets[key] = bucket
+		allowed = true
+	} else {
+		bucket.tokens = rl.refilled(bucket, now)
+		bucket.lastUpdate = now
+
+		if bucket.tokens >= 1 {
+			bucket.tokens--
+			allowed = true
 		}
-		return true
 	}
 
-	elapsed := now.Sub(bucket.lastUpdate).Seconds()
-	bucket.tokens += elapsed * rl.rate
-	if bucket.tokens > float64(rl.capacity) {
-		bucket.tokens = float64(rl.capacity)
+	return rl.quota(bucket.tokens, allowed)
+}
+
+// BucketState is a snapshot of one rate-limit bucket
+type BucketState struct {
+	Key        string // Masked: the auth header is never shown in full
+	Tokens     float64
+	Capacity   int
+	LastUpdate time.Time
+}
+
+// Buckets returns the current state of every active bucket, sorted by key,
+// with tokens refilled up to now
+func (rl *RateLimiter) Buckets() []BucketState {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := rl.clock.Now()
+	states := make([]BucketState, 0, len(rl.buckets))
+	for _, bucket := range rl.buckets {
+		states = append(states, BucketState{
+			Key:        bucket.label,
+			Tokens:     rl.refilled(bucket, now),
+			Capacity:   rl.capacity,
+			LastUpdate: bucket.lastUpdate,
+		})
 	}
-	bucket.lastUpdate = now
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// Reset drops the buckets matching key, either as passed to Take or as
+// reported by Buckets, so they start again with the full burst. It reports
+// how many buckets were dropped.
+func (rl *RateLimiter) Reset(key string) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
-	if bucket.tokens >= 1 {
-		bucket.tokens--
-		return true
+	n := 0
+	for k, bucket := range rl.buckets {
+		if k == key || bucket.label == key {
+			delete(rl.buckets, k)
+			n++
+		}
+	}
+	return n
+}
+
+// maskCredential keeps the auth scheme and the first few characters of the
+// credential so buckets can be told apart without exposing the secret
+func maskCredential(auth string) string {
+	if auth == "" {
+		return ""
+	}
+	scheme, credential, found := strings.Cut(auth, " ")
+	if !found {
+		scheme, credential = "", auth
 	}
-	return false
+	const visible = 6
+	if len(credential) > visible {
+		credential = credential[:visible]
+	}
+	credential += "****"
+	if scheme == "" {
+		return credential
+	}
+	return scheme + " " + credential
 }
 
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return quotaMiddleware(rl.take, next)
+}
+
+// quotaMiddleware limits requests with take, which counts a request against
+// a bucket key and labels the bucket, and reports the quota in headers
+func quotaMiddleware(take func(key, label string) Quota, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// SECURITY FIX: Always include IP to prevent bypass by rotating auth headers
 		ip := getClientIP(r)
 		authKey := r.Header.Get("Authorization")
 		key := ip + ":" + authKey // Combined key prevents bypass
 
-		if !rl.Allow(key) {
-			w.Header().Set("Retry-After", "60")
+		quota := take(key, ip+":"+maskCredential(authKey))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(quota.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(quota.Remaining))
+		// Seconds until a token is available, rounded up
+		reset := int(math.Ceil(quota.Reset.Seconds()))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(reset))
+
+		if !quota.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(max(reset, 1)))
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// tokenBucketScript refills and takes from a bucket stored as a hash of
+// tokens and ts (milliseconds), atomically, so every replica draws on the
+// same budget. ARGV: tokens per second, capacity, now in ms, TTL in ms.
+// Returns {allowed (0 or 1), tokens left as a string}, since Lua numbers
+// are truncated to integers in replies.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+local ts = tonumber(redis.call('HGET', KEYS[1], 'ts'))
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) / 1000 * rate)
+	ts = now
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, tostring(tokens)}
+`
+
+// redisRetryInterval is how long the limiter sticks to in-memory buckets
+// after Redis fails before trying it again
+const redisRetryInterval = 5 * time.Second
+
+// DistributedRateLimiter is a RateLimiter whose buckets live in Redis, so
+// replicas behind a load balancer enforce one budget between them instead of
+// one each. While Redis can't be reached it falls back to the in-memory
+// limiter it was built from, which also sets its rate, burst and clock,
+// retrying Redis every redisRetryInterval.
+type DistributedRateLimiter struct {
+	client    *redisClient
+	fallback  *RateLimiter
+	prefix    string
+	scriptSHA string
+	degraded  atomic.Bool  // Last call fell back, so recovery gets logged
+	retryAt   atomic.Int64 // Unix nanoseconds before which Redis isn't tried
+}
+
+// NewDistributedRateLimiter limits against the Redis server at redisURL,
+// using fallback's settings and falling back to it when Redis fails.
+// redisURL is redis://[[user]:password@]host[:port][/db], rediss:// for TLS,
+// or a bare host:port. No connection is made until the first request.
+func NewDistributedRateLimiter(redisURL string, fallback *RateLimiter) (*DistributedRateLimiter, error) {
+	opts, err := parseRedisURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(tokenBucketScript))
+	return &DistributedRateLimiter{
+		client:    newRedisClient(opts),
+		fallback:  fallback,
+		prefix:    "sennet:ratelimit:",
+		scriptSHA: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// Addr returns the Redis server's host:port, without any credentials
+func (d *DistributedRateLimiter) Addr() string {
+	return d.client.opts.addr
+}
+
+func (d *DistributedRateLimiter) Allow(key string) bool {
+	return d.Take(key).Allowed
+}
+
+// Take counts a request against the key's shared bucket and reports what's
+// left
+func (d *DistributedRateLimiter) Take(key string) Quota {
+	return d.take(key, key)
+}
+
+func (d *DistributedRateLimiter) take(key, label string) Quota {
+	now := d.fallback.clock.Now()
+	if now.UnixNano() < d.retryAt.Load() {
+		return d.fallback.take(key, label)
+	}
+	quota, err := d.takeShared(key)
+	if err != nil {
+		d.retryAt.Store(now.Add(redisRetryInterval).UnixNano())
+		if !d.degraded.Swap(true) {
+			logging.Warnf("Redis rate limiting unavailable, using in-memory buckets: %v", err)
+		}
+		return d.fallback.take(key, label)
+	}
+	if d.degraded.Swap(false) {
+		logging.Infof("Redis rate limiting recovered")
+	}
+	return quota
+}
+
+func (d *DistributedRateLimiter) takeShared(key string) (Quota, error) {
+	rl := d.fallback
+	// Hash the key, as it carries the caller's Authorization header
+	sum := sha256.Sum256([]byte(key))
+	// Keep idle buckets until they'd have refilled anyway
+	ttl := rl.cleanup
+	if rl.rate > 0 {
+		ttl = max(ttl, time.Duration(math.Ceil(float64(rl.capacity)/rl.rate*float64(time.Second))))
+	}
+	args := []string{
+		"1", d.prefix + hex.EncodeToString(sum[:]),
+		strconv.FormatFloat(rl.rate, 'f', -1, 64),
+		strconv.Itoa(rl.capacity),
+		strconv.FormatInt(rl.clock.Now().UnixMilli(), 10),
+		strconv.FormatInt(ttl.Milliseconds(), 10),
+	}
+
+	reply, err := d.client.Do(append([]string{"EVALSHA", d.scriptSHA}, args...)...)
+	var replyErr redisError
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		// First use on this server (or its script cache was flushed)
+		reply, err = d.client.Do(append([]string{"EVAL", tokenBucketScript}, args...)...)
+	}
+	if err != nil {
+		return Quota{}, err
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return Quota{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+	allowed, _ := items[0].(int64)
+	left, _ := items[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return Quota{}, fmt.Errorf("unexpected token count %q", left)
+	}
+	return rl.quota(tokens, allowed == 1), nil
+}
+
+func (d *DistributedRateLimiter) Middleware(next http.Handler) http.Handler {
+	return quotaMiddleware(d.take, next)
+}
+
+// Close closes the Redis connections
+func (d *DistributedRateLimiter) Close() error {
+	return d.client.Close()
+}
@@ -0,0 +1,168 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+// newSharedLimiter returns a Redis-backed limiter with its own in-memory
+// fallback, as a separate replica would have
+func newSharedLimiter(t *testing.T, addr string, fake *clock.FakeClock) *middleware.DistributedRateLimiter {
+	t.Helper()
+	fallback := middleware.NewRateLimiter(60, 4)
+	fallback.SetClock(fake)
+	limiter, err := middleware.NewDistributedRateLimiter(addr, fallback)
+	if err != nil {
+		t.Fatalf("NewDistributedRateLimiter failed: %v", err)
+	}
+	t.Cleanup(func() { limiter.Close() })
+	return limiter
+}
+
+func TestDistributedRateLimiter_SharesBudgetAcrossInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	a := newSharedLimiter(t, server.Addr(), fake)
+	b := newSharedLimiter(t, server.Addr(), fake)
+
+	// Alternate between replicas: the burst of 4 is spent once, not per replica
+	for i := 0; i < 4; i++ {
+		limiter := a
+		if i%2 == 1 {
+			limiter = b
+		}
+		if q := limiter.Take("client"); !q.Allowed || q.Remaining != 3-i {
+			t.Fatalf("Request %d: expected allowed with %d remaining, got %+v", i, 3-i, q)
+		}
+	}
+	if a.Allow("client") || b.Allow("client") {
+		t.Error("Expected the shared budget to be exhausted on both replicas")
+	}
+	if !a.Allow("other-client") {
+		t.Error("Expected other keys to have their own budget")
+	}
+
+	// One token a second, shared as well
+	fake.Advance(time.Second)
+	if !b.Allow("client") {
+		t.Error("Expected a refilled token")
+	}
+	if a.Allow("client") {
+		t.Error("Expected the refilled token to be spent by the other replica")
+	}
+}
+
+func TestDistributedRateLimiter_FallsBackWhenRedisIsDown(t *testing.T) {
+	server := miniredis.RunT(t)
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	limiter := newSharedLimiter(t, server.Addr(), fake)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/costs", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "3" {
+		t.Fatalf("Expected a shared bucket with 3 left, got %d (%q)", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	server.Close()
+	// The in-memory fallback starts its own bucket
+	for i := 0; i < 4; i++ {
+		if rec := do(); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected the fallback to allow, got %d", i, rec.Code)
+		}
+	}
+	if rec := do(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the fallback to enforce the burst, got %d", rec.Code)
+	}
+
+	// Redis is tried again once the retry interval passes
+	if err := server.Restart(); err != nil {
+		t.Fatalf("Failed to restart miniredis: %v", err)
+	}
+	server.FlushAll()
+	if rec := do(); rec.Code != http.StatusTooManyRequests || len(server.Keys()) != 0 {
+		t.Errorf("Expected the fallback until the retry interval passes, got %d with keys %v", rec.Code, server.Keys())
+	}
+	fake.Advance(10 * time.Second)
+	if rec := do(); rec.Code != http.StatusOK || len(server.Keys()) != 1 {
+		t.Errorf("Expected the shared bucket again after recovery, got %d with keys %v", rec.Code, server.Keys())
+	}
+}
+
+func TestDistributedRateLimiter_AuthAndDatabaseFromURL(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("s3cret")
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	limiter := newSharedLimiter(t, "redis://:s3cret@"+server.Addr()+"/2", fake)
+	if limiter.Addr() != server.Addr() {
+		t.Errorf("Expected Addr without credentials, got %q", limiter.Addr())
+	}
+	for i := 0; i < 4; i++ {
+		limiter.Take("client")
+	}
+	if limiter.Allow("client") {
+		t.Error("Expected the budget kept in Redis to be exhausted")
+	}
+	if keys := server.DB(2).Keys(); len(keys) != 1 {
+		t.Errorf("Expected the bucket stored in database 2, got %v", keys)
+	}
+
+	wrong := newSharedLimiter(t, "redis://:wrong@"+server.Addr(), fake)
+	for i := 0; i < 4; i++ {
+		if !wrong.Allow("client") {
+			t.Fatal("Expected a limiter that can't authenticate to fall back to its own budget")
+		}
+	}
+}
+
+func TestDistributedRateLimiter_ConcurrentRequests(t *testing.T) {
+	server := miniredis.RunT(t)
+	fallback := middleware.NewRateLimiter(6000, 1000)
+	limiter, _ := middleware.NewDistributedRateLimiter(server.Addr(), fallback)
+	defer limiter.Close()
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if limiter.Allow("client") {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 500 {
+		t.Errorf("Expected all 500 requests within the shared budget allowed, got %d", got)
+	}
+	if n := server.TotalConnectionCount(); n < 2 {
+		t.Errorf("Expected concurrent requests spread over pooled connections, got %d", n)
+	}
+}
+
+func TestNewDistributedRateLimiter_RejectsBadURL(t *testing.T) {
+	for _, url := range []string{"http://localhost:6379", "redis://localhost:6379/db", "redis:///0"} {
+		if _, err := middleware.NewDistributedRateLimiter(url, middleware.NewRateLimiter(60, 4)); err == nil {
+			t.Errorf("%s: expected an error", url)
+		}
+	}
+}
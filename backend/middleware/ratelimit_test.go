@@ -0,0 +1,110 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestRateLimiter_Headers(t *testing.T) {
+	// 10 tokens a second, so the bucket refills within a few hundred ms
+	rl := middleware.NewRateLimiter(600, 3)
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl.SetClock(fake)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/costs", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	remaining := func(rec *httptest.ResponseRecorder) int {
+		n, err := strconv.Atoi(rec.Header().Get("X-RateLimit-Remaining"))
+		if err != nil {
+			t.Fatalf("Invalid X-RateLimit-Remaining %q", rec.Header().Get("X-RateLimit-Remaining"))
+		}
+		return n
+	}
+
+	for want := 2; want >= 0; want-- {
+		rec := do()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("Expected limit 3, got %q", got)
+		}
+		if got := remaining(rec); got != want {
+			t.Errorf("Expected %d remaining, got %d", want, got)
+		}
+		// Zero while a token is left; the next one is 100ms away, rounded up
+		wantReset := "0"
+		if want == 0 {
+			wantReset = "1"
+		}
+		if got := rec.Header().Get("X-RateLimit-Reset"); got != wantReset {
+			t.Errorf("Expected reset %s with %d remaining, got %q", wantReset, want, got)
+		}
+	}
+
+	rec := do()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 once the bucket is empty, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Reset"); got != "1" {
+		t.Errorf("Expected reset 1 once the bucket is empty, got %q", got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1 on rejection, got %q", got)
+	}
+	if got := remaining(rec); got != 0 {
+		t.Errorf("Expected 0 remaining on rejection, got %d", got)
+	}
+
+	fake.Advance(200 * time.Millisecond)
+	if rec := do(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after partial refill, got %d", rec.Code)
+	}
+	fake.Advance(300 * time.Millisecond)
+	rec = do()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after refill, got %d", rec.Code)
+	}
+	if got := remaining(rec); got != 2 {
+		t.Errorf("Expected the bucket to refill to 2 remaining, got %d", got)
+	}
+}
+
+func TestRateLimiter_PeekDoesNotConsume(t *testing.T) {
+	rl := middleware.NewRateLimiter(60, 2)
+	fake := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	rl.SetClock(fake)
+
+	if q := rl.Peek("k"); !q.Allowed || q.Remaining != 2 || q.Limit != 2 {
+		t.Errorf("Expected a full bucket for an unseen key, got %+v", q)
+	}
+	rl.Take("k")
+	rl.Take("k")
+	for i := 0; i < 3; i++ {
+		if q := rl.Peek("k"); q.Allowed || q.Remaining != 0 || q.Reset != time.Second {
+			t.Errorf("Expected an empty bucket a second from a token, got %+v", q)
+		}
+	}
+
+	fake.Advance(time.Second)
+	if q := rl.Peek("k"); !q.Allowed || q.Remaining != 1 || q.Reset != 0 {
+		t.Errorf("Expected a token after a second, got %+v", q)
+	}
+	if q := rl.Take("k"); !q.Allowed {
+		t.Error("Expected the peeked token to still be there")
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a handler panic into a 500 JSON error, logging the request
+// ID, method, path and stack to logger, so one bad request doesn't take the
+// connection down without a trace. Wrap it inside the logging middleware so
+// the request ID is set. If the handler had already started its response,
+// the status can't be changed, so the connection is aborted instead of
+// leaving the client with a truncated success.
+func Recover(logger *log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec) // A deliberate abort, which net/http handles quietly
+				}
+
+				requestID := GetRequestID(r.Context())
+				logger.Printf("[%s] panic serving %s %s: %v\n%s", requestID, r.Method, r.URL.Path, rec, debug.Stack())
+
+				if wrapped.wroteHeader {
+					panic(http.ErrAbortHandler)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error":      "Internal server error",
+					"request_id": requestID,
+				})
+			}()
+
+			next.ServeHTTP(wrapped, r)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestRecover_PanicYields500AndServerStaysUp(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	logging := middleware.NewLoggingMiddleware(log.New(io.Discard, "", 0))
+	server := httptest.NewServer(logging.Middleware(middleware.Recover(logger)(mux)))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/panic", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected a response to the panicking request, got %v", err)
+	}
+	var body map[string]string
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a 500 JSON error, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if body["request_id"] != "req-123" || body["error"] == "" {
+		t.Errorf("Unexpected error body %v", body)
+	}
+	for _, want := range []string{"[req-123]", "GET /panic", "boom", "recover_test.go"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected the log to contain %q, got %q", want, logs.String())
+		}
+	}
+
+	resp, err = http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatalf("Expected the server to keep serving, got %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != "ok" {
+		t.Errorf("Expected 200 ok after the panic, got %d %q", resp.StatusCode, got)
+	}
+}
+
+func TestRecover_StartedResponseIsNotRewritten(t *testing.T) {
+	h := middleware.Recover(log.New(io.Discard, "", 0))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "partial")
+		panic("late failure")
+	}))
+
+	rec := httptest.NewRecorder()
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("Expected the connection to be aborted, got %v", p)
+		}
+		if rec.Code != http.StatusAccepted || rec.Body.String() != "partial" {
+			t.Errorf("Expected the started response left alone, got %d %q", rec.Code, rec.Body.String())
+		}
+	}()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestRecover_PanicBehindCompressionYields500(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"partial":`) // Under the compression threshold, so still held back
+		panic("boom")
+	})
+	// The same order as the server's chain
+	var h http.Handler = middleware.Timeout(time.Second)(mux)
+	h = middleware.Compression()(h)
+	h = middleware.Recover(log.New(io.Discard, "", 0))(h)
+	h = middleware.NewLoggingMiddleware(log.New(io.Discard, "", 0)).Middleware(h)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/panic", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected the 500 response, got %v", err)
+	}
+	defer resp.Body.Close()
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected a JSON error body, got %v", err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || body["error"] == "" {
+		t.Errorf("Expected a 500 JSON error, got %d %v", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// redisTimeout bounds dialing and each command, so an unreachable Redis
+// costs a request at most this long before the caller falls back
+const redisTimeout = 250 * time.Millisecond
+
+// redisPoolSize is how many idle connections a redisClient keeps, so
+// concurrent requests don't queue behind one socket
+const redisPoolSize = 8
+
+// redisError is an error reply from the server, as opposed to a failure to
+// reach it
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisOptions is where and how to connect, as parsed by parseRedisURL
+type redisOptions struct {
+	addr     string
+	username string // Empty uses AUTH with the password alone
+	password string
+	db       int
+	tls      bool
+}
+
+// parseRedisURL accepts redis://[[user]:password@]host[:port][/db], the
+// same with rediss:// for TLS, or a bare host:port
+func parseRedisURL(raw string) (redisOptions, error) {
+	if !strings.Contains(raw, "://") {
+		return redisOptions{addr: raw}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redisOptions{}, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	var opts redisOptions
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		opts.tls = true
+	default:
+		return redisOptions{}, fmt.Errorf("invalid Redis URL scheme %q (want redis or rediss)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return redisOptions{}, errors.New("invalid Redis URL: no host")
+	}
+	opts.addr = u.Host
+	if u.Port() == "" {
+		opts.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.username = u.User.Username()
+		opts.password, _ = u.User.Password()
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if opts.db, err = strconv.Atoi(path); err != nil || opts.db < 0 {
+			return redisOptions{}, fmt.Errorf("invalid Redis database %q", path)
+		}
+	}
+	return opts, nil
+}
+
+// redisClient is a minimal RESP client with a small pool of connections;
+// one that fails with an I/O error is dropped rather than reused. The rate
+// limiter only needs EVAL and EVALSHA, so this avoids pulling in a full
+// client library.
+type redisClient struct {
+	opts   redisOptions
+	idle   chan *redisConn
+	closed atomic.Bool
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newRedisClient(opts redisOptions) *redisClient {
+	return &redisClient{opts: opts, idle: make(chan *redisConn, redisPoolSize)}
+}
+
+// Do sends one command and returns its reply: a string, int64, nil, or
+// []interface{} of those. Error replies are returned as redisError.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		if conn, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := conn.roundTrip(args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state; don't reuse it
+		conn.Close()
+		return reply, err
+	}
+	if c.closed.Load() {
+		conn.Close()
+		return reply, err
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close() // The pool is full
+	}
+	return reply, err
+}
+
+// dial connects, authenticates and selects the database
+func (c *redisClient) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var netConn net.Conn
+	var err error
+	if c.opts.tls {
+		host, _, _ := net.SplitHostPort(c.opts.addr)
+		netConn, err = tls.DialWithDialer(dialer, "tcp", c.opts.addr, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		netConn, err = dialer.Dial("tcp", c.opts.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, r: bufio.NewReader(netConn)}
+
+	var setup [][]string
+	if c.opts.password != "" {
+		if c.opts.username != "" {
+			setup = append(setup, []string{"AUTH", c.opts.username, c.opts.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.opts.password})
+		}
+	}
+	if c.opts.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.db)})
+	}
+	for _, cmd := range setup {
+		if _, err := conn.roundTrip(cmd); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis %s: %w", cmd[0], err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *redisConn) roundTrip(args []string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err // Null bulk string when n is -1
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			// Fail the whole reply, as the rest of the array is unread and
+			// the connection has to be redialed
+			if items[i], err = c.readReply(); err != nil {
+				return nil, fmt.Errorf("redis: reading array: %v", err)
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// Close closes the idle connections. Connections in use are closed as
+// they are returned.
+func (c *redisClient) Close() error {
+	c.closed.Store(true)
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/gen/go/sentinel/v1/sentinelv1connect"
+)
+
+// API key scopes. db.ScopeAll grants every one of them, and is the only
+// scope that covers RPCs and routes without an entry below.
+const (
+	ScopeHeartbeat    = "heartbeat"
+	ScopeMetricsWrite = "metrics:write"
+	ScopeCostsRead    = "costs:read"
+	ScopeCostsWrite   = "costs:write"
+	ScopeKeysAdmin    = "keys:admin"
+)
+
+// KnownScopes lists every scope a key may be granted, including db.ScopeAll
+var KnownScopes = []string{db.ScopeAll, ScopeHeartbeat, ScopeMetricsWrite, ScopeCostsRead, ScopeCostsWrite, ScopeKeysAdmin}
+
+// readOnlyScopes are the scopes that grant no writes
+var readOnlyScopes = []string{ScopeCostsRead}
+
+// ReadOnly reports whether a key with the given scopes can't change anything
+func ReadOnly(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(readOnlyScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateScopes returns an error naming the first scope not in KnownScopes
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		known := false
+		for _, k := range KnownScopes {
+			if scope == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown scope %q (known scopes: %s)", scope, strings.Join(KnownScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// ProcedureScopes maps each RPC procedure to the scope it requires
+var ProcedureScopes = map[string]string{
+	sentinelv1connect.SentinelServiceHeartbeatProcedure: ScopeHeartbeat,
+}
+
+// ProcedureScope returns the scope needed to call an RPC procedure
+func ProcedureScope(procedure string) string {
+	if scope, ok := ProcedureScopes[procedure]; ok {
+		return scope
+	}
+	return db.ScopeAll
+}
+
+// RouteScope returns the scope needed for an HTTP request, or "" when any
+// valid key may make it
+func RouteScope(r *http.Request) string {
+	path := r.URL.Path
+	under := func(prefix string) bool {
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	switch {
+	case path == "/api/whoami", path == "/whoami":
+		return ""
+	case path == "/api/metrics/bulk":
+		return ScopeMetricsWrite
+	case under("/api/sync-costs"):
+		// Includes the streamed sync, which is a GET but still syncs
+		return ScopeCostsWrite
+	case under("/api/clouds") && strings.HasSuffix(path, "/reveal"):
+		// Returns credentials, so only full-access keys may call it
+		return db.ScopeAll
+	case under("/api/costs"), under("/api/clouds"), under("/api/recommendations"), under("/api/budgets"):
+		if read {
+			return ScopeCostsRead
+		}
+		return ScopeCostsWrite
+	case under("/api/keys"):
+		return ScopeKeysAdmin
+	}
+	return db.ScopeAll
+}
+
+// hasScope reports whether the granted scopes cover the required one
+func hasScope(granted []string, required string) bool {
+	if required == "" {
+		return true
+	}
+	for _, scope := range granted {
+		if scope == db.ScopeAll || scope == required {
+			return true
+		}
+	}
+	return false
+}
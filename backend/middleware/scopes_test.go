@@ -0,0 +1,149 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/middleware"
+	sentinelv1 "github.com/sennet/sennet/gen/go/sentinel/v1"
+	"github.com/sennet/sennet/gen/go/sentinel/v1/sentinelv1connect"
+)
+
+func setupScopedKeys(t *testing.T) (database *db.DB, heartbeatKey, costsKey string) {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	heartbeatKey, err = database.CreateScopedAPIKey("agent", 0, []string{middleware.ScopeHeartbeat})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	costsKey, err = database.CreateScopedAPIKey("reports", 0, []string{middleware.ScopeCostsRead})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	return database, heartbeatKey, costsKey
+}
+
+func TestGetAPIKeyScopes(t *testing.T) {
+	database, heartbeatKey, _ := setupScopedKeys(t)
+
+	scopes, err := database.GetAPIKeyScopes(heartbeatKey)
+	if err != nil {
+		t.Fatalf("GetAPIKeyScopes failed: %v", err)
+	}
+	if len(scopes) != 1 || scopes[0] != middleware.ScopeHeartbeat {
+		t.Errorf("Expected [heartbeat], got %v", scopes)
+	}
+
+	full, _ := database.CreateAPIKey("full")
+	if scopes, _ := database.GetAPIKeyScopes(full); len(scopes) != 1 || scopes[0] != db.ScopeAll {
+		t.Errorf("Expected unscoped key to get full access, got %v", scopes)
+	}
+	if scopes, _ := database.GetAPIKeyScopes("sk_missing"); scopes != nil {
+		t.Errorf("Expected nil scopes for unknown key, got %v", scopes)
+	}
+}
+
+func TestAuthInterceptor_EnforcesProcedureScopes(t *testing.T) {
+	database, heartbeatKey, costsKey := setupScopedKeys(t)
+
+	// The unimplemented service still runs interceptors, so passing auth
+	// surfaces as CodeUnimplemented
+	path, h := sentinelv1connect.NewSentinelServiceHandler(
+		sentinelv1connect.UnimplementedSentinelServiceHandler{},
+		connect.WithInterceptors(middleware.NewAuthInterceptor(database)),
+	)
+	mux := http.NewServeMux()
+	mux.Handle(path, h)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := sentinelv1connect.NewSentinelServiceClient(server.Client(), server.URL)
+
+	heartbeat := func(key string) connect.Code {
+		req := connect.NewRequest(&sentinelv1.HeartbeatRequest{AgentId: "agent-1"})
+		req.Header().Set("Authorization", "Bearer "+key)
+		_, err := client.Heartbeat(context.Background(), req)
+		return connect.CodeOf(err)
+	}
+
+	if code := heartbeat(heartbeatKey); code != connect.CodeUnimplemented {
+		t.Errorf("Expected heartbeat key to pass auth, got %v", code)
+	}
+	if code := heartbeat(costsKey); code != connect.CodePermissionDenied {
+		t.Errorf("Expected costs key to be denied on heartbeat, got %v", code)
+	}
+}
+
+func TestHTTPAuthMiddleware_EnforcesRouteScopes(t *testing.T) {
+	database, heartbeatKey, costsKey := setupScopedKeys(t)
+	h := middleware.NewHTTPAuthMiddleware(database)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		status int
+	}{
+		{"heartbeat key on costs", heartbeatKey, http.MethodGet, "/api/costs", http.StatusForbidden},
+		{"costs key on costs", costsKey, http.MethodGet, "/api/costs/summary", http.StatusOK},
+		{"costs key syncing", costsKey, http.MethodPost, "/api/sync-costs", http.StatusForbidden},
+		{"costs key streaming a sync", costsKey, http.MethodGet, "/api/sync-costs/stream", http.StatusForbidden},
+		{"costs key checking budgets", costsKey, http.MethodGet, "/api/budgets/alerts", http.StatusOK},
+		{"costs key setting a budget", costsKey, http.MethodPost, "/api/budgets", http.StatusForbidden},
+		{"costs key on keys", costsKey, http.MethodGet, "/api/keys", http.StatusForbidden},
+		{"costs key revealing credentials", costsKey, http.MethodGet, "/api/clouds/aws-main/reveal", http.StatusForbidden},
+		{"heartbeat key on whoami", heartbeatKey, http.MethodGet, "/api/whoami", http.StatusOK},
+		{"heartbeat key on the /whoami alias", heartbeatKey, http.MethodGet, "/whoami", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	if err := middleware.ValidateScopes([]string{"*", middleware.ScopeCostsRead, middleware.ScopeHeartbeat}); err != nil {
+		t.Errorf("Expected known scopes to validate, got %v", err)
+	}
+	err := middleware.ValidateScopes([]string{middleware.ScopeCostsRead, "costs:delete"})
+	if err == nil || !strings.Contains(err.Error(), `"costs:delete"`) {
+		t.Errorf("Expected an error naming costs:delete, got %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		want   bool
+	}{
+		{[]string{middleware.ScopeCostsRead}, true},
+		{[]string{"*"}, false},
+		{[]string{middleware.ScopeCostsRead, middleware.ScopeCostsWrite}, false},
+		{[]string{middleware.ScopeHeartbeat}, false},
+	}
+	for _, tt := range tests {
+		if got := middleware.ReadOnly(tt.scopes); got != tt.want {
+			t.Errorf("ReadOnly(%v) = %v, want %v", tt.scopes, got, tt.want)
+		}
+	}
+}
@@ -2,14 +2,18 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sennet/sennet/backend/db"
@@ -20,20 +24,39 @@ const (
 	SignatureHeader = "X-Sennet-Signature"
 	// TimestampHeader is the header containing the request timestamp
 	TimestampHeader = "X-Sennet-Timestamp"
+	// NonceHeader carries a single-use client nonce, which is folded into the
+	// signature. It is optional unless SetNonceRequired is on.
+	NonceHeader = "X-Sennet-Nonce"
+	// MaxNonceLength is the longest nonce accepted
+	MaxNonceLength = 128
 	// MaxTimestampAge is the maximum age of a request before it's rejected (5 minutes)
 	MaxTimestampAge = 5 * 60
+	// DefaultMaxSignedBodyBytes is the largest body buffered for signature verification (1 MiB)
+	DefaultMaxSignedBodyBytes = 1 << 20
 )
 
 // SignatureMiddleware creates middleware that verifies HMAC signatures on requests
 // This provides protection against:
 // - Request tampering (HMAC verification)
-// - Replay attacks (timestamp validation)
+// - Replay attacks (timestamp validation and single-use nonces)
+//
+// The nonce is optional by default: requests signed without one, as older
+// agents send them, are still accepted but can be replayed within
+// MaxTimestampAge. SetNonceRequired closes that gap.
 func SignatureMiddleware(database *db.DB) func(http.Handler) http.Handler {
+	return SignatureMiddlewareWithLimit(database, DefaultMaxSignedBodyBytes)
+}
+
+// SignatureMiddlewareWithLimit is SignatureMiddleware with a custom cap on the
+// body it buffers. Signed requests with larger bodies are rejected with 413
+// once the cap is exceeded, without reading the rest of the body.
+func SignatureMiddlewareWithLimit(database *db.DB, maxBodyBytes int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract headers
 			signature := r.Header.Get(SignatureHeader)
 			timestampStr := r.Header.Get(TimestampHeader)
+			nonce := r.Header.Get(NonceHeader)
 
 			// Signature is optional for backward compatibility
 			// If not present, skip verification but log a warning
@@ -49,20 +72,42 @@ func SignatureMiddleware(database *db.DB) func(http.Handler) http.Handler {
 				http.Error(w, "Invalid timestamp format", http.StatusBadRequest)
 				return
 			}
+			if len(nonce) > MaxNonceLength {
+				http.Error(w, "Nonce too long", http.StatusBadRequest)
+				return
+			}
+			if nonce == "" && nonceRequired.Load() {
+				http.Error(w, "Nonce required", http.StatusUnauthorized)
+				return
+			}
 
 			// Check timestamp is within acceptable range (prevent replay attacks)
-			now := time.Now().Unix()
-			if abs(now-timestamp) > MaxTimestampAge {
+			now := database.Now()
+			if abs(now.Unix()-timestamp) > MaxTimestampAge {
 				http.Error(w, "Request expired", http.StatusUnauthorized)
 				return
 			}
 
-			// Read body for verification
-			body, err := io.ReadAll(r.Body)
+			// Read body for verification, reading at most one byte past the cap
+			if r.ContentLength > maxBodyBytes {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				// Cut off by an outer MaxBodyBytes
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			if err != nil {
 				http.Error(w, "Failed to read request body", http.StatusBadRequest)
 				return
 			}
+			if int64(len(body)) > maxBodyBytes {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			// Restore body for downstream handlers
 			r.Body = io.NopCloser(bytes.NewBuffer(body))
 
@@ -81,19 +126,57 @@ func SignatureMiddleware(database *db.DB) func(http.Handler) http.Handler {
 			}
 
 			// Verify signature
-			expectedSig := signRequest(apiKey, timestamp, body)
+			expectedSig := signRequest(apiKey, timestamp, nonce, body)
 			if !verifySignature(expectedSig, signature) {
 				http.Error(w, "Invalid signature", http.StatusUnauthorized)
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			// Only a verified request may spend a nonce. It is remembered
+			// until the timestamp would be rejected as expired anyway.
+			if nonce != "" {
+				expires := time.Unix(timestamp+MaxTimestampAge, 0)
+				if !signatureNonces.Use(apiKey+"\x00"+nonce, expires, now) {
+					http.Error(w, "Nonce already used", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signatureVerifiedKey{}, true)))
 		})
 	}
 }
 
-// signRequest computes HMAC-SHA256 signature matching the Rust agent implementation
-func signRequest(secret string, timestamp int64, body []byte) string {
+// signatureNonces is shared by every signature middleware, so a request
+// can't be replayed against a different route
+var signatureNonces = NewNonceCache(DefaultNonceCapacity)
+
+// nonceRequired makes every signature middleware refuse signed requests
+// that carry no nonce
+var nonceRequired atomic.Bool
+
+// SetNonceRequired makes signed requests without a NonceHeader fail with
+// 401, so none can be replayed within MaxTimestampAge. Off by default for
+// agents that predate nonces.
+func SetNonceRequired(required bool) {
+	nonceRequired.Store(required)
+}
+
+type signatureVerifiedKey struct{}
+
+// SignatureVerified reports whether the request's signature was checked by
+// an outer signature middleware
+func SignatureVerified(ctx context.Context) bool {
+	verified, _ := ctx.Value(signatureVerifiedKey{}).(bool)
+	return verified
+}
+
+// signRequest computes HMAC-SHA256 signature matching the Rust agent
+// implementation. The nonce, if any, is signed between the timestamp and
+// body behind its little-endian uint32 length, so no bytes can be moved
+// between nonce and body; without one the signature is the legacy
+// timestamp-and-body form.
+func signRequest(secret string, timestamp int64, nonce string, body []byte) string {
 	mac := hmac.New(sha256.New, []byte(secret))
 
 	// Write timestamp as little-endian bytes (matching Rust i64::to_le_bytes())
@@ -101,6 +184,11 @@ func signRequest(secret string, timestamp int64, body []byte) string {
 	binary.LittleEndian.PutUint64(tsBytes, uint64(timestamp))
 	mac.Write(tsBytes)
 
+	if nonce != "" {
+		mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(nonce))))
+		mac.Write([]byte(nonce))
+	}
+
 	// Write body
 	mac.Write(body)
 
@@ -139,8 +227,24 @@ func abs(n int64) int64 {
 // RequireSignature creates a stricter middleware that requires signatures
 // Use this for sensitive endpoints
 func RequireSignature(database *db.DB) func(http.Handler) http.Handler {
+	return requireSignature(database, DefaultMaxSignedBodyBytes)
+}
+
+// RequireSignatureWithLimit is RequireSignature with a custom cap on the
+// body it buffers
+func RequireSignatureWithLimit(database *db.DB, maxBodyBytes int64) func(http.Handler) http.Handler {
+	return requireSignature(database, maxBodyBytes)
+}
+
+func requireSignature(database *db.DB, maxBodyBytes int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Already verified further out in the chain
+			if SignatureVerified(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			signature := r.Header.Get(SignatureHeader)
 			timestampStr := r.Header.Get(TimestampHeader)
 
@@ -154,7 +258,48 @@ func RequireSignature(database *db.DB) func(http.Handler) http.Handler {
 			}
 
 			// Delegate to the standard middleware
-			SignatureMiddleware(database)(next).ServeHTTP(w, r)
+			SignatureMiddlewareWithLimit(database, maxBodyBytes)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// DefaultSignedRoutes are the mutating endpoints that change credentials,
+// cloud configuration or agent behaviour: every key and admin route among
+// them, such as key deletion and the fleet-wide latest version
+var DefaultSignedRoutes = []string{"/api/clouds", "/api/keys", "/api/commands/", "/api/admin/"}
+
+// MutatingRoutes classifies a request as needing a signature when it uses a
+// state-changing method (anything but GET, HEAD and OPTIONS) on one of the
+// given paths. A path matches itself and anything below it; a trailing "/"
+// (as in "/api/commands/") matches only what is below it.
+func MutatingRoutes(paths []string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return false
+		}
+		for _, p := range paths {
+			if r.URL.Path == p || strings.HasPrefix(r.URL.Path, strings.TrimSuffix(p, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SignaturePolicyMiddleware requires signatures on requests matched by
+// requiresSignature and verifies them, when present, on everything else.
+// Signed bodies larger than maxBodyBytes are rejected with 413.
+func SignaturePolicyMiddleware(database *db.DB, requiresSignature func(*http.Request) bool, maxBodyBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		required := requireSignature(database, maxBodyBytes)(next)
+		optional := SignatureMiddlewareWithLimit(database, maxBodyBytes)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requiresSignature(r) {
+				required.ServeHTTP(w, r)
+				return
+			}
+			optional.ServeHTTP(w, r)
 		})
 	}
 }
@@ -0,0 +1,400 @@
+package middleware_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/clock"
+	"github.com/sennet/sennet/backend/db"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+const testSigningKey = "sk_test_signing_key"
+
+func setupSignatureDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.EnsureAPIKey(testSigningKey, "signing"); err != nil {
+		t.Fatalf("EnsureAPIKey failed: %v", err)
+	}
+	return database
+}
+
+// sign mirrors the agent's HMAC-SHA256 over the little-endian timestamp and body
+func sign(req *http.Request, body []byte) {
+	signAt(req, body, time.Now().Unix())
+}
+
+func signAt(req *http.Request, body []byte, ts int64) {
+	signWithNonce(req, body, ts, "")
+}
+
+// signWithNonce signs the length-prefixed nonce between the timestamp and
+// body, sending it in NonceHeader when set
+func signWithNonce(req *http.Request, body []byte, ts int64, nonce string) {
+	mac := hmac.New(sha256.New, []byte(testSigningKey))
+	tsBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tsBytes, uint64(ts))
+	mac.Write(tsBytes)
+	if nonce != "" {
+		mac.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(nonce))))
+		mac.Write([]byte(nonce))
+		req.Header.Set(middleware.NonceHeader, nonce)
+	}
+	mac.Write(body)
+	req.Header.Set(middleware.TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(middleware.SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func signedPolicyHandler(database *db.DB) http.Handler {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	classify := middleware.MutatingRoutes(middleware.DefaultSignedRoutes)
+	return middleware.SignaturePolicyMiddleware(database, classify, middleware.DefaultMaxSignedBodyBytes)(ok)
+}
+
+func TestSignaturePolicy_MutatingRequiresSignature(t *testing.T) {
+	h := signedPolicyHandler(setupSignatureDB(t))
+	body := []byte(`{"id":"aws-main"}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unsigned POST to be rejected with 401, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	sign(req, body)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected signed POST to pass, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignaturePolicy_ReadsStayOptional(t *testing.T) {
+	h := signedPolicyHandler(setupSignatureDB(t))
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/api/clouds"},
+		{http.MethodGet, "/api/keys"},
+		{http.MethodPost, "/api/sync-costs"},
+		{http.MethodPost, "/api/cloudsync"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s %s: expected unsigned request to pass, got %d", tc.method, tc.path, rec.Code)
+		}
+	}
+}
+
+func TestMutatingRoutes(t *testing.T) {
+	classify := middleware.MutatingRoutes([]string{"/api/clouds", "/api/commands/"})
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodPost, "/api/clouds", true},
+		{http.MethodDelete, "/api/clouds", true},
+		{http.MethodPatch, "/api/clouds/aws-main", true},
+		{http.MethodGet, "/api/clouds", false},
+		{http.MethodPost, "/api/commands/by-version", true},
+		{http.MethodPost, "/api/commands", false},
+		{http.MethodPost, "/api/costs", false},
+	}
+	for _, tt := range tests {
+		if got := classify(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultSignedRoutes_CoverMutatingAdminRoutes(t *testing.T) {
+	classify := middleware.MutatingRoutes(middleware.DefaultSignedRoutes)
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/api/clouds"},
+		{http.MethodPatch, "/api/clouds/aws-main"},
+		{http.MethodPost, "/api/keys/create"},
+		{http.MethodPost, "/api/keys/revoke"},
+		{http.MethodDelete, "/api/keys/kid_0123456789abcdef"},
+		{http.MethodPost, "/api/commands/by-version"},
+		{http.MethodPost, "/api/admin/latest-version"},
+		{http.MethodPost, "/api/admin/reencrypt"},
+		{http.MethodDelete, "/api/admin/ratelimits"},
+	} {
+		if !classify(httptest.NewRequest(route.method, route.path, nil)) {
+			t.Errorf("%s %s: expected a signature to be required", route.method, route.path)
+		}
+	}
+	if classify(httptest.NewRequest(http.MethodGet, "/api/keys", nil)) {
+		t.Error("Expected listing keys to stay unsigned")
+	}
+}
+
+// countingReader records how many bytes have been read from an endless body
+type countingReader struct {
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestSignatureMiddleware_RejectsOversizedBody(t *testing.T) {
+	const limit = 1024
+	called := false
+	h := middleware.SignatureMiddlewareWithLimit(setupSignatureDB(t), limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	// Unknown length: the body is streamed and must be cut off at the limit
+	body := &countingReader{}
+	req := httptest.NewRequest(http.MethodPost, "/api/clouds", body)
+	req.ContentLength = -1
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	sign(req, nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", rr.Code)
+	}
+	if called {
+		t.Error("Expected handler not to be called")
+	}
+	if body.n > limit+1 {
+		t.Errorf("Expected at most %d bytes read, got %d", limit+1, body.n)
+	}
+
+	// Declared length over the limit is rejected before reading anything
+	req = httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(make([]byte, limit+1)))
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	sign(req, make([]byte, limit+1))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for declared length, got %d", rr.Code)
+	}
+}
+
+func TestSignatureMiddleware_BodyWithinLimitPreserved(t *testing.T) {
+	var got []byte
+	h := middleware.SignatureMiddlewareWithLimit(setupSignatureDB(t), 1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+	}))
+
+	body := []byte(`{"id":"aws-main"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	sign(req, body)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("Expected downstream body %q, got %q", body, got)
+	}
+}
+
+func TestSignatureMiddleware_TimestampWindowUsesClock(t *testing.T) {
+	database := setupSignatureDB(t)
+	signedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(signedAt)
+	database.SetClock(fake)
+	h := middleware.SignatureMiddleware(database)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func() int {
+		body := []byte(`{}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testSigningKey)
+		signAt(req, body, signedAt.Unix())
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	fake.Advance(4 * time.Minute)
+	if code := do(); code != http.StatusOK {
+		t.Errorf("Expected request inside the window to pass, got %d", code)
+	}
+	fake.Advance(2 * time.Minute)
+	if code := do(); code != http.StatusUnauthorized {
+		t.Errorf("Expected request outside the window to expire, got %d", code)
+	}
+}
+
+func TestRequireSignature_TrustsOuterVerification(t *testing.T) {
+	database := setupSignatureDB(t)
+	verifications := 0
+	inner := middleware.RequireSignature(database)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.SignatureVerified(r.Context()) {
+			verifications++
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	h := middleware.SignatureMiddleware(database)(inner)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/clouds/aws-main/reveal", nil)
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	sign(req, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || verifications != 1 {
+		t.Errorf("Expected signed request through both layers, got %d (verified %d)", rec.Code, verifications)
+	}
+
+	// Unsigned requests still stop at the inner layer
+	req = httptest.NewRequest(http.MethodGet, "/api/clouds/aws-main/reveal", nil)
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unsigned request, got %d", rec.Code)
+	}
+}
+
+func TestSignatureMiddleware_RejectsReplayedNonce(t *testing.T) {
+	database := setupSignatureDB(t)
+	h := middleware.SignatureMiddleware(database)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ts := time.Now().Unix()
+	nonce := "replay-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	do := func(nonce string) int {
+		body := []byte(`{"id":"aws-main"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testSigningKey)
+		signWithNonce(req, body, ts, nonce)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do(nonce); code != http.StatusOK {
+		t.Fatalf("Expected the first use of a nonce to pass, got %d", code)
+	}
+	if code := do(nonce); code != http.StatusUnauthorized {
+		t.Errorf("Expected a replayed nonce to be rejected, got %d", code)
+	}
+	if code := do(nonce + "-fresh"); code != http.StatusOK {
+		t.Errorf("Expected a fresh nonce to pass, got %d", code)
+	}
+
+	// The nonce is signed, so swapping it for a fresh one breaks the signature
+	body := []byte(`{"id":"aws-main"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	signWithNonce(req, body, ts, nonce)
+	req.Header.Set(middleware.NonceHeader, nonce+"-swapped")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Invalid signature") {
+		t.Errorf("Expected a swapped nonce to fail verification, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignatureMiddleware_NonceRequired(t *testing.T) {
+	middleware.SetNonceRequired(true)
+	t.Cleanup(func() { middleware.SetNonceRequired(false) })
+	h := signedPolicyHandler(setupSignatureDB(t))
+	do := func(nonce string) *httptest.ResponseRecorder {
+		body := []byte(`{"id":"aws-main"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testSigningKey)
+		signWithNonce(req, body, time.Now().Unix(), nonce)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(""); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Nonce required") {
+		t.Errorf("Expected a signed request without a nonce to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("nonce-required-1"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a request with a nonce to pass, got %d", rec.Code)
+	}
+}
+
+func TestSignatureMiddleware_NonceBodyBoundary(t *testing.T) {
+	h := signedPolicyHandler(setupSignatureDB(t))
+	ts := time.Now().Unix()
+
+	// Sign nonce "ab" with body "c{}", then move the "c" into the nonce
+	body := []byte(`c{}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Authorization", "Bearer "+testSigningKey)
+	signWithNonce(req, body, ts, "ab")
+	req.Header.Set(middleware.NonceHeader, "abc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Invalid signature") {
+		t.Errorf("Expected bytes moved from the body into the nonce to fail verification, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNonceCache_EvictsExpired(t *testing.T) {
+	cache := middleware.NewNonceCache(10)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ttl := middleware.MaxTimestampAge * time.Second
+
+	for i := 0; i < 5; i++ {
+		if !cache.Use("n"+strconv.Itoa(i), now.Add(ttl), now) {
+			t.Fatalf("Expected nonce %d to be new", i)
+		}
+	}
+	if cache.Use("n0", now.Add(ttl), now.Add(time.Minute)) {
+		t.Error("Expected n0 to be remembered within the TTL")
+	}
+
+	// Once expired, entries are dropped and the nonce may be used again
+	later := now.Add(ttl)
+	if !cache.Use("n0", later.Add(ttl), later) {
+		t.Error("Expected n0 to be usable after it expired")
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Expected expired nonces evicted, %d remain", n)
+	}
+}
+
+func TestNonceCache_BoundedAtCapacity(t *testing.T) {
+	cache := middleware.NewNonceCache(3)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		cache.Use("n"+strconv.Itoa(i), now.Add(time.Duration(i+1)*time.Minute), now)
+	}
+	if n := cache.Len(); n != 3 {
+		t.Errorf("Expected the cache capped at 3, got %d", n)
+	}
+	if cache.Use("n9", now.Add(time.Hour), now) {
+		t.Error("Expected the newest nonce to be kept")
+	}
+}
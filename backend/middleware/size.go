@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/sennet/sennet/backend/metrics"
+)
+
+// UnmatchedRoute labels requests that no route pattern matched
+const UnmatchedRoute = "unmatched"
+
+// countingReader counts the bytes a handler actually reads from a body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// BodySizeMetrics records request and response body sizes per route into m.
+// routeOf maps a request to a bounded route label, such as the pattern
+// returned by http.ServeMux.Handler; "" is recorded as UnmatchedRoute.
+func BodySizeMetrics(m *metrics.Metrics, routeOf func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(wrapped, r)
+
+			route := routeOf(r)
+			if route == "" {
+				route = UnmatchedRoute
+			}
+			m.ObserveHTTPBytes(route, body.n, wrapped.bytes)
+		})
+	}
+}
+
+// DefaultMaxBodyBytes is the largest request body MaxBodyBytes lets through
+// by default (10 MiB)
+const DefaultMaxBodyBytes = 10 << 20
+
+// MaxBodyBytes caps request bodies at limit bytes. A declared Content-Length
+// over the limit is rejected with 413 before the handler runs; otherwise the
+// body is wrapped in http.MaxBytesReader, and once a read hits the limit any
+// 4xx status the handler sends becomes 413. Wrap it outside middleware that
+// reads the body, such as SignatureMiddleware, so they see the limit too.
+func MaxBodyBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+			r.Body = body
+			next.ServeHTTP(&limitedBodyWriter{ResponseWriter: w, body: body}, r)
+		})
+	}
+}
+
+// limitedBody notes when a read fails for exceeding the limit
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limitedBodyWriter reports a client error as 413 when the body was cut off,
+// as handlers typically see only a failed read or decode
+type limitedBodyWriter struct {
+	http.ResponseWriter
+	body *limitedBody
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *limitedBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *limitedBodyWriter) WriteHeader(code int) {
+	if w.body.exceeded && code >= 400 && code < 500 {
+		code = http.StatusRequestEntityTooLarge
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (w *limitedBodyWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,124 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sennet/sennet/backend/metrics"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+// histogramSum returns the observed sum and count for one route's series
+func histogramSum(t *testing.T, h *prometheus.HistogramVec, route string) (float64, uint64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.WithLabelValues(route).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("Failed to read histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleSum(), m.GetHistogram().GetSampleCount()
+}
+
+func TestBodySizeMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+		w.Write(body)
+	})
+	h := middleware.BodySizeMetrics(metrics.Default(), func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	})(mux)
+
+	metrics.HTTPRequestBytes.Reset()
+	metrics.HTTPResponseBytes.Reset()
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/echo", strings.NewReader(strings.Repeat("x", 100))))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	if sum, count := histogramSum(t, metrics.HTTPRequestBytes, "/api/echo"); sum != 100 || count != 1 {
+		t.Errorf("Expected one 100 byte request, got sum=%v count=%d", sum, count)
+	}
+	if sum, count := histogramSum(t, metrics.HTTPResponseBytes, "/api/echo"); sum != 200 || count != 1 {
+		t.Errorf("Expected one 200 byte response, got sum=%v count=%d", sum, count)
+	}
+	if _, count := histogramSum(t, metrics.HTTPResponseBytes, middleware.UnmatchedRoute); count != 1 {
+		t.Errorf("Expected unmatched request under %q, got count=%d", middleware.UnmatchedRoute, count)
+	}
+	if got := testutil.CollectAndCount(metrics.HTTPResponseBytes); got != 2 {
+		t.Errorf("Expected 2 route series, got %d", got)
+	}
+}
+
+// decodeHandler reads a JSON body the way the API handlers do, answering 400
+// on a failed decode
+func decodeHandler(w http.ResponseWriter, r *http.Request) {
+	var v interface{}
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	const limit = 64
+	h := middleware.MaxBodyBytes(limit)(http.HandlerFunc(decodeHandler))
+	body := func(size int) string {
+		return `"` + strings.Repeat("x", size-2) + `"`
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool // Hide the length so only the reader can catch it
+		want    int
+	}{
+		{"at limit", limit, false, http.StatusOK},
+		{"over limit", limit + 1, false, http.StatusRequestEntityTooLarge},
+		{"chunked at limit", limit, true, http.StatusOK},
+		{"chunked over limit", limit + 1, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/clouds", strings.NewReader(body(tt.size)))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytes_AppliesBeforeSignatureRead(t *testing.T) {
+	database := setupSignatureDB(t)
+	h := middleware.MaxBodyBytes(64)(middleware.SignatureMiddlewareWithLimit(database, 1024)(http.HandlerFunc(decodeHandler)))
+
+	for _, tt := range []struct {
+		size int
+		want int
+	}{{64, http.StatusOK}, {65, http.StatusRequestEntityTooLarge}} {
+		payload := []byte(`"` + strings.Repeat("x", tt.size-2) + `"`)
+		req := httptest.NewRequest(http.MethodPost, "/api/clouds", bytes.NewReader(payload))
+		req.ContentLength = -1
+		req.Header.Set("Authorization", "Bearer "+testSigningKey)
+		sign(req, payload)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%d byte signed body: expected %d, got %d: %s", tt.size, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// DefaultRequestTimeout bounds how long a handler may take to start its
+// response, kept under the server's 30s write timeout so the client gets a
+// 503 rather than a dropped connection
+const DefaultRequestTimeout = 25 * time.Second
+
+// Timeout cancels the request context after d and, if the handler hasn't
+// started its response by then, answers 503 with a JSON error; anything the
+// handler writes afterwards is discarded with http.ErrHandlerTimeout.
+// Handlers that have started writing (such as event streams) are left to
+// finish. Unlike http.TimeoutHandler, responses aren't buffered, so
+// flushing still works. Panics in the handler are re-raised on the calling
+// goroutine, so keep Recover outside it; a panic after the 503 was sent has
+// nobody to re-raise it to and is logged with its stack instead.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{w: w, header: make(http.Header), ctx: ctx}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						if tw.recordPanic() {
+							panicked <- p
+							return
+						}
+						logging.Errorf("[%s] panic serving %s %s after it timed out: %v\n%s",
+							GetRequestID(r.Context()), r.Method, r.URL.Path, p, debug.Stack())
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				// A handler returning as it notices the deadline may beat
+				// this goroutine to it; its response is refused either way
+				if !tw.expired() || !tw.timeout(r) {
+					tw.finish()
+				}
+			case p := <-panicked:
+				panic(p)
+			case <-ctx.Done():
+				if tw.timeout(r) {
+					return
+				}
+				// The response had started; let the handler finish it
+				select {
+				case <-done:
+				case p := <-panicked:
+					panic(p)
+				}
+			}
+		})
+	}
+}
+
+// timeoutWriter gives the handler its own header map and serializes its
+// writes against the timeout response
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	ctx    context.Context // Past its deadline, no response may be started
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+	panicked    bool // The handler panicked before timing out, see recordPanic
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter { return tw.w }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.timedOut || tw.wroteHeader || tw.expired() {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || (!tw.wroteHeader && tw.expired()) {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.w.Write(p)
+}
+
+// expired reports whether the deadline has passed, as opposed to the client
+// having gone away. The clock is checked too, as contexts derived with the
+// same deadline have their own timers, which may fire before ctx's.
+func (tw *timeoutWriter) expired() bool {
+	if tw.ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	deadline, _ := tw.ctx.Deadline()
+	return !time.Now().Before(deadline)
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// recordPanic notes that the handler panicked, so the panic is re-raised
+// rather than answered with a 503. It reports false if the 503 already went.
+func (tw *timeoutWriter) recordPanic() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return false
+	}
+	tw.panicked = true
+	return true
+}
+
+// finish sends the header of a handler that returned without writing
+func (tw *timeoutWriter) finish() {
+	tw.WriteHeader(http.StatusOK)
+}
+
+// timeout sends the 503, reporting false if the handler got its response
+// started, or panicked, first
+func (tw *timeoutWriter) timeout(r *http.Request) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader || tw.panicked {
+		return false
+	}
+	tw.timedOut = true
+	tw.w.Header().Set("Content-Type", "application/json")
+	tw.w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(tw.w).Encode(map[string]string{
+		"error":      "Request timed out",
+		"request_id": GetRequestID(r.Context()),
+	})
+	return true
+}
@@ -0,0 +1,132 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+	"github.com/sennet/sennet/backend/middleware"
+)
+
+func TestTimeout_FastHandlerPasses(t *testing.T) {
+	h := middleware.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "fast")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "done")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusCreated || rec.Body.String() != "done" || rec.Header().Get("X-Handler") != "fast" {
+		t.Errorf("Expected the handler's response, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+}
+
+func TestTimeout_SlowHandlerGets503(t *testing.T) {
+	cancelled := make(chan error, 1)
+	wrote := make(chan error, 1)
+	h := middleware.Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+		_, err := io.WriteString(w, "too late")
+		wrote <- err
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a 503 JSON response, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["error"] == "" {
+		t.Errorf("Expected a JSON timeout message, got %v (%v)", body, err)
+	}
+	if err := <-cancelled; err == nil {
+		t.Error("Expected the handler's context to be cancelled")
+	}
+	if err := <-wrote; err != http.ErrHandlerTimeout {
+		t.Errorf("Expected a late write to fail with ErrHandlerTimeout, got %v", err)
+	}
+}
+
+func TestTimeout_StartedResponseFinishes(t *testing.T) {
+	h := middleware.Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "event 1\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		io.WriteString(w, "event 2\n")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "event 1\nevent 2\n" {
+		t.Errorf("Expected the streamed response left intact, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeout_PanicReachesCaller(t *testing.T) {
+	h := middleware.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("Expected the panic re-raised, got %v", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// lockedBuffer collects log output written from another goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTimeout_PanicAfterTimeoutIsLogged(t *testing.T) {
+	logs := &lockedBuffer{}
+	prev := logging.Default()
+	logging.SetDefault(logging.New(logs, logging.LevelError))
+	t.Cleanup(func() { logging.SetDefault(prev) })
+
+	h := middleware.Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond) // Let the 503 go out first
+		panic("late boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/late", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", rec.Code)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(logs.String(), "late boom") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "panic serving GET /late after it timed out: late boom") {
+		t.Fatalf("Expected the late panic logged, got %q", out)
+	}
+	if !strings.Contains(out, "goroutine") {
+		t.Errorf("Expected a stack trace with the late panic, got %q", out)
+	}
+}
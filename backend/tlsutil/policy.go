@@ -0,0 +1,58 @@
+// Package tlsutil builds the server's TLS configuration
+package tlsutil
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// Cipher policy names, following Mozilla's server-side TLS profiles
+const (
+	PolicyModern       = "modern"       // TLS 1.3 only
+	PolicyIntermediate = "intermediate" // TLS 1.2+ with forward-secret AEAD suites
+)
+
+// intermediateCipherSuites applies to TLS 1.2 connections; TLS 1.3 suites
+// are fixed by crypto/tls and always secure
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// ParseMinVersion converts "1.2" or "1.3" into a crypto/tls version constant
+func ParseMinVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS minimum version %q (use 1.2 or 1.3)", v)
+	}
+}
+
+// NewConfig returns a tls.Config enforcing the given minimum version and
+// cipher policy. The modern policy requires a 1.3 minimum.
+func NewConfig(minVersion, cipherPolicy string) (*tls.Config, error) {
+	version, err := ParseMinVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{MinVersion: version}
+	switch cipherPolicy {
+	case PolicyModern:
+		if version != tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher policy %q requires -tls-min-version 1.3", cipherPolicy)
+		}
+	case PolicyIntermediate:
+		cfg.CipherSuites = intermediateCipherSuites
+	default:
+		return nil, fmt.Errorf("unknown cipher policy %q (use %s or %s)", cipherPolicy, PolicyModern, PolicyIntermediate)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,92 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func startTLSServer(t *testing.T, cfg *tls.Config) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func handshake(t *testing.T, srv *httptest.Server, minVer, maxVer uint16) (*tls.ConnectionState, error) {
+	t.Helper()
+	client := srv.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.MinVersion = minVer
+	transport.TLSClientConfig.MaxVersion = maxVer
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return resp.TLS, nil
+}
+
+func TestNewConfig_RefusesTLS11WhenMinIs12(t *testing.T) {
+	cfg, err := NewConfig("1.2", PolicyIntermediate)
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
+	srv := startTLSServer(t, cfg)
+
+	if _, err := handshake(t, srv, tls.VersionTLS11, tls.VersionTLS11); err == nil {
+		t.Error("Expected TLS 1.1 client to be refused")
+	}
+
+	state, err := handshake(t, srv, tls.VersionTLS12, tls.VersionTLS12)
+	if err != nil {
+		t.Fatalf("Expected TLS 1.2 client to connect: %v", err)
+	}
+	if state.Version != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2, got %x", state.Version)
+	}
+}
+
+func TestNewConfig_Negotiates13(t *testing.T) {
+	cfg, err := NewConfig("1.3", PolicyModern)
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
+	srv := startTLSServer(t, cfg)
+
+	if _, err := handshake(t, srv, tls.VersionTLS12, tls.VersionTLS12); err == nil {
+		t.Error("Expected TLS 1.2 client to be refused when minimum is 1.3")
+	}
+
+	state, err := handshake(t, srv, tls.VersionTLS12, tls.VersionTLS13)
+	if err != nil {
+		t.Fatalf("Expected TLS 1.3 handshake: %v", err)
+	}
+	if state.Version != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %x", state.Version)
+	}
+}
+
+func TestNewConfig_RejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name, minVersion, policy string
+	}{
+		{"tls 1.1", "1.1", PolicyIntermediate},
+		{"garbage version", "latest", PolicyIntermediate},
+		{"unknown policy", "1.2", "legacy"},
+		{"modern requires 1.3", "1.2", PolicyModern},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewConfig(tt.minVersion, tt.policy); err == nil {
+				t.Errorf("Expected error for min=%s policy=%s", tt.minVersion, tt.policy)
+			}
+		})
+	}
+}
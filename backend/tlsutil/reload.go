@@ -0,0 +1,106 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// DefaultReloadCheckInterval is how often CertReloader looks at the files'
+// modification times
+const DefaultReloadCheckInterval = 5 * time.Second
+
+// CertReloader serves a certificate from files on disk and re-reads them
+// when either changes, so a renewed certificate (such as from Let's Encrypt)
+// is picked up by new connections without a restart. Hook GetCertificate
+// into a tls.Config.
+type CertReloader struct {
+	certFile, keyFile string
+	checkInterval     time.Duration
+
+	cert      atomic.Pointer[tls.Certificate]
+	nextCheck atomic.Int64 // Unix nanoseconds; earlier handshakes skip the files
+
+	mu          sync.Mutex   // Serializes reloads
+	modTime     [2]time.Time // Of certFile and keyFile when cert was loaded
+	lastFailure string       // Logged reload failure, so each is logged once
+}
+
+// NewCertReloader loads the certificate and key, failing if they don't form
+// a valid pair
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, checkInterval: DefaultReloadCheckInterval}
+	modTime, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	r.nextCheck.Store(time.Now().Add(r.checkInterval).UnixNano())
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, first reloading it if the
+// files changed. The files are checked at most once per check interval, by
+// whichever handshake comes due first. A pair that fails to load (for
+// instance, caught halfway through being replaced) is logged once and the
+// previous certificate kept; it is retried on the next check.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	now := time.Now().UnixNano()
+	next := r.nextCheck.Load()
+	if now >= next && r.nextCheck.CompareAndSwap(next, now+int64(r.checkInterval)) {
+		r.reload()
+	}
+	return r.cert.Load(), nil
+}
+
+// reload re-reads the pair if either file's modification time changed
+func (r *CertReloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.modTimes()
+	if err == nil {
+		if modTime == r.modTime {
+			return
+		}
+		if err = r.load(modTime); err == nil {
+			r.lastFailure = ""
+			logging.Infof("Reloaded TLS certificate from %s", r.certFile)
+			return
+		}
+	}
+	failure := fmt.Sprintf("%v (%v)", err, modTime)
+	if failure != r.lastFailure {
+		r.lastFailure = failure
+		logging.Warnf("Keeping the current TLS certificate: %v", err)
+	}
+}
+
+func (r *CertReloader) modTimes() ([2]time.Time, error) {
+	var modTime [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTime, err
+		}
+		modTime[i] = info.ModTime()
+	}
+	return modTime, nil
+}
+
+func (r *CertReloader) load(modTime [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
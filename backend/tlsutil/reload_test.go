@@ -0,0 +1,162 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sennet/sennet/backend/logging"
+)
+
+// writeSelfSigned writes a self-signed certificate for commonName and its
+// key, stamped with modTime
+func writeSelfSigned(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	// Set explicitly, as a quick rewrite can land within the filesystem's timestamp granularity
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+// servedCommonName completes a TLS handshake with a server using r and
+// returns the common name of the certificate it presented
+func servedCommonName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: r.GetCertificate})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// lockedBuffer collects log output written by the handshake goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCertReloader_PicksUpReplacedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeSelfSigned(t, certFile, keyFile, "first", start)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader failed: %v", err)
+	}
+	r.checkInterval = 0
+	r.nextCheck.Store(0)
+	if got := servedCommonName(t, r); got != "first" {
+		t.Fatalf("Expected the first certificate, got %q", got)
+	}
+
+	writeSelfSigned(t, certFile, keyFile, "renewed", start.Add(time.Second))
+	if got := servedCommonName(t, r); got != "renewed" {
+		t.Errorf("Expected the renewed certificate after the files changed, got %q", got)
+	}
+
+	// A half-written replacement keeps the working certificate, and is
+	// logged once however many handshakes see it
+	logs := &lockedBuffer{}
+	prev := logging.Default()
+	logging.SetDefault(logging.New(logs, logging.LevelWarn))
+	t.Cleanup(func() { logging.SetDefault(prev) })
+	os.WriteFile(keyFile, []byte("truncated"), 0o600)
+	for i := 0; i < 3; i++ {
+		if got := servedCommonName(t, r); got != "renewed" {
+			t.Errorf("Expected the renewed certificate kept when the new pair is invalid, got %q", got)
+		}
+	}
+	if n := strings.Count(logs.String(), "Keeping the current TLS certificate"); n != 1 {
+		t.Errorf("Expected the failed reload logged once, got %d times:\n%s", n, logs.String())
+	}
+}
+
+func TestCertReloader_ChecksFilesPerInterval(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeSelfSigned(t, certFile, keyFile, "first", start)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader failed: %v", err)
+	}
+	writeSelfSigned(t, certFile, keyFile, "renewed", start.Add(time.Second))
+	if got := servedCommonName(t, r); got != "first" {
+		t.Errorf("Expected the files left unchecked within the interval, got %q", got)
+	}
+
+	// Once the interval has passed the next handshake checks them
+	r.nextCheck.Store(time.Now().Add(-time.Millisecond).UnixNano())
+	if got := servedCommonName(t, r); got != "renewed" {
+		t.Errorf("Expected the renewed certificate once the check was due, got %q", got)
+	}
+}
+
+func TestNewCertReloader_RejectsMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Error("Expected an error for missing certificate files")
+	}
+}
@@ -32,6 +32,7 @@ const (
 	Command_COMMAND_NOOP        Command = 1 // No action required
 	Command_COMMAND_UPGRADE     Command = 2 // Agent should download and install new version
 	Command_COMMAND_RECONFIGURE Command = 3 // Agent should fetch new configuration
+	Command_COMMAND_DOWNGRADE   Command = 4 // Agent should install the older version in latest_version
 )
 
 // Enum value maps for Command.
@@ -41,12 +42,14 @@ var (
 		1: "COMMAND_NOOP",
 		2: "COMMAND_UPGRADE",
 		3: "COMMAND_RECONFIGURE",
+		4: "COMMAND_DOWNGRADE",
 	}
 	Command_value = map[string]int32{
 		"COMMAND_UNSPECIFIED": 0,
 		"COMMAND_NOOP":        1,
 		"COMMAND_UPGRADE":     2,
 		"COMMAND_RECONFIGURE": 3,
+		"COMMAND_DOWNGRADE":   4,
 	}
 )
 
@@ -77,6 +80,56 @@ func (Command) EnumDescriptor() ([]byte, []int) {
 	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{0}
 }
 
+// Kinds of event an agent detects locally
+type EventType int32
+
+const (
+	EventType_EVENT_TYPE_UNSPECIFIED  EventType = 0
+	EventType_EVENT_TYPE_ANOMALY      EventType = 1 // Traffic anomaly flagged by eBPF
+	EventType_EVENT_TYPE_LARGE_PACKET EventType = 2 // Packet above the configured size threshold
+)
+
+// Enum value maps for EventType.
+var (
+	EventType_name = map[int32]string{
+		0: "EVENT_TYPE_UNSPECIFIED",
+		1: "EVENT_TYPE_ANOMALY",
+		2: "EVENT_TYPE_LARGE_PACKET",
+	}
+	EventType_value = map[string]int32{
+		"EVENT_TYPE_UNSPECIFIED":  0,
+		"EVENT_TYPE_ANOMALY":      1,
+		"EVENT_TYPE_LARGE_PACKET": 2,
+	}
+)
+
+func (x EventType) Enum() *EventType {
+	p := new(EventType)
+	*p = x
+	return p
+}
+
+func (x EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_sentinel_v1_sentinel_proto_enumTypes[1].Descriptor()
+}
+
+func (EventType) Type() protoreflect.EnumType {
+	return &file_sentinel_v1_sentinel_proto_enumTypes[1]
+}
+
+func (x EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventType.Descriptor instead.
+func (EventType) EnumDescriptor() ([]byte, []int) {
+	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{1}
+}
+
 // Summary of metrics collected by the agent
 type MetricsSummary struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -86,8 +139,12 @@ type MetricsSummary struct {
 	TxBytes       uint64                 `protobuf:"varint,4,opt,name=tx_bytes,json=txBytes,proto3" json:"tx_bytes,omitempty"`
 	DropCount     uint64                 `protobuf:"varint,5,opt,name=drop_count,json=dropCount,proto3" json:"drop_count,omitempty"`
 	UptimeSeconds uint64                 `protobuf:"varint,6,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	// Events detected since the agent started. When set, the server counts
+	// events from these totals instead of from the heartbeat's event list.
+	AnomalyEvents     *uint64 `protobuf:"varint,7,opt,name=anomaly_events,json=anomalyEvents,proto3,oneof" json:"anomaly_events,omitempty"`
+	LargePacketEvents *uint64 `protobuf:"varint,8,opt,name=large_packet_events,json=largePacketEvents,proto3,oneof" json:"large_packet_events,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
 func (x *MetricsSummary) Reset() {
@@ -162,19 +219,173 @@ func (x *MetricsSummary) GetUptimeSeconds() uint64 {
 	return 0
 }
 
+func (x *MetricsSummary) GetAnomalyEvents() uint64 {
+	if x != nil && x.AnomalyEvents != nil {
+		return *x.AnomalyEvents
+	}
+	return 0
+}
+
+func (x *MetricsSummary) GetLargePacketEvents() uint64 {
+	if x != nil && x.LargePacketEvents != nil {
+		return *x.LargePacketEvents
+	}
+	return 0
+}
+
+// Host details reported by the agent. Empty fields are left unchanged.
+type AgentMetadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hostname      string                 `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Os            string                 `protobuf:"bytes,2,opt,name=os,proto3" json:"os,omitempty"`         // Operating system, e.g. "linux"
+	Kernel        string                 `protobuf:"bytes,3,opt,name=kernel,proto3" json:"kernel,omitempty"` // Kernel release, e.g. "6.8.0-31-generic"
+	Arch          string                 `protobuf:"bytes,4,opt,name=arch,proto3" json:"arch,omitempty"`     // CPU architecture, e.g. "x86_64"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentMetadata) Reset() {
+	*x = AgentMetadata{}
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentMetadata) ProtoMessage() {}
+
+func (x *AgentMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentMetadata.ProtoReflect.Descriptor instead.
+func (*AgentMetadata) Descriptor() ([]byte, []int) {
+	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AgentMetadata) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *AgentMetadata) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *AgentMetadata) GetKernel() string {
+	if x != nil {
+		return x.Kernel
+	}
+	return ""
+}
+
+func (x *AgentMetadata) GetArch() string {
+	if x != nil {
+		return x.Arch
+	}
+	return ""
+}
+
+// A discrete event detected by the agent since its last heartbeat
+type AgentEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          EventType              `protobuf:"varint,1,opt,name=type,proto3,enum=sentinel.v1.EventType" json:"type,omitempty"`
+	TimestampMs   int64                  `protobuf:"varint,2,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"` // Unix milliseconds when the agent detected it
+	Detail        string                 `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`                               // Optional human-readable context
+	TraceId       string                 `protobuf:"bytes,4,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`              // Optional, attached to the event counter as an exemplar
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentEvent) Reset() {
+	*x = AgentEvent{}
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentEvent) ProtoMessage() {}
+
+func (x *AgentEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentEvent.ProtoReflect.Descriptor instead.
+func (*AgentEvent) Descriptor() ([]byte, []int) {
+	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AgentEvent) GetType() EventType {
+	if x != nil {
+		return x.Type
+	}
+	return EventType_EVENT_TYPE_UNSPECIFIED
+}
+
+func (x *AgentEvent) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *AgentEvent) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+func (x *AgentEvent) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
 // Heartbeat request sent by agents to the control plane
 type HeartbeatRequest struct {
 	state          protoimpl.MessageState `protogen:"open.v1"`
 	AgentId        string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`                      // Unique UUID of the agent
 	CurrentVersion string                 `protobuf:"bytes,2,opt,name=current_version,json=currentVersion,proto3" json:"current_version,omitempty"` // Current agent version (semver)
 	Metrics        *MetricsSummary        `protobuf:"bytes,3,opt,name=metrics,proto3" json:"metrics,omitempty"`                                     // Latest metrics snapshot
+	Metadata       *AgentMetadata         `protobuf:"bytes,4,opt,name=metadata,proto3" json:"metadata,omitempty"`                                   // Optional host details
+	Events         []*AgentEvent          `protobuf:"bytes,5,rep,name=events,proto3" json:"events,omitempty"`                                       // Events since the last heartbeat, at most 100
 	unknownFields  protoimpl.UnknownFields
 	sizeCache      protoimpl.SizeCache
 }
 
 func (x *HeartbeatRequest) Reset() {
 	*x = HeartbeatRequest{}
-	mi := &file_sentinel_v1_sentinel_proto_msgTypes[1]
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -186,7 +397,7 @@ func (x *HeartbeatRequest) String() string {
 func (*HeartbeatRequest) ProtoMessage() {}
 
 func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_sentinel_v1_sentinel_proto_msgTypes[1]
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -199,7 +410,7 @@ func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
 func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
-	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{1}
+	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *HeartbeatRequest) GetAgentId() string {
@@ -223,6 +434,20 @@ func (x *HeartbeatRequest) GetMetrics() *MetricsSummary {
 	return nil
 }
 
+func (x *HeartbeatRequest) GetMetadata() *AgentMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *HeartbeatRequest) GetEvents() []*AgentEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
 // Heartbeat response from the control plane
 type HeartbeatResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -235,7 +460,7 @@ type HeartbeatResponse struct {
 
 func (x *HeartbeatResponse) Reset() {
 	*x = HeartbeatResponse{}
-	mi := &file_sentinel_v1_sentinel_proto_msgTypes[2]
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -247,7 +472,7 @@ func (x *HeartbeatResponse) String() string {
 func (*HeartbeatResponse) ProtoMessage() {}
 
 func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_sentinel_v1_sentinel_proto_msgTypes[2]
+	mi := &file_sentinel_v1_sentinel_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -260,7 +485,7 @@ func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
 func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
-	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{2}
+	return file_sentinel_v1_sentinel_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *HeartbeatResponse) GetCommand() Command {
@@ -288,7 +513,7 @@ var File_sentinel_v1_sentinel_proto protoreflect.FileDescriptor
 
 const file_sentinel_v1_sentinel_proto_rawDesc = "" +
 	"\n" +
-	"\x1asentinel/v1/sentinel.proto\x12\vsentinel.v1\"\xca\x01\n" +
+	"\x1asentinel/v1/sentinel.proto\x12\vsentinel.v1\"\xd6\x02\n" +
 	"\x0eMetricsSummary\x12\x1d\n" +
 	"\n" +
 	"rx_packets\x18\x01 \x01(\x04R\trxPackets\x12\x19\n" +
@@ -298,21 +523,43 @@ const file_sentinel_v1_sentinel_proto_rawDesc = "" +
 	"\btx_bytes\x18\x04 \x01(\x04R\atxBytes\x12\x1d\n" +
 	"\n" +
 	"drop_count\x18\x05 \x01(\x04R\tdropCount\x12%\n" +
-	"\x0euptime_seconds\x18\x06 \x01(\x04R\ruptimeSeconds\"\x8d\x01\n" +
+	"\x0euptime_seconds\x18\x06 \x01(\x04R\ruptimeSeconds\x12*\n" +
+	"\x0eanomaly_events\x18\a \x01(\x04H\x00R\ranomalyEvents\x88\x01\x01\x123\n" +
+	"\x13large_packet_events\x18\b \x01(\x04H\x01R\x11largePacketEvents\x88\x01\x01B\x11\n" +
+	"\x0f_anomaly_eventsB\x16\n" +
+	"\x14_large_packet_events\"g\n" +
+	"\rAgentMetadata\x12\x1a\n" +
+	"\bhostname\x18\x01 \x01(\tR\bhostname\x12\x0e\n" +
+	"\x02os\x18\x02 \x01(\tR\x02os\x12\x16\n" +
+	"\x06kernel\x18\x03 \x01(\tR\x06kernel\x12\x12\n" +
+	"\x04arch\x18\x04 \x01(\tR\x04arch\"\x8e\x01\n" +
+	"\n" +
+	"AgentEvent\x12*\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x16.sentinel.v1.EventTypeR\x04type\x12!\n" +
+	"\ftimestamp_ms\x18\x02 \x01(\x03R\vtimestampMs\x12\x16\n" +
+	"\x06detail\x18\x03 \x01(\tR\x06detail\x12\x19\n" +
+	"\btrace_id\x18\x04 \x01(\tR\atraceId\"\xf6\x01\n" +
 	"\x10HeartbeatRequest\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12'\n" +
 	"\x0fcurrent_version\x18\x02 \x01(\tR\x0ecurrentVersion\x125\n" +
-	"\ametrics\x18\x03 \x01(\v2\x1b.sentinel.v1.MetricsSummaryR\ametrics\"\x8b\x01\n" +
+	"\ametrics\x18\x03 \x01(\v2\x1b.sentinel.v1.MetricsSummaryR\ametrics\x126\n" +
+	"\bmetadata\x18\x04 \x01(\v2\x1a.sentinel.v1.AgentMetadataR\bmetadata\x12/\n" +
+	"\x06events\x18\x05 \x03(\v2\x17.sentinel.v1.AgentEventR\x06events\"\x8b\x01\n" +
 	"\x11HeartbeatResponse\x12.\n" +
 	"\acommand\x18\x01 \x01(\x0e2\x14.sentinel.v1.CommandR\acommand\x12%\n" +
 	"\x0elatest_version\x18\x02 \x01(\tR\rlatestVersion\x12\x1f\n" +
 	"\vconfig_hash\x18\x03 \x01(\tR\n" +
-	"configHash*b\n" +
+	"configHash*y\n" +
 	"\aCommand\x12\x17\n" +
 	"\x13COMMAND_UNSPECIFIED\x10\x00\x12\x10\n" +
 	"\fCOMMAND_NOOP\x10\x01\x12\x13\n" +
 	"\x0fCOMMAND_UPGRADE\x10\x02\x12\x17\n" +
-	"\x13COMMAND_RECONFIGURE\x10\x032]\n" +
+	"\x13COMMAND_RECONFIGURE\x10\x03\x12\x15\n" +
+	"\x11COMMAND_DOWNGRADE\x10\x04*\\\n" +
+	"\tEventType\x12\x1a\n" +
+	"\x16EVENT_TYPE_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12EVENT_TYPE_ANOMALY\x10\x01\x12\x1b\n" +
+	"\x17EVENT_TYPE_LARGE_PACKET\x10\x022]\n" +
 	"\x0fSentinelService\x12J\n" +
 	"\tHeartbeat\x12\x1d.sentinel.v1.HeartbeatRequest\x1a\x1e.sentinel.v1.HeartbeatResponseB\xa5\x01\n" +
 	"\x0fcom.sentinel.v1B\rSentinelProtoP\x01Z6github.com/sennet/sennet/gen/go/sentinel/v1;sentinelv1\xa2\x02\x03SXX\xaa\x02\vSentinel.V1\xca\x02\vSentinel\\V1\xe2\x02\x17Sentinel\\V1\\GPBMetadata\xea\x02\fSentinel::V1b\x06proto3"
@@ -329,24 +576,30 @@ func file_sentinel_v1_sentinel_proto_rawDescGZIP() []byte {
 	return file_sentinel_v1_sentinel_proto_rawDescData
 }
 
-var file_sentinel_v1_sentinel_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_sentinel_v1_sentinel_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_sentinel_v1_sentinel_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_sentinel_v1_sentinel_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_sentinel_v1_sentinel_proto_goTypes = []any{
 	(Command)(0),              // 0: sentinel.v1.Command
-	(*MetricsSummary)(nil),    // 1: sentinel.v1.MetricsSummary
-	(*HeartbeatRequest)(nil),  // 2: sentinel.v1.HeartbeatRequest
-	(*HeartbeatResponse)(nil), // 3: sentinel.v1.HeartbeatResponse
+	(EventType)(0),            // 1: sentinel.v1.EventType
+	(*MetricsSummary)(nil),    // 2: sentinel.v1.MetricsSummary
+	(*AgentMetadata)(nil),     // 3: sentinel.v1.AgentMetadata
+	(*AgentEvent)(nil),        // 4: sentinel.v1.AgentEvent
+	(*HeartbeatRequest)(nil),  // 5: sentinel.v1.HeartbeatRequest
+	(*HeartbeatResponse)(nil), // 6: sentinel.v1.HeartbeatResponse
 }
 var file_sentinel_v1_sentinel_proto_depIdxs = []int32{
-	1, // 0: sentinel.v1.HeartbeatRequest.metrics:type_name -> sentinel.v1.MetricsSummary
-	0, // 1: sentinel.v1.HeartbeatResponse.command:type_name -> sentinel.v1.Command
-	2, // 2: sentinel.v1.SentinelService.Heartbeat:input_type -> sentinel.v1.HeartbeatRequest
-	3, // 3: sentinel.v1.SentinelService.Heartbeat:output_type -> sentinel.v1.HeartbeatResponse
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	1, // 0: sentinel.v1.AgentEvent.type:type_name -> sentinel.v1.EventType
+	2, // 1: sentinel.v1.HeartbeatRequest.metrics:type_name -> sentinel.v1.MetricsSummary
+	3, // 2: sentinel.v1.HeartbeatRequest.metadata:type_name -> sentinel.v1.AgentMetadata
+	4, // 3: sentinel.v1.HeartbeatRequest.events:type_name -> sentinel.v1.AgentEvent
+	0, // 4: sentinel.v1.HeartbeatResponse.command:type_name -> sentinel.v1.Command
+	5, // 5: sentinel.v1.SentinelService.Heartbeat:input_type -> sentinel.v1.HeartbeatRequest
+	6, // 6: sentinel.v1.SentinelService.Heartbeat:output_type -> sentinel.v1.HeartbeatResponse
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_sentinel_v1_sentinel_proto_init() }
@@ -354,13 +607,14 @@ func file_sentinel_v1_sentinel_proto_init() {
 	if File_sentinel_v1_sentinel_proto != nil {
 		return
 	}
+	file_sentinel_v1_sentinel_proto_msgTypes[0].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sentinel_v1_sentinel_proto_rawDesc), len(file_sentinel_v1_sentinel_proto_rawDesc)),
-			NumEnums:      1,
-			NumMessages:   3,
+			NumEnums:      2,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
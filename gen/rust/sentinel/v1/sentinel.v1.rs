@@ -15,6 +15,42 @@ pub struct MetricsSummary {
     pub drop_count: u64,
     #[prost(uint64, tag="6")]
     pub uptime_seconds: u64,
+    /// Events detected since the agent started. When set, the server counts
+    /// events from these totals instead of from the heartbeat's event list.
+    #[prost(uint64, optional, tag="7")]
+    pub anomaly_events: ::core::option::Option<u64>,
+    #[prost(uint64, optional, tag="8")]
+    pub large_packet_events: ::core::option::Option<u64>,
+}
+/// Host details reported by the agent. Empty fields are left unchanged.
+#[derive(Clone, PartialEq, Eq, Hash, ::prost::Message)]
+pub struct AgentMetadata {
+    #[prost(string, tag="1")]
+    pub hostname: ::prost::alloc::string::String,
+    /// Operating system, e.g. "linux"
+    #[prost(string, tag="2")]
+    pub os: ::prost::alloc::string::String,
+    /// Kernel release, e.g. "6.8.0-31-generic"
+    #[prost(string, tag="3")]
+    pub kernel: ::prost::alloc::string::String,
+    /// CPU architecture, e.g. "x86_64"
+    #[prost(string, tag="4")]
+    pub arch: ::prost::alloc::string::String,
+}
+/// A discrete event detected by the agent since its last heartbeat
+#[derive(Clone, PartialEq, Eq, Hash, ::prost::Message)]
+pub struct AgentEvent {
+    #[prost(enumeration="EventType", tag="1")]
+    pub r#type: i32,
+    /// Unix milliseconds when the agent detected it
+    #[prost(int64, tag="2")]
+    pub timestamp_ms: i64,
+    /// Optional human-readable context
+    #[prost(string, tag="3")]
+    pub detail: ::prost::alloc::string::String,
+    /// Optional, attached to the event counter as an exemplar
+    #[prost(string, tag="4")]
+    pub trace_id: ::prost::alloc::string::String,
 }
 /// Heartbeat request sent by agents to the control plane
 #[derive(Clone, PartialEq, Eq, Hash, ::prost::Message)]
@@ -28,6 +64,12 @@ pub struct HeartbeatRequest {
     /// Latest metrics snapshot
     #[prost(message, optional, tag="3")]
     pub metrics: ::core::option::Option<MetricsSummary>,
+    /// Optional host details
+    #[prost(message, optional, tag="4")]
+    pub metadata: ::core::option::Option<AgentMetadata>,
+    /// Events since the last heartbeat, at most 100
+    #[prost(message, repeated, tag="5")]
+    pub events: ::prost::alloc::vec::Vec<AgentEvent>,
 }
 /// Heartbeat response from the control plane
 #[derive(Clone, PartialEq, Eq, Hash, ::prost::Message)]
@@ -53,6 +95,8 @@ pub enum Command {
     Upgrade = 2,
     /// Agent should fetch new configuration
     Reconfigure = 3,
+    /// Agent should install the older version in latest_version
+    Downgrade = 4,
 }
 impl Command {
     /// String value of the enum field names used in the ProtoBuf definition.
@@ -65,6 +109,7 @@ impl Command {
             Self::Noop => "COMMAND_NOOP",
             Self::Upgrade => "COMMAND_UPGRADE",
             Self::Reconfigure => "COMMAND_RECONFIGURE",
+            Self::Downgrade => "COMMAND_DOWNGRADE",
         }
     }
     /// Creates an enum from field names used in the ProtoBuf definition.
@@ -74,6 +119,39 @@ impl Command {
             "COMMAND_NOOP" => Some(Self::Noop),
             "COMMAND_UPGRADE" => Some(Self::Upgrade),
             "COMMAND_RECONFIGURE" => Some(Self::Reconfigure),
+            "COMMAND_DOWNGRADE" => Some(Self::Downgrade),
+            _ => None,
+        }
+    }
+}
+/// Kinds of event an agent detects locally
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Hash, PartialOrd, Ord, ::prost::Enumeration)]
+#[repr(i32)]
+pub enum EventType {
+    Unspecified = 0,
+    /// Traffic anomaly flagged by eBPF
+    Anomaly = 1,
+    /// Packet above the configured size threshold
+    LargePacket = 2,
+}
+impl EventType {
+    /// String value of the enum field names used in the ProtoBuf definition.
+    ///
+    /// The values are not transformed in any way and thus are considered stable
+    /// (if the ProtoBuf definition does not change) and safe for programmatic use.
+    pub fn as_str_name(&self) -> &'static str {
+        match self {
+            Self::Unspecified => "EVENT_TYPE_UNSPECIFIED",
+            Self::Anomaly => "EVENT_TYPE_ANOMALY",
+            Self::LargePacket => "EVENT_TYPE_LARGE_PACKET",
+        }
+    }
+    /// Creates an enum from field names used in the ProtoBuf definition.
+    pub fn from_str_name(value: &str) -> ::core::option::Option<Self> {
+        match value {
+            "EVENT_TYPE_UNSPECIFIED" => Some(Self::Unspecified),
+            "EVENT_TYPE_ANOMALY" => Some(Self::Anomaly),
+            "EVENT_TYPE_LARGE_PACKET" => Some(Self::LargePacket),
             _ => None,
         }
     }